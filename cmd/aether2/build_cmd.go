@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -92,6 +94,18 @@ type ProjectConfig struct {
 		Linker            string   `toml:"linker,omitempty"`
 		CreateLibrary     bool     `toml:"create_library,omitempty"`
 		LibraryType       string   `toml:"library_type,omitempty"`
+		CPU               string   `toml:"cpu,omitempty"`
+		Features          string   `toml:"features,omitempty"`
+		RelocModel        string   `toml:"reloc_model,omitempty"`
+		CodeModel         string   `toml:"code_model,omitempty"`
+		StackProtector    string   `toml:"stack_protector,omitempty"`
+		Sanitize          string   `toml:"sanitize,omitempty"`
+		PIE               *bool    `toml:"pie,omitempty"`
+		Static            *bool    `toml:"static,omitempty"`
+		Strip             *bool    `toml:"strip,omitempty"`
+		LibraryPaths      []string `toml:"library_paths,omitempty"`
+		Libraries         []string `toml:"libraries,omitempty"`
+		Rpath             []string `toml:"rpath,omitempty"`
 		CompilerFlags     compiler_pkg.CompilerFlags `toml:"compiler_flags,omitempty"`
 		Targets           map[string]compiler_pkg.TargetConfig `toml:"target,omitempty"`
 	} `toml:"build"`
@@ -113,7 +127,6 @@ func loadProjectConfig(projectRoot string) ProjectConfig {
 	config.Build.OutputDirectory = "bin"
 	config.Build.Target = "native"
 	config.Build.Optimization = "debug"
-	config.Build.Linker = "mold"
 
 	// Try to read aether.toml
 	if data, err := os.ReadFile(configPath); err == nil {
@@ -174,6 +187,7 @@ var (
 		outputName     string
 		fuseLd         string
 		optimization   string
+		given          func(name string) bool
 		debugInfo      bool
 		debugSymbols   bool
 		verbose        bool
@@ -195,6 +209,7 @@ var (
 		emitExe        bool
 		emitTokens     bool
 		checkImports   bool
+		warnUnused     bool
 		analyzeOnly    bool
 		parallel       bool
 		threads        int
@@ -322,21 +337,7 @@ func doBuild(args []string) {
 		filesToBuild = files
 
 		// Apply configuration overrides
-		if config.Build.Target != "" {
-			buildFlags.targetOS, buildFlags.targetArch = parseTarget(config.Build.Target)
-		}
-		if config.Build.Optimization != "" {
-			buildFlags.optimization = config.Build.Optimization
-		}
-		if config.Build.Linker != "" {
-			buildFlags.linker = config.Build.Linker
-		}
-		if config.Build.CreateLibrary {
-			buildFlags.createLibrary = true
-		}
-		if config.Build.LibraryType != "" {
-			buildFlags.libraryType = config.Build.LibraryType
-		}
+		applyBuildConfig(config)
 
 		// Store config for later use
 		projectConfig = config
@@ -440,8 +441,10 @@ func doBuild(args []string) {
 		imports, err := analysis.AnalyzeImports(filesToBuild)
 		must(err)
 
-		if len(scheduler.DetectCycles(imports)) > 0 {
+		graph, _ := resolveImportGraph(imports, projectRoot)
+		if errs := analysis.ImportCycles(graph); len(errs) > 0 {
 			fmt.Println("Error: Circular imports detected")
+			fmt.Print(utils.FormatErrorSummary(utils.GroupErrorsByFile(errs)))
 			os.Exit(1)
 		}
 
@@ -459,35 +462,23 @@ func doBuild(args []string) {
 	}
 
 	// Compilation phase
+	pipeline := optimizationPipeline()
 	if !buildFlags.emitExe && !buildFlags.emitObj && !buildFlags.emitIR && !buildFlags.emitASM && !buildFlags.emitBitcode {
 		buildFlags.emitExe = true
 	}
+	if err := checkBuildFlags(buildFlags.emitExe || buildFlags.createLibrary); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
 	imports, err := analysis.AnalyzeImports(filesToBuild)
 	must(err)
 
-	// Resolve import paths to actual files
-	importedFiles, err := analysis.ResolveImportPathsToFiles(imports, projectRoot)
-	must(err)
-
-	// Create a mapping from import names to resolved file paths
-	importNameToPath := make(map[string]string)
-	for _, importPath := range importedFiles {
-		importName := filepath.Base(importPath)
-		importName = strings.TrimSuffix(importName, ".ae")
-		importNameToPath[importName] = importPath
-	}
-
-	// Update imports map to use resolved file paths instead of import names
-	resolvedImports := make(map[string][]string)
-	for sourceFile, importNames := range imports {
-		var resolvedPaths []string
-		for _, importName := range importNames {
-			if resolvedPath, exists := importNameToPath[importName]; exists {
-				resolvedPaths = append(resolvedPaths, resolvedPath)
-			}
-		}
-		resolvedImports[sourceFile] = resolvedPaths
+	resolvedImports, importedFiles := resolveImportGraph(imports, projectRoot)
+	if errs := analysis.ImportCycles(resolvedImports); len(errs) > 0 {
+		fmt.Println("Error: Circular imports detected")
+		fmt.Print(utils.FormatErrorSummary(utils.GroupErrorsByFile(errs)))
+		os.Exit(1)
 	}
 
 	// Combine source files with imported files, ensuring uniqueness
@@ -512,10 +503,52 @@ func doBuild(args []string) {
 
 	var objectFiles []string
 	var allParseErrors []utils.ParseError
-	var moduleSymbols map[string]map[string]interface{} = make(map[string]map[string]interface{})
-	entryFile := ""
-	if len(filesToBuild) > 0 {
-		entryFile = filesToBuild[0]
+	entryFile := entryFileOf(filesToBuild)
+
+	// Every module's exports are known before anything is compiled, so
+	// importers can declare them whether or not the module is rebuilt.
+	moduleSymbols, fileIncludes, modules := scanModules(sortedFiles, entryFile)
+
+	// The call graph tells which modules and functions ever run; the rest
+	// is left out of the build. Without it, when a module does not parse,
+	// everything is built and the parse errors are reported below.
+	var graph *analysis.CallGraph
+	if len(modules) == len(sortedFiles) {
+		graph = analysis.BuildCallGraph(modules, moduleSymbols, buildFlags.createLibrary)
+		if warns := graph.Unreachable(); buildFlags.warnUnused && len(warns) > 0 && !buildFlags.quiet {
+			for _, w := range warns {
+				fmt.Println(w.File)
+				fmt.Print(utils.FormatErrorWithContext(w))
+			}
+		}
+	}
+	linked := func(file string) bool {
+		return graph == nil || graph.ModuleReachable(moduleNameOf(file))
+	}
+	// live lists the functions of file that are compiled, or nil for all.
+	live := func(file string) []string {
+		if graph == nil {
+			return nil
+		}
+		var names []string
+		for name := range graph.Live(moduleNameOf(file)) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// The entry point initializes the other modules in dependency order.
+	// The C headers any of them includes name the libraries to link: a
+	// package binding C functions is not linked itself when its functions
+	// are called by their C names, like pow from math.
+	var initOrder []string
+	var includes []analysis.CInclude
+	for _, file := range sortedFiles {
+		includes = append(includes, fileIncludes[file]...)
+		if linked(file) && file != entryFile {
+			initOrder = append(initOrder, moduleNameOf(file))
+		}
 	}
 
 	cachePath := filepath.Join(projectRoot, ".aetherbuildcache.json")
@@ -540,6 +573,22 @@ func doBuild(args []string) {
 			reasons[file] = "changed"
 			return true
 		}
+		if entry.Optimization != optimizationSettings() {
+			stale[file] = true
+			reasons[file] = "optimization changed"
+			return true
+		}
+		if entry.Codegen != codegenKey() {
+			stale[file] = true
+			reasons[file] = "code generation flags changed"
+			return true
+		}
+		// A function that was left out may be called now.
+		if strings.Join(entry.Live, " ") != strings.Join(live(file), " ") {
+			stale[file] = true
+			reasons[file] = "reachable functions changed"
+			return true
+		}
 		// Check output exists
 		output := strings.TrimSuffix(file, ".ae") + ".o"
 		if _, err := os.Stat(output); err != nil {
@@ -573,10 +622,19 @@ func doBuild(args []string) {
 	parseErrorsMu := &sync.Mutex{}
 
 	for _, file := range sortedFiles {
+		if !linked(file) {
+			if buildFlags.verbose {
+				fmt.Printf("Left out: %s (never used)\n", file)
+			}
+			continue
+		}
 		if !stale[file] {
 			if buildFlags.verbose {
 				fmt.Printf("Up to date: %s\n", file)
 			}
+			if buildFlags.emitObj || buildFlags.emitExe {
+				objectFiles = append(objectFiles, strings.TrimSuffix(file, ".ae")+".o")
+			}
 			continue
 		}
 		f := file
@@ -594,11 +652,6 @@ func doBuild(args []string) {
 			}
 			p := parser.NewParser(l)
 			p.SetFile(f)
-			if f == entryFile {
-				p.IsEntryFile = true
-			} else {
-				p.IsEntryFile = false
-			}
 			ast := p.Parse()
 			if len(p.Errors.Errors) > 0 {
 				parseErrorsMu.Lock()
@@ -608,9 +661,46 @@ func doBuild(args []string) {
 				parseErrorsMu.Unlock()
 				return
 			}
-			moduleName := strings.TrimSuffix(filepath.Base(f), ".ae")
-			moduleSymbols[moduleName] = extractModuleSymbols(ast)
-			ir := compiler_pkg.CompileWithOptionsAndModules(ast, moduleName, moduleSymbols)
+			errs := analysis.CheckTypes(ast, f, string(content), moduleSymbols)
+			errs = append(errs, analysis.CheckCalls(ast, f, string(content), moduleSymbols)...)
+			errs = append(errs, analysis.CheckBorrows(ast, f, string(content), moduleSymbols)...)
+			errs = append(errs, analysis.CheckConstants(ast, f, string(content), moduleSymbols)...)
+			if len(errs) > 0 {
+				parseErrorsMu.Lock()
+				allParseErrors = append(allParseErrors, errs...)
+				parseErrorsMu.Unlock()
+				return
+			}
+			if warns := analysis.CheckFlow(ast, f, string(content), moduleSymbols); len(warns) > 0 && !buildFlags.quiet {
+				parseErrorsMu.Lock()
+				fmt.Println(f)
+				for _, w := range warns {
+					fmt.Print(utils.FormatErrorWithContext(w))
+				}
+				parseErrorsMu.Unlock()
+			}
+			moduleName := moduleNameOf(f)
+			opts := compiler_pkg.Options{
+				ModuleName:    moduleName,
+				Entry:         f == entryFile,
+				SourceFile:    f,
+				Debug:         isDebugBuild(),
+				ModuleSymbols: moduleSymbols,
+				InitOrder:     initOrder,
+				Pipeline:      pipeline,
+				Codegen:       codegenSettings(),
+				DebugInfo:     buildFlags.debugInfo,
+			}
+			if graph != nil {
+				opts.Live = graph.Live(moduleName)
+			}
+			ir, errs := compiler_pkg.CompileModule(ast, opts)
+			if len(errs) > 0 {
+				parseErrorsMu.Lock()
+				allParseErrors = append(allParseErrors, errs...)
+				parseErrorsMu.Unlock()
+				return
+			}
 			baseName := strings.TrimSuffix(f, ".ae")
 			if buildFlags.emitIR || buildFlags.emitLLVM {
 				llFile := baseName + ".ll"
@@ -652,11 +742,14 @@ func doBuild(args []string) {
 			}
 			cacheMu.Lock()
 			cache.Files[f] = buildcache.BuildCacheEntry{
-				Hash:      fileHashVal,
-				Output:    baseName + ".o",
-				Deps:      resolvedImports[f],
-				DepHashes: depHashes,
-				LastBuild: time.Now().Unix(),
+				Hash:         fileHashVal,
+				Output:       baseName + ".o",
+				Deps:         resolvedImports[f],
+				DepHashes:    depHashes,
+				Live:         live(f),
+				Optimization: optimizationSettings(),
+				Codegen:      codegenKey(),
+				LastBuild:    time.Now().Unix(),
 			}
 			cacheMu.Unlock()
 		}
@@ -688,7 +781,7 @@ func doBuild(args []string) {
 			// Use configured output directory
 			output = filepath.Join(projectConfig.Build.OutputDirectory, "aether.out")
 		}
-		linkObjectFiles(objectFiles, output)
+		linkObjectFiles(objectFiles, output, analysis.LinkLibraries(includes))
 
 		if !buildFlags.quiet {
 			fmt.Println("Build complete! Executable at:", output)
@@ -736,6 +829,7 @@ Examples:
   aether build --emit-ir         # Only generate LLVM IR
   aether build --analyze-only    # Only analyze, don't compile`,
 	Run: func(cmd *cobra.Command, args []string) {
+		buildFlags.given = cmd.Flags().Changed
 		doBuild(args)
 	},
 }
@@ -747,12 +841,12 @@ func init() {
 	flags.BoolVar(&buildFlags.noStdlib, "no-stdlib", false, "disable stdlib builtins")
 	flags.StringVar(&buildFlags.targetOS, "target-os", runtime.GOOS, "target operating system (linux, darwin, windows)")
 	flags.StringVar(&buildFlags.targetArch, "target-arch", runtime.GOARCH, "target architecture (amd64, arm64, 386, arm)")
-	flags.StringVar(&buildFlags.linker, "linker", getDefaultLinker(), "linker to use (mold, ld, lld)")
+	flags.StringVar(&buildFlags.linker, "linker", getDefaultLinker(), "linker to use (mold, lld, gold, ld); mold when installed")
 	flags.StringVarP(&buildFlags.outputName, "output", "o", "bin/aether.out", "output executable name")
 	flags.StringVar(&buildFlags.fuseLd, "fuse-ld", "", "linker to use (like clang -fuse-ld)")
 
 	// Optimization flags
-	flags.StringVar(&buildFlags.optimization, "O", "2", "optimization level (0, 1, 2, 3, s, z)")
+	flags.StringVarP(&buildFlags.optimization, "O", "O", "2", "optimization level (0, 1, 2, 3, s, z)")
 	flags.BoolVar(&buildFlags.noOptimize, "no-optimize", false, "disable all optimizations")
 	flags.BoolVar(&buildFlags.noInline, "no-inline", false, "disable function inlining")
 	flags.BoolVar(&buildFlags.noVectorize, "no-vectorize", false, "disable vectorization")
@@ -775,6 +869,7 @@ func init() {
 	// Analysis flags
 	flags.BoolVar(&buildFlags.checkImports, "check-imports", true, "check import validity")
 	flags.BoolVar(&buildFlags.analyzeOnly, "analyze-only", false, "only analyze, don't compile")
+	flags.BoolVar(&buildFlags.warnUnused, "warn-unused", false, "warn about functions and modules left out because they never run")
 
 	// Performance flags
 	flags.BoolVar(&buildFlags.parallel, "parallel", true, "enable parallel compilation")
@@ -861,38 +956,114 @@ func init() {
 	flags.StringVar(&buildFlags.libraryProvides, "library-provides", "", "libraries provided by the library")
 }
 
-func getOptimizationLevel() string {
+// isDebugBuild reports whether runtime checks should carry source locations
+// and the program should report leaks at exit.
+func isDebugBuild() bool {
+	return buildFlags.debugInfo || buildFlags.optimization == "0"
+}
+
+// applyBuildConfig takes the build settings of aether.toml, except those
+// given on the command line, which win.
+func applyBuildConfig(config ProjectConfig) {
+	build := config.Build
+	setString := func(flag string, dst *string, value string) {
+		if value != "" && !flagGiven(flag) {
+			*dst = value
+		}
+	}
+	setBool := func(flag string, dst *bool, value *bool) {
+		if value != nil && !flagGiven(flag) {
+			*dst = *value
+		}
+	}
+	if build.Target != "" && !flagGiven("target-os") && !flagGiven("target-arch") {
+		buildFlags.targetOS, buildFlags.targetArch = parseTarget(build.Target)
+	}
+	setString("O", &buildFlags.optimization, configOptimizationLevel(build.Optimization))
+	setString("linker", &buildFlags.linker, build.Linker)
+	if build.CreateLibrary {
+		buildFlags.createLibrary = true
+	}
+	setString("library-type", &buildFlags.libraryType, build.LibraryType)
+	setString("cpu", &buildFlags.cpu, build.CPU)
+	setString("features", &buildFlags.features, build.Features)
+	setString("reloc-model", &buildFlags.relocModel, build.RelocModel)
+	setString("code-model", &buildFlags.codeModel, build.CodeModel)
+	setString("stack-protector", &buildFlags.stackProtector, build.StackProtector)
+	setString("sanitize", &buildFlags.sanitize, build.Sanitize)
+	setBool("pie", &buildFlags.pie, build.PIE)
+	setBool("static", &buildFlags.static, build.Static)
+	setBool("strip", &buildFlags.strip, build.Strip)
+	setString("library-path", &buildFlags.libraryPath, strings.Join(build.LibraryPaths, ","))
+	setString("library", &buildFlags.library, strings.Join(build.Libraries, ","))
+	setString("rpath", &buildFlags.rpath, strings.Join(build.Rpath, ","))
+}
+
+// configOptimizationLevel returns the -O level for the optimization key of
+// aether.toml, which may also name a profile: "debug" or "release".
+func configOptimizationLevel(level string) string {
+	switch level {
+	case "debug":
+		return "0"
+	case "release":
+		return "3"
+	}
+	return level
+}
+
+// optimizationLevel is the -O level in effect; --no-optimize means 0.
+func optimizationLevel() string {
 	if buildFlags.noOptimize {
-		return "default<O0>"
-	}
-
-	switch buildFlags.optimization {
-	case "0":
-		return "default<O0>"
-	case "1":
-		return "default<O1>"
-	case "2":
-		return "default<O2>"
-	case "3":
-		return "default<O3>"
-	case "s":
-		return "default<Os>"
-	case "z":
-		return "default<Oz>"
+		return "0"
+	}
+	return buildFlags.optimization
+}
+
+// optimizationPipeline returns the passes to run over each module, with
+// the --no-inline, --no-unroll and --no-vectorize flags applied.
+func optimizationPipeline() compiler_pkg.Pipeline {
+	pipeline, err := compiler_pkg.ParsePipeline(optimizationLevel())
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	pipeline.NoInline = buildFlags.noInline
+	pipeline.NoUnroll = buildFlags.noUnroll
+	pipeline.NoVectorize = buildFlags.noVectorize
+	return pipeline
+}
+
+// optimizationSettings describes the settings that change the code built,
+// for the build cache.
+func optimizationSettings() string {
+	settings := "O" + optimizationLevel()
+	if buildFlags.noInline {
+		settings += " no-inline"
+	}
+	if buildFlags.noUnroll {
+		settings += " no-unroll"
+	}
+	if buildFlags.noVectorize {
+		settings += " no-vectorize"
+	}
+	return settings
+}
+
+// llcOptimization is the llc flag for the -O level; llc has no levels
+// for size, so those use -O2.
+func llcOptimization() string {
+	switch level := optimizationLevel(); level {
+	case "s", "z":
+		return "-O2"
 	default:
-		return "default<O2>"
+		return "-O" + level
 	}
 }
 
 func generateAssembly(ir string, outputFile string) {
 	// Generate assembly from IR
 	llFile := strings.TrimSuffix(outputFile, ".s") + ".ll"
-	must(os.WriteFile(llFile, []byte(ir), 0644))
-
-	cmd := exec.Command("llc", "-filetype=asm", llFile, "-o", outputFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	must(cmd.Run())
+	compileIR(ir, llFile, outputFile, "asm")
 }
 
 func generateBitcode(ir string, outputFile string) {
@@ -909,192 +1080,509 @@ func generateBitcode(ir string, outputFile string) {
 func generateObjectFile(ir string, outputFile string) {
 	// Generate object file from IR
 	llFile := strings.TrimSuffix(outputFile, ".o") + ".ll"
+	compileIR(ir, llFile, outputFile, "obj")
+}
+
+// compileIR writes ir to llFile and has llc compile it to outputFile, of
+// fileType obj or asm, with the code generation flags. Sanitizers have opt
+// instrument the module first.
+func compileIR(ir string, llFile string, outputFile string, fileType string) {
 	must(os.WriteFile(llFile, []byte(ir), 0644))
 
-	cmd := exec.Command("llc", "-filetype=obj", llFile, "-o", outputFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	must(cmd.Run())
+	codegen := codegenSettings()
+	input := llFile
+	if passes := codegen.SanitizerPasses(); passes != "" {
+		instrumented, err := os.CreateTemp("", "aether-*.bc")
+		must(err)
+		instrumented.Close()
+		defer os.Remove(instrumented.Name())
+
+		must(runLLVM(exec.Command("opt", "-passes="+passes, llFile, "-o", instrumented.Name())))
+		input = instrumented.Name()
+	}
+
+	args := append([]string{llcOptimization(), "-filetype=" + fileType}, codegen.LLCArgs()...)
+	must(runLLVM(exec.Command("llc", append(args, input, "-o", outputFile)...)))
 }
 
-func linkObjectFiles(objectFiles []string, output string) {
-	args := append(objectFiles, "-o", output)
+// runLLVM runs an LLVM tool, passing its warnings on. When the tool fails,
+// often by crashing on a flag it does not accept, the error carries its
+// messages instead of the exit status, and leaves out its stack dump.
+func runLLVM(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		os.Stderr.Write(stderr.Bytes())
+		return nil
+	}
+	var msgs []string
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "PLEASE submit a bug report") || line == "Stack dump:" {
+			break
+		}
+		if line != "" && (len(msgs) == 0 || msgs[len(msgs)-1] != line) {
+			msgs = append(msgs, line)
+		}
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("%s failed: %v", filepath.Base(cmd.Path), err)
+	}
+	return fmt.Errorf("%s failed: %s", filepath.Base(cmd.Path), strings.Join(msgs, "\n   "))
+}
 
-	// Add linker-specific flags
-	if buildFlags.fuseLd != "" {
-		args = append([]string{"-fuse-ld=" + buildFlags.fuseLd}, args...)
+// codegenSettings returns the code generation flags, for llc and the
+// compiler.
+func codegenSettings() compiler_pkg.Codegen {
+	triple, _ := compiler_pkg.TargetTriple(buildFlags.targetOS, buildFlags.targetArch)
+	return compiler_pkg.Codegen{
+		Triple:         triple,
+		CPU:            buildFlags.cpu,
+		Features:       buildFlags.features,
+		RelocModel:     buildFlags.relocModel,
+		CodeModel:      buildFlags.codeModel,
+		StackProtector: buildFlags.stackProtector,
+		Sanitizers:     splitList(buildFlags.sanitize),
 	}
+}
 
-	// Add target-specific libraries
-	addTargetLibraries(&args)
+// codegenKey describes the code generation flags, for the build cache.
+func codegenKey() string {
+	codegen := codegenSettings()
+	key := strings.Join(append(codegen.LLCArgs(), codegen.StackProtector, codegen.SanitizerPasses()), " ")
+	if buildFlags.debugInfo {
+		key += " debug-info"
+	}
+	return key
+}
 
-	// Add optimization flags
-	if buildFlags.optimization != "0" {
-		args = append(args, "-O"+buildFlags.optimization)
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	// Add debug flags
-	if buildFlags.debugSymbols && !buildFlags.strip {
-		args = append(args, "-g")
+// checkBuildFlags reports the first build flag, or combination of flags,
+// that cannot work, rather than leave it to be ignored or to fail deep in
+// llc or the linker. linking says whether the build links.
+func checkBuildFlags(linking bool) error {
+	if _, err := compiler_pkg.TargetTriple(buildFlags.targetOS, buildFlags.targetArch); err != nil {
+		return err
+	}
+	codegen := codegenSettings()
+	if err := codegen.Check(); err != nil {
+		return err
+	}
+	pic := codegen.RelocModel == "" || codegen.RelocModel == "pic"
+	sharedLibrary := buildFlags.shared || buildFlags.createLibrary && buildFlags.libraryType != "static"
+	switch {
+	case buildFlags.pie && !pic:
+		return fmt.Errorf("--pie needs position independent code, but --reloc-model is %s; use --reloc-model=pic", codegen.RelocModel)
+	case sharedLibrary && !pic:
+		return fmt.Errorf("shared libraries need position independent code, but --reloc-model is %s; use --reloc-model=pic", codegen.RelocModel)
+	case buildFlags.static && buildFlags.shared:
+		return fmt.Errorf("--static and --shared cannot be combined")
+	case buildFlags.pie && buildFlags.shared:
+		return fmt.Errorf("--pie and --shared cannot be combined; a shared library is position independent already")
+	case buildFlags.static && len(codegen.Sanitizers) > 0:
+		return fmt.Errorf("sanitizers need their runtime as a shared library; drop --static")
+	case buildFlags.strip && buildFlags.debugSymbols:
+		return fmt.Errorf("--strip removes the symbols --debug-symbols asks for; use one or the other")
+	case buildFlags.strip && buildFlags.debugInfo:
+		return fmt.Errorf("--strip removes the debug info --debug-info asks for; use one or the other")
+	case buildFlags.wholeArchive && buildFlags.noWholeArchive:
+		return fmt.Errorf("--whole-archive and --no-whole-archive cannot be combined")
+	case buildFlags.asNeeded && buildFlags.noAsNeeded:
+		return fmt.Errorf("--as-needed and --no-as-needed cannot be combined")
+	case flagGiven("eh-frame-hdr") && buildFlags.noEhFrameHdr:
+		return fmt.Errorf("--eh-frame-hdr and --no-eh-frame-hdr cannot be combined")
+	case buildFlags.soname != "" && !sharedLibrary:
+		return fmt.Errorf("--soname only applies to shared libraries (--shared or --create-library)")
+	case buildFlags.preload != "":
+		return fmt.Errorf("--preload is not a link option; set LD_PRELOAD when running the program instead")
+	case (buildFlags.framework != "" || buildFlags.frameworkPath != "") && buildFlags.targetOS != "darwin":
+		return fmt.Errorf("--framework and --framework-path only apply to darwin targets")
+	}
+	switch buildFlags.hashStyle {
+	case "", "sysv", "gnu", "both":
+	default:
+		return fmt.Errorf("unknown hash style '%s' (use sysv, gnu or both)", buildFlags.hashStyle)
+	}
+	switch id := buildFlags.buildID; {
+	case id == "", id == "none", id == "fast", id == "md5", id == "sha1", id == "uuid", strings.HasPrefix(id, "0x"):
+	default:
+		return fmt.Errorf("unknown build ID style '%s' (use none, fast, md5, sha1, uuid or a 0x hex string)", id)
+	}
+	for flag, file := range map[string]string{"--version-script": buildFlags.versionScript, "--dynamic-list": buildFlags.dynamicList} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("%s file %s: %v", flag, file, err)
+		}
+	}
+	if linking {
+		if _, _, err := linkerDriver(); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if buildFlags.strip {
-		args = append(args, "-s")
+// flagGiven reports whether the flag was set on the command line, where it
+// wins over aether.toml.
+func flagGiven(name string) bool {
+	return buildFlags.given != nil && buildFlags.given(name)
+}
+
+// linkerNames maps the linkers --linker takes to the -fuse-ld name the C
+// compiler knows them by and the program it runs.
+var linkerNames = map[string][2]string{
+	"mold": {"mold", "mold"},
+	"lld":  {"lld", "ld.lld"},
+	"gold": {"gold", "ld.gold"},
+	"ld":   {"bfd", "ld.bfd"},
+	"bfd":  {"bfd", "ld.bfd"},
+}
+
+// linkerDriver returns the C compiler that runs the link, which also
+// brings in the C runtime's startup files, and the flags that choose the
+// target and the linker for it. $CC overrides the compiler.
+func linkerDriver() (string, []string, error) {
+	driver := os.Getenv("CC")
+	if driver == "" {
+		driver = "cc"
+	}
+	var args []string
+	triple, err := compiler_pkg.TargetTriple(buildFlags.targetOS, buildFlags.targetArch)
+	if err != nil {
+		return "", nil, err
+	}
+	host, _ := compiler_pkg.TargetTriple(runtime.GOOS, runtime.GOARCH)
+	needsClang := ""
+	if triple != host {
+		needsClang = fmt.Sprintf("linking for %s-%s", buildFlags.targetOS, buildFlags.targetArch)
+		args = append(args, "--target="+triple)
+	}
+	for _, s := range splitList(buildFlags.sanitize) {
+		if s == "memory" {
+			needsClang = "the memory sanitizer's runtime"
+		}
+	}
+	if needsClang != "" && !strings.Contains(filepath.Base(driver), "clang") {
+		if _, err := exec.LookPath("clang"); err != nil {
+			return "", nil, fmt.Errorf("%s needs clang, which is not installed; install it or set CC, or build objects only with --emit-obj --emit-exe=false", needsClang)
+		}
+		driver = "clang"
+	}
+	if _, err := exec.LookPath(driver); err != nil {
+		return "", nil, fmt.Errorf("no C compiler to link with: %s not found in PATH (set CC)", driver)
 	}
 
-	// Add sanitizer flags
-	if buildFlags.sanitize != "" {
-		args = append(args, "-fsanitize="+buildFlags.sanitize)
+	linker := buildFlags.linker
+	if buildFlags.fuseLd != "" {
+		linker = buildFlags.fuseLd
+	}
+	if linker != "" {
+		names, ok := linkerNames[linker]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown linker '%s' (use mold, lld, gold or ld)", linker)
+		}
+		if _, err := exec.LookPath(names[1]); err != nil {
+			return "", nil, fmt.Errorf("linker '%s' not found in PATH; install it or choose another with --linker (mold, lld, gold or ld)", linker)
+		}
+		args = append(args, "-fuse-ld="+names[0])
 	}
+	return driver, args, nil
+}
 
-	// Add linking flags
-	if buildFlags.static {
+func linkObjectFiles(objectFiles []string, output string, libs []string) {
+	driver, args, err := linkerDriver()
+	must(err)
+	must(os.MkdirAll(filepath.Dir(output), 0755))
+	args = append(args, objectFiles...)
+	args = append(args, "-o", output)
+
+	// Position independence: objects are PIC unless --reloc-model says
+	// otherwise, which the C compiler's default of PIE cannot link.
+	switch {
+	case buildFlags.static && buildFlags.pie:
+		args = append(args, "-static-pie")
+	case buildFlags.static:
 		args = append(args, "-static")
+	case buildFlags.pie:
+		args = append(args, "-pie")
+	case buildFlags.relocModel != "" && buildFlags.relocModel != "pic":
+		args = append(args, "-no-pie")
 	}
-
 	if buildFlags.shared {
 		args = append(args, "-shared")
 	}
 
-	if buildFlags.pie {
-		args = append(args, "-pie")
+	// Add debug flags
+	if buildFlags.debugSymbols {
+		args = append(args, "-g")
+	}
+	if buildFlags.strip {
+		args = append(args, "-s")
 	}
 
-	if buildFlags.rdynamic {
-		args = append(args, "-rdynamic")
+	// Add sanitizer runtimes
+	if buildFlags.sanitize != "" {
+		args = append(args, "-fsanitize="+strings.Join(splitList(buildFlags.sanitize), ","))
 	}
 
-	if buildFlags.exportDynamic {
-		args = append(args, "-export-dynamic")
+	// Add symbol export flags
+	if buildFlags.rdynamic || buildFlags.exportDynamic {
+		args = append(args, "-rdynamic")
 	}
 
 	// Add library flags
 	if buildFlags.nostdlib {
 		args = append(args, "-nostdlib")
 	}
-
-	if buildFlags.nodefaultlibs {
+	if buildFlags.nodefaultlibs || buildFlags.noDefaultLibs {
 		args = append(args, "-nodefaultlibs")
 	}
-
-	if buildFlags.nostartfiles {
+	if buildFlags.nostartfiles || buildFlags.noStartFiles {
 		args = append(args, "-nostartfiles")
 	}
 
-	// Add archive flags
-	if buildFlags.wholeArchive {
-		args = append(args, "-whole-archive")
-	}
+	args = append(args, linkerArgs()...)
 
-	if buildFlags.noWholeArchive {
-		args = append(args, "-no-whole-archive")
+	// Add library paths and libraries; --as-needed and --whole-archive
+	// apply to the libraries after them.
+	for _, dir := range splitList(buildFlags.libraryPath) {
+		args = append(args, "-L"+dir)
 	}
-
 	if buildFlags.asNeeded {
-		args = append(args, "-as-needed")
+		args = append(args, "-Wl,--as-needed")
 	}
-
 	if buildFlags.noAsNeeded {
-		args = append(args, "-no-as-needed")
+		args = append(args, "-Wl,--no-as-needed")
 	}
-
-	// Add runtime flags
-	if buildFlags.rpath != "" {
-		args = append(args, "-rpath", buildFlags.rpath)
+	if buildFlags.wholeArchive {
+		args = append(args, "-Wl,--whole-archive")
 	}
-
-	if buildFlags.rpathLink != "" {
-		args = append(args, "-rpath-link", buildFlags.rpathLink)
+	for _, lib := range append(splitList(buildFlags.library), libs...) {
+		args = append(args, "-l"+lib)
 	}
-
-	if buildFlags.soname != "" {
-		args = append(args, "-soname", buildFlags.soname)
+	if buildFlags.wholeArchive {
+		args = append(args, "-Wl,--no-whole-archive")
 	}
 
-	// Add library paths and libraries
-	if buildFlags.libraryPath != "" {
-		args = append(args, "-L"+buildFlags.libraryPath)
+	for _, framework := range splitList(buildFlags.framework) {
+		args = append(args, "-framework", framework)
 	}
-
-	if buildFlags.library != "" {
-		args = append(args, "-l"+buildFlags.library)
+	for _, dir := range splitList(buildFlags.frameworkPath) {
+		args = append(args, "-F"+dir)
 	}
 
-	if buildFlags.framework != "" {
-		args = append(args, "-framework", buildFlags.framework)
+	cmd := exec.Command(driver, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if buildFlags.verbose {
+		fmt.Println("   ", cmd.String())
 	}
+	must(cmd.Run())
+}
 
-	if buildFlags.frameworkPath != "" {
-		args = append(args, "-F"+buildFlags.frameworkPath)
+// linkerArgs returns the flags the C compiler passes on to the linker.
+func linkerArgs() []string {
+	var args []string
+	wl := func(flags ...string) {
+		args = append(args, "-Wl,"+strings.Join(flags, ","))
+	}
+	if buildFlags.buildID != "" {
+		wl("--build-id=" + buildFlags.buildID)
+	}
+	if buildFlags.hashStyle != "" {
+		wl("--hash-style=" + buildFlags.hashStyle)
+	}
+	if buildFlags.noEhFrameHdr {
+		wl("--no-eh-frame-hdr")
+	} else if flagGiven("eh-frame-hdr") {
+		wl("--eh-frame-hdr")
+	}
+	if buildFlags.excludeLibsAll != "" {
+		wl("--exclude-libs=ALL")
+	} else if buildFlags.excludeLibs != "" {
+		wl("--exclude-libs=" + buildFlags.excludeLibs)
+	}
+	for _, dir := range splitList(buildFlags.rpath) {
+		wl("-rpath", dir)
+	}
+	for _, dir := range splitList(buildFlags.rpathLink) {
+		wl("-rpath-link", dir)
+	}
+	if buildFlags.soname != "" {
+		wl("-soname", buildFlags.soname)
+	}
+	if buildFlags.versionScript != "" {
+		wl("--version-script=" + buildFlags.versionScript)
+	}
+	if buildFlags.dynamicList != "" {
+		wl("--dynamic-list=" + buildFlags.dynamicList)
 	}
-
-	// Add initialization flags
 	if buildFlags.init != "" {
-		args = append(args, "-init", buildFlags.init)
+		wl("-init", buildFlags.init)
 	}
-
 	if buildFlags.fini != "" {
-		args = append(args, "-fini", buildFlags.fini)
+		wl("-fini", buildFlags.fini)
 	}
-
-	if buildFlags.preload != "" {
-		args = append(args, "-preload", buildFlags.preload)
+	for _, symbol := range splitList(buildFlags.wrap) {
+		wl("--wrap=" + symbol)
 	}
-
-	if buildFlags.wrap != "" {
-		args = append(args, "-wrap", buildFlags.wrap)
+	if buildFlags.demangle {
+		wl("--demangle")
 	}
+	return args
+}
 
-	// Add output control flags
-	if buildFlags.demangle {
-		args = append(args, "--demangle")
+// getDefaultLinker returns mold when it is installed; otherwise the C
+// compiler uses its own default.
+func getDefaultLinker() string {
+	if runtime.GOOS == "windows" {
+		return "lld"
+	}
+	if _, err := exec.LookPath("mold"); err == nil {
+		return "mold"
 	}
+	return ""
+}
 
-	cmd := exec.Command(buildFlags.linker, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	must(cmd.Run())
+// moduleNameOf is the name of the module defined by the source file path:
+// its file name without the .aeth or .ae extension.
+func moduleNameOf(path string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".aeth"), ".ae")
 }
 
-func addTargetLibraries(args *[]string) {
-	switch buildFlags.targetOS {
-	case "linux":
-		switch buildFlags.targetArch {
-		case "amd64":
-			*args = append(*args, "-L/usr/lib/x86_64-linux-gnu", "-L/usr/lib", "/usr/lib/x86_64-linux-gnu/crt1.o", "/usr/lib/x86_64-linux-gnu/crti.o", "-lc", "/usr/lib/x86_64-linux-gnu/crtn.o")
-		case "arm64":
-			*args = append(*args, "-L/usr/lib/aarch64-linux-gnu", "-L/usr/lib", "/usr/lib/aarch64-linux-gnu/crt1.o", "/usr/lib/aarch64-linux-gnu/crti.o", "-lc", "/usr/lib/aarch64-linux-gnu/crtn.o")
-		default:
-			*args = append(*args, "-lc")
+// entryFileOf returns the file the program starts in: the main module when
+// one is built, or else the first file.
+func entryFileOf(files []string) string {
+	for _, file := range files {
+		if moduleNameOf(file) == "main" {
+			return file
 		}
-	case "darwin":
-		*args = append(*args, "-L/usr/lib", "-lc")
-	case "windows":
-		*args = append(*args, "-lkernel32", "-lmsvcrt", "-lucrt", "-loldnames")
 	}
+	if len(files) == 0 {
+		return ""
+	}
+	return files[0]
 }
 
-func getDefaultLinker() string {
-	if runtime.GOOS == "windows" {
-		return "lld"
+// scanModules scans files, which are in dependency order, and returns the
+// exports of every module by name, with the parameter types the importers
+// fix, the C headers each file includes and the modules that parse, for
+// the call graph.
+func scanModules(files []string, entryFile string) (map[string]map[string]interface{}, map[string][]analysis.CInclude, []analysis.ProjectModule) {
+	moduleSymbols := make(map[string]map[string]interface{})
+	fileIncludes := make(map[string][]analysis.CInclude)
+	var modules []analysis.ProjectModule
+	for _, file := range files {
+		exports, includes, module := scanModule(file, file == entryFile, moduleSymbols)
+		fileIncludes[file] = includes
+		if module != nil {
+			moduleSymbols[moduleNameOf(file)] = exports
+			modules = append(modules, *module)
+		}
 	}
-	return "mold"
+	analysis.ResolveOpenTypes(modules, moduleSymbols)
+	return moduleSymbols, fileIncludes, modules
 }
 
-func extractModuleSymbols(prog *parser.Program) map[string]interface{} {
-	symbols := make(map[string]interface{})
+// scanModule parses file, the entry file when entry is set, and returns
+// what it exports, the C headers it includes and, when it parses, the
+// module for the call graph. imports
+// holds the exports of the modules scanned before, which include file's
+// dependencies. Parse errors are reported when the file itself is compiled;
+// a file that does not parse exports nothing.
+func scanModule(file string, entry bool, imports map[string]map[string]interface{}) (map[string]interface{}, []analysis.CInclude, *analysis.ProjectModule) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, nil
+	}
+	p := parser.NewParser(lexer.NewLexer(string(content)))
+	p.SetFile(file)
+	prog := p.Parse()
+	var includes []analysis.CInclude
 	for _, stmt := range prog.Statements {
-		if assign, ok := stmt.(*parser.Assignment); ok {
-			if len(assign.Names) > 0 && assign.Names[0].Value[0] >= 'A' && assign.Names[0].Value[0] <= 'Z' {
-				// This is an exported symbol
-				symbols[assign.Names[0].Value] = assign.Value
-			}
+		if c, ok := stmt.(*parser.CComment); ok {
+			includes = append(includes, analysis.ParseCIncludes(c.Content)...)
 		}
 	}
-	return symbols
+	if p.Errors.Len() > 0 {
+		return nil, includes, nil
+	}
+	module := &analysis.ProjectModule{
+		Name:   moduleNameOf(file),
+		File:   file,
+		Source: string(content),
+		Prog:   prog,
+		Entry:  entry,
+	}
+	return analysis.ModuleExports(prog, imports), includes, module
 }
 
 // New library creation functions
+// resolveImportGraph follows imports, which maps the files to build to the
+// names they import, to the module files those names resolve to and their
+// own imports in turn. It returns the graph of every file to the files it
+// imports, and the imported module files.
+func resolveImportGraph(imports map[string][]string, projectRoot string) (map[string][]string, []string) {
+	// Resolve import paths to actual files
+	importedFiles, err := analysis.ResolveImportPathsToFiles(imports, projectRoot)
+	must(err)
+
+	// Imported modules are part of the graph too, with their own imports.
+	for pending := importedFiles; len(pending) > 0; {
+		var fresh []string
+		for _, f := range pending {
+			if _, seen := imports[f]; !seen {
+				fresh = append(fresh, f)
+			}
+		}
+		more, err := analysis.AnalyzeImports(fresh)
+		must(err)
+		for f, deps := range more {
+			imports[f] = deps
+		}
+		pending, err = analysis.ResolveImportPathsToFiles(more, projectRoot)
+		must(err)
+		importedFiles = append(importedFiles, pending...)
+	}
+
+	// Create a mapping from import names to resolved file paths. The
+	// files being built are modules too, and may import each other.
+	importNameToPath := make(map[string]string)
+	for sourceFile := range imports {
+		importNameToPath[moduleNameOf(sourceFile)] = sourceFile
+	}
+	for _, importPath := range importedFiles {
+		importNameToPath[moduleNameOf(importPath)] = importPath
+	}
+
+	// Update imports map to use resolved file paths instead of import names
+	resolvedImports := make(map[string][]string)
+	for sourceFile, importNames := range imports {
+		var resolvedPaths []string
+		for _, importName := range importNames {
+			if resolvedPath, exists := importNameToPath[importName]; exists {
+				resolvedPaths = append(resolvedPaths, resolvedPath)
+			}
+		}
+		resolvedImports[sourceFile] = resolvedPaths
+	}
+	return resolvedImports, importedFiles
+}
+
 func createLibrary(objectFiles []string, outputBase string, libType string) {
 	switch libType {
 	case "shared":
@@ -1114,32 +1602,36 @@ func createSharedLibrary(objectFiles []string, outputBase string) {
 	libName := getLibraryName(outputBase)
 	outputFile := getSharedLibraryPath(libName)
 
-	args := append(objectFiles, "-o", outputFile)
+	driver, args, err := linkerDriver()
+	must(err)
+	args = append(args, objectFiles...)
+	args = append(args, "-o", outputFile)
 
 	// Add shared library specific flags
 	args = append(args, "-shared")
 
-	// Add position independent code
-	args = append(args, "-fPIC")
-
-	// Add soname if specified
-	if buildFlags.soname != "" {
-		args = append(args, "-soname", buildFlags.soname)
-	} else {
-		args = append(args, "-soname", libName)
+	// The soname defaults to the library's file name
+	if buildFlags.soname == "" {
+		args = append(args, "-Wl,-soname,"+libName)
 	}
 
 	// Add export symbols if requested
 	if buildFlags.exportSymbols {
-		args = append(args, "-export-dynamic")
+		args = append(args, "-rdynamic")
 	}
 
-	// Use mold for fast linking
-	if buildFlags.fuseLd != "" {
-		args = append([]string{"-fuse-ld=" + buildFlags.fuseLd}, args...)
+	if buildFlags.sanitize != "" {
+		args = append(args, "-fsanitize="+strings.Join(splitList(buildFlags.sanitize), ","))
+	}
+	args = append(args, linkerArgs()...)
+	for _, dir := range splitList(buildFlags.libraryPath) {
+		args = append(args, "-L"+dir)
+	}
+	for _, lib := range splitList(buildFlags.library) {
+		args = append(args, "-l"+lib)
 	}
 
-	cmd := exec.Command(buildFlags.linker, args...)
+	cmd := exec.Command(driver, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	must(cmd.Run())
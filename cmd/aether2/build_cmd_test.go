@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+)
+
+func TestModuleNameOf(t *testing.T) {
+	for path, want := range map[string]string{
+		"src/main.aeth":    "main",
+		"lib/mathx.ae":     "mathx",
+		"geo.aeth":         "geo",
+		"vendor/util.aeth": "util",
+	} {
+		if got := moduleNameOf(path); got != want {
+			t.Errorf("moduleNameOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestEntryFileOf(t *testing.T) {
+	if got := entryFileOf([]string{"src/geo.aeth", "src/main.aeth"}); got != "src/main.aeth" {
+		t.Errorf("expected the main module to be the entry, got %q", got)
+	}
+	if got := entryFileOf([]string{"app.aeth", "util.aeth"}); got != "app.aeth" {
+		t.Errorf("expected the first file to be the entry, got %q", got)
+	}
+}
+
+func TestBuildResolvesAethProject(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "src", "main.aeth")
+	mathxFile := filepath.Join(dir, "src", "mathx.aeth")
+	for file, src := range map[string]string{
+		filepath.Join(dir, "aether.toml"): "[package]\nname = \"demo\"\n",
+		mainFile:                          "import mathx\nprint(mathx.greet(\"bob\"))\n",
+		mathxFile:                         "func greet(name) {\n    return name\n}\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graph, _ := resolveImportGraph(map[string][]string{mainFile: {"mathx"}, mathxFile: nil}, dir)
+	if deps := graph[mainFile]; len(deps) != 1 || deps[0] != mathxFile {
+		t.Fatalf("expected main to import %s, got %v", mathxFile, deps)
+	}
+
+	symbols, _, modules := scanModules([]string{mathxFile, mainFile}, mainFile)
+	if len(modules) != 2 || modules[0].Name != "mathx" || modules[1].Name != "main" {
+		t.Fatalf("expected modules mathx and main, got %+v", modules)
+	}
+	greet, ok := symbols["mathx"]["greet"].(analysis.FunctionInfo)
+	if !ok || greet.Parameters[0].Type != "string" {
+		t.Errorf("expected greet to take the string main passes, got %+v", symbols["mathx"]["greet"])
+	}
+	calls := analysis.BuildCallGraph(modules, symbols, false)
+	if !calls.ModuleReachable("mathx") {
+		t.Error("expected mathx to be reachable from main")
+	}
+	if !calls.Live("mathx")["greet"] {
+		t.Error("expected greet to be compiled")
+	}
+}
+
+func TestScanModulesSkipsFilesThatDoNotParse(t *testing.T) {
+	mainFile := filepath.Join(t.TempDir(), "main.aeth")
+	if err := os.WriteFile(mainFile, []byte("x = 1\nmatch x {\n    case 1 {\n}\nprint(x)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symbols, _, modules := scanModules([]string{mainFile}, mainFile)
+	if len(modules) != 0 || symbols["main"] != nil {
+		t.Errorf("expected no module or exports for a file with parse errors, got %+v and %+v", modules, symbols)
+	}
+}
+
+func TestBuildLinksLibrariesOfDependencyPackages(t *testing.T) {
+	if _, err := exec.LookPath("llc"); err != nil {
+		t.Skip("llc not found")
+	}
+	math, err := os.ReadFile("../../packages/c/src/math.ae")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	// At -O0 pow is not folded away, so the binary links only with libm.
+	for file, src := range map[string]string{
+		"aether.toml":      "[project]\nname = \"roots\"\n\n[build]\nsource_directories = [\"src\"]\noptimization = \"0\"\n\n[dependencies]\nmath = \"packages/math.ae\"\n",
+		"packages/math.ae": string(math),
+		"src/main.aeth":    "import math\n\nfunc root(x) {\n    return pow(x, 0.5)\n}\n\nprint(root(16.0))\n",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Chdir(dir)
+	doBuild(nil)
+	out, err := exec.Command(filepath.Join(dir, "bin", "aether.out")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("running the program: %v\n%s", err, out)
+	}
+	if string(out) != "4\n" {
+		t.Errorf("got %q, want %q", out, "4\n")
+	}
+}
+
+func TestRunLLVMReportsToolMessages(t *testing.T) {
+	if _, err := exec.LookPath("llc"); err != nil {
+		t.Skip("llc not found")
+	}
+	ll := filepath.Join(t.TempDir(), "main.ll")
+	if err := os.WriteFile(ll, []byte("define i32 @main() {\n  ret i32 0\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := runLLVM(exec.Command("llc", "-mtriple=x86_64-unknown-linux-gnu", "-mcpu=bogus", ll, "-o", ll+".s"))
+	if err == nil {
+		t.Skip("llc accepted -mcpu=bogus")
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "llc failed: ") || !strings.Contains(msg, "'bogus' is not a recognized processor") || strings.Contains(msg, "Stack dump") {
+		t.Errorf("expected llc's messages without its stack dump, got %q", msg)
+	}
+}
@@ -2,22 +2,125 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"aether/lib/utils"
+	"aether/src/analysis"
+	"aether/src/scheduler"
+
 	"github.com/spf13/cobra"
 )
 
+var depsFlags struct {
+	graph   string
+	output  string
+	library bool
+}
+
+func init() {
+	flags := DepsCmd.Flags()
+	flags.StringVar(&depsFlags.graph, "graph", "", "print the call graph of the project instead (dot, json)")
+	flags.StringVarP(&depsFlags.output, "output", "o", "", "write the call graph to a file instead of stdout")
+	flags.BoolVar(&depsFlags.library, "library", false, "treat the exported functions of every module as used, as for a library")
+}
+
 var DepsCmd = &cobra.Command{
-	Use:   "deps",
+	Use:   "deps [files...]",
 	Short: "Resolve and update dependencies",
+	Long: `Resolve the dependencies in aether.toml and update aether.lock.
+
+With --graph, print the call graph of the project instead: which functions
+and modules refer to which, starting from main (and, with --library, the
+exported functions). What never runs is marked unreachable, as it is left
+out of the build.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if depsFlags.graph != "" {
+			doDepsGraph(args)
+			return
+		}
 		doDeps()
 	},
 }
 
+func doDepsGraph(args []string) {
+	if depsFlags.graph != "dot" && depsFlags.graph != "json" {
+		fmt.Printf("Error: Unknown graph format '%s' (use dot or json)\n", depsFlags.graph)
+		os.Exit(1)
+	}
+
+	var files []string
+	projectRoot := findProjectRoot(".")
+	if len(args) == 0 {
+		sourceDirs := loadProjectConfig(projectRoot).Build.SourceDirectories
+		if len(sourceDirs) == 0 {
+			sourceDirs = []string{"src", "."}
+		}
+		for _, sourceDir := range sourceDirs {
+			found, err := analysis.FindAetherFiles(sourceDir)
+			if err == nil && len(found) > 0 {
+				files = found
+				break
+			}
+		}
+	}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("Error: Cannot access '%s': %v\n", arg, err)
+			os.Exit(1)
+		}
+		if info.IsDir() {
+			found, err := analysis.FindAetherFiles(arg)
+			must(err)
+			files = append(files, found...)
+			projectRoot = findProjectRoot(arg)
+		} else {
+			files = append(files, arg)
+			projectRoot = findProjectRoot(filepath.Dir(arg))
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("No Aether files found.")
+		os.Exit(1)
+	}
+
+	imports, err := analysis.AnalyzeImports(files)
+	must(err)
+	resolved, _ := resolveImportGraph(imports, projectRoot)
+	if errs := analysis.ImportCycles(resolved); len(errs) > 0 {
+		fmt.Println("Error: Circular imports detected")
+		fmt.Print(utils.FormatErrorSummary(utils.GroupErrorsByFile(errs)))
+		os.Exit(1)
+	}
+	sorted, err := scheduler.TopoSort(resolved)
+	must(err)
+
+	moduleSymbols, _, modules := scanModules(sorted, files[0])
+	if len(modules) != len(sorted) {
+		fmt.Println("Error: Some modules do not parse; run aether build to see why")
+		os.Exit(1)
+	}
+	graph := analysis.BuildCallGraph(modules, moduleSymbols, depsFlags.library)
+
+	var out []byte
+	if depsFlags.graph == "dot" {
+		out = []byte(graph.DOT())
+	} else {
+		out, err = json.MarshalIndent(graph, "", "  ")
+		must(err)
+		out = append(out, '\n')
+	}
+	if depsFlags.output == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	must(os.WriteFile(depsFlags.output, out, 0644))
+}
+
 func doDeps() {
 	fmt.Println("Resolving dependencies...")
 	data, err := os.ReadFile("aether.toml")
@@ -153,7 +153,7 @@ source_directories = ["%s"]
 output_directory = "%s"
 target = "native"
 optimization = "2"
-linker = "mold"
+# linker = "mold"          # mold, lld, gold, ld; mold when installed
 create_library = false
 library_type = "shared"
 
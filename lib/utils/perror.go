@@ -13,6 +13,18 @@ const (
 	InvalidNumber
 	UnexpectedSemicolon // New error kind for semicolons
 	UndefinedReference // New error kind for undefined references
+	TypeMismatch       // A value whose type does not fit where it is used
+	ShadowedName       // A binding that hides another of the same name (a warning)
+	ArityMismatch      // A call with more or fewer arguments than its callee takes
+	BorrowConflict     // A use of a value that another name has borrowed
+	UnreachableCode    // Code no path of its function reaches (a warning)
+	MissingReturn      // A function that returns a value on some paths only (a warning)
+	UnassignedUse      // A read of a variable some path has not assigned (a warning)
+	DivisionByZero     // An integer division or remainder by a constant zero
+	ConstantCondition  // A condition whose value is known at compile time (a warning)
+	ImportCycle        // An import that is part of a cycle of modules importing each other
+	UnusedCode         // A function or module that never runs and is left out (a warning)
+	InternalError      // A bug in the compiler, reported at the code it was compiling
 )
 
 type ParseError struct {
@@ -68,6 +80,30 @@ func getErrorType(kind ErrorKind) string {
 		return "SyntaxError"
 	case UndefinedReference:
 		return "UndefinedReference"
+	case TypeMismatch:
+		return "TypeError"
+	case ShadowedName:
+		return "ShadowWarning"
+	case ArityMismatch:
+		return "ArityError"
+	case BorrowConflict:
+		return "BorrowError"
+	case UnreachableCode:
+		return "UnreachableWarning"
+	case MissingReturn:
+		return "ReturnWarning"
+	case UnassignedUse:
+		return "UnassignedWarning"
+	case DivisionByZero:
+		return "ArithmeticError"
+	case ConstantCondition:
+		return "ConditionWarning"
+	case ImportCycle:
+		return "ImportError"
+	case UnusedCode:
+		return "UnusedWarning"
+	case InternalError:
+		return "InternalCompilerError"
 	default:
 		return "Error"
 	}
@@ -44,13 +44,11 @@ func AnalyzeProject(projectPath string) *AnalysisResult {
 	}
 
 	validateImports(result)
-	checkDependencies(result)
 
-	cycles := scheduler.DetectCycles(result.Dependencies)
-	if len(cycles) > 0 {
+	if cycles := scheduler.DetectCycles(result.Dependencies); len(cycles) > 0 {
 		result.Cycles = cycles
 		result.Valid = false
-		result.Errors = append(result.Errors, utils.ParseError{Message: "Circular dependency detected"})
+		result.Errors = append(result.Errors, ImportCycles(result.Dependencies)...)
 	}
 
 	checkUnusedDeclarations(result)
@@ -204,7 +202,7 @@ func AnalyzeDependencies(projectPath string) *DependencyAnalysis {
 	}
 
 	importedModules := make(map[string]bool)
-	moduleFunctions := make(map[string]map[string]bool)
+	moduleExports := make(map[string]map[string]interface{})
 
 	for _, file := range files {
 		content, err := os.ReadFile(file)
@@ -243,7 +241,6 @@ func AnalyzeDependencies(projectPath string) *DependencyAnalysis {
 
 		// Extract imports and functions from AST
 		currentModuleName := strings.TrimSuffix(filepath.Base(file), ".ae")
-		moduleFunctions[currentModuleName] = make(map[string]bool)
 
 		for _, stmt := range ast.Statements {
 			if importStmt, ok := stmt.(*parser.Import); ok {
@@ -254,16 +251,8 @@ func AnalyzeDependencies(projectPath string) *DependencyAnalysis {
 				importedModules[importedModuleName] = true
 			}
 
-			if assign, ok := stmt.(*parser.Assignment); ok {
-				if len(assign.Names) > 0 {
-					moduleFunctions[currentModuleName][assign.Names[0].Value] = true
-				}
-			}
-
-			if funcDecl, ok := stmt.(*parser.Function); ok {
-				moduleFunctions[currentModuleName][funcDecl.Name.Value] = true
-			}
 		}
+		moduleExports[currentModuleName] = ModuleExports(ast, nil)
 	}
 
 	// Validate imports against declared dependencies
@@ -281,6 +270,13 @@ func AnalyzeDependencies(projectPath string) *DependencyAnalysis {
 				})
 			} else {
 				result.ResolvedDeps[moduleName] = fullDepPath
+				// The functions of a dependency, including the C functions
+				// it binds, may be called from the importing files.
+				if content, err := os.ReadFile(fullDepPath); err == nil {
+					dep := parser.NewParser(lexer.NewLexer(string(content))).Parse()
+					depName := strings.TrimSuffix(filepath.Base(fullDepPath), ".ae")
+					moduleExports[depName] = ModuleExports(dep, nil)
+				}
 			}
 		} else {
 			result.Valid = false
@@ -308,7 +304,7 @@ func AnalyzeDependencies(projectPath string) *DependencyAnalysis {
 		}
 	}
 
-	// Validate function calls against available functions
+	// Check the names, types and calls of each file
 	for _, file := range files {
 		content, err := os.ReadFile(file)
 		if err != nil {
@@ -323,8 +319,35 @@ func AnalyzeDependencies(projectPath string) *DependencyAnalysis {
 			continue
 		}
 
-		// Check all function calls in this file
-		validateFunctionCalls(ast, moduleFunctions, result)
+		res := Resolve(ast, file, string(content), moduleExports)
+		if len(res.Errors) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, res.Errors...)
+		}
+		for _, w := range res.Warnings {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d:%d: %s", file, w.Line, w.Column, w.Message))
+		}
+
+		if errs := CheckTypeNames(ast, file); len(errs) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, errs...)
+		}
+		if errs := CheckTypes(ast, file, string(content), moduleExports); len(errs) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, errs...)
+		}
+		if errs := CheckCalls(ast, file, string(content), moduleExports); len(errs) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, errs...)
+		}
+		if errs := CheckBorrows(ast, file, string(content), moduleExports); len(errs) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, errs...)
+		}
+		if errs := CheckConstants(ast, file, string(content), moduleExports); len(errs) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, errs...)
+		}
 	}
 
 	// Check for unused dependencies
@@ -404,19 +427,72 @@ func analyzeFile(filePath string, result *AnalysisResult) {
 		return
 	}
 
-	analyzeAST(ast, filePath, result)
+	analyzeAST(ast, filePath, string(content), result)
 }
 
-func analyzeAST(ast *parser.Program, filePath string, result *AnalysisResult) {
+func analyzeAST(ast *parser.Program, filePath, source string, result *AnalysisResult) {
 	for _, stmt := range ast.Statements {
 		analyzeStatement(stmt, filePath, result)
 	}
+	resolveNames(ast, filePath, source, result)
+	fillInferredTypes(ast, result)
+	result.Errors = append(result.Errors, CheckTypeNames(ast, filePath)...)
+	for _, w := range CheckFlow(ast, filePath, source, nil) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d:%d: %s", filePath, w.Line, w.Column, w.Message))
+	}
+}
+
+// resolveNames reports the undefined names of ast, and the bindings that
+// shadow another, found by resolving it scope by scope.
+func resolveNames(ast *parser.Program, filePath, source string, result *AnalysisResult) {
+	res := Resolve(ast, filePath, source, nil)
+	for _, err := range res.Errors {
+		result.Undefined = append(result.Undefined, fmt.Sprintf("%s:%d:%d: %s", filePath, err.Line, err.Column, err.Message))
+		result.Errors = append(result.Errors, err)
+	}
+	for _, w := range res.Warnings {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("%s:%d:%d: %s", filePath, w.Line, w.Column, w.Message))
+	}
+}
+
+// fillInferredTypes sets the types that ast leaves unannotated on the
+// functions and variables it declares.
+func fillInferredTypes(ast *parser.Program, result *AnalysisResult) {
+	table := InferTypes(ast, nil)
+	for _, stmt := range ast.Statements {
+		fn, ok := stmt.(*parser.Function)
+		if !ok || fn.Name == nil {
+			continue
+		}
+		info, ok := result.Functions[fn.Name.Value]
+		if !ok {
+			continue
+		}
+		if t := table.Return(fn); t != "" {
+			info.ReturnType = t
+		}
+		for i, t := range table.Params(fn) {
+			if i < len(info.Parameters) && info.Parameters[i].Type == "" {
+				info.Parameters[i].Type = t
+			}
+		}
+		result.Functions[fn.Name.Value] = info
+	}
+	for name, info := range result.Variables {
+		if info.Type == "" {
+			info.Type = table.Variable(name)
+			result.Variables[name] = info
+		}
+	}
 }
 
 func analyzeStatement(stmt parser.Statement, filePath string, result *AnalysisResult) {
 	switch s := stmt.(type) {
 	case *parser.Import:
 		analyzeImportStatement(s, filePath, result)
+		if info := result.Imports[s.Name.Value]; info.Exists && info.Resolved != "" {
+			result.Dependencies[filePath] = append(result.Dependencies[filePath], info.Resolved)
+		}
 	case *parser.Function:
 		analyzeFunctionDeclaration(s, filePath, result)
 	case *parser.Assignment:
@@ -437,9 +513,15 @@ func analyzeStatement(stmt parser.Statement, filePath string, result *AnalysisRe
 		analyzeBlock(s, filePath, result)
 	case *parser.CComment:
 		analyzeCComment(s, filePath, result)
+	case *parser.ForeignFunction:
+		analyzeForeignFunction(s, result)
 	}
 }
 
+func analyzeForeignFunction(f *parser.ForeignFunction, result *AnalysisResult) {
+	result.Functions[f.Name.Value] = foreignFunctionInfo(f)
+}
+
 func analyzeImportStatement(importStmt *parser.Import, filePath string, result *AnalysisResult) {
 	importPath := importStmt.Name.Value
 	importInfo := ImportInfo{
@@ -544,18 +626,11 @@ func analyzeFunctionCall(call *parser.Call, filePath string, result *AnalysisRes
 	if ident, ok := call.Function.(*parser.Identifier); ok {
 		funcName := ident.Value
 
+		// Undefined functions are reported by resolveNames, which
+		// knows the scopes they are called from.
 		if funcInfo, exists := result.Functions[funcName]; exists {
 			funcInfo.Used = true
 			result.Functions[funcName] = funcInfo
-		} else if !isStdlibFunction(funcName) {
-			// Enhanced error: function not found in any imported binding/module
-			result.Undefined = append(result.Undefined, fmt.Sprintf("function '%s'", funcName))
-			result.Errors = append(result.Errors, utils.ParseError{
-				Kind:    utils.UndefinedReference,
-				Message: "undefined reference to function '" + funcName + "' in imported binding or file. (Aether imports are not modules unless HMR is enabled.) Check spelling or update your binding.",
-				File:    filePath,
-				Fix:     "Check the spelling or update your binding.",
-			})
 		}
 	}
 
@@ -570,7 +645,7 @@ func analyzeAssignment(assign *parser.Assignment, filePath string, result *Analy
 			varInfo.Used = true
 			result.Variables[assign.Names[0].Value] = varInfo
 		} else {
-			result.Undefined = append(result.Undefined, fmt.Sprintf("variable '%s'", assign.Names[0].Value))
+			analyzeVariableDeclaration(assign, filePath, result)
 		}
 
 		analyzeExpression(assign.Value, filePath, result)
@@ -661,6 +736,10 @@ func analyzeExpression(expr parser.Expression, filePath string, result *Analysis
 		for _, elem := range e.Elements {
 			analyzeExpression(elem, filePath, result)
 		}
+	case *parser.Tuple:
+		for _, elem := range e.Elements {
+			analyzeExpression(elem, filePath, result)
+		}
 	case *parser.PropertyAccess:
 		analyzeExpression(e.Object, filePath, result)
 	case *parser.PartialApplication:
@@ -686,8 +765,6 @@ func analyzeIdentifier(ident *parser.Identifier, filePath string, result *Analys
 	} else if typeInfo, exists := result.Types[name]; exists {
 		typeInfo.Used = true
 		result.Types[name] = typeInfo
-	} else if !isStdlibFunction(name) {
-		result.Undefined = append(result.Undefined, fmt.Sprintf("identifier '%s'", name))
 	}
 }
 
@@ -703,14 +780,6 @@ func validateImports(result *AnalysisResult) {
 	}
 }
 
-func checkDependencies(result *AnalysisResult) {
-	// Build dependency graph based on imports
-	for path, importInfo := range result.Imports {
-		if importInfo.Exists && importInfo.Resolved != "" {
-			result.Dependencies[path] = []string{importInfo.Resolved}
-		}
-	}
-}
 
 
 
@@ -756,11 +825,9 @@ func checkUndefinedReferences(result *AnalysisResult) {
 	}
 	result.Undefined = unique
 
+	// The errors themselves were added when the names were resolved.
 	if len(result.Undefined) > 0 {
 		result.Valid = false
-		for _, item := range result.Undefined {
-			result.Errors = append(result.Errors, utils.ParseError{Message: fmt.Sprintf("Undefined reference: %s", item)})
-		}
 	}
 }
 
@@ -796,11 +863,21 @@ func isExported(name string) bool {
 	return len(name) > 0 && name[0] >= 'A' && name[0] <= 'Z'
 }
 
+// builtinFunctions are compiled inline by the compiler rather than defined
+// in a module.
+var builtinFunctions = map[string]bool{
+	"print":  true,
+	"len":    true,
+	"append": true,
+	"copy":   true,
+	"push":   true,
+	"map":    true,
+}
+
 func isStdlibFunction(name string) bool {
-	// Stdlib functions are now defined in actual Aether files
-	// This function is kept for backward compatibility but always returns false
-	// The real stdlib functions are in lib/core/*.ae files
-	return false
+	// The rest of the stdlib is defined in actual Aether files, in
+	// lib/core/*.ae.
+	return builtinFunctions[name]
 }
 
 func findProjectRoot(start string) string {
@@ -836,80 +913,3 @@ func findAetherFiles(root string) ([]string, error) {
 
 	return files, err
 }
-
-func validateFunctionCalls(ast *parser.Program, moduleFunctions map[string]map[string]bool, result *DependencyAnalysis) {
-	for _, stmt := range ast.Statements {
-		validateStatementFunctionCalls(stmt, moduleFunctions, result)
-	}
-}
-
-func validateStatementFunctionCalls(stmt parser.Statement, moduleFunctions map[string]map[string]bool, result *DependencyAnalysis) {
-	switch s := stmt.(type) {
-	case *parser.Block:
-		for _, subStmt := range s.Statements {
-			validateStatementFunctionCalls(subStmt, moduleFunctions, result)
-		}
-	case *parser.If:
-		validateStatementFunctionCalls(s.Consequence, moduleFunctions, result)
-		if s.Alternative != nil {
-			validateStatementFunctionCalls(s.Alternative, moduleFunctions, result)
-		}
-	case *parser.While:
-		validateStatementFunctionCalls(s.Body, moduleFunctions, result)
-	case *parser.For:
-		validateStatementFunctionCalls(s.Body, moduleFunctions, result)
-	case *parser.Function:
-		validateStatementFunctionCalls(s.Body, moduleFunctions, result)
-	}
-
-	// Check for function calls in expressions
-	validateExpressionFunctionCalls(stmt, moduleFunctions, result)
-}
-
-func validateExpressionFunctionCalls(expr interface{}, moduleFunctions map[string]map[string]bool, result *DependencyAnalysis) {
-	switch e := expr.(type) {
-	case *parser.Call:
-		if ident, ok := e.Function.(*parser.Identifier); ok {
-			if !isStdlibFunction(ident.Value) {
-				// Check if function exists in any module
-				found := false
-				for moduleName, functions := range moduleFunctions {
-					_ = moduleName
-					if functions[ident.Value] {
-						found = true
-						break
-					}
-				}
-				if !found {
-					result.Valid = false
-					result.Errors = append(result.Errors, utils.ParseError{
-						Kind:    utils.UndefinedReference,
-						Message: "undefined reference to function '" + ident.Value + "' in imported binding or file. (Aether imports are not modules unless HMR is enabled.) Check spelling or update your binding.",
-						Fix:     "Check the spelling or update your binding.",
-					})
-				}
-			}
-		}
-	case *parser.PropertyAccess:
-		if moduleIdent, ok := e.Object.(*parser.Identifier); ok {
-			// Check if module.property exists
-			if functions, exists := moduleFunctions[moduleIdent.Value]; exists {
-				if !functions[e.Property.Value] {
-					result.Valid = false
-					result.Errors = append(result.Errors, utils.ParseError{
-						Kind:    utils.UndefinedReference,
-						Message: "undefined reference to function '" + e.Property.Value + "' in binding or file '" + moduleIdent.Value + "'. (Aether imports are not modules unless HMR is enabled.) Check spelling or update your binding.",
-						Fix:     "Check the spelling or update your binding.",
-					})
-				}
-			} else {
-				result.Valid = false
-				result.Errors = append(result.Errors, utils.ParseError{
-					Kind:    utils.UndefinedReference,
-					Message: "undefined reference to module '" + moduleIdent.Value + "'. (Aether imports are not modules unless HMR is enabled.) Check import or binding.",
-					Fix:     "Check the import or binding for the module.",
-				})
-			}
-		}
-	}
-}
@@ -0,0 +1,719 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// Borrowing follows section 1 of the 0.4.0 spec, with no syntax for it. A
+// value is mutated through a name by assigning to an element or field of
+// it, by pushing to it, or by passing it to a parameter the callee mutates;
+// any other use reads it. Which parameters a function mutates is inferred
+// from its body and the functions it calls.
+//
+// A borrow is made by giving a value a second name: y = x makes y refer to
+// the value of x, and a lambda borrows the locals it captures. The borrow
+// lasts from where it is made to the last use of y or the lambda, and ends
+// early when either name is assigned a new value, once that value has been
+// computed: xs = append(xs, 1) still mutates the value an alias of xs
+// shares. While it lasts, the value
+// may be read through both names, but once one of them mutates it, the other
+// may not be used. Passing the same value for two parameters borrows it
+// twice for the call, and a for loop borrows what it iterates over for the
+// whole loop. Uses are ordered by their position in the source, except that
+// a borrow made before a loop and used in it lasts the whole loop.
+
+// CheckBorrows reports the uses in prog that break exclusive borrowing: a
+// name used after its value was mutated through another name the borrow
+// still holds, at the use, and references to a variable that stay in use
+// after the block declaring it ends. imports holds
+// the exports of the modules prog may use; their functions are taken to only
+// read their arguments.
+func CheckBorrows(prog *parser.Program, file, source string, imports map[string]map[string]interface{}) []utils.ParseError {
+	b := &borrowChecker{
+		res:      Resolve(prog, file, source, imports),
+		file:     file,
+		lines:    strings.Split(source, "\n"),
+		decls:    make(map[parser.Node]*parser.Function),
+		params:   make(map[*parser.Function][]*Symbol),
+		modes:    make(map[*parser.Function][]bool),
+		lambdas:  make(map[*parser.Block]*Scope),
+		reported: make(map[parser.Node]bool),
+	}
+	b.collect(prog)
+	b.inferModes(prog)
+	b.body(prog)
+	for fn := range b.modes {
+		b.body(fn.Body)
+	}
+	for lambda := range b.lambdas {
+		b.body(lambda)
+	}
+	b.arguments(prog)
+	sortByPosition(b.errs)
+	return b.errs
+}
+
+type borrowChecker struct {
+	res   *Resolution
+	file  string
+	lines []string
+	// decls maps the name of a function to its declaration, params its
+	// parameters to their symbols, and modes tells which of them it
+	// mutates.
+	decls  map[parser.Node]*parser.Function
+	params map[*parser.Function][]*Symbol
+	modes  map[*parser.Function][]bool
+	// lambdas maps each block literal to its scope.
+	lambdas map[*parser.Block]*Scope
+	// aliases are the pairs of a variable and the variable or iterated
+	// value it was given, which mutating the first also mutates.
+	aliases [][2]*Symbol
+	// mutating holds the identifiers through which a value is mutated.
+	mutating map[parser.Node]bool
+	// loops are the spans of loop bodies and branches the groups of spans
+	// of which at most one runs.
+	loops    []span
+	branches [][]span
+	reported map[parser.Node]bool
+	errs     []utils.ParseError
+}
+
+// span is the stretch of source from the first to the last position of a
+// node.
+type span struct{ from, to parser.Pos }
+
+func (s span) contains(p parser.Pos) bool {
+	return s.from.Line != 0 && !posBefore(p, s.from) && !posBefore(s.to, p)
+}
+
+// ended reports whether the rebind s has taken effect at p.
+func (s span) ended(p parser.Pos) bool {
+	return s.from.Line != 0 && posBefore(s.to, p)
+}
+
+func posBefore(a, b parser.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func spanOf(n parser.Node) span {
+	var s span
+	parser.Inspect(n, func(n parser.Node) bool {
+		var p parser.Pos
+		switch n := n.(type) {
+		case *parser.Identifier:
+			p = n.Pos
+		case *parser.Literal:
+			p = n.Pos
+		case *parser.Spread:
+			p = n.Pos
+		case *parser.Array:
+			p = n.Pos
+		case *parser.StructInstantiation:
+			p = n.Pos
+		}
+		if p.Line == 0 {
+			return true
+		}
+		if s.from.Line == 0 || posBefore(p, s.from) {
+			s.from = p
+		}
+		if posBefore(s.to, p) {
+			s.to = p
+		}
+		return true
+	})
+	return s
+}
+
+func (b *borrowChecker) collect(prog *parser.Program) {
+	var scopes func(sc *Scope)
+	scopes = func(sc *Scope) {
+		if sc.Kind == LambdaScope {
+			b.lambdas[sc.Node.(*parser.Block)] = sc
+		}
+		for _, child := range sc.Children {
+			scopes(child)
+		}
+	}
+	scopes(b.res.Root)
+
+	parser.Inspect(prog, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Function:
+			if n.Name != nil {
+				b.decls[n.Name] = n
+			}
+			b.modes[n] = make([]bool, len(n.Params))
+			for _, p := range n.Params {
+				b.params[n] = append(b.params[n], b.res.SymbolOf(p))
+			}
+		case *parser.Assignment:
+			if len(n.Names) == 1 {
+				if from, ok := n.Value.(*parser.Identifier); ok {
+					b.alias(n.Names[0], from)
+				}
+			}
+		case *parser.For:
+			b.alias(n.Value, b.root(n.Iterable))
+			b.loops = append(b.loops, spanOf(n.Body))
+		case *parser.While:
+			b.loops = append(b.loops, spanOf(n.Body))
+		case *parser.Repeat:
+			b.loops = append(b.loops, spanOf(n.Body))
+		case *parser.If:
+			if n.Consequence != nil && n.Alternative != nil {
+				b.branches = append(b.branches, []span{spanOf(n.Consequence), spanOf(n.Alternative)})
+			}
+		case *parser.Match:
+			var arms []span
+			for _, c := range n.Cases {
+				arms = append(arms, spanOf(c))
+			}
+			b.branches = append(b.branches, arms)
+		}
+		return true
+	})
+}
+
+func (b *borrowChecker) alias(to, from *parser.Identifier) {
+	if to == nil || from == nil {
+		return
+	}
+	y, x := b.local(to), b.local(from)
+	if y != nil && x != nil && y != x {
+		b.aliases = append(b.aliases, [2]*Symbol{y, x})
+	}
+}
+
+// local returns the local variable or parameter n is bound to, or nil.
+func (b *borrowChecker) local(n parser.Node) *Symbol {
+	if sym := b.res.SymbolOf(n); sym != nil && sym.Kind.local() {
+		return sym
+	}
+	return nil
+}
+
+// root returns the variable whose value e is part of: xs for xs, xs[i] and
+// xs[i].name. It is nil for values that are not held by a variable.
+func (b *borrowChecker) root(e parser.Expression) *parser.Identifier {
+	switch e := e.(type) {
+	case *parser.Identifier:
+		if b.local(e) != nil {
+			return e
+		}
+	case *parser.ArrayIndex:
+		return b.root(e.Array)
+	case *parser.PropertyAccess:
+		return b.root(e.Object)
+	}
+	return nil
+}
+
+// inferModes finds the parameters each function mutates. A function that
+// passes a parameter on to one that mutates it mutates it too, so this
+// repeats until nothing changes.
+func (b *borrowChecker) inferModes(prog *parser.Program) {
+	for changed := true; changed; {
+		changed = false
+		b.mutating = b.mutatingUses(prog)
+		mutated := b.mutatedSymbols()
+		for fn, params := range b.params {
+			for i, p := range params {
+				if p != nil && mutated[p] && !b.modes[fn][i] {
+					b.modes[fn][i] = true
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func (b *borrowChecker) mutatingUses(prog *parser.Program) map[parser.Node]bool {
+	mutating := make(map[parser.Node]bool)
+	mark := func(e parser.Expression) {
+		if root := b.root(e); root != nil {
+			mutating[root] = true
+		}
+	}
+	parser.Inspect(prog, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.ElementAssignment:
+			mark(n.Target)
+		case *parser.Call:
+			switch fn := n.Function.(type) {
+			case *parser.PropertyAccess:
+				if p := fn.Property.Value; p == "push" || p == "append" {
+					mark(fn.Object)
+				}
+			case *parser.Identifier:
+				if fn.Value == "append" && b.res.SymbolOf(fn) == nil && len(n.Args) == 2 {
+					mark(n.Args[0])
+				}
+				if decl := b.callee(fn); decl != nil {
+					for i, arg := range b.boundArgs(decl, n.Args) {
+						if b.modes[decl][i] {
+							mark(arg)
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+	return mutating
+}
+
+// mutatedSymbols returns the variables whose value is mutated, through
+// their own name or through a variable they were given to.
+func (b *borrowChecker) mutatedSymbols() map[*Symbol]bool {
+	mutated := make(map[*Symbol]bool)
+	for n := range b.mutating {
+		mutated[b.res.SymbolOf(n)] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, a := range b.aliases {
+			if mutated[a[0]] && !mutated[a[1]] {
+				mutated[a[1]] = true
+				changed = true
+			}
+		}
+	}
+	return mutated
+}
+
+// callee returns the function fn names, if it is declared in the program.
+func (b *borrowChecker) callee(fn *parser.Identifier) *parser.Function {
+	if sym := b.res.SymbolOf(fn); sym != nil && sym.Kind == FunctionSymbol {
+		return b.decls[sym.Decl]
+	}
+	return nil
+}
+
+// boundArgs returns the arguments of a call of decl that bind to its fixed
+// parameters, in order. Arguments after a spread are left out, since which
+// parameter they bind to depends on its length.
+func (b *borrowChecker) boundArgs(decl *parser.Function, args []parser.Expression) []parser.Expression {
+	fixed := len(decl.Params)
+	if fixed > 0 && decl.Params[fixed-1].IsVararg {
+		fixed--
+	}
+	var out []parser.Expression
+	for _, arg := range args {
+		if _, ok := arg.(*parser.Spread); ok || len(out) == fixed {
+			break
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// captures returns the variables of enclosing scopes that lambda uses.
+func (b *borrowChecker) captures(lambda *parser.Block) []*Symbol {
+	inside := func(sc *Scope) bool {
+		for ; sc != nil; sc = sc.Parent {
+			if sc == b.lambdas[lambda] {
+				return true
+			}
+		}
+		return false
+	}
+	var out []*Symbol
+	seen := make(map[*Symbol]bool)
+	parser.Inspect(lambda, func(n parser.Node) bool {
+		if sym := b.local(n); sym != nil && !inside(sym.Scope) && !seen[sym] {
+			seen[sym] = true
+			out = append(out, sym)
+		}
+		return true
+	})
+	return out
+}
+
+// mutatesIn reports whether sym is mutated inside n.
+func (b *borrowChecker) mutatesIn(n parser.Node, sym *Symbol) bool {
+	found := false
+	parser.Inspect(n, func(n parser.Node) bool {
+		found = found || b.mutating[n] && b.res.SymbolOf(n) == sym
+		return !found
+	})
+	return found
+}
+
+// access is a use of a variable in a body.
+type access struct {
+	sym     *Symbol
+	node    parser.Node
+	pos     parser.Pos
+	mutable bool
+}
+
+// borrow is a second name for the value of target, held by holder from
+// start on. node is the identifier or lambda that made it. A lambda holder
+// mutates the value on every use when mutable is set.
+type borrow struct {
+	holder, target *Symbol
+	node           parser.Node
+	start          parser.Pos
+	lambda         bool
+	mutable        bool
+}
+
+// body checks the borrows made by the statements of a function, a lambda
+// or the module, leaving out the functions and lambdas declared in it.
+func (b *borrowChecker) body(root parser.Node) {
+	var accesses []access
+	var borrows []borrow
+	rebinds := make(map[*Symbol][]span)
+	targets := make(map[parser.Node]bool)
+	// A lambda assigned to a variable is made where the variable is.
+	made := make(map[*parser.Block]parser.Pos)
+	use := func(n parser.Node, pos parser.Pos) {
+		if sym := b.local(n); sym != nil && sym.Decl != n && !targets[n] {
+			accesses = append(accesses, access{sym: sym, node: n, pos: pos, mutable: b.mutating[n]})
+		}
+	}
+	parser.Inspect(root, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Function:
+			return n == root
+		case *parser.Block:
+			if _, ok := b.lambdas[n]; ok && n != root {
+				// The lambda reads or mutates what it captures each
+				// time it is called, which the borrows of its holder
+				// cover; here it only reads it once.
+				pos, ok := made[n]
+				if !ok {
+					pos = spanOf(n).from
+				}
+				for _, sym := range b.captures(n) {
+					accesses = append(accesses, access{sym: sym, node: n, pos: pos, mutable: b.mutatesIn(n, sym)})
+				}
+				return false
+			}
+		case *parser.Assignment:
+			for _, name := range n.Names {
+				targets[name] = true
+				if sym := b.local(name); sym != nil {
+					rebinds[sym] = append(rebinds[sym], rebindSpan(name, n.Value))
+				}
+			}
+			if len(n.Names) != 1 {
+				break
+			}
+			holder := b.local(n.Names[0])
+			if holder == nil {
+				break
+			}
+			switch v := n.Value.(type) {
+			case *parser.Identifier:
+				if target := b.local(v); target != nil && target != holder {
+					borrows = append(borrows, borrow{holder: holder, target: target, node: v, start: v.Pos})
+				}
+			case *parser.Block:
+				if _, ok := b.lambdas[v]; ok {
+					made[v] = n.Names[0].Pos
+					for _, target := range b.captures(v) {
+						borrows = append(borrows, borrow{holder: holder, target: target, node: v, start: n.Names[0].Pos, lambda: true, mutable: b.mutatesIn(v, target)})
+					}
+				}
+			}
+		case *parser.For:
+			b.iteration(n)
+		case *parser.Identifier:
+			use(n, n.Pos)
+		case *parser.Spread:
+			use(n, n.Pos)
+		}
+		return true
+	})
+	for _, bw := range borrows {
+		b.check(bw, accesses, rebinds)
+	}
+}
+
+// check reports the accesses of the target of bw that conflict with the
+// uses of its holder.
+func (b *borrowChecker) check(bw borrow, accesses []access, rebinds map[*Symbol][]span) {
+	start := bw.start
+	holderEnd := nextRebind(rebinds[bw.holder], start)
+	targetEnd := nextRebind(rebinds[bw.target], start)
+	var uses []access
+	for _, a := range accesses {
+		if a.sym == bw.holder && posBefore(start, a.pos) && !holderEnd.ended(a.pos) {
+			if bw.lambda {
+				a.mutable = bw.mutable
+			}
+			uses = append(uses, a)
+		}
+	}
+	if len(uses) == 0 {
+		return
+	}
+	if !bw.lambda {
+		b.lifetime(bw, uses, accesses)
+	}
+	for _, a := range accesses {
+		if a.sym != bw.target || a.node == bw.node || !posBefore(start, a.pos) || b.exclusive(start, a.pos) {
+			continue
+		}
+		if targetEnd.ended(a.pos) {
+			continue
+		}
+		later := b.laterUses(start, a, uses)
+		if len(later) == 0 {
+			continue
+		}
+		// Whichever name mutates the value first, the other one may not
+		// be used after it while the borrow lasts.
+		if mut := b.mutationBefore(a, uses); mut != nil {
+			b.targetConflict(bw, a, *mut, later[0])
+		} else if a.mutable {
+			b.holderConflict(bw, later[0], a)
+		}
+	}
+}
+
+// mutationBefore returns the first use among uses that mutates the value
+// before a, if any.
+func (b *borrowChecker) mutationBefore(a access, uses []access) *access {
+	for i, u := range uses {
+		if u.mutable && posBefore(u.pos, a.pos) && !b.exclusive(u.pos, a.pos) {
+			return &uses[i]
+		}
+	}
+	return nil
+}
+
+// laterUses returns the uses of a holder that keep its borrow alive at a:
+// the ones after it, or when the borrow was made before a loop around a,
+// any use in that loop.
+func (b *borrowChecker) laterUses(start parser.Pos, a access, uses []access) []access {
+	var later []access
+	for _, u := range uses {
+		if posBefore(a.pos, u.pos) && !b.exclusive(a.pos, u.pos) {
+			later = append(later, u)
+		}
+	}
+	if len(later) > 0 {
+		return later
+	}
+	for _, loop := range b.loops {
+		if !loop.contains(a.pos) || !posBefore(start, loop.from) {
+			continue
+		}
+		for _, u := range uses {
+			if loop.contains(u.pos) {
+				later = append(later, u)
+			}
+		}
+	}
+	return later
+}
+
+// exclusive reports whether p and q lie in different branches of the same
+// if or match, so that they never both run.
+func (b *borrowChecker) exclusive(p, q parser.Pos) bool {
+	for _, group := range b.branches {
+		pi, qi := -1, -1
+		for i, s := range group {
+			if s.contains(p) {
+				pi = i
+			}
+			if s.contains(q) {
+				qi = i
+			}
+		}
+		if pi >= 0 && qi >= 0 && pi != qi {
+			return true
+		}
+	}
+	return false
+}
+
+// rebindSpan is the span of an assignment of value to name, which takes
+// effect at its end, after the value is computed.
+func rebindSpan(name *parser.Identifier, value parser.Expression) span {
+	s := span{from: name.Pos, to: name.Pos}
+	if v := spanOf(value); v.to.Line != 0 && posBefore(s.to, v.to) {
+		s.to = v.to
+	}
+	return s
+}
+
+func nextRebind(rebinds []span, after parser.Pos) span {
+	for _, s := range rebinds {
+		if posBefore(after, s.from) {
+			return s
+		}
+	}
+	return span{}
+}
+
+// conflictMessage is the error for using name, mutably or not, while
+// another name has mutated its value.
+func conflictMessage(name string, mutable bool) string {
+	if mutable {
+		return fmt.Sprintf("cannot borrow %s as mutable more than once at a time", name)
+	}
+	return fmt.Sprintf("cannot borrow %s as shared while it is also borrowed as mutable", name)
+}
+
+// holderConflict reports use, a use of the holder of bw after mut mutated
+// the value through its target.
+func (b *borrowChecker) holderConflict(bw borrow, use, mut access) {
+	x, y := bw.target.Name, bw.holder.Name
+	if bw.lambda {
+		b.report(use.node, use.pos, conflictMessage(x, use.mutable),
+			fmt.Sprintf("the lambda in '%s' captures '%s' at line %d, and line %d mutates '%s' while the lambda is still in use. Call '%s' before line %d.",
+				y, x, bw.start.Line, mut.pos.Line, x, y, mut.pos.Line))
+		return
+	}
+	b.report(use.node, use.pos, conflictMessage(y, use.mutable),
+		fmt.Sprintf("'%s' refers to the same value as '%s' since line %d, and line %d mutates it through '%s'. Use '%s' here instead, or give '%s' its own copy with copy(%s).",
+			y, x, bw.start.Line, mut.pos.Line, x, x, y, x))
+}
+
+// targetConflict reports a, a use of the target of bw after mut mutated the
+// value through the holder, which is used again at next.
+func (b *borrowChecker) targetConflict(bw borrow, a, mut, next access) {
+	x, y := bw.target.Name, bw.holder.Name
+	if bw.lambda {
+		b.report(a.node, a.pos, conflictMessage(x, a.mutable),
+			fmt.Sprintf("the lambda in '%s' captures '%s' at line %d, mutates it when called at line %d and is called again at line %d. Use '%s' after that call.",
+				y, x, bw.start.Line, mut.pos.Line, next.pos.Line, x))
+		return
+	}
+	b.report(a.node, a.pos, conflictMessage(x, a.mutable),
+		fmt.Sprintf("'%s' refers to the same value as '%s' since line %d, mutates it at line %d and is used again at line %d. Use '%s' here instead, or give '%s' its own copy with copy(%s).",
+			y, x, bw.start.Line, mut.pos.Line, next.pos.Line, y, y, x))
+}
+
+// lifetime reports an alias that is used after the block declaring the
+// variable it refers to has ended, while that variable went on being used
+// after the alias was made.
+func (b *borrowChecker) lifetime(bw borrow, uses, accesses []access) {
+	sc := bw.target.Scope
+	if sc.Kind == ModuleScope || sc.Kind == FunctionScope || sc.Kind == LambdaScope || !outside(bw.holder.Scope, sc) {
+		return
+	}
+	shared := false
+	for _, a := range accesses {
+		shared = shared || a.sym == bw.target && a.node != bw.node && posBefore(bw.start, a.pos)
+	}
+	if !shared {
+		return
+	}
+	end := spanOf(sc.Node).to
+	for _, u := range uses {
+		if posBefore(end, u.pos) {
+			b.report(bw.node, bw.start, fmt.Sprintf("reference to %s outlives its scope", bw.target.Name),
+				fmt.Sprintf("'%s' is used at line %d, after the block declaring '%s' ends at line %d. Declare '%s' outside the block, or assign '%s' a copy with copy(%s).",
+					bw.holder.Name, u.pos.Line, bw.target.Name, end.Line, bw.target.Name, bw.holder.Name, bw.target.Name))
+			return
+		}
+	}
+}
+
+// outside reports whether sc is a proper ancestor of inner.
+func outside(sc, inner *Scope) bool {
+	for s := inner.Parent; s != nil; s = s.Parent {
+		if s == sc {
+			return true
+		}
+	}
+	return false
+}
+
+// iteration reports mutations of a variable inside a for loop over it.
+func (b *borrowChecker) iteration(loop *parser.For) {
+	root := b.root(loop.Iterable)
+	if root == nil {
+		return
+	}
+	sym := b.local(root)
+	parser.Inspect(loop.Body, func(n parser.Node) bool {
+		if b.mutating[n] && b.res.SymbolOf(n) == sym {
+			pos := n.(*parser.Identifier).Pos
+			b.report(n, pos, fmt.Sprintf("cannot borrow %s as mutable while it is also borrowed as shared", sym.Name),
+				fmt.Sprintf("The for loop at line %d iterates over '%s' until it ends. Collect the changes and apply them after the loop, or iterate over a copy with copy(%s).",
+					root.Pos.Line, sym.Name, sym.Name))
+		}
+		return true
+	})
+}
+
+// arguments reports calls that pass the same variable for two parameters
+// when the callee mutates either of them.
+func (b *borrowChecker) arguments(prog *parser.Program) {
+	parser.Inspect(prog, func(n parser.Node) bool {
+		call, ok := n.(*parser.Call)
+		if !ok {
+			return true
+		}
+		fn, ok := call.Function.(*parser.Identifier)
+		if !ok {
+			return true
+		}
+		decl := b.callee(fn)
+		if decl == nil {
+			return true
+		}
+		args := b.boundArgs(decl, call.Args)
+		for j, arg := range args {
+			later, ok := arg.(*parser.Identifier)
+			if !ok || b.local(later) == nil {
+				continue
+			}
+			for i, prev := range args[:j] {
+				earlier, ok := prev.(*parser.Identifier)
+				if !ok || b.local(earlier) != b.local(later) {
+					continue
+				}
+				mi, mj := b.modes[decl][i], b.modes[decl][j]
+				if !mi && !mj {
+					continue
+				}
+				var msg string
+				switch {
+				case mi && mj:
+					msg = fmt.Sprintf("cannot borrow %s as mutable more than once at a time", later.Value)
+				case mj:
+					msg = fmt.Sprintf("cannot borrow %s as mutable while it is also borrowed as shared", later.Value)
+				default:
+					msg = fmt.Sprintf("cannot borrow %s as shared while it is also borrowed as mutable", later.Value)
+				}
+				mutated := decl.Params[j].Value
+				if !mj {
+					mutated = decl.Params[i].Value
+				}
+				b.report(later, later.Pos, msg,
+					fmt.Sprintf("'%s' mutates its parameter '%s', so '%s' cannot be passed for both '%s' and '%s'. Pass a copy with copy(%s) for one of them.",
+						fn.Value, mutated, later.Value, decl.Params[i].Value, decl.Params[j].Value, later.Value))
+				break
+			}
+		}
+		return true
+	})
+}
+
+func (b *borrowChecker) report(n parser.Node, pos parser.Pos, msg, fix string) {
+	if b.reported[n] {
+		return
+	}
+	b.reported[n] = true
+	b.errs = append(b.errs, utils.ParseError{
+		Kind:    utils.BorrowConflict,
+		Message: msg,
+		File:    b.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Snippet: sourceLine(b.lines, pos.Line),
+		Caret:   pos.Column,
+		Fix:     fix,
+	})
+}
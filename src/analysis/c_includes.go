@@ -80,3 +80,61 @@ func AnalyzeCComment(comment *parser.CComment, filePath string, result *Analysis
 	includes := ParseCIncludes(comment.Content)
 	result.CIncludes = append(result.CIncludes, includes...)
 }
+
+// headerLibraries names the library behind system headers that are not
+// part of libc.
+var headerLibraries = map[string]string{
+	"math.h":    "m",
+	"complex.h": "m",
+	"fenv.h":    "m",
+	"pthread.h": "pthread",
+	"dlfcn.h":   "dl",
+	"zlib.h":    "z",
+}
+
+// libcHeaders are served by libc, which is always linked.
+var libcHeaders = map[string]bool{
+	"stdio.h": true, "stdlib.h": true, "string.h": true, "strings.h": true,
+	"ctype.h": true, "errno.h": true, "time.h": true, "stdint.h": true,
+	"stddef.h": true, "stdarg.h": true, "stdbool.h": true, "limits.h": true,
+	"float.h": true, "assert.h": true, "signal.h": true, "setjmp.h": true,
+	"locale.h": true, "wchar.h": true, "wctype.h": true, "unistd.h": true,
+	"fcntl.h": true, "inttypes.h": true, "iso646.h": true, "stdalign.h": true,
+	"stdnoreturn.h": true, "uchar.h": true,
+}
+
+// LinkLibraries returns the libraries to pass to the linker with -l for
+// the system headers in includes, each once and in order of first use.
+// Local headers belong to sources compiled with the program and name no
+// library.
+func LinkLibraries(includes []CInclude) []string {
+	var libs []string
+	seen := make(map[string]bool)
+	for _, inc := range includes {
+		if !inc.IsSystem {
+			continue
+		}
+		lib := headerLibrary(inc.Header)
+		if lib == "" || seen[lib] {
+			continue
+		}
+		seen[lib] = true
+		libs = append(libs, lib)
+	}
+	return libs
+}
+
+func headerLibrary(header string) string {
+	if lib, ok := headerLibraries[header]; ok {
+		return lib
+	}
+	if libcHeaders[header] || strings.HasPrefix(header, "sys/") || strings.HasPrefix(header, "arpa/") || strings.HasPrefix(header, "netinet/") {
+		return ""
+	}
+	// <curl/curl.h> is libcurl's, <png.h> libpng's.
+	if i := strings.Index(header, "/"); i > 0 {
+		return header[:i]
+	}
+	name := strings.TrimSuffix(header, ".h")
+	return strings.TrimPrefix(name, "lib")
+}
@@ -0,0 +1,370 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// The call graph of a project has a node for every top-level function of
+// every module and one for the body of each module, the top-level
+// statements its initializer runs. A node refers to the functions its code
+// names, whether it calls them, passes them on or builds a closure over
+// them: calls in lambdas and nested functions count for the node they are
+// written in, and module.name counts for the function name of the module.
+//
+// What runs is found from the roots: the body of the entry module and its
+// main function, and in a library the exported functions of every module.
+// A module is linked in when one of its functions runs, when another
+// module reads one of its bindings, or when its body calls anything, since
+// that may have effects. Its body then runs as well. The functions that
+// are not reached, and the modules that are not linked in, can be left out
+// of the build.
+
+// ProjectModule is a parsed module of a project. Name is the name it is
+// imported by; Entry marks the module the program starts in.
+type ProjectModule struct {
+	Name   string
+	File   string
+	Source string
+	Prog   *parser.Program
+	Entry  bool
+}
+
+// CallNode is a node of a CallGraph: a function, or the body of Module when
+// Function is empty. Refs are the nodes its code refers to, sorted by ID.
+type CallNode struct {
+	Module    string
+	Function  string
+	File      string
+	Pos       parser.Pos
+	Refs      []*CallNode
+	Reachable bool
+	// line is the source line at Pos, for diagnostics, and importedAt the
+	// first import of the module of a module body.
+	line       string
+	importedAt *importSite
+}
+
+type importSite struct {
+	file string
+	pos  parser.Pos
+	line string
+}
+
+// ID names n: "module.function", or the module name for a module body.
+func (n *CallNode) ID() string {
+	if n.Function == "" {
+		return n.Module
+	}
+	return n.Module + "." + n.Function
+}
+
+// CallGraph is the call graph of a project. Nodes holds every node sorted
+// by ID.
+type CallGraph struct {
+	Nodes []*CallNode
+	byID  map[string]*CallNode
+}
+
+// Node returns the node with the given ID, or nil.
+func (g *CallGraph) Node(id string) *CallNode {
+	return g.byID[id]
+}
+
+// ModuleReachable reports whether module is linked into the program.
+func (g *CallGraph) ModuleReachable(module string) bool {
+	n := g.byID[module]
+	return n != nil && n.Reachable
+}
+
+// Live returns the functions of module that are reached, by name.
+func (g *CallGraph) Live(module string) map[string]bool {
+	live := make(map[string]bool)
+	for _, n := range g.Nodes {
+		if n.Module == module && n.Function != "" && n.Reachable {
+			live[n.Function] = true
+		}
+	}
+	return live
+}
+
+// BuildCallGraph builds the call graph of modules and finds what runs.
+// imports holds the exports of every module by name, as for Resolve. When
+// library is set the exported functions of every module are roots too.
+func BuildCallGraph(modules []ProjectModule, imports map[string]map[string]interface{}, library bool) *CallGraph {
+	g := &CallGraph{byID: make(map[string]*CallNode)}
+	b := &callGraphBuilder{g: g, effects: make(map[*CallNode]bool)}
+	for _, m := range modules {
+		b.declare(m)
+	}
+	for _, m := range modules {
+		b.refs(m, imports)
+	}
+	for _, n := range g.byID {
+		g.Nodes = append(g.Nodes, n)
+		sort.Slice(n.Refs, func(i, j int) bool { return n.Refs[i].ID() < n.Refs[j].ID() })
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID() < g.Nodes[j].ID() })
+
+	var roots []*CallNode
+	for _, m := range modules {
+		body := g.byID[m.Name]
+		if m.Entry || b.effects[body] {
+			roots = append(roots, body)
+		}
+		if main := g.byID[m.Name+".main"]; m.Entry && main != nil {
+			roots = append(roots, main)
+		}
+	}
+	if library {
+		for _, n := range g.Nodes {
+			if n.Function != "" && isExported(n.Function) {
+				roots = append(roots, n)
+			}
+		}
+	}
+	for work := roots; len(work) > 0; {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		if n.Reachable {
+			continue
+		}
+		n.Reachable = true
+		work = append(work, n.Refs...)
+		if body := g.byID[n.Module]; n.Function != "" && body != nil {
+			work = append(work, body)
+		}
+	}
+	return g
+}
+
+type callGraphBuilder struct {
+	g *CallGraph
+	// effects marks the module bodies that call something.
+	effects map[*CallNode]bool
+}
+
+func (b *callGraphBuilder) declare(m ProjectModule) {
+	lines := strings.Split(m.Source, "\n")
+	b.g.byID[m.Name] = &CallNode{Module: m.Name, File: m.File}
+	for _, stmt := range m.Prog.Statements {
+		if fn, ok := stmt.(*parser.Function); ok && fn.Name != nil && fn.Name.Value != "" {
+			n := &CallNode{Module: m.Name, Function: fn.Name.Value, File: m.File, Pos: fn.Name.Pos, line: sourceLine(lines, fn.Name.Pos.Line)}
+			b.g.byID[n.ID()] = n
+		}
+	}
+}
+
+// refs adds the references of the functions and the body of m.
+func (b *callGraphBuilder) refs(m ProjectModule, imports map[string]map[string]interface{}) {
+	res := Resolve(m.Prog, m.File, m.Source, imports)
+	lines := strings.Split(m.Source, "\n")
+	body := b.g.byID[m.Name]
+	for _, stmt := range m.Prog.Statements {
+		switch s := stmt.(type) {
+		case *parser.Import:
+			if target := b.g.byID[moduleBaseName(s.Name.Value)]; target != nil && target.importedAt == nil {
+				target.importedAt = &importSite{file: m.File, pos: s.Name.Pos, line: sourceLine(lines, s.Name.Pos.Line)}
+			}
+		case *parser.Function:
+			if s.Name != nil && s.Name.Value != "" {
+				b.walk(b.g.byID[m.Name+"."+s.Name.Value], s.Body, res)
+			} else {
+				b.walk(body, s, res)
+			}
+		case *parser.StructDef, *parser.ForeignFunction:
+		default:
+			b.walk(body, s, res)
+		}
+	}
+	b.effects[body] = hasEffects(m.Prog)
+}
+
+// walk adds the nodes the code of root refers to to the references of n.
+func (b *callGraphBuilder) walk(n *CallNode, root parser.Node, res *Resolution) {
+	if root == nil {
+		return
+	}
+	parser.Inspect(root, func(node parser.Node) bool {
+		switch e := node.(type) {
+		case *parser.PropertyAccess:
+			if ident, ok := e.Object.(*parser.Identifier); ok && e.Property != nil {
+				if sym := res.SymbolOf(ident); sym != nil && sym.Kind == ModuleSymbol {
+					module := moduleBaseName(sym.Module)
+					if target := b.g.byID[module+"."+e.Property.Value]; target != nil {
+						b.ref(n, target)
+					} else if target := b.g.byID[module]; target != nil {
+						b.ref(n, target)
+					}
+					return false
+				}
+			}
+		case *parser.Identifier:
+			sym := res.SymbolOf(e)
+			if sym == nil || sym.Scope.Parent != nil {
+				break
+			}
+			switch sym.Kind {
+			case FunctionSymbol:
+				b.ref(n, b.g.byID[n.Module+"."+sym.Name])
+			case ModuleSymbol:
+				b.ref(n, b.g.byID[moduleBaseName(sym.Module)])
+			}
+		}
+		return true
+	})
+}
+
+func (b *callGraphBuilder) ref(from, to *CallNode) {
+	if to == nil || to == from {
+		return
+	}
+	for _, r := range from.Refs {
+		if r == to {
+			return
+		}
+	}
+	from.Refs = append(from.Refs, to)
+}
+
+// hasEffects reports whether running the body of prog calls anything: a
+// function, a builtin or a method. Operators, and the code of the functions
+// and lambdas it only defines, do not count.
+func hasEffects(prog *parser.Program) bool {
+	effects := false
+	for _, block := range BuildCFG(prog).Blocks {
+		for _, node := range block.Nodes {
+			switch n := node.(type) {
+			case *parser.For, *parser.Function:
+				continue
+			case *parser.Case:
+				node = n.Pattern
+			}
+			parser.Inspect(node, func(n parser.Node) bool {
+				switch n := n.(type) {
+				case *parser.Function, *parser.Block:
+					return false
+				case *parser.Call:
+					if ident, ok := n.Function.(*parser.Identifier); !ok || isNameToken(ident.Value) {
+						effects = true
+					}
+				}
+				return !effects
+			})
+		}
+	}
+	return effects
+}
+
+// Unreachable reports, as warnings, the modules that are not linked in and
+// the functions of the others that never run. Functions of a module left
+// out are covered by the warning for the module.
+func (g *CallGraph) Unreachable() []utils.ParseError {
+	var warns []utils.ParseError
+	for _, n := range g.Nodes {
+		if n.Reachable {
+			continue
+		}
+		if n.Function == "" {
+			warn := utils.ParseError{
+				Kind:    utils.UnusedCode,
+				Message: fmt.Sprintf("module '%s' is never used and is left out of the build", n.Module),
+				File:    n.File,
+				Fix:     "Remove the imports of the module, or use one of its functions.",
+			}
+			if at := n.importedAt; at != nil {
+				warn.Message = fmt.Sprintf("module '%s' is imported but never used, and is left out of the build", n.Module)
+				warn.File, warn.Line, warn.Column, warn.Snippet, warn.Caret = at.file, at.pos.Line, at.pos.Column, at.line, at.pos.Column
+			}
+			warns = append(warns, warn)
+			continue
+		}
+		if !g.ModuleReachable(n.Module) {
+			continue
+		}
+		warns = append(warns, utils.ParseError{
+			Kind:    utils.UnusedCode,
+			Message: fmt.Sprintf("function '%s' is never called and is left out of the build", n.Function),
+			File:    n.File,
+			Line:    n.Pos.Line,
+			Column:  n.Pos.Column,
+			Snippet: n.line,
+			Caret:   n.Pos.Column,
+			Fix:     "Remove it, or call it from code that runs.",
+		})
+	}
+	return warns
+}
+
+// DOT renders g in the Graphviz DOT language. Module bodies are boxes, and
+// what never runs is dashed and grey.
+func (g *CallGraph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph calls {\n")
+	for _, n := range g.Nodes {
+		var attrs []string
+		if n.Function == "" {
+			attrs = append(attrs, "shape=box")
+		}
+		if !n.Reachable {
+			attrs = append(attrs, "style=dashed", "color=grey")
+		}
+		fmt.Fprintf(&sb, "\t%q", n.ID())
+		if len(attrs) > 0 {
+			fmt.Fprintf(&sb, " [%s]", strings.Join(attrs, ", "))
+		}
+		sb.WriteString(";\n")
+	}
+	for _, n := range g.Nodes {
+		for _, r := range n.Refs {
+			fmt.Fprintf(&sb, "\t%q -> %q;\n", n.ID(), r.ID())
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// callGraphJSON is the JSON form of a CallGraph.
+type callGraphJSON struct {
+	Nodes []callNodeJSON `json:"nodes"`
+	Edges []callEdgeJSON `json:"edges"`
+}
+
+type callNodeJSON struct {
+	ID        string `json:"id"`
+	Module    string `json:"module"`
+	Function  string `json:"function,omitempty"`
+	File      string `json:"file"`
+	Line      int    `json:"line,omitempty"`
+	Reachable bool   `json:"reachable"`
+}
+
+type callEdgeJSON struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MarshalJSON renders g as its nodes and edges, e.g.
+// {"nodes": [{"id": "math.plus", ...}], "edges": [{"from": "main", "to": "math.plus"}]}.
+func (g *CallGraph) MarshalJSON() ([]byte, error) {
+	out := callGraphJSON{Nodes: []callNodeJSON{}, Edges: []callEdgeJSON{}}
+	for _, n := range g.Nodes {
+		out.Nodes = append(out.Nodes, callNodeJSON{
+			ID:        n.ID(),
+			Module:    n.Module,
+			Function:  n.Function,
+			File:      n.File,
+			Line:      n.Pos.Line,
+			Reachable: n.Reachable,
+		})
+		for _, r := range n.Refs {
+			out.Edges = append(out.Edges, callEdgeJSON{From: n.ID(), To: r.ID()})
+		}
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,446 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// Arguments bind to parameters from the left, as the compiler binds them. A
+// spread ...xs fills the fixed parameters that the plain arguments after it
+// leave free, and its remaining elements go to a ...rest parameter; how many
+// it holds is only known at run time. Extra arguments of a C function
+// declared with ... are passed as C varargs, which a spread cannot feed.
+
+// signature is what calls of a function are checked against.
+type signature struct {
+	params []string
+	// rest marks a final ...rest parameter and cVarargs a C function
+	// taking extra arguments after its parameters.
+	rest     bool
+	cVarargs bool
+	// spelled overrides how the signature is shown, e.g. for the closure
+	// made by a partial application.
+	spelled string
+}
+
+func (s signature) fixed() int {
+	if s.rest {
+		return len(s.params) - 1
+	}
+	return len(s.params)
+}
+
+func (s signature) String() string {
+	if s.spelled != "" {
+		return s.spelled
+	}
+	params := append([]string(nil), s.params...)
+	if s.rest && len(params) > 0 {
+		params[len(params)-1] = "..." + params[len(params)-1]
+	}
+	if s.cVarargs {
+		params = append(params, "...")
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+func functionSignature(fn *parser.Function) signature {
+	var sig signature
+	for _, p := range fn.Params {
+		sig.params = append(sig.params, p.Value)
+		sig.rest = p.IsVararg
+	}
+	return sig
+}
+
+func foreignSignature(fn *parser.ForeignFunction) signature {
+	sig := signature{cVarargs: fn.Variadic}
+	for _, p := range fn.Params {
+		sig.params = append(sig.params, p.Value)
+	}
+	return sig
+}
+
+func exportSignature(info FunctionInfo) signature {
+	sig := signature{cVarargs: info.Foreign && info.Variadic}
+	for _, p := range info.Parameters {
+		sig.params = append(sig.params, p.Name)
+		sig.rest = p.Variadic
+	}
+	return sig
+}
+
+// builtinArity is the number of arguments the builtin functions with a
+// fixed count take.
+var builtinArity = map[string]int{"len": 1, "append": 2, "copy": 1}
+
+// CheckCalls reports the calls and partial applications in prog whose
+// arguments do not fit the callee's parameters: too many or too few
+// arguments, and spreads with no parameter left to fill. Callees are the
+// functions and foreign functions in scope, the functions of imported
+// modules, whose exports imports holds keyed by module name, and variables
+// bound once to a function, a lambda or a partial application. Any other
+// callee is checked against the function type inferred for it, such as
+// the closure a call returns. Calls of a module function that the module
+// does not export, and reads of any other member it does not export, are
+// reported too.
+func CheckCalls(prog *parser.Program, file, source string, imports map[string]map[string]interface{}) []utils.ParseError {
+	c := &callChecker{
+		res:     Resolve(prog, file, source, imports),
+		file:    file,
+		lines:   strings.Split(source, "\n"),
+		imports: imports,
+		types:   InferTypes(prog, imports),
+		decls:   make(map[parser.Node]parser.Node),
+		values:  make(map[*Symbol]parser.Expression),
+	}
+	c.collect(prog)
+	// Callees are checked with their calls.
+	callees := make(map[parser.Node]bool)
+	parser.Inspect(prog, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Call:
+			callees[n.Function] = true
+			c.call(n, n.Function, n.Args, false)
+		case *parser.PartialApplication:
+			callees[n.Function] = true
+			c.call(n, n.Function, n.Args, true)
+		case *parser.PropertyAccess:
+			if !callees[n] {
+				c.member(n)
+			}
+		}
+		return true
+	})
+	sortByPosition(c.errs)
+	return c.errs
+}
+
+type callChecker struct {
+	res     *Resolution
+	file    string
+	lines   []string
+	imports map[string]map[string]interface{}
+	types   *TypeTable
+	// decls maps the name of a function or foreign function to its
+	// declaration, and values the variables bound by a single assignment
+	// to the value assigned.
+	decls  map[parser.Node]parser.Node
+	values map[*Symbol]parser.Expression
+	errs   []utils.ParseError
+}
+
+func (c *callChecker) collect(prog *parser.Program) {
+	assigned := make(map[*Symbol]int)
+	parser.Inspect(prog, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Function:
+			if n.Name != nil {
+				c.decls[n.Name] = n
+			}
+		case *parser.ForeignFunction:
+			c.decls[n.Name] = n
+		case *parser.Assignment:
+			for _, name := range n.Names {
+				if sym := c.res.SymbolOf(name); sym != nil {
+					assigned[sym]++
+					if len(n.Names) == 1 {
+						c.values[sym] = n.Value
+					}
+				}
+			}
+		}
+		return true
+	})
+	for sym, n := range assigned {
+		if n != 1 || sym.Kind != VariableSymbol {
+			delete(c.values, sym)
+		}
+	}
+}
+
+// call checks the arguments of a call, or of a partial application when
+// partial is set, of fn.
+func (c *callChecker) call(at, fn parser.Expression, args []parser.Expression, partial bool) {
+	name := calleeName(fn)
+	if ident, ok := fn.(*parser.Identifier); ok && c.res.SymbolOf(ident) == nil {
+		if n, ok := builtinArity[ident.Value]; ok && !partial {
+			c.count(at, name, args, signature{params: make([]string, n), spelled: fmt.Sprintf("%s with %d argument%s", name, n, plural(n))}, "")
+		}
+		return
+	}
+	sig, ok := c.signatureOf(fn, 0)
+	if !ok {
+		if sig, ok = c.inferredSignature(fn); !ok {
+			return
+		}
+	}
+	context := ""
+	if partial {
+		context = " in partial application"
+	}
+	c.count(at, name, args, sig, context)
+}
+
+// signatureOf returns the signature of the function fn refers to. depth
+// bounds how many variables are followed to their values.
+func (c *callChecker) signatureOf(fn parser.Expression, depth int) (signature, bool) {
+	switch fn := fn.(type) {
+	case *parser.Identifier:
+		sym := c.res.SymbolOf(fn)
+		if sym == nil {
+			return signature{}, false
+		}
+		switch sym.Kind {
+		case FunctionSymbol:
+			if decl, ok := c.decls[sym.Decl].(*parser.Function); ok {
+				return functionSignature(decl), true
+			}
+		case ForeignSymbol:
+			if decl, ok := c.decls[sym.Decl].(*parser.ForeignFunction); ok {
+				return foreignSignature(decl), true
+			}
+			if exports, ok := importedExports(c.imports, sym.Module); ok {
+				if info, ok := exports[sym.Name].(FunctionInfo); ok {
+					return exportSignature(info), true
+				}
+			}
+		case VariableSymbol:
+			if val, ok := c.values[sym]; ok && depth < 8 {
+				return c.valueSignature(val, depth+1)
+			}
+		}
+	case *parser.PropertyAccess:
+		obj, ok := fn.Object.(*parser.Identifier)
+		if !ok {
+			return signature{}, false
+		}
+		sym := c.res.SymbolOf(obj)
+		if sym == nil || sym.Kind != ModuleSymbol {
+			return signature{}, false
+		}
+		exports, ok := importedExports(c.imports, sym.Module)
+		if !ok {
+			return signature{}, false
+		}
+		switch info := exports[fn.Property.Value].(type) {
+		case FunctionInfo:
+			return exportSignature(info), true
+		case nil:
+			c.missingMember(fn, sym, exports)
+		}
+	}
+	return signature{}, false
+}
+
+// inferredSignature returns the signature of the function type inferred
+// for fn.
+func (c *callChecker) inferredSignature(fn parser.Expression) (signature, bool) {
+	n, rest, ok := c.types.Arity(fn)
+	if !ok {
+		return signature{}, false
+	}
+	sig := signature{params: make([]string, n), rest: rest}
+	fixed := sig.fixed()
+	sig.spelled = fmt.Sprintf("a function taking %d argument%s", fixed, plural(fixed))
+	if rest {
+		sig.spelled = fmt.Sprintf("a function taking at least %d argument%s", fixed, plural(fixed))
+	}
+	return sig, true
+}
+
+// valueSignature returns the signature of the function a variable is
+// bound to.
+func (c *callChecker) valueSignature(val parser.Expression, depth int) (signature, bool) {
+	switch val := val.(type) {
+	case *parser.Block:
+		return signature{spelled: "a lambda, which takes no arguments"}, true
+	case *parser.Identifier:
+		return c.signatureOf(val, depth)
+	case *parser.PartialApplication:
+		// The closure takes exactly one argument per placeholder, even
+		// where the placeholders fill a rest parameter.
+		var sig signature
+		for _, arg := range val.Args {
+			if isHole(arg) {
+				sig.params = append(sig.params, "_")
+			}
+		}
+		sig.spelled = fmt.Sprintf("%s(...), which leaves %d parameter%s open", calleeName(val.Function), len(sig.params), plural(len(sig.params)))
+		return sig, true
+	}
+	return signature{}, false
+}
+
+// count checks the number of arguments against sig.
+func (c *callChecker) count(at parser.Expression, name string, args []parser.Expression, sig signature, context string) {
+	fix := fmt.Sprintf("'%s' is declared as %s%s.", name, name, sig)
+	if sig.spelled != "" {
+		fix = fmt.Sprintf("'%s' is %s.", name, sig)
+	}
+	nFixed := sig.fixed()
+	plain, spreads := 0, 0
+	for _, arg := range args {
+		if _, ok := arg.(*parser.Spread); ok {
+			spreads++
+		} else {
+			plain++
+		}
+	}
+	extraOK := sig.rest || sig.cVarargs
+	expected := fmt.Sprintf("%d", nFixed)
+	if extraOK {
+		expected = "at least " + expected
+	}
+	switch {
+	case plain > nFixed && !extraOK:
+		found := fmt.Sprintf("%d", plain)
+		if spreads > 0 {
+			found = "at least " + found
+		}
+		c.report(args[plainIndex(args, nFixed)], fmt.Sprintf("too many arguments to '%s'%s: expected %s, found %s", name, context, expected, found), fix)
+		return
+	case plain < nFixed && spreads == 0:
+		c.report(at, fmt.Sprintf("not enough arguments to '%s'%s: expected %s, found %d", name, context, expected, plain), fix)
+		return
+	}
+	// Each spread fills the fixed parameters left after the arguments
+	// before it and the plain arguments after it.
+	filled := 0
+	for i, arg := range args {
+		s, ok := arg.(*parser.Spread)
+		if !ok {
+			if filled < nFixed {
+				filled++
+			}
+			continue
+		}
+		if s.Name == "" {
+			c.report(s, fmt.Sprintf("spread in call to '%s' needs an array: write ...name", name), fix)
+			continue
+		}
+		plainAfter := 0
+		for _, later := range args[i+1:] {
+			if _, isSpread := later.(*parser.Spread); !isSpread {
+				plainAfter++
+			}
+		}
+		k := nFixed - filled - plainAfter
+		if k < 0 {
+			k = 0
+		}
+		filled += k
+		switch {
+		case k > 0 || sig.rest:
+		case sig.cVarargs:
+			c.report(s, fmt.Sprintf("cannot spread '%s' into the C varargs of '%s'%s", s.Name, name, context), fix)
+		default:
+			c.report(s, fmt.Sprintf("spread '%s' in call to '%s'%s has no parameter left to fill", s.Name, name, context), fix)
+		}
+	}
+}
+
+// missingMember reports a call of a function a module does not export.
+func (c *callChecker) missingMember(fn *parser.PropertyAccess, module *Symbol, exports map[string]interface{}) {
+	var names []string
+	for n, export := range exports {
+		if _, ok := export.(FunctionInfo); ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	msg := fmt.Sprintf("module '%s' has no function '%s'", module.Name, fn.Property.Value)
+	fix := "Check the spelling, or export the function from the module."
+	if suggestion := closestName(fn.Property.Value, names); suggestion != "" {
+		msg += "; did you mean '" + suggestion + "'?"
+		fix = "Replace '" + fn.Property.Value + "' with '" + suggestion + "'."
+	}
+	c.report(fn, msg, fix)
+}
+
+// member reports a read of a module member the module does not export.
+// Functions are exported whatever their names; other top-level bindings
+// only when their names are capitalized.
+func (c *callChecker) member(e *parser.PropertyAccess) {
+	obj, ok := e.Object.(*parser.Identifier)
+	if !ok || e.Property == nil {
+		return
+	}
+	module := c.res.SymbolOf(obj)
+	if module == nil || module.Kind != ModuleSymbol {
+		return
+	}
+	exports, ok := importedExports(c.imports, module.Module)
+	if !ok || exports[e.Property.Value] != nil {
+		return
+	}
+	name := e.Property.Value
+	msg := fmt.Sprintf("module '%s' has no exported member '%s'", module.Name, name)
+	fix := "Check the spelling, or export the binding from the module."
+	if !isExported(name) {
+		fix = "Only bindings whose names start with a capital letter are exported; rename '" + name + "' in the module to export it."
+	} else {
+		var names []string
+		for n := range exports {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if suggestion := closestName(name, names); suggestion != "" {
+			msg += "; did you mean '" + suggestion + "'?"
+			fix = "Replace '" + name + "' with '" + suggestion + "'."
+		}
+	}
+	c.reportAs(utils.UndefinedReference, e, msg, fix)
+}
+
+func (c *callChecker) report(at parser.Expression, msg, fix string) {
+	c.reportAs(utils.ArityMismatch, at, msg, fix)
+}
+
+func (c *callChecker) reportAs(kind utils.ErrorKind, at parser.Expression, msg, fix string) {
+	pos := exprPos(at)
+	if s, ok := at.(*parser.Spread); ok {
+		pos = s.Pos
+	}
+	c.errs = append(c.errs, utils.ParseError{
+		Kind:    kind,
+		Message: msg,
+		File:    c.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Snippet: sourceLine(c.lines, pos.Line),
+		Caret:   pos.Column,
+		Fix:     fix,
+	})
+}
+
+// plainIndex returns the index in args of the plain argument numbered n,
+// counting from 0 and skipping spreads.
+func plainIndex(args []parser.Expression, n int) int {
+	for i, arg := range args {
+		if _, ok := arg.(*parser.Spread); ok {
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(args) - 1
+}
+
+func isHole(arg parser.Expression) bool {
+	ident, ok := arg.(*parser.Identifier)
+	return ok && ident.Value == "_"
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,353 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"aether/src/parser"
+)
+
+// A control-flow graph splits a body into basic blocks: runs of nodes that
+// execute in order, entered only at the top and left only at the bottom.
+// The nodes of a block are the statements of the body and the expressions
+// that decide where control goes: the condition of if and while, the count
+// of repeat, the value a for loop iterates over and the subject of match.
+// Two kinds of node stand for a step of a compound statement: a *parser.For
+// at the head of its loop binds the loop variables for the next iteration,
+// and a *parser.Case tests its pattern and binds its variables. Their bodies
+// belong to other blocks.
+//
+// Functions and lambdas declared in a body get graphs of their own. Their
+// declaration is a node of the body; a lambda is part of the expression it
+// appears in.
+
+// BasicBlock is a node of a CFG. Index is its position in CFG.Blocks.
+// After is set on a block that follows a return, break or continue, an if
+// or match, a while loop or an arm that matches every value: the statement
+// or *parser.Case control has to get past to reach the block, which tells
+// why nothing does when it is unreachable.
+type BasicBlock struct {
+	Index int
+	Nodes []parser.Node
+	Succs []*BasicBlock
+	Preds []*BasicBlock
+	After parser.Node
+}
+
+// CFG is the control-flow graph of a function, lambda or module body.
+// Entry is where the body starts and Exit, which holds no nodes, is where
+// it returns, whether by a return statement or by running off the end.
+// Blocks holds every block, Entry first and Exit last; blocks after a
+// return, break or continue may be unreachable from Entry.
+type CFG struct {
+	Body   parser.Node
+	Entry  *BasicBlock
+	Exit   *BasicBlock
+	Blocks []*BasicBlock
+	// Nested are the functions and lambdas declared in Body, in source
+	// order.
+	Nested []parser.Node
+}
+
+// BuildCFG builds the graph of body, which is a *parser.Function, a lambda
+// *parser.Block or the *parser.Program whose top-level statements form
+// the module body.
+func BuildCFG(body parser.Node) *CFG {
+	b := &cfgBuilder{cfg: &CFG{Body: body}}
+	b.cfg.Entry = b.newBlock()
+	b.cur = b.cfg.Entry
+	exit := &BasicBlock{}
+	b.cfg.Exit = exit
+	switch body := body.(type) {
+	case *parser.Function:
+		if body.Body != nil {
+			b.stmts(body.Body.Statements)
+		}
+	case *parser.Block:
+		b.stmts(body.Statements)
+	case *parser.Program:
+		b.stmts(body.Statements)
+	}
+	b.edge(b.cur, exit)
+	b.cfg.Blocks = append(b.cfg.Blocks, exit)
+	b.prune()
+	return b.cfg
+}
+
+// BuildCFGs builds the graph of the module body of prog followed by those
+// of every function and lambda in it, outermost first.
+func BuildCFGs(prog *parser.Program) []*CFG {
+	graphs := []*CFG{BuildCFG(prog)}
+	for i := 0; i < len(graphs); i++ {
+		for _, n := range graphs[i].Nested {
+			graphs = append(graphs, BuildCFG(n))
+		}
+	}
+	return graphs
+}
+
+// Reachable reports, by block index, which blocks control can reach from
+// Entry.
+func (g *CFG) Reachable() []bool {
+	seen := make([]bool, len(g.Blocks))
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		if seen[b.Index] {
+			return
+		}
+		seen[b.Index] = true
+		for _, s := range b.Succs {
+			visit(s)
+		}
+	}
+	visit(g.Entry)
+	return seen
+}
+
+// String lists the blocks of g one per line, with the kind and line of
+// each node and the successors, e.g. "b1: Assignment:2 Call:3 -> b2 b3".
+func (g *CFG) String() string {
+	var sb strings.Builder
+	for _, b := range g.Blocks {
+		fmt.Fprintf(&sb, "b%d:", b.Index)
+		for _, n := range b.Nodes {
+			sb.WriteString(" " + describeNode(n))
+		}
+		if len(b.Succs) > 0 {
+			sb.WriteString(" ->")
+			for _, s := range b.Succs {
+				fmt.Fprintf(&sb, " b%d", s.Index)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func describeNode(n parser.Node) string {
+	kind := strings.TrimPrefix(fmt.Sprintf("%T", n), "*parser.")
+	return fmt.Sprintf("%s:%d", kind, NodePos(n).Line)
+}
+
+// NodePos is the position of a statement or other node: that of its first
+// name or literal, the loop variable of a for and the name of a function.
+func NodePos(n parser.Node) parser.Pos {
+	switch n := n.(type) {
+	case *parser.For:
+		if n.Value != nil {
+			return n.Value.Pos
+		}
+	case *parser.Function:
+		if n.Name != nil {
+			return n.Name.Pos
+		}
+	}
+	return spanOf(n).from
+}
+
+type cfgBuilder struct {
+	cfg   *CFG
+	cur   *BasicBlock
+	loops []loopTargets
+}
+
+// loopTargets are where break and continue go in the innermost loop.
+type loopTargets struct {
+	brk, cont *BasicBlock
+}
+
+func (b *cfgBuilder) newBlock() *BasicBlock {
+	block := &BasicBlock{Index: len(b.cfg.Blocks)}
+	b.cfg.Blocks = append(b.cfg.Blocks, block)
+	return block
+}
+
+func (b *cfgBuilder) edge(from, to *BasicBlock) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// jump ends the current block with an edge to target and continues in a
+// block nothing leads to, after s.
+func (b *cfgBuilder) jump(target *BasicBlock, s parser.Statement) {
+	b.edge(b.cur, target)
+	b.cur = b.newBlock()
+	b.cur.After = s
+}
+
+// add appends n to the current block and records the functions and
+// lambdas declared in it.
+func (b *cfgBuilder) add(n parser.Node) {
+	b.cur.Nodes = append(b.cur.Nodes, n)
+	var root parser.Node = n
+	switch n := n.(type) {
+	case *parser.For:
+		return
+	case *parser.Case:
+		root = n.Pattern
+	}
+	parser.Inspect(root, func(n parser.Node) bool {
+		switch n.(type) {
+		case *parser.Function, *parser.Block:
+			b.cfg.Nested = append(b.cfg.Nested, n)
+			return false
+		}
+		return true
+	})
+}
+
+func (b *cfgBuilder) stmts(list []parser.Statement) {
+	for _, s := range list {
+		b.stmt(s)
+	}
+}
+
+func (b *cfgBuilder) stmt(s parser.Statement) {
+	switch s := s.(type) {
+	case nil:
+	case *parser.Block:
+		b.stmts(s.Statements)
+	case *parser.If:
+		b.add(s.Condition)
+		cond := b.cur
+		join := &BasicBlock{After: s}
+		b.branch(cond, s.Consequence, join)
+		if s.Alternative != nil {
+			b.branch(cond, s.Alternative, join)
+		} else {
+			b.edge(cond, join)
+		}
+		b.place(join)
+	case *parser.While:
+		head := b.newBlock()
+		b.edge(b.cur, head)
+		b.cur = head
+		b.add(s.Condition)
+		after := &BasicBlock{After: s}
+		b.loop(head, s.Body, after)
+		if !isTrue(s.Condition) {
+			b.edge(head, after)
+		}
+		b.place(after)
+	case *parser.Repeat:
+		b.add(s.Count)
+		head := b.newBlock()
+		b.edge(b.cur, head)
+		after := &BasicBlock{}
+		b.loop(head, s.Body, after)
+		b.edge(head, after)
+		b.place(after)
+	case *parser.For:
+		b.add(s.Iterable)
+		head := b.newBlock()
+		b.edge(b.cur, head)
+		b.cur = head
+		b.add(s)
+		after := &BasicBlock{}
+		b.loop(head, s.Body, after)
+		b.edge(head, after)
+		b.place(after)
+	case *parser.Match:
+		b.add(s.Expr)
+		end := &BasicBlock{After: s}
+		for _, c := range s.Cases {
+			b.add(c)
+			test := b.cur
+			b.branch(test, c.Body, end)
+			if irrefutable(c.Pattern) {
+				// The arms after one that always matches are never
+				// tried.
+				b.cur = b.newBlock()
+				b.cur.After = c
+				continue
+			}
+			b.cur = b.newBlock()
+			b.edge(test, b.cur)
+		}
+		b.edge(b.cur, end)
+		b.place(end)
+	case *parser.Return:
+		b.add(s)
+		b.jump(b.cfg.Exit, s)
+	case *parser.Break:
+		if len(b.loops) > 0 {
+			b.jump(b.loops[len(b.loops)-1].brk, s)
+		}
+	case *parser.Continue:
+		if len(b.loops) > 0 {
+			b.jump(b.loops[len(b.loops)-1].cont, s)
+		}
+	default:
+		b.add(s)
+	}
+}
+
+// branch builds body in a new block entered from from and leaves it for
+// join.
+func (b *cfgBuilder) branch(from *BasicBlock, body *parser.Block, join *BasicBlock) {
+	b.cur = b.newBlock()
+	b.edge(from, b.cur)
+	if body != nil {
+		b.stmts(body.Statements)
+	}
+	b.edge(b.cur, join)
+}
+
+// loop builds the body of a loop entered from head. The end of the body
+// and continue go back to head, and break goes to after.
+func (b *cfgBuilder) loop(head *BasicBlock, body *parser.Block, after *BasicBlock) {
+	b.loops = append(b.loops, loopTargets{brk: after, cont: head})
+	b.branch(head, body, head)
+	b.loops = b.loops[:len(b.loops)-1]
+}
+
+// place makes block, created ahead of its position, the current block.
+func (b *cfgBuilder) place(block *BasicBlock) {
+	block.Index = len(b.cfg.Blocks)
+	b.cfg.Blocks = append(b.cfg.Blocks, block)
+	b.cur = block
+}
+
+// prune drops the empty blocks nothing leads to, which jumps leave behind,
+// and numbers the rest in order. Their successors take over what they
+// follow.
+func (b *cfgBuilder) prune() {
+	kept := b.cfg.Blocks[:0]
+	for _, block := range b.cfg.Blocks {
+		if block != b.cfg.Entry && block != b.cfg.Exit && len(block.Nodes) == 0 && len(block.Preds) == 0 {
+			for _, s := range block.Succs {
+				s.Preds = removeBlock(s.Preds, block)
+				if s.After == nil {
+					s.After = block.After
+				}
+			}
+			continue
+		}
+		block.Index = len(kept)
+		kept = append(kept, block)
+	}
+	b.cfg.Blocks = kept
+}
+
+func removeBlock(list []*BasicBlock, block *BasicBlock) []*BasicBlock {
+	out := list[:0]
+	for _, b := range list {
+		if b != block {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// isTrue reports whether cond is the literal true, as in while true.
+func isTrue(cond parser.Expression) bool {
+	ident, ok := cond.(*parser.Identifier)
+	return ok && ident.Value == "true"
+}
+
+// irrefutable reports whether a match pattern matches every value: _ or a
+// bare name.
+func irrefutable(pat parser.Expression) bool {
+	ident, ok := pat.(*parser.Identifier)
+	return ok && ident.Value != "true" && ident.Value != "false"
+}
@@ -0,0 +1,575 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// Constant evaluation folds the expressions whose value is known when the
+// program is compiled: literals, arithmetic and comparisons of constants,
+// the concatenation of constant strings, len and .length of a constant
+// string or array, elements of a constant array at a constant index, and
+// array literals of constants. It follows the run-time rules: a number
+// literal that fits 32 bits is an int and wraps like one, a larger one is
+// 64 bits wide, an int meeting a float becomes a float, and division rounds
+// toward zero.
+//
+// A variable assigned exactly once, to a constant, is an immutable binding
+// and its uses have that value, wherever they are. An array binding also
+// needs every use to read it: indexing it, taking its length, printing it
+// or looping over it. The compiler emits a folded expression as its value,
+// and compiles module-level immutable bindings to constant globals, so they
+// hold their value from the start of the program.
+
+// ConstKind tells what kind of value a Const is.
+type ConstKind int
+
+const (
+	ConstInt ConstKind = iota
+	ConstFloat
+	ConstString
+	ConstBool
+	ConstArray
+)
+
+// Const is a value known at compile time. Int holds an integer, which is
+// 64 bits wide when Wide is set and 32 bits otherwise; Float, Str, Bool and
+// Elems hold the other kinds.
+type Const struct {
+	Kind  ConstKind
+	Int   int64
+	Wide  bool
+	Float float64
+	Str   string
+	Bool  bool
+	Elems []Const
+}
+
+// String spells c as an Aether literal, e.g. 42, 2.5, "hi" or [1, 2].
+func (c Const) String() string {
+	switch c.Kind {
+	case ConstFloat:
+		s := strconv.FormatFloat(c.Float, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEnI") {
+			s += ".0"
+		}
+		return s
+	case ConstString:
+		return strconv.Quote(c.Str)
+	case ConstBool:
+		return strconv.FormatBool(c.Bool)
+	case ConstArray:
+		elems := make([]string, len(c.Elems))
+		for i, el := range c.Elems {
+			elems[i] = el.String()
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return strconv.FormatInt(c.Int, 10)
+}
+
+// truthy is the value c has as a condition: false, 0 and 0.0 are false.
+func (c Const) truthy() (bool, bool) {
+	switch c.Kind {
+	case ConstBool:
+		return c.Bool, true
+	case ConstInt:
+		return c.Int != 0, true
+	case ConstFloat:
+		return c.Float != 0, true
+	}
+	return false, false
+}
+
+// Constants evaluates the expressions of a program. Its methods are safe
+// to call on a nil *Constants, which knows no values.
+type Constants struct {
+	res *Resolution
+	// immutable maps the symbols of immutable bindings to the value they
+	// are assigned.
+	immutable map[*Symbol]parser.Expression
+	memo      map[parser.Expression]*Const
+	// busy holds the bindings being evaluated, which a binding whose value
+	// depends on itself finds.
+	busy map[*Symbol]bool
+}
+
+// EvalConstants finds the immutable bindings of prog and prepares its
+// expressions for evaluation. imports holds the exports of the modules
+// prog may use.
+func EvalConstants(prog *parser.Program, imports map[string]map[string]interface{}) *Constants {
+	c := &Constants{
+		res:       Resolve(prog, "", "", imports),
+		immutable: make(map[*Symbol]parser.Expression),
+		memo:      make(map[parser.Expression]*Const),
+		busy:      make(map[*Symbol]bool),
+	}
+	c.collect(prog)
+	return c
+}
+
+// collect finds the immutable bindings: the variables assigned once, by an
+// assignment to that name alone, and never mutated.
+func (c *Constants) collect(prog *parser.Program) {
+	assigned := make(map[*Symbol]int)
+	values := make(map[*Symbol]parser.Expression)
+	// escapes holds the variables used other than by reading them, which
+	// may let their value be mutated.
+	escapes := make(map[*Symbol]bool)
+	use := func(n parser.Node, reads bool) {
+		if sym := c.res.SymbolOf(n); sym != nil && !reads {
+			escapes[sym] = true
+		}
+	}
+	parser.Inspect(prog, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Assignment:
+			for _, name := range n.Names {
+				sym := c.res.SymbolOf(name)
+				if sym == nil {
+					continue
+				}
+				assigned[sym]++
+				// A type annotation may give the value another type
+				// than its literal has.
+				if len(n.Names) == 1 && name.Type == "" {
+					values[sym] = n.Value
+				}
+			}
+			if ident, ok := n.Value.(*parser.Identifier); ok {
+				// y = x gives the value of x a second name.
+				use(ident, false)
+			}
+		case *parser.ElementAssignment:
+			if sym := c.res.SymbolOf(rootIdent(n.Target)); sym != nil {
+				assigned[sym]++
+			}
+		case *parser.For:
+			for _, v := range []*parser.Identifier{n.Index, n.Value} {
+				if v == nil {
+					continue
+				}
+				if sym := c.res.SymbolOf(v); sym != nil {
+					assigned[sym]++
+				}
+			}
+			use(n.Iterable, true)
+		case *parser.ArrayIndex:
+			use(n.Array, true)
+		case *parser.Slice:
+			use(n.Array, true)
+		case *parser.PropertyAccess:
+			use(n.Object, n.Property != nil && n.Property.Value == "length")
+		case *parser.Call:
+			ident, _ := n.Function.(*parser.Identifier)
+			pure := ident != nil && c.res.SymbolOf(ident) == nil &&
+				(ident.Value == "len" || ident.Value == "print" || isOperatorName(ident.Value))
+			for _, arg := range n.Args {
+				use(arg, pure)
+			}
+		case *parser.PartialApplication:
+			for _, arg := range n.Args {
+				use(arg, false)
+			}
+		case *parser.Array:
+			for _, el := range n.Elements {
+				use(el, false)
+			}
+		case *parser.Tuple:
+			for _, el := range n.Elements {
+				use(el, false)
+			}
+		case *parser.StructInstantiation:
+			for _, v := range n.Fields {
+				use(v, false)
+			}
+		case *parser.Return:
+			use(n.Value, false)
+		case *parser.Match:
+			use(n.Expr, true)
+		case *parser.Case:
+			parser.Inspect(n.Pattern, func(p parser.Node) bool {
+				if sym := c.res.SymbolOf(p); sym != nil && sym.Decl == p {
+					assigned[sym]++
+				}
+				return true
+			})
+		}
+		return true
+	})
+	for sym, n := range assigned {
+		value := values[sym]
+		if n != 1 || value == nil || sym.Kind != VariableSymbol {
+			continue
+		}
+		if _, isArray := value.(*parser.Array); isArray && escapes[sym] {
+			continue
+		}
+		c.immutable[sym] = value
+	}
+}
+
+// rootIdent is the variable an element or field target such as xs[0] or
+// p.x stores into.
+func rootIdent(e parser.Expression) parser.Node {
+	for {
+		switch t := e.(type) {
+		case *parser.ArrayIndex:
+			e = t.Array
+		case *parser.PropertyAccess:
+			e = t.Object
+		default:
+			return e
+		}
+	}
+}
+
+// ValueOf returns the value of e when it is known at compile time.
+func (c *Constants) ValueOf(e parser.Expression) (Const, bool) {
+	if c == nil || e == nil {
+		return Const{}, false
+	}
+	if v, ok := c.memo[e]; ok {
+		if v == nil {
+			return Const{}, false
+		}
+		return *v, true
+	}
+	v, ok := c.eval(e)
+	if ok {
+		c.memo[e] = &v
+	} else {
+		c.memo[e] = nil
+	}
+	return v, ok
+}
+
+// Binding returns the value of the immutable binding name declares, if it
+// is one and its value is known.
+func (c *Constants) Binding(name *parser.Identifier) (Const, bool) {
+	if c == nil {
+		return Const{}, false
+	}
+	sym := c.res.SymbolOf(name)
+	if sym == nil || sym.Decl != parser.Node(name) {
+		return Const{}, false
+	}
+	return c.bindingValue(sym)
+}
+
+func (c *Constants) bindingValue(sym *Symbol) (Const, bool) {
+	value, ok := c.immutable[sym]
+	if !ok || c.busy[sym] {
+		return Const{}, false
+	}
+	c.busy[sym] = true
+	defer delete(c.busy, sym)
+	return c.ValueOf(value)
+}
+
+func (c *Constants) eval(e parser.Expression) (Const, bool) {
+	switch e := e.(type) {
+	case *parser.Literal:
+		return literalConst(e)
+	case *parser.Identifier:
+		if sym := c.res.SymbolOf(e); sym != nil {
+			return c.bindingValue(sym)
+		}
+		switch e.Value {
+		case "true":
+			return Const{Kind: ConstBool, Bool: true}, true
+		case "false":
+			return Const{Kind: ConstBool}, true
+		}
+	case *parser.Array:
+		arr := Const{Kind: ConstArray, Elems: make([]Const, 0, len(e.Elements))}
+		for _, el := range e.Elements {
+			v, ok := c.ValueOf(el)
+			if !ok {
+				return Const{}, false
+			}
+			arr.Elems = append(arr.Elems, v)
+		}
+		return arr, true
+	case *parser.ArrayIndex:
+		arr, ok := c.ValueOf(e.Array)
+		index, ok2 := c.ValueOf(e.Index)
+		if !ok || !ok2 || arr.Kind != ConstArray || index.Kind != ConstInt {
+			return Const{}, false
+		}
+		if index.Int < 0 || index.Int >= int64(len(arr.Elems)) {
+			return Const{}, false
+		}
+		return arr.Elems[index.Int], true
+	case *parser.PropertyAccess:
+		if e.Property != nil && e.Property.Value == "length" {
+			return c.length(e.Object)
+		}
+	case *parser.Call:
+		ident, ok := e.Function.(*parser.Identifier)
+		if !ok || c.res.SymbolOf(ident) != nil {
+			return Const{}, false
+		}
+		if ident.Value == "len" && len(e.Args) == 1 {
+			return c.length(e.Args[0])
+		}
+		args := make([]Const, len(e.Args))
+		for i, arg := range e.Args {
+			v, ok := c.ValueOf(arg)
+			if !ok {
+				return Const{}, false
+			}
+			args[i] = v
+		}
+		switch len(args) {
+		case 1:
+			return foldUnary(ident.Value, args[0])
+		case 2:
+			return foldBinary(ident.Value, args[0], args[1])
+		}
+	}
+	return Const{}, false
+}
+
+// length is the length of a constant string or array, which is an int.
+func (c *Constants) length(e parser.Expression) (Const, bool) {
+	v, ok := c.ValueOf(e)
+	switch {
+	case !ok:
+	case v.Kind == ConstString:
+		return intConst(int64(len(v.Str)), false), true
+	case v.Kind == ConstArray:
+		return intConst(int64(len(v.Elems)), false), true
+	}
+	return Const{}, false
+}
+
+// literalConst is the value of a literal, parsed the way the compiler
+// does.
+func literalConst(lit *parser.Literal) (Const, bool) {
+	switch v := lit.Value.(type) {
+	case int:
+		return intConst(int64(v), false), true
+	case float64:
+		return Const{Kind: ConstFloat, Float: v}, true
+	case bool:
+		return Const{Kind: ConstBool, Bool: v}, true
+	case string:
+		if lit.Kind != parser.NumberLiteral {
+			return Const{Kind: ConstString, Str: v}, true
+		}
+		if strings.Contains(v, ".") {
+			f, err := strconv.ParseFloat(v, 64)
+			return Const{Kind: ConstFloat, Float: f}, err == nil
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Const{}, false
+		}
+		return intConst(n, n > math.MaxInt32 || n < math.MinInt32), true
+	}
+	return Const{}, false
+}
+
+// intConst makes an integer constant, wrapping it to 32 bits unless wide.
+func intConst(n int64, wide bool) Const {
+	if !wide {
+		n = int64(int32(n))
+	}
+	return Const{Kind: ConstInt, Int: n, Wide: wide}
+}
+
+func foldUnary(op string, v Const) (Const, bool) {
+	switch op {
+	case "-":
+		switch v.Kind {
+		case ConstInt:
+			return intConst(-v.Int, v.Wide), true
+		case ConstFloat:
+			return Const{Kind: ConstFloat, Float: -v.Float}, true
+		}
+	case "!":
+		if b, ok := v.truthy(); ok {
+			return Const{Kind: ConstBool, Bool: !b}, true
+		}
+	}
+	return Const{}, false
+}
+
+func foldBinary(op string, a, b Const) (Const, bool) {
+	switch {
+	case op == "..":
+		if a.Kind == ConstString && b.Kind == ConstString {
+			return Const{Kind: ConstString, Str: a.Str + b.Str}, true
+		}
+	case a.Kind == ConstString && b.Kind == ConstString:
+		return compareConst(op, strings.Compare(a.Str, b.Str))
+	case a.Kind == ConstBool && b.Kind == ConstBool:
+		switch op {
+		case "==":
+			return Const{Kind: ConstBool, Bool: a.Bool == b.Bool}, true
+		case "!=":
+			return Const{Kind: ConstBool, Bool: a.Bool != b.Bool}, true
+		}
+	case a.Kind == ConstInt && b.Kind == ConstInt:
+		return foldInt(op, a, b)
+	case isNumberConst(a) && isNumberConst(b):
+		return foldFloat(op, asFloat(a), asFloat(b))
+	}
+	return Const{}, false
+}
+
+func isNumberConst(c Const) bool {
+	return c.Kind == ConstInt || c.Kind == ConstFloat
+}
+
+func asFloat(c Const) float64 {
+	if c.Kind == ConstInt {
+		return float64(c.Int)
+	}
+	return c.Float
+}
+
+func foldInt(op string, a, b Const) (Const, bool) {
+	wide := a.Wide || b.Wide
+	x, y := a.Int, b.Int
+	switch op {
+	case "+":
+		return intConst(x+y, wide), true
+	case "-":
+		return intConst(x-y, wide), true
+	case "*":
+		return intConst(x*y, wide), true
+	case "/", "%":
+		// Dividing the smallest integer by -1 overflows, which is
+		// undefined at run time.
+		min := int64(math.MinInt32)
+		if wide {
+			min = math.MinInt64
+		}
+		if y == 0 || x == min && y == -1 {
+			return Const{}, false
+		}
+		if op == "/" {
+			return intConst(x/y, wide), true
+		}
+		return intConst(x%y, wide), true
+	case "^":
+		return intConst(x^y, wide), true
+	}
+	switch {
+	case x < y:
+		return compareConst(op, -1)
+	case x > y:
+		return compareConst(op, 1)
+	}
+	return compareConst(op, 0)
+}
+
+func foldFloat(op string, x, y float64) (Const, bool) {
+	switch op {
+	case "+":
+		return Const{Kind: ConstFloat, Float: x + y}, true
+	case "-":
+		return Const{Kind: ConstFloat, Float: x - y}, true
+	case "*":
+		return Const{Kind: ConstFloat, Float: x * y}, true
+	case "/":
+		return Const{Kind: ConstFloat, Float: x / y}, true
+	case "%":
+		return Const{Kind: ConstFloat, Float: math.Mod(x, y)}, true
+	case "==":
+		return Const{Kind: ConstBool, Bool: x == y}, true
+	case "!=":
+		return Const{Kind: ConstBool, Bool: x != y}, true
+	case "<":
+		return Const{Kind: ConstBool, Bool: x < y}, true
+	case ">":
+		return Const{Kind: ConstBool, Bool: x > y}, true
+	case "<=":
+		return Const{Kind: ConstBool, Bool: x <= y}, true
+	case ">=":
+		return Const{Kind: ConstBool, Bool: x >= y}, true
+	}
+	return Const{}, false
+}
+
+// compareConst applies a comparison operator to the sign of a comparison.
+func compareConst(op string, cmp int) (Const, bool) {
+	var r bool
+	switch op {
+	case "==":
+		r = cmp == 0
+	case "!=":
+		r = cmp != 0
+	case "<":
+		r = cmp < 0
+	case ">":
+		r = cmp > 0
+	case "<=":
+		r = cmp <= 0
+	case ">=":
+		r = cmp >= 0
+	default:
+		return Const{}, false
+	}
+	return Const{Kind: ConstBool, Bool: r}, true
+}
+
+// CheckConstants reports the integer divisions and remainders of prog by a
+// divisor that is zero at compile time, which fail when they run. imports
+// holds the exports of the modules prog may use.
+func CheckConstants(prog *parser.Program, file, source string, imports map[string]map[string]interface{}) []utils.ParseError {
+	consts := EvalConstants(prog, imports)
+	types := InferTypes(prog, imports)
+	lines := strings.Split(source, "\n")
+	var errs []utils.ParseError
+	parser.Inspect(prog, func(n parser.Node) bool {
+		call, ok := n.(*parser.Call)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		op, ok := call.Function.(*parser.Identifier)
+		if !ok || op.Value != "/" && op.Value != "%" {
+			return true
+		}
+		divisor, ok := consts.ValueOf(call.Args[1])
+		if !ok || divisor.Kind != ConstInt || divisor.Int != 0 {
+			return true
+		}
+		if v, ok := consts.ValueOf(call.Args[0]); ok && v.Kind != ConstInt || isFloatType(types.TypeOf(call.Args[0])) {
+			return true
+		}
+		what := "division"
+		if op.Value == "%" {
+			what = "remainder"
+		}
+		pos := spanOf(call.Args[1]).from
+		msg := fmt.Sprintf("integer %s by zero", what)
+		if _, literal := call.Args[1].(*parser.Literal); !literal {
+			msg = fmt.Sprintf("integer %s by zero: the divisor is always 0", what)
+		}
+		errs = append(errs, utils.ParseError{
+			Kind:    utils.DivisionByZero,
+			Message: msg,
+			File:    file,
+			Line:    pos.Line,
+			Column:  pos.Column,
+			Snippet: sourceLine(lines, pos.Line),
+			Caret:   pos.Column,
+			Fix:     "Dividing an integer by zero stops the program. Use a divisor that is not 0, or check it before dividing.",
+		})
+		return true
+	})
+	sortByPosition(errs)
+	return errs
+}
+
+func isFloatType(t string) bool {
+	return t == "float" || t == "f32" || t == "f64"
+}
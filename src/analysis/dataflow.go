@@ -0,0 +1,88 @@
+package analysis
+
+// Dataflow is a dataflow problem over the blocks of a CFG, whose facts are
+// values of type F. A forward problem computes the fact at the start of
+// each block from those at the end of its predecessors; a backward one the
+// fact at the end of each block from those at the start of its successors.
+//
+// Boundary is the fact at the start of Entry, or at the end of Exit for a
+// backward problem, and Init the fact every other block starts from. Meet
+// combines the facts flowing into a block and Transfer carries a fact
+// across a block, forwards or backwards. Neither may modify its arguments.
+// Equal tells when a fact has stopped changing. For Solve to finish, the
+// facts must form a lattice of finite height that Meet and Transfer only
+// ever move down.
+type Dataflow[F any] struct {
+	Backward bool
+	Boundary F
+	Init     F
+	Meet     func(a, b F) F
+	Transfer func(b *BasicBlock, f F) F
+	Equal    func(a, b F) bool
+}
+
+// Solve computes the fixed point of d over g with a worklist. It returns
+// the facts at the start and at the end of every block, indexed by block
+// index. A block that nothing flows into keeps Init on that side.
+func Solve[F any](g *CFG, d Dataflow[F]) (in, out []F) {
+	n := len(g.Blocks)
+	in, out = make([]F, n), make([]F, n)
+	for i := range g.Blocks {
+		in[i], out[i] = d.Init, d.Init
+	}
+	// before and after are the sides facts flow into and out of.
+	before, after := in, out
+	first, edges := g.Entry, func(b *BasicBlock) []*BasicBlock { return b.Preds }
+	if d.Backward {
+		before, after = out, in
+		first, edges = g.Exit, func(b *BasicBlock) []*BasicBlock { return b.Succs }
+	}
+	queued := make([]bool, n)
+	var work []*BasicBlock
+	push := func(b *BasicBlock) {
+		if !queued[b.Index] {
+			queued[b.Index] = true
+			work = append(work, b)
+		}
+	}
+	if d.Backward {
+		for i := n - 1; i >= 0; i-- {
+			push(g.Blocks[i])
+		}
+	} else {
+		for _, b := range g.Blocks {
+			push(b)
+		}
+	}
+	for len(work) > 0 {
+		b := work[0]
+		work = work[1:]
+		queued[b.Index] = false
+
+		fact := d.Init
+		if b == first {
+			fact = d.Boundary
+		}
+		for i, p := range edges(b) {
+			if i == 0 && b != first {
+				fact = after[p.Index]
+			} else {
+				fact = d.Meet(fact, after[p.Index])
+			}
+		}
+		before[b.Index] = fact
+		next := d.Transfer(b, fact)
+		if d.Equal(next, after[b.Index]) {
+			continue
+		}
+		after[b.Index] = next
+		targets := b.Succs
+		if d.Backward {
+			targets = b.Preds
+		}
+		for _, t := range targets {
+			push(t)
+		}
+	}
+	return in, out
+}
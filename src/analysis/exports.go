@@ -0,0 +1,158 @@
+package analysis
+
+import "aether/src/parser"
+
+// ModuleExports lists what a module offers its importers, keyed by name: a
+// FunctionInfo for every top-level function, including foreign ones, a
+// VariableInfo for every exported top-level binding whose type can be
+// inferred, and a TypeInfo for every struct, which the exported signatures
+// may name. Types are spelled like annotations, e.g. "int", "string",
+// "[int]", "(int, string)" or "Point", except that foreign functions keep
+// their C type names.
+// Parameter and return types that inference leaves open are empty, and a
+// ...rest parameter whose elements it leaves open is "[]": importers may
+// use them at any type, and ResolveOpenTypes fixes them to the types the
+// importers use. The compiler takes the ones left open as ints. imports
+// holds the exports of the modules prog uses.
+func ModuleExports(prog *parser.Program, imports map[string]map[string]interface{}) map[string]interface{} {
+	return moduleExports(prog, imports, nil)
+}
+
+// moduleExports is ModuleExports with hints for the parameters that have no
+// annotation, as ResolveOpenTypes collects them.
+func moduleExports(prog *parser.Program, imports map[string]map[string]interface{}, hints map[string][]string) map[string]interface{} {
+	in := newInferrer(imports)
+	in.hints = hints
+	in.run(prog)
+	table := in.table
+	exports := make(map[string]interface{})
+	for _, stmt := range prog.Statements {
+		if parser.IsNilNode(stmt) {
+			continue
+		}
+		switch s := stmt.(type) {
+		case *parser.ForeignFunction:
+			exports[s.Name.Value] = foreignFunctionInfo(s)
+		case *parser.StructDef:
+			if s.Name == nil || s.Name.Value == "" {
+				continue
+			}
+			info := TypeInfo{
+				Name:     s.Name.Value,
+				Defined:  true,
+				Exported: true,
+				Fields:   make(map[string]string),
+			}
+			for _, f := range s.Fields {
+				info.Fields[f.Name.Value] = table.Field(s.Name.Value, f.Name.Value)
+				info.Order = append(info.Order, f.Name.Value)
+			}
+			exports[s.Name.Value] = info
+		case *parser.Function:
+			if s.Name == nil || s.Name.Value == "" {
+				continue
+			}
+			info := FunctionInfo{
+				Name:       s.Name.Value,
+				Parameters: []ParameterInfo{},
+				ReturnType: table.Return(s),
+				Defined:    true,
+				Exported:   true,
+			}
+			inferred := table.Params(s)
+			for i, p := range s.Params {
+				t := inferred[i]
+				if p.Type != "" {
+					t = paramTypeName(p)
+				} else if t == "" && p.IsVararg {
+					t = "[]"
+				}
+				info.Parameters = append(info.Parameters, ParameterInfo{Name: p.Value, Type: t, Variadic: p.IsVararg})
+			}
+			exports[s.Name.Value] = info
+		case *parser.Assignment:
+			if len(s.Names) != 1 || !isExported(s.Names[0].Value) {
+				continue
+			}
+			name := s.Names[0].Value
+			if t := table.Variable(name); t != "" {
+				exports[name] = VariableInfo{
+					Name:     name,
+					Type:     t,
+					Defined:  true,
+					Exported: true,
+					Scope:    "global",
+				}
+			}
+		}
+	}
+	return exports
+}
+
+// ResolveOpenTypes fixes the parameter types that ModuleExports leaves open
+// to the types the other modules of a project pass them. modules are in
+// dependency order, and exports holds the exports of each of them by module
+// name. A module whose parameters gain types has its exports inferred
+// again, which can fix its return types too. When callers disagree the
+// first one wins, and type checking reports the others.
+func ResolveOpenTypes(modules []ProjectModule, exports map[string]map[string]interface{}) {
+	hints := make(map[string]map[string][]string)
+	// Importers come after their dependencies, so going backwards every
+	// caller of a module is seen before the module itself.
+	for i := len(modules) - 1; i >= 0; i-- {
+		m := modules[i]
+		if hints[m.Name] != nil {
+			exports[m.Name] = moduleExports(m.Prog, exports, hints[m.Name])
+		}
+		in := newInferrer(exports)
+		in.hints = hints[m.Name]
+		in.run(m.Prog)
+		for _, use := range in.uses {
+			info, ok := exports[use.module][use.name].(FunctionInfo)
+			if !ok || info.Foreign {
+				continue
+			}
+			for j, p := range info.Parameters {
+				if (p.Type != "" && p.Type != "[]") || j >= len(use.t.Params) {
+					continue
+				}
+				t := spell(use.t.Params[j])
+				if t == "" {
+					continue
+				}
+				if hints[use.module] == nil {
+					hints[use.module] = make(map[string][]string)
+				}
+				if hints[use.module][use.name] == nil {
+					hints[use.module][use.name] = make([]string, len(info.Parameters))
+				}
+				if h := hints[use.module][use.name]; h[j] == "" {
+					h[j] = t
+				}
+			}
+		}
+	}
+}
+
+func foreignFunctionInfo(f *parser.ForeignFunction) FunctionInfo {
+	info := FunctionInfo{
+		Name:       f.Name.Value,
+		Parameters: []ParameterInfo{},
+		ReturnType: f.ReturnType,
+		Defined:    true,
+		Exported:   true,
+		Foreign:    true,
+		Variadic:   f.Variadic,
+	}
+	for _, p := range f.Params {
+		info.Parameters = append(info.Parameters, ParameterInfo{Name: p.Value, Type: p.Type})
+	}
+	return info
+}
+
+func paramTypeName(p *parser.Identifier) string {
+	if p.IsVararg {
+		return "[" + p.Type + "]"
+	}
+	return p.Type
+}
@@ -0,0 +1,555 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// The flow checks run over the control-flow graph of every function, lambda
+// and the module body. They report code no path reaches, functions that
+// return a value on some paths but run off their end on others, and reads of
+// a variable that some path reaches before assigning it. The compiler
+// accepts all three: the end of a function returns the zero value of its
+// return type and a variable not yet assigned holds zero. So they are
+// warnings. So are if and while conditions whose value is known at
+// compile time.
+//
+// Locals are assigned before they are read in source order, or they would
+// not resolve, but the variables of the module body are declared up front
+// and its functions can read them. Calling a function from the module body
+// reads the module variables it, or a function it calls, reads without
+// assigning, and assigns the ones it assigns.
+
+// CheckFlow returns the flow warnings for prog. imports holds the exports
+// of the modules prog may use.
+func CheckFlow(prog *parser.Program, file, source string, imports map[string]map[string]interface{}) []utils.ParseError {
+	f := &flowChecker{
+		res:     Resolve(prog, file, source, imports),
+		file:    file,
+		lines:   strings.Split(source, "\n"),
+		frames:  make(map[*Symbol]parser.Node),
+		effects: make(map[*Symbol]*callEffect),
+	}
+	f.collect(prog)
+	f.conditions(prog, EvalConstants(prog, imports))
+	for _, g := range BuildCFGs(prog) {
+		reach := g.Reachable()
+		f.unreachable(g, reach)
+		f.missingReturn(g, reach)
+		f.unassigned(g)
+	}
+	sortByPosition(f.warns)
+	return f.warns
+}
+
+type flowChecker struct {
+	res   *Resolution
+	file  string
+	lines []string
+	// frames maps each variable to the function, lambda or program whose
+	// frame holds it.
+	frames map[*Symbol]parser.Node
+	// effects holds the module variables each top-level function reads
+	// and assigns, counting the functions it calls.
+	effects map[*Symbol]*callEffect
+	warns   []utils.ParseError
+}
+
+type callEffect struct {
+	reads, writes symbolSet
+}
+
+func (f *flowChecker) collect(prog *parser.Program) {
+	var scopes func(sc *Scope, frame parser.Node)
+	scopes = func(sc *Scope, frame parser.Node) {
+		switch sc.Kind {
+		case ModuleScope, FunctionScope, LambdaScope:
+			frame = sc.Node
+		}
+		for _, sym := range sc.Symbols {
+			if sym.Kind == VariableSymbol {
+				f.frames[sym] = frame
+			}
+		}
+		for _, child := range sc.Children {
+			scopes(child, frame)
+		}
+	}
+	scopes(f.res.Root, prog)
+
+	root := f.res.Root
+	calls := make(map[*Symbol][]*Symbol)
+	for _, stmt := range prog.Statements {
+		fn, ok := stmt.(*parser.Function)
+		if !ok || fn.Name == nil || fn.Body == nil {
+			continue
+		}
+		sym := f.res.SymbolOf(fn.Name)
+		if sym == nil || sym.Scope != root {
+			continue
+		}
+		e := &callEffect{reads: symbolSet{}, writes: symbolSet{}}
+		f.effects[sym] = e
+		targets := make(map[parser.Node]bool)
+		parser.Inspect(fn.Body, func(n parser.Node) bool {
+			switch n := n.(type) {
+			case *parser.Assignment:
+				for _, name := range n.Names {
+					targets[name] = true
+					if s := f.res.SymbolOf(name); s != nil && f.frames[s] == parser.Node(prog) {
+						e.writes[s] = true
+					}
+				}
+			case *parser.Identifier:
+				s := f.res.SymbolOf(n)
+				switch {
+				case s == nil, targets[n]:
+				case s.Kind == FunctionSymbol && s.Scope == root:
+					calls[sym] = append(calls[sym], s)
+				case f.frames[s] == parser.Node(prog):
+					e.reads[s] = true
+				}
+			}
+			return true
+		})
+	}
+	for changed := true; changed; {
+		changed = false
+		for caller, callees := range calls {
+			for _, callee := range callees {
+				e, from := f.effects[caller], f.effects[callee]
+				if from == nil {
+					continue
+				}
+				changed = e.reads.addAll(from.reads) || changed
+				changed = e.writes.addAll(from.writes) || changed
+			}
+		}
+	}
+}
+
+// conditions reports the if and while conditions that constant folding
+// decides. A literal or a lone name is taken to be written that way on
+// purpose, as in while true or if debug.
+func (f *flowChecker) conditions(prog *parser.Program, consts *Constants) {
+	parser.Inspect(prog, func(n parser.Node) bool {
+		var cond parser.Expression
+		var fix [2]string
+		switch n := n.(type) {
+		case *parser.If:
+			cond = n.Condition
+			fix = [2]string{"Its body never runs. Remove the if, or fix the condition.", "Remove the if and keep its body."}
+			if n.Alternative != nil {
+				fix[1] = "The else branch never runs. Remove the if and keep its body."
+			}
+		case *parser.While:
+			cond = n.Condition
+			fix = [2]string{"The loop body never runs. Remove the loop, or fix the condition.", "The loop only ends by break or return. Write while true if that is meant."}
+		}
+		switch cond.(type) {
+		case nil, *parser.Identifier, *parser.Literal:
+			return true
+		}
+		v, ok := consts.ValueOf(cond)
+		if !ok {
+			return true
+		}
+		if truth, ok := v.truthy(); ok {
+			pos := spanOf(cond).from
+			if truth {
+				f.report(utils.ConstantCondition, pos, "condition is always true", fix[1])
+			} else {
+				f.report(utils.ConstantCondition, pos, "condition is always false", fix[0])
+			}
+		}
+		return true
+	})
+}
+
+// unreachable reports each stretch of code that no path from the entry of
+// g reaches, once, at its first node.
+func (f *flowChecker) unreachable(g *CFG, reach []bool) {
+	covered := make([]bool, len(g.Blocks))
+	var cover func(b *BasicBlock)
+	cover = func(b *BasicBlock) {
+		if covered[b.Index] || reach[b.Index] {
+			return
+		}
+		covered[b.Index] = true
+		for _, s := range b.Succs {
+			cover(s)
+		}
+	}
+	for {
+		// Start from the dead code that comes first in the source; the
+		// rest of the stretch follows from it.
+		var first *BasicBlock
+		var pos parser.Pos
+		for _, b := range g.Blocks {
+			if reach[b.Index] || covered[b.Index] {
+				continue
+			}
+			p := firstPos(b)
+			if p.Line == 0 {
+				covered[b.Index] = true
+				continue
+			}
+			if first == nil || posBefore(p, pos) {
+				first, pos = b, p
+			}
+		}
+		if first == nil {
+			return
+		}
+		cover(first)
+		msg, fix := unreachableReason(first)
+		f.report(utils.UnreachableCode, pos, msg, fix)
+	}
+}
+
+// firstPos is the position of the first node of b that has one.
+func firstPos(b *BasicBlock) parser.Pos {
+	for _, n := range b.Nodes {
+		if p := NodePos(n); p.Line != 0 {
+			return p
+		}
+	}
+	return parser.Pos{}
+}
+
+// unreachableReason describes why nothing reaches b, from what it follows.
+func unreachableReason(b *BasicBlock) (msg, fix string) {
+	fix = "Remove the code, or move it to where it runs."
+	switch s := b.After.(type) {
+	case *parser.Return:
+		msg = "unreachable code after return"
+		if line := spanOf(s.Value).from.Line; line != 0 {
+			fix = fmt.Sprintf("The function returns at line %d before it gets here. Remove the code, or move it before the return.", line)
+		}
+	case *parser.Break:
+		msg = "unreachable code after break"
+		fix = "The loop ends before it gets here. Remove the code, or move it before the break."
+	case *parser.Continue:
+		msg = "unreachable code after continue"
+		fix = "The loop starts its next iteration before it gets here. Remove the code, or move it before the continue."
+	case *parser.If:
+		msg = fmt.Sprintf("unreachable code; no branch of the if at line %d continues past it", spanOf(s.Condition).from.Line)
+	case *parser.Match:
+		msg = fmt.Sprintf("unreachable code; no arm of the match at line %d continues past it", spanOf(s.Expr).from.Line)
+	case *parser.While:
+		msg = fmt.Sprintf("unreachable code after the endless loop at line %d", spanOf(s.Condition).from.Line)
+		fix = "Nothing breaks out of the loop. Add a break to it, or remove the code."
+	case *parser.Case:
+		msg = "unreachable match arm; an earlier arm matches every value"
+		if line := spanOf(s.Pattern).from.Line; line != 0 {
+			msg = fmt.Sprintf("unreachable match arm; the arm at line %d matches every value", line)
+		}
+		fix = "Move this arm above the one that matches every value, or remove it."
+	default:
+		msg = "unreachable code"
+	}
+	return msg, fix
+}
+
+// missingReturn reports a function or lambda that returns a value on some
+// paths and reaches the end of its body on others.
+func (f *flowChecker) missingReturn(g *CFG, reach []bool) {
+	var ret *parser.Return
+	for _, b := range g.Blocks {
+		for _, n := range b.Nodes {
+			if r, ok := n.(*parser.Return); ok && reach[b.Index] && ret == nil {
+				ret = r
+			}
+		}
+	}
+	if ret == nil {
+		return
+	}
+	falls := false
+	for _, p := range g.Exit.Preds {
+		if !reach[p.Index] {
+			continue
+		}
+		if len(p.Nodes) > 0 {
+			if _, ok := p.Nodes[len(p.Nodes)-1].(*parser.Return); ok {
+				continue
+			}
+		}
+		falls = true
+	}
+	if !falls {
+		return
+	}
+	var what string
+	var pos parser.Pos
+	switch body := g.Body.(type) {
+	case *parser.Function:
+		if body.Name == nil || body.Name.Value == "" {
+			what, pos = "function", spanOf(body).from
+		} else {
+			what, pos = fmt.Sprintf("function '%s'", body.Name.Value), body.Name.Pos
+		}
+	case *parser.Block:
+		what, pos = "lambda", spanOf(body).from
+	default:
+		return
+	}
+	if pos.Line == 0 {
+		return
+	}
+	f.report(utils.MissingReturn, pos,
+		fmt.Sprintf("%s returns a value on some paths but not on others", what),
+		fmt.Sprintf("It returns a value at line %d but can also reach the end of its body, which returns zero. Return a value on every path.", spanOf(ret.Value).from.Line))
+}
+
+// unassigned reports the first read of each variable of the frame of g
+// that some path from the entry reaches before assigning it.
+func (f *flowChecker) unassigned(g *CFG) {
+	all := symbolSet{}
+	for sym, frame := range f.frames {
+		if frame == g.Body {
+			all[sym] = true
+		}
+	}
+	if len(all) == 0 {
+		return
+	}
+	events := make(map[parser.Node][]flowEvent)
+	for _, b := range g.Blocks {
+		for _, n := range b.Nodes {
+			events[n] = f.events(n, all, g.Body)
+		}
+	}
+	transfer := func(b *BasicBlock, in symbolSet) symbolSet {
+		out := in.copy()
+		for _, n := range b.Nodes {
+			for _, ev := range events[n] {
+				if ev.def {
+					out[ev.sym] = true
+				}
+			}
+		}
+		return out
+	}
+	// Definitely assigned on every path, and on at least one.
+	every, _ := Solve(g, Dataflow[symbolSet]{
+		Boundary: symbolSet{},
+		Init:     all,
+		Meet:     symbolSet.intersect,
+		Transfer: transfer,
+		Equal:    symbolSet.equal,
+	})
+	some, _ := Solve(g, Dataflow[symbolSet]{
+		Boundary: symbolSet{},
+		Init:     symbolSet{},
+		Meet:     symbolSet.union,
+		Transfer: transfer,
+		Equal:    symbolSet.equal,
+	})
+
+	reach := g.Reachable()
+	first := make(map[*Symbol]flowEvent)
+	maybe := make(map[*Symbol]bool)
+	for _, b := range g.Blocks {
+		if !reach[b.Index] {
+			continue
+		}
+		sure, any := every[b.Index].copy(), some[b.Index].copy()
+		for _, n := range b.Nodes {
+			for _, ev := range events[n] {
+				if ev.def {
+					sure[ev.sym], any[ev.sym] = true, true
+					continue
+				}
+				if sure[ev.sym] {
+					continue
+				}
+				if prev, ok := first[ev.sym]; !ok || posBefore(ev.pos, prev.pos) {
+					first[ev.sym], maybe[ev.sym] = ev, any[ev.sym]
+				}
+			}
+		}
+	}
+	for sym, ev := range first {
+		name := "'" + sym.Name + "'"
+		used := "used"
+		if ev.via != nil {
+			used = fmt.Sprintf("read by '%s'", ev.via.Name)
+		}
+		if maybe[sym] {
+			f.report(utils.UnassignedUse, ev.pos,
+				fmt.Sprintf("%s may be %s before it is assigned", name, used),
+				fmt.Sprintf("Some paths to here do not assign %s. Assign it on every path, or before the branch or loop that does.", name))
+			continue
+		}
+		fix := fmt.Sprintf("Assign %s before this point.", name)
+		if sym.Pos.Line > 0 && sym.Pos.Line != ev.pos.Line {
+			fix = fmt.Sprintf("%s is first assigned at line %d; assign it before this point.", name, sym.Pos.Line)
+		}
+		f.report(utils.UnassignedUse, ev.pos, fmt.Sprintf("%s is %s before it is assigned", name, used), fix)
+	}
+}
+
+// flowEvent is a read or an assignment of a variable by a node, directly or
+// through a call of via.
+type flowEvent struct {
+	sym *Symbol
+	def bool
+	pos parser.Pos
+	via *Symbol
+}
+
+// events lists the reads of the variables in vars that node n makes,
+// followed by its assignments of them. Lambdas and functions declared in n
+// read nothing until they are called. In the module body, calls of
+// top-level functions read and assign what those functions do.
+func (f *flowChecker) events(n parser.Node, vars symbolSet, body parser.Node) []flowEvent {
+	var reads, defs []flowEvent
+	def := func(ident parser.Node) {
+		if sym := f.res.SymbolOf(ident); sym != nil && vars[sym] {
+			defs = append(defs, flowEvent{sym: sym, def: true})
+		}
+	}
+	var root parser.Node = n
+	switch n := n.(type) {
+	case *parser.Function:
+		return nil
+	case *parser.For:
+		for _, v := range []*parser.Identifier{n.Index, n.Value} {
+			if v != nil {
+				def(v)
+			}
+		}
+		return defs
+	case *parser.Case:
+		parser.Inspect(n.Pattern, func(p parser.Node) bool {
+			if sym := f.res.SymbolOf(p); sym != nil && sym.Decl == p {
+				def(p)
+			}
+			return true
+		})
+		return defs
+	case *parser.Assignment:
+		root = n.Value
+		for _, name := range n.Names {
+			def(name)
+		}
+	}
+	_, module := body.(*parser.Program)
+	parser.Inspect(root, func(node parser.Node) bool {
+		switch node := node.(type) {
+		case *parser.Function, *parser.Block:
+			return false
+		case *parser.Identifier, *parser.Spread:
+			if sym := f.res.SymbolOf(node); sym != nil && vars[sym] {
+				reads = append(reads, flowEvent{sym: sym, pos: NodePos(node)})
+			}
+		case *parser.Call:
+			ident, ok := node.Function.(*parser.Identifier)
+			if !ok || !module {
+				break
+			}
+			sym := f.res.SymbolOf(ident)
+			e := f.effects[sym]
+			if e == nil {
+				break
+			}
+			for _, v := range e.reads.sorted() {
+				if vars[v] && !e.writes[v] {
+					reads = append(reads, flowEvent{sym: v, pos: ident.Pos, via: sym})
+				}
+			}
+			for _, v := range e.writes.sorted() {
+				if vars[v] {
+					defs = append(defs, flowEvent{sym: v, def: true})
+				}
+			}
+		}
+		return true
+	})
+	return append(reads, defs...)
+}
+
+func (f *flowChecker) report(kind utils.ErrorKind, pos parser.Pos, msg, fix string) {
+	f.warns = append(f.warns, utils.ParseError{
+		Kind:    kind,
+		Message: msg,
+		File:    f.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Snippet: sourceLine(f.lines, pos.Line),
+		Caret:   pos.Column,
+		Fix:     fix,
+	})
+}
+
+// symbolSet is a set of symbols. Its methods other than addAll leave their
+// receiver and argument unchanged.
+type symbolSet map[*Symbol]bool
+
+func (s symbolSet) copy() symbolSet {
+	out := make(symbolSet, len(s))
+	for sym := range s {
+		out[sym] = true
+	}
+	return out
+}
+
+func (s symbolSet) union(t symbolSet) symbolSet {
+	out := s.copy()
+	out.addAll(t)
+	return out
+}
+
+func (s symbolSet) intersect(t symbolSet) symbolSet {
+	out := symbolSet{}
+	for sym := range s {
+		if t[sym] {
+			out[sym] = true
+		}
+	}
+	return out
+}
+
+func (s symbolSet) equal(t symbolSet) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for sym := range s {
+		if !t[sym] {
+			return false
+		}
+	}
+	return true
+}
+
+// addAll adds the symbols of t to s and reports whether that changed s.
+func (s symbolSet) addAll(t symbolSet) bool {
+	changed := false
+	for sym := range t {
+		if !s[sym] {
+			s[sym] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// sorted returns the symbols of s in the order they are declared.
+func (s symbolSet) sorted() []*Symbol {
+	var out []*Symbol
+	for sym := range s {
+		out = append(out, sym)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Pos != out[j].Pos {
+			return posBefore(out[i].Pos, out[j].Pos)
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"aether/lib/utils"
+	"aether/src/scheduler"
 )
 
 func AnalyzeImports(files []string) (map[string][]string, error) {
@@ -48,6 +49,59 @@ func extractImportsFromAST(ast *parser.Program) []string {
 	return imports
 }
 
+// ImportCycles reports the import cycles of graph, which maps each file to
+// the files it imports. Each import in a cycle gets an error at its import
+// statement that spells out the whole chain, e.g. "a.ae → b.ae → a.ae", so
+// every file of the cycle shows where it takes part.
+func ImportCycles(graph map[string][]string) []utils.ParseError {
+	var errs []utils.ParseError
+	for _, cycle := range scheduler.DetectCycles(graph) {
+		names := make([]string, len(cycle))
+		for i, file := range cycle {
+			names[i] = filepath.Base(file)
+		}
+		chain := strings.Join(names, " → ")
+		for i := 0; i+1 < len(cycle); i++ {
+			from, to := cycle[i], cycle[i+1]
+			err := utils.ParseError{
+				Kind:    utils.ImportCycle,
+				Message: fmt.Sprintf("import cycle %s: %s imports %s", chain, names[i], names[i+1]),
+				File:    from,
+				Fix:     "Modules cannot import each other. Move what they share into a module that imports none of them, or remove one of the imports.",
+			}
+			if stmt, source := findImport(from, to); stmt != nil {
+				err.Line = stmt.Name.Pos.Line
+				err.Column = stmt.Name.Pos.Column
+				err.Snippet = sourceLine(strings.Split(source, "\n"), err.Line)
+				err.Caret = err.Column
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// findImport finds the import statement of file that names the module in
+// target, the way the build resolves imports by base name, and returns it
+// with the source of file.
+func findImport(file, target string) (*parser.Import, string) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, ""
+	}
+	module := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(target), ".ae"), ".aeth")
+	for _, stmt := range parser.NewParser(lexer.NewLexer(string(src))).Parse().Statements {
+		imp, ok := stmt.(*parser.Import)
+		if !ok || imp.Name == nil {
+			continue
+		}
+		if imp.Name.Value == module || filepath.Base(imp.Name.Value) == module || imp.As != nil && imp.As.Value == module {
+			return imp, string(src)
+		}
+	}
+	return nil, string(src)
+}
+
 func AnalyzeImportStatement(importStmt *parser.Import, filePath string, result *AnalysisResult) {
 	importPath := importStmt.Name.Value
 	importInfo := ImportInfo{
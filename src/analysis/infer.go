@@ -0,0 +1,1179 @@
+package analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"aether/src/parser"
+)
+
+// Types are inferred Hindley-Milner style. Every expression gets a type,
+// types that are not known yet are type variables, and using two values
+// together unifies their types. Top-level functions are generalized once
+// their bodies have been inferred, so every call instantiates its own copy
+// of the function's type and a function may be used at several types.
+//
+// Numbers follow the compiler rather than plain unification: arithmetic on
+// two known numeric types yields the wider one, arguments are converted to
+// numeric parameter types, and number literals take the type they are used
+// at, defaulting to int and float.
+
+// TypeTable holds the inferred types of a program. Types are spelled like
+// annotations, e.g. "int", "u8", "[string]" or "(int, float)"; the empty
+// string means the type is not known, or is a function type.
+type TypeTable struct {
+	exprs   map[parser.Expression]Type
+	funcs   map[*parser.Function]*FuncType
+	vars    map[string]Type
+	structs map[string]*StructType
+}
+
+// TypeOf returns the type of expr.
+func (t *TypeTable) TypeOf(expr parser.Expression) string {
+	if t == nil {
+		return ""
+	}
+	return spell(t.exprs[expr])
+}
+
+// ValueType returns the type of expr like TypeOf, except that function
+// types are spelled too, as func(A, ...[B]): R, so that the compiler can
+// lay out the closures a value holds.
+func (t *TypeTable) ValueType(expr parser.Expression) string {
+	if t == nil || !known(t.exprs[expr], true) {
+		return ""
+	}
+	return prune(t.exprs[expr]).String()
+}
+
+// Params returns the types of fn's parameters. A final ...rest parameter
+// has the type of the array that collects the extra arguments.
+func (t *TypeTable) Params(fn *parser.Function) []string {
+	if t == nil || t.funcs[fn] == nil {
+		return make([]string, len(fn.Params))
+	}
+	params := make([]string, len(fn.Params))
+	for i, p := range t.funcs[fn].Params {
+		params[i] = spell(p)
+	}
+	return params
+}
+
+// Arity returns the number of parameters of expr when it is a function,
+// and whether the last of them is a ...rest parameter.
+func (t *TypeTable) Arity(expr parser.Expression) (params int, rest bool, ok bool) {
+	if t == nil || t.exprs[expr] == nil {
+		return 0, false, false
+	}
+	ft, ok := prune(t.exprs[expr]).(*FuncType)
+	if !ok {
+		return 0, false, false
+	}
+	return len(ft.Params), ft.Variadic, true
+}
+
+// Return returns the type fn returns.
+func (t *TypeTable) Return(fn *parser.Function) string {
+	if t == nil || t.funcs[fn] == nil {
+		return ""
+	}
+	return spell(t.funcs[fn].Ret)
+}
+
+// Variable returns the type of the variable name. Variables are looked up
+// by name only, so for a name bound in several places the first binding
+// wins.
+func (t *TypeTable) Variable(name string) string {
+	if t == nil {
+		return ""
+	}
+	return spell(t.vars[name])
+}
+
+// Field returns the type of the field of the struct declared as name.
+func (t *TypeTable) Field(name, field string) string {
+	if t == nil || t.structs[name] == nil {
+		return ""
+	}
+	return spell(t.structs[name].Fields[field])
+}
+
+func spell(t Type) string {
+	if t == nil || !resolved(t) {
+		return ""
+	}
+	return prune(t).String()
+}
+
+// InferTypes infers the types of prog. imports holds the exports of the
+// modules prog may use, as returned by ModuleExports and keyed by module
+// name.
+func InferTypes(prog *parser.Program, imports map[string]map[string]interface{}) *TypeTable {
+	in := newInferrer(imports)
+	in.run(prog)
+	return in.table
+}
+
+func newInferrer(imports map[string]map[string]interface{}) *inferrer {
+	structs := make(map[string]*StructType)
+	return &inferrer{
+		table: &TypeTable{
+			exprs:   make(map[parser.Expression]Type),
+			funcs:   make(map[*parser.Function]*FuncType),
+			vars:    make(map[string]Type),
+			structs: structs,
+		},
+		funcs:    make(map[string]*parser.Function),
+		schemes:  make(map[*parser.Function]*Scheme),
+		active:   make(map[*parser.Function]*FuncType),
+		structs:  structs,
+		foreign:  make(map[string]*FuncType),
+		imports:  imports,
+		aliases:  make(map[string]string),
+		globals:  newTypeScope(nil),
+		declared: make(map[string]bool),
+	}
+}
+
+func (in *inferrer) run(prog *parser.Program) {
+	for _, stmt := range prog.Statements {
+		if parser.IsNilNode(stmt) {
+			continue
+		}
+		switch s := stmt.(type) {
+		case *parser.StructDef:
+			in.declared[s.Name.Value] = true
+			st := in.structType(s.Name.Value)
+			for _, f := range s.Fields {
+				st.Fields[f.Name.Value] = in.annotation(f.Type)
+			}
+		case *parser.ForeignFunction:
+			in.foreign[s.Name.Value] = foreignType(s.Params, s.ReturnType)
+		case *parser.Function:
+			if s.Name != nil && s.Name.Value != "" {
+				in.funcs[s.Name.Value] = s
+			}
+		case *parser.Assignment:
+			// Declared up front so that functions can use module
+			// variables bound further down.
+			if len(s.Names) == 1 && in.globals.vars[s.Names[0].Value] == nil {
+				in.globals.define(s.Names[0].Value, in.fresh(anyClass))
+				in.record(s.Names[0].Value, in.globals.vars[s.Names[0].Value].t)
+			}
+		}
+	}
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*parser.Function); ok && fn != nil && fn.Name != nil && fn.Name.Value != "" {
+			continue
+		}
+		in.stmt(stmt, in.globals)
+	}
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*parser.Function); ok && fn != nil && fn.Name != nil && fn.Name.Value != "" {
+			in.function(fn)
+		}
+	}
+	// Before numbers default to int, note which mismatches expected any
+	// number at all.
+	for i, te := range in.errs {
+		if v, ok := prune(te.expected).(*TypeVar); ok && te.want == "" {
+			switch v.class {
+			case numericClass:
+				in.errs[i].want = "a number"
+			case floatClass:
+				in.errs[i].want = "a float"
+			}
+		}
+	}
+	for _, v := range in.vars {
+		if v.ref != nil {
+			continue
+		}
+		switch v.class {
+		case numericClass:
+			v.ref = basic("int")
+		case floatClass:
+			v.ref = basic("float")
+		}
+	}
+}
+
+type inferrer struct {
+	table   *TypeTable
+	vars    []*TypeVar
+	funcs   map[string]*parser.Function
+	schemes map[*parser.Function]*Scheme
+	active  map[*parser.Function]*FuncType
+	structs map[string]*StructType
+	foreign map[string]*FuncType
+	imports map[string]map[string]interface{}
+	aliases map[string]string
+	globals *typeScope
+	// ret is the return type of the function or lambda being inferred,
+	// and fnName the name reported for it.
+	ret    Type
+	fnName string
+	// declared holds the structs declared with the struct keyword.
+	declared map[string]bool
+	errs     []typeError
+	// hints holds types for the unannotated parameters of the top-level
+	// functions, by function name, as other modules pass them.
+	hints map[string][]string
+	// uses records every use of a function of an imported module, at the
+	// type it is used at.
+	uses []importUse
+}
+
+// importUse is a use of the function name of module at type t.
+type importUse struct {
+	module, name string
+	t            *FuncType
+}
+
+// typeError is a type mismatch found by inference. The types are spelled
+// when the error is reported, once inference has learned all it can.
+type typeError struct {
+	at       parser.Expression
+	what     string
+	expected Type
+	found    Type
+	// want describes the expected type when it is a kind of type, such
+	// as a number, rather than a type.
+	want string
+	// message replaces the usual "what: expected ..., found ..." text.
+	message string
+}
+
+func (in *inferrer) mismatch(at parser.Expression, what string, expected, found Type) {
+	in.errs = append(in.errs, typeError{at: at, what: what, expected: expected, found: found})
+}
+
+// expect converts a value of type found, from at, to expected, and reports
+// a mismatch when it cannot be.
+func (in *inferrer) expect(at parser.Expression, what string, expected, found Type) {
+	if !convert(expected, found) {
+		in.mismatch(at, what, expected, found)
+	}
+}
+
+// typeScope maps the variables of a block to their types.
+type typeScope struct {
+	vars   map[string]*Scheme
+	parent *typeScope
+}
+
+func newTypeScope(parent *typeScope) *typeScope {
+	return &typeScope{vars: make(map[string]*Scheme), parent: parent}
+}
+
+func (s *typeScope) lookup(name string) *Scheme {
+	for ; s != nil; s = s.parent {
+		if sc, ok := s.vars[name]; ok {
+			return sc
+		}
+	}
+	return nil
+}
+
+func (s *typeScope) define(name string, t Type) {
+	s.vars[name] = &Scheme{t: t}
+}
+
+func (in *inferrer) fresh(class numClass) *TypeVar {
+	v := &TypeVar{id: len(in.vars), class: class}
+	in.vars = append(in.vars, v)
+	return v
+}
+
+// record notes the type of a variable for TypeTable.Variable.
+func (in *inferrer) record(name string, t Type) {
+	if _, seen := in.table.vars[name]; !seen {
+		in.table.vars[name] = t
+	}
+}
+
+func (in *inferrer) structType(name string) *StructType {
+	st, ok := in.structs[name]
+	if !ok {
+		st = &StructType{Name: name, Fields: make(map[string]Type)}
+		in.structs[name] = st
+	}
+	return st
+}
+
+// instantiate copies the type of sc with fresh variables for the
+// generalized ones.
+func (in *inferrer) instantiate(sc *Scheme) Type {
+	if len(sc.vars) == 0 {
+		return sc.t
+	}
+	subst := make(map[*TypeVar]Type, len(sc.vars))
+	for _, v := range sc.vars {
+		subst[v] = in.fresh(v.class)
+	}
+	return substitute(sc.t, subst)
+}
+
+func substitute(t Type, subst map[*TypeVar]Type) Type {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		if u, ok := subst[t]; ok {
+			return u
+		}
+		return t
+	case *ArrayType:
+		return &ArrayType{Elem: substitute(t.Elem, subst)}
+	case *TupleType:
+		elems := make([]Type, len(t.Elems))
+		for i, e := range t.Elems {
+			elems[i] = substitute(e, subst)
+		}
+		return &TupleType{Elems: elems}
+	case *FuncType:
+		params := make([]Type, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = substitute(p, subst)
+		}
+		return &FuncType{Params: params, Ret: substitute(t.Ret, subst), Variadic: t.Variadic}
+	case *StructType:
+		if t.Name != "" {
+			return t
+		}
+		fields := make(map[string]Type, len(t.Fields))
+		for name, f := range t.Fields {
+			fields[name] = substitute(f, subst)
+		}
+		return &StructType{Fields: fields}
+	default:
+		return t
+	}
+}
+
+// generalize quantifies t over the variables that neither the module
+// variables nor the functions still being inferred mention.
+func (in *inferrer) generalize(t Type) *Scheme {
+	free := make(map[*TypeVar]bool)
+	freeVars(t, free)
+	env := make(map[*TypeVar]bool)
+	for _, sc := range in.globals.vars {
+		freeVars(sc.t, env)
+	}
+	for _, ft := range in.active {
+		freeVars(ft, env)
+	}
+	sc := &Scheme{t: t}
+	for v := range free {
+		if !env[v] {
+			sc.vars = append(sc.vars, v)
+		}
+	}
+	return sc
+}
+
+// function infers the type of a top-level function, unless that was done
+// already, and returns its scheme. A function that is still being inferred,
+// because it is recursive, is used at its own type.
+func (in *inferrer) function(fn *parser.Function) *Scheme {
+	if sc, ok := in.schemes[fn]; ok {
+		return sc
+	}
+	if ft, ok := in.active[fn]; ok {
+		return &Scheme{t: ft}
+	}
+	ft := in.signature(fn)
+	in.active[fn] = ft
+	in.body(fn, ft, in.globals)
+	delete(in.active, fn)
+	sc := in.generalize(ft)
+	in.schemes[fn] = sc
+	return sc
+}
+
+// signature makes the type of fn from its annotations, with variables for
+// the parameters that have none.
+func (in *inferrer) signature(fn *parser.Function) *FuncType {
+	ft := &FuncType{Params: make([]Type, len(fn.Params)), Ret: in.fresh(anyClass)}
+	hints := in.hints[fn.Name.Value]
+	for i, p := range fn.Params {
+		if p.Type == "" && i < len(hints) && hints[i] != "" {
+			ft.Params[i] = in.typeName(hints[i])
+			ft.Variadic = p.IsVararg && i == len(fn.Params)-1
+			continue
+		}
+		t := in.annotation(p.Type)
+		if p.IsVararg {
+			t = &ArrayType{Elem: t}
+			ft.Variadic = i == len(fn.Params)-1
+		}
+		ft.Params[i] = t
+	}
+	in.table.funcs[fn] = ft
+	return ft
+}
+
+// body infers the body of fn, whose parameters have the types of ft, in a
+// scope below parent.
+func (in *inferrer) body(fn *parser.Function, ft *FuncType, parent *typeScope) {
+	sc := newTypeScope(parent)
+	for i, p := range fn.Params {
+		sc.define(p.Value, ft.Params[i])
+		in.record(p.Value, ft.Params[i])
+	}
+	savedRet, savedName := in.ret, in.fnName
+	in.ret, in.fnName = ft.Ret, fn.Name.Value
+	if fn.Body != nil {
+		in.block(fn.Body, sc)
+	}
+	in.ret, in.fnName = savedRet, savedName
+}
+
+// annotation returns the type an annotation names, or a variable when there
+// is none.
+func (in *inferrer) annotation(name string) Type {
+	if name == "" {
+		return in.fresh(anyClass)
+	}
+	return in.typeName(name)
+}
+
+// typeName parses a type spelled like an annotation, as the types of
+// module exports are. Unknown names become variables.
+func (in *inferrer) typeName(name string) Type {
+	name = strings.TrimSpace(name)
+	n := len(name)
+	switch {
+	case n >= 2 && name[0] == '[' && name[n-1] == ']':
+		return &ArrayType{Elem: in.typeName(name[1 : n-1])}
+	case n >= 2 && name[0] == '(' && name[n-1] == ')':
+		var elems []Type
+		for _, part := range splitTypes(name[1 : n-1]) {
+			elems = append(elems, in.typeName(part))
+		}
+		return &TupleType{Elems: elems}
+	case builtinTypes[name]:
+		return basic(name)
+	case in.structs[name] != nil:
+		return in.structs[name]
+	}
+	return in.fresh(anyClass)
+}
+
+// splitTypes splits "A, (B, C), [D]" at the commas that are not nested in
+// brackets.
+func splitTypes(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cTypeNames maps the C type names of foreign declarations to Aether types.
+var cTypeNames = map[string]string{
+	"int": "int", "uint": "u32", "char": "i8", "uchar": "u8", "byte": "u8",
+	"short": "i16", "ushort": "u16", "long": "i64", "ulong": "u64",
+	"size_t": "u64", "ssize_t": "i64", "float": "f32", "double": "float",
+	"cstr": "string", "str": "string", "void": "void",
+}
+
+func cTypeOf(name string) Type {
+	if t, ok := cTypeNames[name]; ok {
+		return basic(t)
+	}
+	if name == "" {
+		return basic("int")
+	}
+	return basic(name)
+}
+
+// foreignType returns the type of a foreign function. C varargs take
+// values of any type, so they are left out.
+func foreignType(params []*parser.Identifier, ret string) *FuncType {
+	ft := &FuncType{Ret: cTypeOf(ret)}
+	for _, p := range params {
+		ft.Params = append(ft.Params, cTypeOf(p.Type))
+	}
+	return ft
+}
+
+// exportType returns the type of an export of an imported module.
+func (in *inferrer) exportType(export interface{}) Type {
+	switch e := export.(type) {
+	case FunctionInfo:
+		if e.Foreign {
+			params := make([]*parser.Identifier, len(e.Parameters))
+			for i, p := range e.Parameters {
+				params[i] = &parser.Identifier{Value: p.Name, Type: p.Type}
+			}
+			return foreignType(params, e.ReturnType)
+		}
+		ft := &FuncType{Ret: in.typeName(e.ReturnType)}
+		for i, p := range e.Parameters {
+			ft.Params = append(ft.Params, in.typeName(p.Type))
+			ft.Variadic = p.Variadic && i == len(e.Parameters)-1
+		}
+		return ft
+	case VariableInfo:
+		return in.typeName(e.Type)
+	}
+	return in.fresh(anyClass)
+}
+
+// module returns the name and exports of the module an identifier names,
+// if it is not a variable.
+func (in *inferrer) module(expr parser.Expression, sc *typeScope) (string, map[string]interface{}, bool) {
+	ident, ok := expr.(*parser.Identifier)
+	if !ok || sc.lookup(ident.Value) != nil {
+		return "", nil, false
+	}
+	name := ident.Value
+	if target, ok := in.aliases[name]; ok {
+		name = target
+	}
+	exports, ok := in.imports[name]
+	return name, exports, ok
+}
+
+func (in *inferrer) block(b *parser.Block, sc *typeScope) {
+	for _, stmt := range b.Statements {
+		in.stmt(stmt, sc)
+	}
+}
+
+func (in *inferrer) stmt(stmt parser.Statement, sc *typeScope) {
+	if parser.IsNilNode(stmt) {
+		return
+	}
+	switch s := stmt.(type) {
+	case *parser.Assignment:
+		in.assign(s, sc)
+	case *parser.ElementAssignment:
+		val := in.expr(s.Value, sc)
+		switch target := s.Target.(type) {
+		case *parser.ArrayIndex:
+			arr := in.expr(target.Array, sc)
+			in.expr(target.Index, sc)
+			if _, isString := prune(arr).(*BasicType); !isString {
+				elem := in.fresh(anyClass)
+				unify(arr, &ArrayType{Elem: elem})
+				in.expect(s.Value, "array element", elem, val)
+			}
+		case *parser.PropertyAccess:
+			in.expect(s.Value, "field '"+target.Property.Value+"'", in.expr(target, sc), val)
+		}
+	case *parser.Function:
+		if s.Name == nil || s.Name.Value == "" {
+			return
+		}
+		// Nested functions are not generalized; their signature is
+		// bound first so that they can call themselves.
+		ft := in.signature(s)
+		sc.define(s.Name.Value, ft)
+		in.body(s, ft, sc)
+	case *parser.Import:
+		if s.As != nil && s.As.Value != "" {
+			in.aliases[s.As.Value] = s.Name.Value
+		}
+		// The foreign functions of a module can be called unqualified.
+		for name, export := range in.imports[s.Name.Value] {
+			if f, ok := export.(FunctionInfo); ok && f.Foreign {
+				if _, bound := in.foreign[name]; !bound {
+					in.foreign[name] = in.exportType(f).(*FuncType)
+				}
+			}
+		}
+	case *parser.If:
+		in.expr(s.Condition, sc)
+		if s.Consequence != nil {
+			in.block(s.Consequence, newTypeScope(sc))
+		}
+		if s.Alternative != nil {
+			in.block(s.Alternative, newTypeScope(sc))
+		}
+	case *parser.While:
+		in.expr(s.Condition, sc)
+		in.block(s.Body, newTypeScope(sc))
+	case *parser.Repeat:
+		in.expr(s.Count, sc)
+		in.block(s.Body, newTypeScope(sc))
+	case *parser.For:
+		iter := in.expr(s.Iterable, sc)
+		elem := in.fresh(anyClass)
+		unify(iter, &ArrayType{Elem: elem})
+		body := newTypeScope(sc)
+		if s.Index != nil {
+			body.define(s.Index.Value, basic("int"))
+		}
+		if s.Value != nil {
+			body.define(s.Value.Value, elem)
+			in.record(s.Value.Value, elem)
+		}
+		in.block(s.Body, body)
+	case *parser.Match:
+		subject := in.expr(s.Expr, sc)
+		for _, c := range s.Cases {
+			arm := newTypeScope(sc)
+			in.pattern(c.Pattern, subject, arm)
+			in.block(c.Body, arm)
+		}
+	case *parser.Return:
+		if s.Value != nil && in.ret != nil {
+			what := "return value"
+			if in.fnName != "" {
+				what += " of '" + in.fnName + "'"
+			}
+			in.expect(s.Value, what, in.ret, in.expr(s.Value, sc))
+		}
+	case *parser.Block:
+		in.block(s, newTypeScope(sc))
+	case *parser.ExpressionStatement:
+		in.expr(s.Expr, sc)
+	case parser.Expression:
+		in.expr(s, sc)
+	}
+}
+
+// assign binds the names of an assignment. Assigning to a variable in scope
+// unifies with its type; when the types cannot be unified the variable is
+// rebound, as the compiler does. Destructuring a tuple, or an array
+// literal, into the wrong number of names is reported at the first name.
+func (in *inferrer) assign(s *parser.Assignment, sc *typeScope) {
+	if len(s.Names) == 1 {
+		in.bind(s.Names[0], in.expr(s.Value, sc), sc)
+		return
+	}
+	var elems []Type
+	if tuple, ok := s.Value.(*parser.Tuple); ok && len(tuple.Elements) == len(s.Names) {
+		elems = in.expr(tuple, sc).(*TupleType).Elems
+	} else {
+		val := in.expr(s.Value, sc)
+		elems = make([]Type, len(s.Names))
+		for i := range elems {
+			elems[i] = in.fresh(anyClass)
+		}
+		if n := valueCount(s.Value, val); n >= 0 && n != len(s.Names) {
+			in.errs = append(in.errs, typeError{at: s.Names[0], message: fmt.Sprintf(
+				"assignment to %d names: expected %d values, found %d", len(s.Names), len(s.Names), n)})
+		} else {
+			unify(val, &TupleType{Elems: elems})
+		}
+	}
+	for i, name := range s.Names {
+		in.bind(name, elems[i], sc)
+	}
+}
+
+// valueCount returns how many values the right side of a destructuring
+// assignment holds, or -1 when that is known only at run time.
+func valueCount(expr parser.Expression, t Type) int {
+	switch e := expr.(type) {
+	case *parser.Tuple:
+		return len(e.Elements)
+	case *parser.Array:
+		return len(e.Elements)
+	}
+	if tuple, ok := prune(t).(*TupleType); ok {
+		return len(tuple.Elems)
+	}
+	return -1
+}
+
+func (in *inferrer) bind(name *parser.Identifier, t Type, sc *typeScope) {
+	if name.Value == "_" {
+		return
+	}
+	if name.Type != "" {
+		ann := in.typeName(name.Type)
+		convert(ann, t)
+		t = ann
+	}
+	if prev := sc.lookup(name.Value); prev != nil && len(prev.vars) == 0 {
+		if convertible(prev.t, t) || unify(prev.t, t) {
+			return
+		}
+	}
+	sc.define(name.Value, t)
+	in.record(name.Value, t)
+}
+
+// convertible reports whether the compiler converts values of type from
+// to type to: both are known numbers, one is a bool and the other a
+// number, or a string is passed as a pointer.
+func convertible(to, from Type) bool {
+	if isBool(to) && isNumber(from) || isNumber(to) && isBool(from) {
+		return true
+	}
+	if _, ok := numericName(to); ok {
+		_, ok = numericName(from)
+		return ok
+	}
+	a, okA := prune(to).(*BasicType)
+	b, okB := prune(from).(*BasicType)
+	return okA && okB && a.Name == "ptr" && b.Name == "string"
+}
+
+func isBool(t Type) bool {
+	b, ok := prune(t).(*BasicType)
+	return ok && b.Name == "bool"
+}
+
+// isNumber reports whether t is a numeric type or a variable restricted to
+// them.
+func isNumber(t Type) bool {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		return t.class != anyClass
+	case *BasicType:
+		_, numeric := numericWidths[t.Name]
+		return numeric
+	}
+	return false
+}
+
+// convert records that a value of type from is stored where a value of
+// type to is expected, and reports whether that is possible. Scalars are
+// converted, everything else must unify.
+func convert(to, from Type) bool {
+	return convertible(to, from) || unify(to, from)
+}
+
+// widen returns the type of an arithmetic result on operands of types a
+// and b: the wider type when both are known numbers, their unified type
+// otherwise. ok is false when the types do not go together.
+func widen(a, b Type) (t Type, ok bool) {
+	na, okA := numericName(a)
+	nb, okB := numericName(b)
+	if okA && okB {
+		if numericWidths[nb] > numericWidths[na] {
+			return b, true
+		}
+		return a, true
+	}
+	if convertible(a, b) {
+		return a, true
+	}
+	return a, unify(a, b)
+}
+
+// number requires an operand of op to be a number. A type that is not
+// known yet is restricted to numbers.
+func (in *inferrer) number(at parser.Expression, op string, t Type) {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		if t.class == anyClass {
+			t.class = numericClass
+		}
+		return
+	case *BasicType:
+		if _, numeric := numericWidths[t.Name]; numeric {
+			return
+		}
+	}
+	in.errs = append(in.errs, typeError{at: at, what: "operand of '" + op + "'", want: "a number", found: t})
+}
+
+// pattern binds the variables of a match pattern against a subject of
+// type t.
+func (in *inferrer) pattern(pat parser.Expression, t Type, sc *typeScope) {
+	switch p := pat.(type) {
+	case *parser.Identifier:
+		switch p.Value {
+		case "_":
+		case "true", "false":
+			unify(t, basic("bool"))
+		default:
+			sc.define(p.Value, t)
+			in.record(p.Value, t)
+		}
+	case *parser.Literal:
+		in.expect(p, "match pattern", t, in.expr(p, sc))
+	case *parser.Array:
+		elem := in.fresh(anyClass)
+		unify(t, &ArrayType{Elem: elem})
+		for _, sub := range p.Elements {
+			if rest, ok := sub.(*parser.Spread); ok {
+				if rest.Name != "" {
+					sc.define(rest.Name, t)
+					in.record(rest.Name, t)
+				}
+				continue
+			}
+			in.pattern(sub, elem, sc)
+		}
+	case *parser.StructInstantiation:
+		st, ok := prune(t).(*StructType)
+		if !ok {
+			return
+		}
+		for name, sub := range p.Fields {
+			field, ok := st.Fields[name]
+			if !ok {
+				field = in.fresh(anyClass)
+			}
+			in.pattern(sub, field, sc)
+		}
+	}
+}
+
+// expr infers the type of e and records it in the table.
+func (in *inferrer) expr(e parser.Expression, sc *typeScope) Type {
+	if e == nil {
+		return in.fresh(anyClass)
+	}
+	t := in.infer(e, sc)
+	in.table.exprs[e] = t
+	return t
+}
+
+func (in *inferrer) infer(e parser.Expression, sc *typeScope) Type {
+	switch e := e.(type) {
+	case *parser.Identifier:
+		return in.identifier(e.Value, sc)
+	case *parser.Literal:
+		return literalType(e, in)
+	case *parser.Array:
+		var elem Type = in.fresh(anyClass)
+		for _, el := range e.Elements {
+			var t Type
+			if s, ok := el.(*parser.Spread); ok {
+				t = in.fresh(anyClass)
+				unify(in.identifier(s.Name, sc), &ArrayType{Elem: t})
+			} else {
+				t = in.expr(el, sc)
+			}
+			wider, ok := widen(elem, t)
+			if !ok {
+				in.mismatch(el, "array element", elem, t)
+			}
+			elem = wider
+		}
+		return &ArrayType{Elem: elem}
+	case *parser.Tuple:
+		elems := make([]Type, len(e.Elements))
+		for i, el := range e.Elements {
+			elems[i] = in.expr(el, sc)
+		}
+		return &TupleType{Elems: elems}
+	case *parser.Call:
+		return in.call(e, sc)
+	case *parser.PropertyAccess:
+		if module, exports, ok := in.module(e.Object, sc); ok {
+			if export, ok := exports[e.Property.Value]; ok {
+				t := in.exportType(export)
+				if ft, ok := t.(*FuncType); ok {
+					in.uses = append(in.uses, importUse{module: module, name: e.Property.Value, t: ft})
+				}
+				return t
+			}
+			return in.fresh(anyClass)
+		}
+		obj := in.expr(e.Object, sc)
+		if e.Property.Value == "length" {
+			if _, isStruct := prune(obj).(*StructType); !isStruct {
+				return basic("int")
+			}
+		}
+		if st, ok := prune(obj).(*StructType); ok {
+			if field, ok := st.Fields[e.Property.Value]; ok {
+				return field
+			}
+		}
+		return in.fresh(anyClass)
+	case *parser.ArrayIndex:
+		arr := in.expr(e.Array, sc)
+		in.expr(e.Index, sc)
+		if b, ok := prune(arr).(*BasicType); ok && b.Name == "string" {
+			return basic("string")
+		}
+		if tuple, ok := prune(arr).(*TupleType); ok {
+			if lit, ok := e.Index.(*parser.Literal); ok {
+				if s, ok := lit.Value.(string); ok {
+					if i, err := strconv.Atoi(s); err == nil && i >= 0 && i < len(tuple.Elems) {
+						return tuple.Elems[i]
+					}
+				}
+			}
+			return in.fresh(anyClass)
+		}
+		elem := in.fresh(anyClass)
+		unify(arr, &ArrayType{Elem: elem})
+		return elem
+	case *parser.Slice:
+		arr := in.expr(e.Array, sc)
+		if e.Low != nil {
+			in.expr(e.Low, sc)
+		}
+		if e.High != nil {
+			in.expr(e.High, sc)
+		}
+		return arr
+	case *parser.Cast:
+		in.expr(e.Expr, sc)
+		return basic(e.Type)
+	case *parser.StructInstantiation:
+		var st *StructType
+		if e.TypeName != nil && e.TypeName.Value != "" {
+			st = in.structType(e.TypeName.Value)
+		} else {
+			st = &StructType{Fields: make(map[string]Type)}
+		}
+		for name, val := range e.Fields {
+			t := in.expr(val, sc)
+			field, ok := st.Fields[name]
+			switch {
+			case ok:
+				in.expect(val, "field '"+name+"' of '"+st.Name+"'", field, t)
+			case in.declared[st.Name]:
+				in.errs = append(in.errs, typeError{at: val, message: "struct '" + st.Name + "' has no field '" + name + "'"})
+			default:
+				st.Fields[name] = t
+			}
+		}
+		return st
+	case *parser.Block:
+		// A lambda takes no arguments and returns what its return
+		// statements do.
+		ft := &FuncType{Ret: in.fresh(anyClass)}
+		savedRet, savedName := in.ret, in.fnName
+		in.ret, in.fnName = ft.Ret, ""
+		in.block(e, newTypeScope(sc))
+		in.ret, in.fnName = savedRet, savedName
+		return ft
+	case *parser.PartialApplication:
+		callee := in.expr(e.Function, sc)
+		ft, ok := prune(callee).(*FuncType)
+		if !ok {
+			return in.fresh(anyClass)
+		}
+		rest := &FuncType{Ret: ft.Ret}
+		for i, arg := range e.Args {
+			var param Type = in.fresh(anyClass)
+			if i < len(ft.Params) {
+				param = ft.Params[i]
+			}
+			if ident, ok := arg.(*parser.Identifier); ok && ident.Value == "_" {
+				rest.Params = append(rest.Params, param)
+				continue
+			}
+			in.expect(arg, "argument of '"+calleeName(e.Function)+"'", param, in.expr(arg, sc))
+		}
+		return rest
+	}
+	return in.fresh(anyClass)
+}
+
+func literalType(lit *parser.Literal, in *inferrer) Type {
+	switch v := lit.Value.(type) {
+	case bool:
+		return basic("bool")
+	case float64:
+		return in.fresh(floatClass)
+	case int:
+		return in.fresh(numericClass)
+	case string:
+		if lit.Kind == parser.StringLiteral {
+			return basic("string")
+		}
+		if strings.Contains(v, ".") {
+			return in.fresh(floatClass)
+		}
+		// Like the compiler, integers that do not fit an int are i64.
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && (n > 1<<31-1 || n < -1<<31) {
+			return basic("i64")
+		}
+		return in.fresh(numericClass)
+	}
+	return in.fresh(anyClass)
+}
+
+// identifier returns the type of a name: a variable, a function of the
+// module, which is instantiated, or a foreign function.
+func (in *inferrer) identifier(name string, sc *typeScope) Type {
+	switch name {
+	case "true", "false":
+		return basic("bool")
+	case "_":
+		return in.fresh(anyClass)
+	}
+	if s := sc.lookup(name); s != nil {
+		return in.instantiate(s)
+	}
+	if fn, ok := in.funcs[name]; ok {
+		return in.instantiate(in.function(fn))
+	}
+	if ft, ok := in.foreign[name]; ok {
+		return ft
+	}
+	return in.fresh(anyClass)
+}
+
+func (in *inferrer) call(e *parser.Call, sc *typeScope) Type {
+	if ident, ok := e.Function.(*parser.Identifier); ok {
+		switch ident.Value {
+		case "+", "-", "*", "/", "%":
+			types := make([]Type, len(e.Args))
+			for i, arg := range e.Args {
+				types[i] = in.expr(arg, sc)
+				in.number(arg, ident.Value, types[i])
+			}
+			switch len(types) {
+			case 1:
+				return types[0]
+			case 2:
+				t, _ := widen(types[0], types[1])
+				return t
+			}
+		case "==", "!=", "<", ">", "<=", ">=":
+			if len(e.Args) == 2 {
+				left, right := in.expr(e.Args[0], sc), in.expr(e.Args[1], sc)
+				if _, ok := widen(left, right); !ok {
+					in.mismatch(e.Args[1], "right operand of '"+ident.Value+"'", left, right)
+				}
+				return basic("bool")
+			}
+			in.args(e.Args, sc)
+			return basic("bool")
+		case "!", "&&", "||":
+			in.args(e.Args, sc)
+			return basic("bool")
+		case "..":
+			in.args(e.Args, sc)
+			return basic("string")
+		}
+		if sc.lookup(ident.Value) == nil {
+			switch ident.Value {
+			case "print":
+				in.args(e.Args, sc)
+				return basic("int")
+			case "len":
+				in.args(e.Args, sc)
+				return basic("int")
+			case "append":
+				if len(e.Args) == 2 {
+					arr := in.expr(e.Args[0], sc)
+					in.push(e.Args[0], "argument 1 of 'append'", arr, e.Args[1], in.expr(e.Args[1], sc))
+					return arr
+				}
+			case "copy":
+				if len(e.Args) == 1 {
+					arr := in.expr(e.Args[0], sc)
+					if !unify(arr, &ArrayType{Elem: in.fresh(anyClass)}) {
+						in.errs = append(in.errs, typeError{at: e.Args[0], what: "argument 1 of 'copy'", want: "an array", found: arr})
+					}
+					return arr
+				}
+			}
+		}
+	}
+	if prop, ok := e.Function.(*parser.PropertyAccess); ok {
+		if _, _, isModule := in.module(prop.Object, sc); !isModule && len(e.Args) == 1 {
+			switch prop.Property.Value {
+			case "push", "append":
+				arr := in.expr(prop.Object, sc)
+				in.push(prop.Object, "receiver of '"+calleeName(prop)+"'", arr, e.Args[0], in.expr(e.Args[0], sc))
+				return arr
+			case "map":
+				arr := in.expr(prop.Object, sc)
+				if ft, ok := prune(in.expr(e.Args[0], sc)).(*FuncType); ok {
+					// A function given to map takes each element.
+					if len(ft.Params) > 0 {
+						unify(arr, &ArrayType{Elem: ft.Params[0]})
+					}
+					return &ArrayType{Elem: ft.Ret}
+				}
+				return &ArrayType{Elem: in.fresh(anyClass)}
+			}
+		}
+	}
+	callee := in.expr(e.Function, sc)
+	ft, ok := prune(callee).(*FuncType)
+	if !ok {
+		if v, isVar := prune(callee).(*TypeVar); isVar {
+			// Calling an unknown value makes it a function of the
+			// arguments given.
+			ft = &FuncType{Ret: in.fresh(anyClass)}
+			for range e.Args {
+				ft.Params = append(ft.Params, in.fresh(anyClass))
+			}
+			bindVar(v, ft)
+		} else {
+			in.args(e.Args, sc)
+			return in.fresh(anyClass)
+		}
+	}
+	nFixed := len(ft.Params)
+	if ft.Variadic {
+		nFixed--
+	}
+	// A spread may feed any number of parameters, so after one the
+	// arguments are only matched up loosely.
+	spread := false
+	name := calleeName(e.Function)
+	for i, arg := range e.Args {
+		if s, ok := arg.(*parser.Spread); ok {
+			spread = true
+			arr := in.identifier(s.Name, sc)
+			in.table.exprs[arg] = arr
+			if i < nFixed {
+				unify(arr, &ArrayType{Elem: ft.Params[i]})
+			} else if ft.Variadic {
+				unify(arr, ft.Params[nFixed])
+			}
+			continue
+		}
+		t := in.expr(arg, sc)
+		switch {
+		case spread:
+		case i < nFixed:
+			in.expect(arg, fmt.Sprintf("argument %d of '%s'", i+1, name), ft.Params[i], t)
+		case ft.Variadic:
+			elem := in.fresh(anyClass)
+			unify(ft.Params[nFixed], &ArrayType{Elem: elem})
+			in.expect(arg, fmt.Sprintf("argument %d of '%s'", i+1, name), elem, t)
+		}
+	}
+	return ft.Ret
+}
+
+// calleeName names the function called through fn in messages.
+func calleeName(fn parser.Expression) string {
+	switch fn := fn.(type) {
+	case *parser.Identifier:
+		return fn.Value
+	case *parser.PropertyAccess:
+		if obj, ok := fn.Object.(*parser.Identifier); ok {
+			return obj.Value + "." + fn.Property.Value
+		}
+		return fn.Property.Value
+	}
+	return "function"
+}
+
+// push records that a value of type t, from at, is added to an array of
+// type arr, from arrAt. what names arrAt in the error when arr is no array.
+func (in *inferrer) push(arrAt parser.Expression, what string, arr Type, at parser.Expression, t Type) {
+	elem := in.fresh(anyClass)
+	if !unify(arr, &ArrayType{Elem: elem}) {
+		in.errs = append(in.errs, typeError{at: arrAt, what: what, want: "an array", found: arr})
+		return
+	}
+	in.expect(at, "array element", elem, t)
+}
+
+func (in *inferrer) args(args []parser.Expression, sc *typeScope) {
+	for _, arg := range args {
+		in.expr(arg, sc)
+	}
+}
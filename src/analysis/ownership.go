@@ -0,0 +1,150 @@
+package analysis
+
+import "aether/src/parser"
+
+// Ownership tells reference counting which bindings only borrow their
+// value, so that the compiler can leave them out of the count. A binding
+// borrows when another name is sure to keep its value alive for as long as
+// the binding is in use:
+//
+//   - a parameter the function never assigns borrows the argument, which
+//     the caller holds for the whole call;
+//   - y = x borrows the value of x when neither name is assigned again;
+//   - a lambda borrows a captured variable that is never assigned again.
+//
+// These are the borrows CheckBorrows knows, less those an assignment ends
+// early. Every other binding owns its value. A captured variable that is
+// assigned again is shared instead: the lambda and the enclosing function
+// see each other's assignments. The methods are safe to call on a nil
+// *Ownership, which takes every binding to own its value.
+type Ownership struct {
+	res *Resolution
+	// assigned counts the bindings of each local: its assignments, and
+	// the for loop or match pattern declaring it.
+	assigned map[*Symbol]int
+	// aliases maps y to x for each y = x.
+	aliases map[*Symbol]*Symbol
+	lambdas map[*parser.Block]*Scope
+	// captured holds the locals some lambda captures.
+	captured map[*Symbol]bool
+}
+
+// FindOwnership works out which bindings of prog borrow. imports holds the
+// exports of the modules prog may use.
+func FindOwnership(prog *parser.Program, imports map[string]map[string]interface{}) *Ownership {
+	o := &Ownership{
+		res:      Resolve(prog, "", "", imports),
+		assigned: make(map[*Symbol]int),
+		aliases:  make(map[*Symbol]*Symbol),
+		lambdas:  make(map[*parser.Block]*Scope),
+		captured: make(map[*Symbol]bool),
+	}
+	o.collect(prog)
+	return o
+}
+
+func (o *Ownership) collect(prog *parser.Program) {
+	var scopes func(sc *Scope)
+	scopes = func(sc *Scope) {
+		if sc.Kind == LambdaScope {
+			o.lambdas[sc.Node.(*parser.Block)] = sc
+		}
+		for _, child := range sc.Children {
+			scopes(child)
+		}
+	}
+	scopes(o.res.Root)
+	for _, syms := range o.res.Captures {
+		for _, sym := range syms {
+			o.captured[sym] = true
+		}
+	}
+
+	parser.Inspect(prog, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Assignment:
+			for _, name := range n.Names {
+				if sym := o.res.SymbolOf(name); sym != nil {
+					o.assigned[sym]++
+				}
+			}
+			if from, ok := n.Value.(*parser.Identifier); ok && len(n.Names) == 1 {
+				y, x := o.res.SymbolOf(n.Names[0]), o.res.SymbolOf(from)
+				if y != nil && x != nil && x.Kind.local() && y.Decl == parser.Node(n.Names[0]) {
+					o.aliases[y] = x
+				}
+			}
+		case *parser.For:
+			for _, v := range []*parser.Identifier{n.Index, n.Value} {
+				if v == nil {
+					continue
+				}
+				if sym := o.res.SymbolOf(v); sym != nil {
+					o.assigned[sym]++
+				}
+			}
+		case *parser.Case:
+			parser.Inspect(n.Pattern, func(p parser.Node) bool {
+				if sym := o.res.SymbolOf(p); sym != nil && sym.Decl == p {
+					o.assigned[sym]++
+				}
+				return true
+			})
+		}
+		return true
+	})
+}
+
+// stable reports whether sym keeps the value it is first given: a
+// parameter that is never assigned, or a variable that is bound once.
+func (o *Ownership) stable(sym *Symbol) bool {
+	switch sym.Kind {
+	case ParameterSymbol:
+		return o.assigned[sym] == 0
+	case VariableSymbol:
+		return o.assigned[sym] <= 1
+	}
+	return false
+}
+
+// Borrows reports whether the parameter or variable that name declares
+// only borrows its value.
+func (o *Ownership) Borrows(name *parser.Identifier) bool {
+	if o == nil || name == nil {
+		return false
+	}
+	sym := o.res.SymbolOf(name)
+	if sym == nil || sym.Decl != parser.Node(name) || !o.stable(sym) {
+		return false
+	}
+	if sym.Kind == ParameterSymbol {
+		return true
+	}
+	x, ok := o.aliases[sym]
+	return ok && o.stable(x)
+}
+
+// BorrowsCapture reports whether lambda can borrow the variable name it
+// captures rather than hold a reference of its own.
+func (o *Ownership) BorrowsCapture(lambda *parser.Block, name string) bool {
+	if o == nil {
+		return false
+	}
+	sc, ok := o.lambdas[lambda]
+	if !ok {
+		return false
+	}
+	sym := sc.Lookup(name)
+	return sym != nil && sym.Kind.local() && o.stable(sym)
+}
+
+// Shared reports whether the parameter or variable that decl declares is
+// captured by a lambda and assigned again, by the lambda or after it, so
+// that both must use the same storage for it.
+func (o *Ownership) Shared(decl parser.Node) bool {
+	if o == nil || decl == nil {
+		return false
+	}
+	sym := o.res.SymbolOf(decl)
+	return sym != nil && sym.Kind.local() && o.captured[sym] && !o.stable(sym)
+}
@@ -0,0 +1,695 @@
+package analysis
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// Names are resolved lexically, the way the compiler looks them up. The
+// module is the outermost scope and holds the imports, structs, functions
+// and module-level variables, all visible throughout the file. Function
+// bodies, lambdas, the bodies of if, while and for, and match arms each open
+// a scope below the one they appear in. Inside a scope a variable exists from
+// the assignment that first binds it; assigning to a name already visible
+// stores to that variable instead of declaring a new one. A named function
+// runs in its own frame, so it sees the functions and structs around it but
+// not the local variables of an enclosing function; a lambda sees them all
+// and captures the locals it uses.
+
+// ScopeKind tells what opened a scope.
+type ScopeKind int
+
+const (
+	ModuleScope   ScopeKind = iota
+	FunctionScope           // the parameters and body of a named function
+	LambdaScope             // the body of a block literal
+	BlockScope              // an if branch or a bare block
+	LoopScope               // the body of while, repeat or for
+	ArmScope                // a match arm and the bindings of its pattern
+)
+
+// SymbolKind tells what declared a name.
+type SymbolKind int
+
+const (
+	VariableSymbol SymbolKind = iota
+	ParameterSymbol
+	FunctionSymbol
+	ForeignSymbol
+	StructSymbol
+	ModuleSymbol
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case ParameterSymbol:
+		return "parameter"
+	case FunctionSymbol:
+		return "function"
+	case ForeignSymbol:
+		return "foreign function"
+	case StructSymbol:
+		return "struct"
+	case ModuleSymbol:
+		return "module"
+	}
+	return "variable"
+}
+
+// local reports whether symbols of kind k live in the frame of the function
+// declaring them.
+func (k SymbolKind) local() bool {
+	return k == VariableSymbol || k == ParameterSymbol
+}
+
+// Symbol is a declared name. Decl is the identifier, or the ...rest of a
+// pattern, that declares it; it is nil for the foreign functions a module
+// brings in by being imported. Uses lists the identifiers and spreads bound
+// to the symbol, in source order. Module is the import path of the module
+// a ModuleSymbol names, or that brought in a foreign function.
+type Symbol struct {
+	Name   string
+	Kind   SymbolKind
+	Decl   parser.Node
+	Pos    parser.Pos
+	Scope  *Scope
+	Uses   []parser.Node
+	Module string
+}
+
+// Scope is a node of the scope tree. Node is the program, function, block,
+// for loop or match case that opened it.
+type Scope struct {
+	Kind     ScopeKind
+	Node     parser.Node
+	Parent   *Scope
+	Children []*Scope
+	Symbols  map[string]*Symbol
+}
+
+func newScope(kind ScopeKind, node parser.Node, parent *Scope) *Scope {
+	sc := &Scope{Kind: kind, Node: node, Parent: parent, Symbols: make(map[string]*Symbol)}
+	if parent != nil {
+		parent.Children = append(parent.Children, sc)
+	}
+	return sc
+}
+
+// Lookup finds the symbol name refers to in s. Past the scope of a named
+// function only module-level names and the functions and structs of
+// enclosing scopes remain visible.
+func (s *Scope) Lookup(name string) *Symbol {
+	locals := true
+	for sc := s; sc != nil; sc = sc.Parent {
+		if sym, ok := sc.Symbols[name]; ok && (locals || sc.Parent == nil || !sym.Kind.local()) {
+			return sym
+		}
+		if sc.Kind == FunctionScope {
+			locals = false
+		}
+	}
+	return nil
+}
+
+// hiddenLocal returns the local variable of an enclosing function that
+// Lookup skips for name, if there is one.
+func (s *Scope) hiddenLocal(name string) *Symbol {
+	for sc := s; sc != nil; sc = sc.Parent {
+		if sym, ok := sc.Symbols[name]; ok && sym.Kind.local() && sc.Parent != nil {
+			return sym
+		}
+	}
+	return nil
+}
+
+// Resolution is the result of resolving the names of a program: the scope
+// tree, the symbol every identifier and spread is bound to, and the local
+// variables each lambda captures.
+type Resolution struct {
+	Root     *Scope
+	Bindings map[parser.Node]*Symbol
+	Captures map[*parser.Block][]*Symbol
+	// Errors holds the undefined names and Warnings the bindings that
+	// shadow a name of an enclosing scope.
+	Errors   []utils.ParseError
+	Warnings []utils.ParseError
+}
+
+// SymbolOf returns the symbol an identifier or spread is bound to, or nil.
+func (r *Resolution) SymbolOf(n parser.Node) *Symbol {
+	if r == nil {
+		return nil
+	}
+	return r.Bindings[n]
+}
+
+// UsedInFunctions returns the names of the module-level variables that a
+// function or lambda uses, so that they must outlive the module body.
+func (r *Resolution) UsedInFunctions() map[string]bool {
+	names := make(map[string]bool)
+	var walk func(sc *Scope)
+	walk = func(sc *Scope) {
+		if sc.Kind != FunctionScope && sc.Kind != LambdaScope {
+			for _, child := range sc.Children {
+				walk(child)
+			}
+			return
+		}
+		parser.Inspect(sc.Node, func(n parser.Node) bool {
+			if sym := r.SymbolOf(n); sym != nil && sym.Kind == VariableSymbol && sym.Scope == r.Root {
+				names[sym.Name] = true
+			}
+			return true
+		})
+	}
+	walk(r.Root)
+	return names
+}
+
+// Resolve builds the scope tree of prog and binds every name to its
+// declaration. Names that are not declared anywhere in scope are reported
+// with the closest visible name as a suggestion, and bindings that hide a
+// name of an enclosing scope are reported as warnings. imports holds the
+// exports of the modules prog may use, keyed by module name; calls to
+// unknown functions are not reported when prog imports a module whose
+// exports are not known, since that module may bind them.
+func Resolve(prog *parser.Program, file, source string, imports map[string]map[string]interface{}) *Resolution {
+	r := &resolver{
+		res: &Resolution{
+			Bindings: make(map[parser.Node]*Symbol),
+			Captures: make(map[*parser.Block][]*Symbol),
+		},
+		file:    file,
+		lines:   strings.Split(source, "\n"),
+		imports: imports,
+	}
+	r.res.Root = newScope(ModuleScope, prog, nil)
+	r.module(prog)
+	sortByPosition(r.res.Errors)
+	sortByPosition(r.res.Warnings)
+	return r.res
+}
+
+type resolver struct {
+	res     *Resolution
+	file    string
+	lines   []string
+	imports map[string]map[string]interface{}
+	// opaque is set when an imported module's exports are not known.
+	opaque bool
+}
+
+// module declares the names of the module scope up front, so that
+// functions can use the ones declared further down, then resolves the
+// statements.
+func (r *resolver) module(prog *parser.Program) {
+	root := r.res.Root
+	for _, stmt := range prog.Statements {
+		switch s := stmt.(type) {
+		case *parser.Import:
+			r.importModule(s)
+		case *parser.StructDef:
+			r.declare(root, s.Name, StructSymbol)
+		case *parser.ForeignFunction:
+			r.declare(root, s.Name, ForeignSymbol)
+		case *parser.Function:
+			if s.Name != nil && s.Name.Value != "" {
+				r.declare(root, s.Name, FunctionSymbol)
+			}
+		case *parser.Assignment:
+			for _, name := range s.Names {
+				if name.Value != "_" && root.Symbols[name.Value] == nil {
+					r.declare(root, name, VariableSymbol)
+				}
+			}
+		}
+	}
+	for _, stmt := range prog.Statements {
+		r.stmt(stmt, root)
+	}
+}
+
+// importModule declares the name a module is imported under and the
+// foreign functions it can be called with unqualified.
+func (r *resolver) importModule(s *parser.Import) {
+	if s.Name == nil {
+		return
+	}
+	var sym *Symbol
+	if s.As != nil && s.As.Value != "" {
+		sym = r.declare(r.res.Root, s.As, ModuleSymbol)
+	} else {
+		sym = r.declareAt(r.res.Root, s.Name, moduleBaseName(s.Name.Value), s.Name.Pos, ModuleSymbol)
+	}
+	sym.Module = s.Name.Value
+	exports, ok := importedExports(r.imports, s.Name.Value)
+	if !ok {
+		r.opaque = true
+		return
+	}
+	for fn, export := range exports {
+		if f, ok := export.(FunctionInfo); ok && f.Foreign && r.res.Root.Symbols[fn] == nil {
+			r.res.Root.Symbols[fn] = &Symbol{Name: fn, Kind: ForeignSymbol, Scope: r.res.Root, Module: s.Name.Value}
+		}
+	}
+}
+
+// moduleBaseName is the name a module imported by path is used under
+// without an alias: the file name without its extension.
+func moduleBaseName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// importedExports returns the exports of the module imported by path,
+// keyed in imports by the path itself or by its base name.
+func importedExports(imports map[string]map[string]interface{}, path string) (map[string]interface{}, bool) {
+	if exports, ok := imports[path]; ok {
+		return exports, true
+	}
+	exports, ok := imports[moduleBaseName(path)]
+	return exports, ok
+}
+
+// declare adds a symbol for name to sc.
+func (r *resolver) declare(sc *Scope, name *parser.Identifier, kind SymbolKind) *Symbol {
+	return r.declareAt(sc, name, name.Value, name.Pos, kind)
+}
+
+func (r *resolver) declareAt(sc *Scope, decl parser.Node, name string, pos parser.Pos, kind SymbolKind) *Symbol {
+	sym := &Symbol{Name: name, Kind: kind, Decl: decl, Pos: pos, Scope: sc}
+	sc.Symbols[name] = sym
+	r.res.Bindings[decl] = sym
+	return sym
+}
+
+// bindNew declares a name that always opens a new binding, such as a
+// parameter or a loop variable, warning when it hides one visible from sc.
+// Module variables are visible in functions declared before them, but only
+// hiding one declared earlier is worth a warning; functions, structs and
+// imports are in scope throughout the module.
+func (r *resolver) bindNew(sc *Scope, decl parser.Node, name string, pos parser.Pos, kind SymbolKind) {
+	if name == "" || name == "_" {
+		return
+	}
+	if prev := sc.Lookup(name); prev != nil && prev.Scope != sc && !(prev.Kind == VariableSymbol && posBefore(pos, prev.Pos)) {
+		r.shadowed(kind, name, pos, prev)
+	}
+	r.declareAt(sc, decl, name, pos, kind)
+}
+
+func (r *resolver) shadowed(kind SymbolKind, name string, pos parser.Pos, prev *Symbol) {
+	msg := fmt.Sprintf("%s '%s' shadows the %s '%s'", kind, name, prev.Kind, name)
+	if prev.Pos.Line > 0 {
+		msg += fmt.Sprintf(" declared at line %d, column %d", prev.Pos.Line, prev.Pos.Column)
+	}
+	r.res.Warnings = append(r.res.Warnings, utils.ParseError{
+		Kind:    utils.ShadowedName,
+		Message: msg,
+		File:    r.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Snippet: sourceLine(r.lines, pos.Line),
+		Caret:   pos.Column,
+		Fix:     fmt.Sprintf("Rename the %s if it is not meant to hide the outer '%s'.", kind, name),
+	})
+}
+
+func (r *resolver) block(b *parser.Block, sc *Scope) {
+	if b == nil {
+		return
+	}
+	for _, stmt := range b.Statements {
+		r.stmt(stmt, sc)
+	}
+}
+
+func (r *resolver) stmt(stmt parser.Statement, sc *Scope) {
+	switch s := stmt.(type) {
+	case *parser.Assignment:
+		r.expr(s.Value, sc)
+		for _, name := range s.Names {
+			r.assign(name, sc)
+		}
+	case *parser.ElementAssignment:
+		r.expr(s.Value, sc)
+		r.expr(s.Target, sc)
+	case *parser.Function:
+		r.function(s, sc)
+	case *parser.If:
+		r.expr(s.Condition, sc)
+		if s.Consequence != nil {
+			r.block(s.Consequence, newScope(BlockScope, s.Consequence, sc))
+		}
+		if s.Alternative != nil {
+			r.block(s.Alternative, newScope(BlockScope, s.Alternative, sc))
+		}
+	case *parser.While:
+		r.expr(s.Condition, sc)
+		r.block(s.Body, newScope(LoopScope, s.Body, sc))
+	case *parser.Repeat:
+		r.expr(s.Count, sc)
+		r.block(s.Body, newScope(LoopScope, s.Body, sc))
+	case *parser.For:
+		r.expr(s.Iterable, sc)
+		body := newScope(LoopScope, s, sc)
+		for _, v := range []*parser.Identifier{s.Index, s.Value} {
+			if v != nil {
+				r.bindNew(body, v, v.Value, v.Pos, VariableSymbol)
+			}
+		}
+		r.block(s.Body, body)
+	case *parser.Match:
+		r.expr(s.Expr, sc)
+		for _, c := range s.Cases {
+			arm := newScope(ArmScope, c, sc)
+			r.pattern(c.Pattern, arm)
+			r.block(c.Body, arm)
+		}
+	case *parser.Return:
+		r.expr(s.Value, sc)
+	case *parser.Block:
+		r.block(s, newScope(BlockScope, s, sc))
+	case *parser.ExpressionStatement:
+		r.expr(s.Expr, sc)
+	case parser.Expression:
+		r.expr(s, sc)
+	}
+}
+
+// assign binds an assignment target: the variable visible under that name,
+// or a new variable of sc.
+func (r *resolver) assign(name *parser.Identifier, sc *Scope) {
+	if name.Value == "_" {
+		return
+	}
+	if prev := sc.Lookup(name.Value); prev != nil {
+		if prev.Kind.local() {
+			r.use(name, prev, sc)
+			return
+		}
+		if prev.Scope != sc {
+			r.shadowed(VariableSymbol, name.Value, name.Pos, prev)
+		}
+	}
+	r.declare(sc, name, VariableSymbol)
+}
+
+// function resolves a named function. A nested function is declared in
+// the scope it appears in; top-level ones already are.
+func (r *resolver) function(fn *parser.Function, sc *Scope) {
+	if fn.Name != nil && fn.Name.Value != "" && sc.Kind != ModuleScope {
+		r.bindNew(sc, fn.Name, fn.Name.Value, fn.Name.Pos, FunctionSymbol)
+	}
+	body := newScope(FunctionScope, fn, sc)
+	for _, p := range fn.Params {
+		r.bindNew(body, p, p.Value, p.Pos, ParameterSymbol)
+	}
+	r.block(fn.Body, body)
+}
+
+// pattern declares the variables a match pattern binds in the arm scope.
+func (r *resolver) pattern(pat parser.Expression, arm *Scope) {
+	switch p := pat.(type) {
+	case *parser.Identifier:
+		switch p.Value {
+		case "_", "true", "false":
+		default:
+			r.bindNew(arm, p, p.Value, p.Pos, VariableSymbol)
+		}
+	case *parser.Array:
+		for _, el := range p.Elements {
+			if rest, ok := el.(*parser.Spread); ok {
+				r.bindNew(arm, rest, rest.Name, rest.Pos, VariableSymbol)
+				continue
+			}
+			r.pattern(el, arm)
+		}
+	case *parser.StructInstantiation:
+		r.structName(p.TypeName, arm)
+		for _, name := range sortedFieldNames(p.Fields) {
+			r.pattern(p.Fields[name], arm)
+		}
+	}
+}
+
+func (r *resolver) expr(e parser.Expression, sc *Scope) {
+	switch e := e.(type) {
+	case nil:
+	case *parser.Identifier:
+		r.name(e, sc, false)
+	case *parser.Spread:
+		if e.Name != "" {
+			r.lookup(e, e.Name, e.Pos, sc, false)
+		}
+	case *parser.Array:
+		for _, el := range e.Elements {
+			r.expr(el, sc)
+		}
+	case *parser.Tuple:
+		for _, el := range e.Elements {
+			r.expr(el, sc)
+		}
+	case *parser.Call:
+		if ident, ok := e.Function.(*parser.Identifier); ok {
+			r.name(ident, sc, true)
+		} else {
+			r.expr(e.Function, sc)
+		}
+		for _, arg := range e.Args {
+			r.expr(arg, sc)
+		}
+	case *parser.PartialApplication:
+		if ident, ok := e.Function.(*parser.Identifier); ok {
+			r.name(ident, sc, true)
+		} else {
+			r.expr(e.Function, sc)
+		}
+		for _, arg := range e.Args {
+			r.expr(arg, sc)
+		}
+	case *parser.PropertyAccess:
+		// Properties are fields, methods or module members, which are
+		// not names of a scope.
+		r.expr(e.Object, sc)
+	case *parser.ArrayIndex:
+		r.expr(e.Array, sc)
+		r.expr(e.Index, sc)
+	case *parser.Slice:
+		r.expr(e.Array, sc)
+		r.expr(e.Low, sc)
+		r.expr(e.High, sc)
+	case *parser.Cast:
+		r.expr(e.Expr, sc)
+	case *parser.StructInstantiation:
+		r.structName(e.TypeName, sc)
+		for _, name := range sortedFieldNames(e.Fields) {
+			r.expr(e.Fields[name], sc)
+		}
+	case *parser.Block:
+		r.block(e, newScope(LambdaScope, e, sc))
+	case *parser.Function:
+		r.function(e, sc)
+	}
+}
+
+// name resolves an identifier used as a value or, when called is set, as
+// the function of a call.
+func (r *resolver) name(ident *parser.Identifier, sc *Scope, called bool) {
+	switch {
+	case ident.Value == "_", ident.Value == "true", ident.Value == "false":
+		return
+	case !isNameToken(ident.Value):
+		// Operators are parsed as calls of the operator's identifier.
+		return
+	}
+	r.lookup(ident, ident.Value, ident.Pos, sc, called)
+}
+
+func (r *resolver) structName(name *parser.Identifier, sc *Scope) {
+	if name == nil || name.Value == "" {
+		return
+	}
+	if sym := sc.Lookup(name.Value); sym != nil && sym.Kind == StructSymbol {
+		r.use(name, sym, sc)
+		return
+	}
+	r.undefined("struct '"+name.Value+"'", name.Value, name.Pos, sc, func(s *Symbol) bool {
+		return s.Kind == StructSymbol
+	})
+}
+
+func (r *resolver) lookup(n parser.Node, name string, pos parser.Pos, sc *Scope, called bool) {
+	if sym := sc.Lookup(name); sym != nil {
+		r.use(n, sym, sc)
+		return
+	}
+	if builtinFunctions[name] {
+		return
+	}
+	if hidden := sc.hiddenLocal(name); hidden != nil {
+		r.report(pos, fmt.Sprintf("undefined reference to '%s'; a named function cannot use the %ss of the function around it", name, hidden.Kind),
+			"Pass '"+name+"' as a parameter, or use a lambda, which captures it.")
+		return
+	}
+	if called {
+		if r.opaque {
+			return
+		}
+		r.undefined("function '"+name+"'", name, pos, sc, func(s *Symbol) bool {
+			return s.Kind != StructSymbol && s.Kind != ModuleSymbol
+		})
+		return
+	}
+	r.undefined("'"+name+"'", name, pos, sc, func(s *Symbol) bool {
+		return s.Kind != StructSymbol
+	})
+}
+
+// use binds n to sym. A local variable used inside a lambda declared below
+// its scope is captured by every lambda in between.
+func (r *resolver) use(n parser.Node, sym *Symbol, sc *Scope) {
+	sym.Uses = append(sym.Uses, n)
+	r.res.Bindings[n] = sym
+	if !sym.Kind.local() || sym.Scope.Parent == nil {
+		return
+	}
+	for s := sc; s != nil && s != sym.Scope; s = s.Parent {
+		if s.Kind != LambdaScope {
+			continue
+		}
+		lambda := s.Node.(*parser.Block)
+		captured := false
+		for _, c := range r.res.Captures[lambda] {
+			captured = captured || c == sym
+		}
+		if !captured {
+			r.res.Captures[lambda] = append(r.res.Captures[lambda], sym)
+		}
+	}
+}
+
+// undefined reports a name that is not in scope, suggesting the closest
+// visible name that fits.
+func (r *resolver) undefined(what, name string, pos parser.Pos, sc *Scope, fits func(*Symbol) bool) {
+	msg := "undefined reference to " + what
+	fix := "Declare '" + name + "' before using it, or check the spelling."
+	if strings.HasPrefix(what, "function") {
+		fix = "Define '" + name + "', or declare it with foreign func if it is a C function."
+	}
+	if suggestion := suggestName(name, sc, fits); suggestion != "" {
+		msg += "; did you mean '" + suggestion + "'?"
+		fix = "Replace '" + name + "' with '" + suggestion + "'."
+	}
+	r.report(pos, msg, fix)
+}
+
+func (r *resolver) report(pos parser.Pos, msg, fix string) {
+	r.res.Errors = append(r.res.Errors, utils.ParseError{
+		Kind:    utils.UndefinedReference,
+		Message: msg,
+		File:    r.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Snippet: sourceLine(r.lines, pos.Line),
+		Caret:   pos.Column,
+		Fix:     fix,
+	})
+}
+
+// suggestName returns the name visible from sc, or builtin function, that
+// is closest to name, if one is close enough to be a likely misspelling.
+// Nearer scopes win ties.
+func suggestName(name string, sc *Scope, fits func(*Symbol) bool) string {
+	var candidates []string
+	locals := true
+	for s := sc; s != nil; s = s.Parent {
+		names := make([]string, 0, len(s.Symbols))
+		for n, sym := range s.Symbols {
+			if fits(sym) && (locals || s.Parent == nil || !sym.Kind.local()) {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		candidates = append(candidates, names...)
+		if s.Kind == FunctionScope {
+			locals = false
+		}
+	}
+	builtins := make([]string, 0, len(builtinFunctions))
+	for n := range builtinFunctions {
+		builtins = append(builtins, n)
+	}
+	sort.Strings(builtins)
+	return closestName(name, append(candidates, builtins...))
+}
+
+// closestName returns the candidate closest to name if it is within one
+// edit per three characters of it. Earlier candidates win ties.
+func closestName(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+1
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d > 0 && d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters that turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// isNameToken reports whether s is spelled like an identifier rather than
+// an operator.
+func isNameToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func sortedFieldNames(fields map[string]parser.Expression) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortByPosition(errs []utils.ParseError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+}
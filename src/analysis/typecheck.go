@@ -0,0 +1,104 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// CheckTypes reports the type mismatches in prog: arguments that do not fit
+// the parameters of the function called, returns that disagree with the
+// function's return type, struct fields given the wrong type or not
+// declared, mixed array elements and operands of the wrong type. Each error
+// points at the offending expression in source. imports holds the exports
+// of the modules prog uses, so calls into them are checked too.
+func CheckTypes(prog *parser.Program, file, source string, imports map[string]map[string]interface{}) []utils.ParseError {
+	in := newInferrer(imports)
+	in.run(prog)
+	lines := strings.Split(source, "\n")
+	seen := make(map[string]bool)
+	var errs []utils.ParseError
+	for _, te := range in.errs {
+		msg := te.message
+		if msg == "" {
+			want := te.want
+			if want == "" {
+				want = te.expected.String()
+			}
+			msg = fmt.Sprintf("%s: expected %s, found %s", te.what, want, te.found.String())
+		}
+		pos := exprPos(te.at)
+		key := fmt.Sprintf("%d:%d:%s", pos.Line, pos.Column, msg)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		errs = append(errs, utils.ParseError{
+			Kind:    utils.TypeMismatch,
+			Message: msg,
+			File:    file,
+			Line:    pos.Line,
+			Column:  pos.Column,
+			Snippet: sourceLine(lines, pos.Line),
+			Caret:   pos.Column,
+		})
+	}
+	sortByPosition(errs)
+	return errs
+}
+
+// sourceLine returns line n of a source split into lines, for snippets.
+func sourceLine(lines []string, n int) string {
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[n-1], "\r")
+}
+
+// exprPos returns where expr starts in source. Binary operations start at
+// their left operand; only leaves and a few composite expressions carry a
+// position of their own.
+func exprPos(expr parser.Expression) parser.Pos {
+	switch e := expr.(type) {
+	case *parser.Identifier:
+		return e.Pos
+	case *parser.Literal:
+		return e.Pos
+	case *parser.Array:
+		return e.Pos
+	case *parser.StructInstantiation:
+		return e.Pos
+	case *parser.Call:
+		if len(e.Args) == 2 {
+			if op, ok := e.Function.(*parser.Identifier); ok && isOperatorName(op.Value) {
+				return exprPos(e.Args[0])
+			}
+		}
+		return exprPos(e.Function)
+	case *parser.PropertyAccess:
+		return exprPos(e.Object)
+	case *parser.ArrayIndex:
+		return exprPos(e.Array)
+	case *parser.Slice:
+		return exprPos(e.Array)
+	case *parser.Cast:
+		return exprPos(e.Expr)
+	case *parser.PartialApplication:
+		return exprPos(e.Function)
+	case *parser.Tuple:
+		if len(e.Elements) > 0 {
+			return exprPos(e.Elements[0])
+		}
+	}
+	return parser.Pos{}
+}
+
+func isOperatorName(name string) bool {
+	switch name {
+	case "+", "-", "*", "/", "%", "^", "==", "!=", "<", ">", "<=", ">=", "..", "&&", "||":
+		return true
+	}
+	return false
+}
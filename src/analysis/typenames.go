@@ -0,0 +1,84 @@
+package analysis
+
+import (
+	"fmt"
+
+	"aether/lib/utils"
+	"aether/src/parser"
+)
+
+// builtinTypes are the type names every module can use in annotations.
+// The sized integer types have the given width, u8..u64 being unsigned;
+// int is an i32 and float an f64.
+var builtinTypes = map[string]bool{
+	"int": true, "float": true, "string": true, "bool": true,
+	"i8": true, "i16": true, "i32": true, "i64": true,
+	"u8": true, "u16": true, "u32": true, "u64": true,
+	"f32": true, "f64": true, "str": true, "ptr": true,
+}
+
+// castTypes are the types a value can be converted to with as.
+var castTypes = map[string]bool{
+	"int": true, "float": true, "bool": true,
+	"i8": true, "i16": true, "i32": true, "i64": true,
+	"u8": true, "u16": true, "u32": true, "u64": true,
+	"f32": true, "f64": true,
+}
+
+// CheckTypeNames reports the parameter and field annotations of ast that
+// name an unknown type, and the casts to a type that is not numeric.
+// Annotations may also name the structs defined in ast.
+func CheckTypeNames(ast *parser.Program, file string) []utils.ParseError {
+	structs := make(map[string]bool)
+	for _, stmt := range ast.Statements {
+		if def, ok := stmt.(*parser.StructDef); ok {
+			structs[def.Name.Value] = true
+		}
+	}
+	var errs []utils.ParseError
+	unknown := func(typ, what string, pos parser.Pos) {
+		errs = append(errs, utils.ParseError{
+			Kind:    utils.UndefinedReference,
+			Message: fmt.Sprintf("unknown type '%s' for %s", typ, what),
+			File:    file,
+			Line:    pos.Line,
+			Column:  pos.Column,
+			Fix:     "Use int, float, string, bool, i8..i64, u8..u64, f32, f64, str, ptr or a struct name.",
+		})
+	}
+	parser.Inspect(ast, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Function:
+			for _, p := range n.Params {
+				if !knownType(p.Type, structs) {
+					unknown(p.Type, fmt.Sprintf("parameter '%s' of '%s'", p.Value, n.Name.Value), parser.Pos{})
+				}
+			}
+		case *parser.StructDef:
+			for _, f := range n.Fields {
+				if !knownType(f.Type, structs) {
+					unknown(f.Type, fmt.Sprintf("field '%s' of '%s'", f.Name.Value, n.Name.Value), parser.Pos{})
+				}
+			}
+		case *parser.Cast:
+			if !castTypes[n.Type] {
+				errs = append(errs, utils.ParseError{
+					Kind:    utils.InvalidSyntax,
+					Message: fmt.Sprintf("cannot cast to '%s'", n.Type),
+					File:    file,
+					Line:    n.Line,
+					Column:  n.Column,
+					Fix:     "Casts convert between the numeric types and bool.",
+				})
+			}
+		}
+		return true
+	})
+	return errs
+}
+
+// knownType reports whether an annotation names a known type. An empty
+// annotation leaves the type to be inferred.
+func knownType(name string, structs map[string]bool) bool {
+	return name == "" || builtinTypes[name] || structs[name]
+}
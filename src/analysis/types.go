@@ -33,6 +33,10 @@ type FunctionInfo struct {
 	Defined    bool
 	Used       bool
 	Exported   bool
+	// Foreign marks a C function. Its types are C type names, Variadic
+	// marks C varargs, and it is linked under its own name.
+	Foreign  bool
+	Variadic bool
 }
 
 type VariableInfo struct {
@@ -50,6 +54,9 @@ type TypeInfo struct {
 	Used     bool
 	Exported bool
 	Fields   map[string]string
+	// Order lists the fields in the order they are declared, which is
+	// the layout of the struct.
+	Order []string
 }
 
 type ConstantInfo struct {
@@ -64,6 +71,9 @@ type ConstantInfo struct {
 type ParameterInfo struct {
 	Name string
 	Type string
+	// Variadic marks a final ...rest parameter, whose Type is the array
+	// that collects the extra arguments.
+	Variadic bool
 }
 
 type DependencyInfo struct {
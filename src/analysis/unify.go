@@ -0,0 +1,338 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+)
+
+// Type is an inferred type. String spells it like an annotation, with ?
+// for the parts that are still unknown.
+type Type interface {
+	String() string
+}
+
+// numClass restricts what a type variable may stand for.
+type numClass int
+
+const (
+	anyClass     numClass = iota
+	numericClass          // an integer or float type, int by default
+	floatClass            // f32 or float, float by default
+)
+
+// TypeVar stands for a type that is not known yet. Unification binds it to
+// ref.
+type TypeVar struct {
+	id    int
+	ref   Type
+	class numClass
+}
+
+func (v *TypeVar) String() string {
+	if v.ref != nil {
+		return v.ref.String()
+	}
+	return "?"
+}
+
+// BasicType is a scalar: int, float, string, bool, a sized type, ptr or
+// void. i32, f64 and str are spelled int, float and string.
+type BasicType struct {
+	Name string
+}
+
+func (b *BasicType) String() string { return b.Name }
+
+// ArrayType is [Elem].
+type ArrayType struct {
+	Elem Type
+}
+
+func (a *ArrayType) String() string { return "[" + a.Elem.String() + "]" }
+
+// TupleType is (A, B, ...).
+type TupleType struct {
+	Elems []Type
+}
+
+func (t *TupleType) String() string {
+	parts := make([]string, len(t.Elems))
+	for i, e := range t.Elems {
+		parts[i] = e.String()
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// FuncType is the type of functions and closures. When Variadic is set the
+// last parameter is the array that receives the extra arguments.
+type FuncType struct {
+	Params   []Type
+	Ret      Type
+	Variadic bool
+}
+
+func (f *FuncType) String() string {
+	parts := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		parts[i] = p.String()
+	}
+	if f.Variadic && len(parts) > 0 {
+		parts[len(parts)-1] = "..." + parts[len(parts)-1]
+	}
+	return "func(" + strings.Join(parts, ", ") + "): " + f.Ret.String()
+}
+
+// StructType is a struct declared with the struct keyword, which has a
+// Name, or the type of an anonymous struct literal.
+type StructType struct {
+	Name   string
+	Fields map[string]Type
+}
+
+func (s *StructType) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + ": " + s.Fields[name].String()
+	}
+	return "{" + strings.Join(names, ", ") + "}"
+}
+
+// Scheme is a type generalized over vars, which every use replaces with
+// fresh variables. A scheme without vars is an ordinary type.
+type Scheme struct {
+	vars []*TypeVar
+	t    Type
+}
+
+// canonicalTypes maps the aliases of the built-in types to one spelling.
+var canonicalTypes = map[string]string{"i32": "int", "f64": "float", "str": "string"}
+
+// numericWidths orders the numeric types for arithmetic: the result of
+// mixing two of them is the wider, floats being wider than integers.
+var numericWidths = map[string]int{
+	"i8": 8, "u8": 8, "i16": 16, "u16": 16,
+	"int": 32, "u32": 32, "i64": 64, "u64": 64,
+	"f32": 132, "float": 164,
+}
+
+func basic(name string) *BasicType {
+	if c, ok := canonicalTypes[name]; ok {
+		name = c
+	}
+	return &BasicType{Name: name}
+}
+
+func isFloatName(name string) bool {
+	return name == "f32" || name == "float"
+}
+
+// prune follows bound type variables to the type they stand for.
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*TypeVar)
+		if !ok || v.ref == nil {
+			return t
+		}
+		t = v.ref
+	}
+}
+
+// numericName returns the name of t if it is a known numeric type.
+func numericName(t Type) (string, bool) {
+	b, ok := prune(t).(*BasicType)
+	if !ok {
+		return "", false
+	}
+	_, numeric := numericWidths[b.Name]
+	return b.Name, numeric
+}
+
+// unify makes a and b the same type, binding type variables as needed. It
+// reports false when they cannot be made equal; bindings made before the
+// mismatch was found are kept.
+func unify(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	if a == b {
+		return true
+	}
+	if v, ok := a.(*TypeVar); ok {
+		return bindVar(v, b)
+	}
+	if v, ok := b.(*TypeVar); ok {
+		return bindVar(v, a)
+	}
+	switch a := a.(type) {
+	case *BasicType:
+		b, ok := b.(*BasicType)
+		return ok && a.Name == b.Name
+	case *ArrayType:
+		b, ok := b.(*ArrayType)
+		return ok && unify(a.Elem, b.Elem)
+	case *TupleType:
+		b, ok := b.(*TupleType)
+		if !ok || len(a.Elems) != len(b.Elems) {
+			return false
+		}
+		for i := range a.Elems {
+			if !unify(a.Elems[i], b.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *FuncType:
+		b, ok := b.(*FuncType)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !unify(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return unify(a.Ret, b.Ret)
+	case *StructType:
+		b, ok := b.(*StructType)
+		if !ok {
+			return false
+		}
+		if a.Name != "" || b.Name != "" {
+			return a.Name == b.Name
+		}
+		if len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		for name, t := range a.Fields {
+			u, ok := b.Fields[name]
+			if !ok || !unify(t, u) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// bindVar binds v to t, unless t contains v or is outside v's class.
+func bindVar(v *TypeVar, t Type) bool {
+	if occurs(v, t) {
+		return false
+	}
+	if v.class != anyClass {
+		switch t := t.(type) {
+		case *TypeVar:
+			if v.class > t.class {
+				t.class = v.class
+			}
+		case *BasicType:
+			if _, numeric := numericWidths[t.Name]; !numeric {
+				return false
+			}
+			if v.class == floatClass && !isFloatName(t.Name) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	v.ref = t
+	return true
+}
+
+func occurs(v *TypeVar, t Type) bool {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		return t == v
+	case *ArrayType:
+		return occurs(v, t.Elem)
+	case *TupleType:
+		for _, e := range t.Elems {
+			if occurs(v, e) {
+				return true
+			}
+		}
+	case *FuncType:
+		for _, p := range t.Params {
+			if occurs(v, p) {
+				return true
+			}
+		}
+		return occurs(v, t.Ret)
+	case *StructType:
+		if t.Name == "" {
+			for _, f := range t.Fields {
+				if occurs(v, f) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// freeVars adds the unbound type variables of t to set.
+func freeVars(t Type, set map[*TypeVar]bool) {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		set[t] = true
+	case *ArrayType:
+		freeVars(t.Elem, set)
+	case *TupleType:
+		for _, e := range t.Elems {
+			freeVars(e, set)
+		}
+	case *FuncType:
+		for _, p := range t.Params {
+			freeVars(p, set)
+		}
+		freeVars(t.Ret, set)
+	case *StructType:
+		if t.Name == "" {
+			for _, f := range t.Fields {
+				freeVars(f, set)
+			}
+		}
+	}
+}
+
+// resolved reports whether t is fully known and can be written as an
+// annotation, which excludes function types.
+func resolved(t Type) bool {
+	return known(t, false)
+}
+
+// known reports whether t is fully known. Function types are known when
+// funcs is set and their parameter and return types are known.
+func known(t Type, funcs bool) bool {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		return false
+	case *FuncType:
+		if !funcs {
+			return false
+		}
+		for _, p := range t.Params {
+			if !known(p, funcs) {
+				return false
+			}
+		}
+		return known(t.Ret, funcs)
+	case *ArrayType:
+		return known(t.Elem, funcs)
+	case *TupleType:
+		for _, e := range t.Elems {
+			if !known(e, funcs) {
+				return false
+			}
+		}
+	case *StructType:
+		return t.Name != ""
+	}
+	return true
+}
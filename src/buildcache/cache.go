@@ -14,6 +14,12 @@ type BuildCacheEntry struct {
   Output       string            `json:"output"`
   Deps         []string          `json:"deps"`
   DepHashes    map[string]string `json:"dep_hashes"`
+  // Live lists the functions the object file was built with.
+  Live         []string          `json:"live,omitempty"`
+  // Optimization records the -O level and --no-* flags it was built with.
+  Optimization string            `json:"optimization,omitempty"`
+  // Codegen records the code generation flags it was built with.
+  Codegen      string            `json:"codegen,omitempty"`
   LastBuild    int64             `json:"last_build"`
 }
 
@@ -0,0 +1,379 @@
+package compiler
+
+import (
+	"strings"
+
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Arrays are heap allocated headers { elem* data, i64 len, i64 cap } and an
+// array value is a pointer to its header, so appending through one binding is
+// visible through every other binding of the same array. The header is a
+// counted object, which owns the elements and the storage holding them.
+
+const (
+	arrayDataField = 0
+	arrayLenField  = 1
+	arrayCapField  = 2
+)
+
+// arrayType returns the header pointer type for arrays of elem.
+func (c *CompilerContext) arrayType(elem types.Type) *types.PointerType {
+	key := typeKey(elem)
+	st, ok := c.arrayTypes[key]
+	if !ok {
+		st = types.NewStruct(types.NewPointer(elem), types.I64, types.I64)
+		c.module.NewTypeDef("aether.array."+key, st)
+		c.arrayTypes[key] = st
+		c.arrayElems[st] = elem
+	}
+	return types.NewPointer(st)
+}
+
+// arrayElemType reports the element type if t is an array header pointer.
+func (c *CompilerContext) arrayElemType(t types.Type) (types.Type, bool) {
+	ptr, ok := t.(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+	st, ok := ptr.ElemType.(*types.StructType)
+	if !ok {
+		return nil, false
+	}
+	elem, ok := c.arrayElems[st]
+	return elem, ok
+}
+
+// typeKey turns t into a string usable inside symbol names.
+func typeKey(t types.Type) string {
+	if st, ok := t.(*types.StructType); ok && st.Name() != "" {
+		return st.Name()
+	}
+	if ptr, ok := t.(*types.PointerType); ok {
+		return typeKey(ptr.ElemType) + "ptr"
+	}
+	if sig, ok := t.(*types.FuncType); ok {
+		parts := []string{"fn", typeKey(sig.RetType)}
+		for _, p := range sig.Params {
+			parts = append(parts, typeKey(p))
+		}
+		return strings.Join(parts, ".")
+	}
+	r := strings.NewReplacer("%", "", "*", "ptr", " ", "", ",", "_", "{", "s", "}", "e", "[", "a", "]", "e", "\"", "")
+	return r.Replace(t.LLString())
+}
+
+func compileArrayLiteral(e *parser.Array, ctx *CompilerContext) value.Value {
+	if data := constArrayData(ctx, e); data != nil {
+		elemType := data.ContentType.(*types.ArrayType).ElemType
+		arr := ctx.builder.NewCall(arrayNewFunc(ctx, elemType), constant.NewInt(types.I64, int64(len(e.Elements))))
+		dst := ctx.builder.NewBitCast(arrayField(ctx.builder, arr, arrayDataField), i8Ptr)
+		ctx.builder.NewCall(rtMemcpy(ctx), dst, constant.NewBitCast(data, i8Ptr), sizeOf(data.ContentType))
+		return ctx.own(arr)
+	}
+	bugs := len(ctx.bugs)
+	elems := make([]value.Value, 0, len(e.Elements))
+	for _, el := range e.Elements {
+		v := compileExpr(el, ctx)
+		if v == nil {
+			ctx.uncompiled("array literal with", el, bugs)
+			return nil
+		}
+		elems = append(elems, v)
+	}
+	var elemType types.Type = types.I32
+	if inferred := ctx.types.ValueType(e); len(elems) == 0 && inferred != "" {
+		if t, ok := typeFromAnnotation(ctx, inferred); ok {
+			elemType, _ = ctx.arrayElemType(t)
+		}
+	}
+	if len(elems) > 0 {
+		elemType = elems[0].Type()
+		for _, v := range elems[1:] {
+			elemType = widerType(elemType, v.Type())
+		}
+	}
+	arr := ctx.builder.NewCall(arrayNewFunc(ctx, elemType), constant.NewInt(types.I64, int64(len(elems))))
+	data := arrayField(ctx.builder, arr, arrayDataField)
+	for i, v := range elems {
+		slot := ctx.builder.NewGetElementPtr(elemType, data, constant.NewInt(types.I64, int64(i)))
+		v = convertValue(ctx, v, elemType)
+		if ctx.isCounted(elemType) {
+			ctx.take(v)
+		}
+		ctx.builder.NewStore(v, slot)
+	}
+	return ctx.own(arr)
+}
+
+// arrayField loads one of the header fields of arr.
+func arrayField(block *ir.Block, arr value.Value, field int64) value.Value {
+	st := arr.Type().(*types.PointerType).ElemType
+	ptr := block.NewGetElementPtr(st, arr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+	return block.NewLoad(st.(*types.StructType).Fields[field], ptr)
+}
+
+func setArrayField(block *ir.Block, arr value.Value, field int64, v value.Value) {
+	st := arr.Type().(*types.PointerType).ElemType
+	ptr := block.NewGetElementPtr(st, arr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+	block.NewStore(v, ptr)
+}
+
+// arrayElementPtr returns a pointer to arr[index] after checking the index
+// against the current length. Out of range indices abort the program.
+func arrayElementPtr(ctx *CompilerContext, arr value.Value, index value.Value, line int) value.Value {
+	elem, _ := ctx.arrayElemType(arr.Type())
+	idx := convertValue(ctx, index, types.I64)
+	length := arrayField(ctx.builder, arr, arrayLenField)
+	ok := ctx.builder.NewICmp(enum.IPredULT, idx, length)
+	inRange := ctx.NewBlock("bounds.ok")
+	outOfRange := ctx.NewBlock("bounds.fail")
+	ctx.builder.NewCondBr(ok, inRange, outOfRange)
+	outOfRange.NewCall(boundsFailFunc(ctx), sourceLocation(ctx, line), idx, length)
+	outOfRange.NewUnreachable()
+	ctx.builder = inRange
+	data := arrayField(ctx.builder, arr, arrayDataField)
+	return ctx.builder.NewGetElementPtr(elem, data, idx)
+}
+
+func compileArrayIndex(e *parser.ArrayIndex, ctx *CompilerContext) value.Value {
+	arr := compileExpr(e.Array, ctx)
+	index := compileExpr(e.Index, ctx)
+	if arr == nil || index == nil {
+		return nil
+	}
+	elem, ok := ctx.arrayElemType(arr.Type())
+	if !ok {
+		return nil
+	}
+	return ctx.builder.NewLoad(elem, arrayElementPtr(ctx, arr, index, e.Line))
+}
+
+func compileElementAssignment(s *parser.ElementAssignment, ctx *CompilerContext) {
+	if prop, ok := s.Target.(*parser.PropertyAccess); ok {
+		compileFieldAssignment(prop, s.Value, ctx)
+		return
+	}
+	target, ok := s.Target.(*parser.ArrayIndex)
+	if !ok {
+		return
+	}
+	arr := compileExpr(target.Array, ctx)
+	index := compileExpr(target.Index, ctx)
+	val := compileExpr(s.Value, ctx)
+	if arr == nil || index == nil || val == nil {
+		return
+	}
+	elem, ok := ctx.arrayElemType(arr.Type())
+	if !ok {
+		return
+	}
+	ptr := arrayElementPtr(ctx, arr, index, target.Line)
+	ctx.store(convertValue(ctx, val, elem), ptr)
+}
+
+func compileSlice(e *parser.Slice, ctx *CompilerContext) value.Value {
+	arr := compileExpr(e.Array, ctx)
+	if arr == nil {
+		return nil
+	}
+	elem, ok := ctx.arrayElemType(arr.Type())
+	if !ok {
+		return nil
+	}
+	var low, high value.Value = constant.NewInt(types.I64, 0), nil
+	if e.Low != nil {
+		if low = compileExpr(e.Low, ctx); low == nil {
+			return nil
+		}
+		low = convertValue(ctx, low, types.I64)
+	}
+	if e.High != nil {
+		if high = compileExpr(e.High, ctx); high == nil {
+			return nil
+		}
+		high = convertValue(ctx, high, types.I64)
+	} else {
+		high = arrayField(ctx.builder, arr, arrayLenField)
+	}
+	return ctx.own(ctx.builder.NewCall(arraySliceFunc(ctx, elem), arr, low, high, sourceLocation(ctx, e.Line)))
+}
+
+// compileArrayLen returns the length of arr as an int.
+func compileArrayLen(ctx *CompilerContext, arr value.Value) value.Value {
+	return ctx.builder.NewTrunc(arrayField(ctx.builder, arr, arrayLenField), types.I32)
+}
+
+// compileArrayPush appends v to arr in place and returns arr, so that both
+// xs.push(v) and xs = append(xs, v) work. The array takes v over.
+func compileArrayPush(ctx *CompilerContext, arr value.Value, v value.Value) value.Value {
+	elem, _ := ctx.arrayElemType(arr.Type())
+	v = convertValue(ctx, v, elem)
+	if ctx.isCounted(elem) {
+		ctx.take(v)
+	}
+	ctx.builder.NewCall(arrayPushFunc(ctx, elem), arr, v)
+	return arr
+}
+
+// arrayNewFunc returns aether.array.new.<T>(i64 n), which allocates an array
+// of length n with a count of one. The elements are left for the caller to
+// store.
+func arrayNewFunc(ctx *CompilerContext, elem types.Type) *ir.Func {
+	arrType := ctx.arrayType(elem)
+	n := ir.NewParam("n", types.I64)
+	fn, fresh := newRuntimeFunc(ctx, "aether.array.new."+typeKey(elem), arrType, n)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	header := entry.NewBitCast(rcAlloc(ctx, entry, sizeOf(arrType.ElemType), arrayDropFunc(ctx, elem)), arrType)
+	// Never ask malloc for zero bytes so data is always a valid pointer.
+	capacity := entry.NewSelect(entry.NewICmp(enum.IPredEQ, n, constant.NewInt(types.I64, 0)), constant.NewInt(types.I64, 1), n)
+	bytes := entry.NewMul(capacity, sizeOf(elem))
+	data := entry.NewBitCast(rtAlloc(ctx, entry, bytes), types.NewPointer(elem))
+	setArrayField(entry, header, arrayDataField, data)
+	setArrayField(entry, header, arrayLenField, n)
+	setArrayField(entry, header, arrayCapField, capacity)
+	entry.NewRet(header)
+	return fn
+}
+
+// arrayPushFunc returns aether.array.push.<T>(arr, v), growing the backing
+// storage geometrically when it is full.
+func arrayPushFunc(ctx *CompilerContext, elem types.Type) *ir.Func {
+	arrType := ctx.arrayType(elem)
+	arr := ir.NewParam("arr", arrType)
+	v := ir.NewParam("v", elem)
+	fn, fresh := newRuntimeFunc(ctx, "aether.array.push."+typeKey(elem), types.Void, arr, v)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	grow := fn.NewBlock("grow")
+	store := fn.NewBlock("store")
+
+	length := arrayField(entry, arr, arrayLenField)
+	capacity := arrayField(entry, arr, arrayCapField)
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, length, capacity), grow, store)
+
+	newCap := grow.NewMul(capacity, constant.NewInt(types.I64, 2))
+	raw := grow.NewBitCast(arrayField(grow, arr, arrayDataField), i8Ptr)
+	grown := grow.NewCall(rtRealloc(ctx), raw, grow.NewMul(newCap, sizeOf(elem)))
+	setArrayField(grow, arr, arrayDataField, grow.NewBitCast(grown, types.NewPointer(elem)))
+	setArrayField(grow, arr, arrayCapField, newCap)
+	grow.NewBr(store)
+
+	data := arrayField(store, arr, arrayDataField)
+	store.NewStore(v, store.NewGetElementPtr(elem, data, length))
+	setArrayField(store, arr, arrayLenField, store.NewAdd(length, constant.NewInt(types.I64, 1)))
+	store.NewRet(nil)
+	return fn
+}
+
+// arrayExtendFunc returns aether.array.extend.<T>(arr, src, n), appending n
+// elements read from src, which arr retains.
+func arrayExtendFunc(ctx *CompilerContext, elem types.Type) *ir.Func {
+	arrType := ctx.arrayType(elem)
+	arr := ir.NewParam("arr", arrType)
+	src := ir.NewParam("src", types.NewPointer(elem))
+	n := ir.NewParam("n", types.I64)
+	fn, fresh := newRuntimeFunc(ctx, "aether.array.extend."+typeKey(elem), types.Void, arr, src, n)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	grow := fn.NewBlock("grow")
+	copyBlock := fn.NewBlock("copy")
+
+	length := arrayField(entry, arr, arrayLenField)
+	capacity := arrayField(entry, arr, arrayCapField)
+	needed := entry.NewAdd(length, n)
+	entry.NewCondBr(entry.NewICmp(enum.IPredUGT, needed, capacity), grow, copyBlock)
+
+	doubled := grow.NewMul(capacity, constant.NewInt(types.I64, 2))
+	newCap := grow.NewSelect(grow.NewICmp(enum.IPredUGT, needed, doubled), needed, doubled)
+	raw := grow.NewBitCast(arrayField(grow, arr, arrayDataField), i8Ptr)
+	grown := grow.NewCall(rtRealloc(ctx), raw, grow.NewMul(newCap, sizeOf(elem)))
+	setArrayField(grow, arr, arrayDataField, grow.NewBitCast(grown, types.NewPointer(elem)))
+	setArrayField(grow, arr, arrayCapField, newCap)
+	grow.NewBr(copyBlock)
+
+	dst := copyBlock.NewGetElementPtr(elem, arrayField(copyBlock, arr, arrayDataField), length)
+	copyBlock.NewCall(rtMemcpy(ctx), copyBlock.NewBitCast(dst, i8Ptr), copyBlock.NewBitCast(src, i8Ptr), copyBlock.NewMul(n, sizeOf(elem)))
+	if ctx.isCounted(elem) {
+		copyBlock.NewCall(elemsRetainFunc(ctx, elem), dst, n)
+	}
+	setArrayField(copyBlock, arr, arrayLenField, needed)
+	copyBlock.NewRet(nil)
+	return fn
+}
+
+// arraySliceFunc returns aether.array.slice.<T>(arr, lo, hi, where), which
+// copies arr[lo:hi] into a new array after validating the bounds.
+func arraySliceFunc(ctx *CompilerContext, elem types.Type) *ir.Func {
+	arrType := ctx.arrayType(elem)
+	arr := ir.NewParam("arr", arrType)
+	lo := ir.NewParam("lo", types.I64)
+	hi := ir.NewParam("hi", types.I64)
+	where := ir.NewParam("where", i8Ptr)
+	fn, fresh := newRuntimeFunc(ctx, "aether.array.slice."+typeKey(elem), arrType, arr, lo, hi, where)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	copyBlock := fn.NewBlock("copy")
+	fail := fn.NewBlock("fail")
+
+	length := arrayField(entry, arr, arrayLenField)
+	loOK := entry.NewICmp(enum.IPredULE, lo, hi)
+	hiOK := entry.NewICmp(enum.IPredULE, hi, length)
+	entry.NewCondBr(entry.NewAnd(loOK, hiOK), copyBlock, fail)
+
+	rtPanic(ctx, fail, "slice bounds out of range [%lld:%lld] with length %lld", where, lo, hi, length)
+
+	n := copyBlock.NewSub(hi, lo)
+	out := copyBlock.NewCall(arrayNewFunc(ctx, elem), n)
+	src := copyBlock.NewGetElementPtr(elem, arrayField(copyBlock, arr, arrayDataField), lo)
+	dst := arrayField(copyBlock, out, arrayDataField)
+	copyBlock.NewCall(rtMemcpy(ctx), copyBlock.NewBitCast(dst, i8Ptr), copyBlock.NewBitCast(src, i8Ptr), copyBlock.NewMul(n, sizeOf(elem)))
+	if ctx.isCounted(elem) {
+		copyBlock.NewCall(elemsRetainFunc(ctx, elem), dst, n)
+	}
+	copyBlock.NewRet(out)
+	return fn
+}
+
+// arithFailFunc returns the cold path shared by the arithmetic checks of
+// debug builds.
+func arithFailFunc(ctx *CompilerContext) *ir.Func {
+	where := ir.NewParam("where", i8Ptr)
+	what := ir.NewParam("what", i8Ptr)
+	fn, fresh := newRuntimeFunc(ctx, "aether.arith_fail", types.Void, where, what)
+	if !fresh {
+		return fn
+	}
+	fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoReturn, enum.FuncAttrCold, enum.FuncAttrNoInline)
+	rtPanic(ctx, fn.NewBlock("entry"), "%s", where, what)
+	return fn
+}
+
+// boundsFailFunc returns the cold path shared by every index check.
+func boundsFailFunc(ctx *CompilerContext) *ir.Func {
+	where := ir.NewParam("where", i8Ptr)
+	index := ir.NewParam("index", types.I64)
+	length := ir.NewParam("len", types.I64)
+	fn, fresh := newRuntimeFunc(ctx, "aether.bounds_fail", types.Void, where, index, length)
+	if !fresh {
+		return fn
+	}
+	fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoReturn, enum.FuncAttrCold, enum.FuncAttrNoInline)
+	rtPanic(ctx, fn.NewBlock("entry"), "index out of range [%lld] with length %lld", where, index, length)
+	return fn
+}
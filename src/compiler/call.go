@@ -0,0 +1,367 @@
+package compiler
+
+import (
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Calls bind their arguments to parameters from the left. A spread argument
+// ...xs takes the fixed parameters that the plain arguments after it leave
+// free, and the rest of its elements go to the variadic parameter. Extra
+// arguments of a variadic Aether function are collected into an array.
+
+// callArg is a compiled call argument. spread marks an array to expand.
+type callArg struct {
+	v      value.Value
+	spread bool
+}
+
+// argSlot says where the value of a fixed parameter comes from: the
+// argument itself, or element elem of a spread argument.
+type argSlot struct {
+	arg  int
+	elem int
+}
+
+// restPart is an argument that goes to the variadic parameter. For spreads,
+// the elements from index from onwards are passed.
+type restPart struct {
+	arg  int
+	from int
+}
+
+func compileCall(e *parser.Call, ctx *CompilerContext) value.Value {
+	if ident, ok := e.Function.(*parser.Identifier); ok {
+		if isOperator(ident.Value) {
+			return compileOperator(ident.Value, ident.Line, e.Args, ctx)
+		}
+		if isStdlibFunction(ident.Value) {
+			return compileStdlibCall(ident.Value, e.Args, ctx)
+		}
+		if _, shadowed := ctx.GetSymbol(ident.Value); !shadowed {
+			if v, handled := compileBuiltinCall(ident.Value, e.Args, ctx); handled {
+				return v
+			}
+		}
+	}
+	if prop, ok := e.Function.(*parser.PropertyAccess); ok {
+		if v, handled := compileMethodCall(prop, e.Args, ctx); handled {
+			return v
+		}
+	}
+	args, ok := compileCallArgs(e.Args, ctx)
+	if !ok {
+		return nil
+	}
+	fn := resolveCallee(e.Function, ctx)
+	if fn == nil {
+		return nil
+	}
+	return emitCall(ctx, fn, args)
+}
+
+// compileCallArgs compiles call arguments in order. Spreads must name an
+// array.
+func compileCallArgs(exprs []parser.Expression, ctx *CompilerContext) ([]callArg, bool) {
+	args := make([]callArg, 0, len(exprs))
+	for _, expr := range exprs {
+		arg, ok := compileCallArg(expr, ctx)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, arg)
+	}
+	return args, true
+}
+
+func compileCallArg(expr parser.Expression, ctx *CompilerContext) (callArg, bool) {
+	if s, ok := expr.(*parser.Spread); ok {
+		if s.Name == "" {
+			return callArg{}, false
+		}
+		v := compileExpr(&parser.Identifier{Value: s.Name}, ctx)
+		if v == nil {
+			return callArg{}, false
+		}
+		if _, ok := ctx.arrayElemType(v.Type()); !ok {
+			return callArg{}, false
+		}
+		return callArg{v: v, spread: true}, true
+	}
+	v := compileExpr(expr, ctx)
+	return callArg{v: v}, v != nil
+}
+
+// planArgs assigns args to nFixed fixed parameters and a variadic tail.
+func planArgs(args []callArg, nFixed int) (fixed []argSlot, rest []restPart) {
+	for i, arg := range args {
+		if !arg.spread {
+			if len(fixed) < nFixed {
+				fixed = append(fixed, argSlot{arg: i, elem: -1})
+			} else {
+				rest = append(rest, restPart{arg: i})
+			}
+			continue
+		}
+		plainAfter := 0
+		for _, later := range args[i+1:] {
+			if !later.spread {
+				plainAfter++
+			}
+		}
+		k := nFixed - len(fixed) - plainAfter
+		if k < 0 {
+			k = 0
+		}
+		for e := 0; e < k; e++ {
+			fixed = append(fixed, argSlot{arg: i, elem: e})
+		}
+		rest = append(rest, restPart{arg: i, from: k})
+	}
+	return fixed, rest
+}
+
+// slotType is the static type of the value bound by slot, or nil for a
+// placeholder whose value is not known yet.
+func slotType(ctx *CompilerContext, args []callArg, slot argSlot) types.Type {
+	if args[slot.arg].v == nil {
+		return nil
+	}
+	t := args[slot.arg].v.Type()
+	if slot.elem >= 0 {
+		t, _ = ctx.arrayElemType(t)
+	}
+	return t
+}
+
+// restElemType picks the element type of a variadic array from the first
+// argument that goes into it.
+func restElemType(ctx *CompilerContext, args []callArg, rest []restPart) types.Type {
+	if len(rest) == 0 {
+		return nil
+	}
+	slot := argSlot{arg: rest[0].arg, elem: -1}
+	if args[slot.arg].spread {
+		slot.elem = rest[0].from
+	}
+	return slotType(ctx, args, slot)
+}
+
+// calleeSignature returns the function a call to fn with args calls, and
+// its parameter and return types. A pending Aether function is compiled
+// for the argument types first, and an Aether function already compiled
+// for other types is compiled again for these. variadic reports whether
+// the last parameter takes the extra arguments as an array.
+func calleeSignature(ctx *CompilerContext, fn value.Value, args []callArg) (callee value.Value, params []types.Type, variadic bool, ret types.Type, ok bool) {
+	if sig, isClosure := ctx.closureSig(fn.Type()); isClosure {
+		return fn, sig.Params[1:], false, sig.RetType, true
+	}
+	irFn, isFunc := fn.(*ir.Func)
+	if !isFunc {
+		return nil, nil, false, nil, false
+	}
+	if pf, aether := ctx.pending[irFn]; aether {
+		variadic = pf.variadic
+		nFixed := len(irFn.Params)
+		if variadic {
+			nFixed--
+		}
+		fixed, rest := planArgs(args, nFixed)
+		argTypes := make([]types.Type, len(irFn.Params))
+		for i, slot := range fixed {
+			argTypes[i] = slotType(ctx, args, slot)
+		}
+		if elem := restElemType(ctx, args, rest); variadic && elem != nil {
+			argTypes[nFixed] = ctx.arrayType(elem)
+		}
+		irFn = instanceFor(ctx, irFn, argTypes)
+		ensureFunctionCompiled(ctx, irFn, argTypes)
+	}
+	params = make([]types.Type, len(irFn.Params))
+	for i, p := range irFn.Params {
+		params[i] = p.Typ
+	}
+	return irFn, params, variadic, irFn.Sig.RetType, true
+}
+
+// emitCall calls fn with args, which may contain spreads. The callee
+// borrows the arguments, and the caller owns what it returns.
+func emitCall(ctx *CompilerContext, fn value.Value, args []callArg) value.Value {
+	if irFn, ok := fn.(*ir.Func); ok && ctx.pending[irFn] == nil {
+		return emitExternalCall(ctx, irFn, args)
+	}
+	callee, params, variadic, _, ok := calleeSignature(ctx, fn, args)
+	if !ok {
+		ctx.unchecked("call of %s, which is not a function", fn.Type())
+		return nil
+	}
+	fn = callee
+	ctx.holdArg(fn)
+	for _, arg := range args {
+		ctx.holdArg(arg.v)
+	}
+	vals, ok := bindArgs(ctx, args, params, variadic)
+	if !ok {
+		return nil
+	}
+	if _, isClosure := ctx.closureSig(fn.Type()); isClosure {
+		return ctx.own(callClosure(ctx, fn, vals))
+	}
+	call := ctx.builder.NewCall(fn, vals...)
+	if ctx.unsigned[fn] {
+		ctx.unsigned[call] = true
+	}
+	return ctx.own(call)
+}
+
+// emitExternalCall calls a function without an Aether body. Arguments
+// beyond the declared parameters are passed through C varargs, so they
+// cannot come from a spread.
+func emitExternalCall(ctx *CompilerContext, fn *ir.Func, args []callArg) value.Value {
+	paramTypes := make([]types.Type, len(fn.Params))
+	for i, p := range fn.Params {
+		paramTypes[i] = p.Typ
+	}
+	fixed, rest := planArgs(args, len(paramTypes))
+	vals := bindFixed(ctx, args, fixed, paramTypes, true)
+	for _, part := range rest {
+		arg := args[part.arg]
+		switch {
+		case !arg.spread:
+			vals = append(vals, cVararg(ctx, arg.v))
+		case part.from > 0:
+			// Fed the fixed parameters, and was checked to be used up.
+		case fn.Sig.Variadic:
+			ctx.unchecked("spread into the C varargs of %s", fn.Ident())
+			return nil
+		default:
+			checkSpreadLen(ctx, arg.v, 0, true)
+		}
+	}
+	call := ctx.builder.NewCall(fn, vals...)
+	if ctx.unsigned[fn] {
+		ctx.unsigned[call] = true
+	}
+	return call
+}
+
+// bindArgs computes the parameter values for a call. When variadic is set
+// the last parameter is an array receiving the extra arguments.
+func bindArgs(ctx *CompilerContext, args []callArg, params []types.Type, variadic bool) ([]value.Value, bool) {
+	nFixed := len(params)
+	if variadic {
+		nFixed--
+	}
+	fixed, rest := planArgs(args, nFixed)
+	if len(fixed) < nFixed {
+		ctx.unchecked("not enough arguments: expected %d, found %d", nFixed, len(fixed))
+		return nil, false
+	}
+	vals := bindFixed(ctx, args, fixed, params[:nFixed], !variadic)
+	if !variadic {
+		for _, part := range rest {
+			arg := args[part.arg]
+			if !arg.spread {
+				ctx.unchecked("too many arguments: expected %d, found %d", nFixed, len(args))
+				return nil, false
+			}
+			if part.from == 0 {
+				checkSpreadLen(ctx, arg.v, 0, true)
+			}
+		}
+		return vals, true
+	}
+	arrType := params[nFixed]
+	elem, ok := ctx.arrayElemType(arrType)
+	if !ok {
+		ctx.unchecked("rest parameter of %s, which is not an array", arrType)
+		return nil, false
+	}
+	b := ctx.builder
+	extra := ctx.own(b.NewCall(arrayNewFunc(ctx, elem), constant.NewInt(types.I64, 0)))
+	for _, part := range rest {
+		arg := args[part.arg]
+		if !arg.spread {
+			compileArrayPush(ctx, extra, arg.v)
+			continue
+		}
+		if !arg.v.Type().Equal(arrType) {
+			ctx.unchecked("spread of %s into a rest parameter of %s", arg.v.Type(), arrType)
+			return nil, false
+		}
+		from := constant.NewInt(types.I64, int64(part.from))
+		data := b.NewGetElementPtr(elem, arrayField(b, arg.v, arrayDataField), from)
+		n := b.NewSub(arrayField(b, arg.v, arrayLenField), from)
+		b.NewCall(arrayExtendFunc(ctx, elem), extra, data, n)
+	}
+	return append(vals, extra), true
+}
+
+// bindFixed loads the values of the fixed parameters. Every spread that
+// feeds them is checked to have enough elements, or exactly enough when
+// exact is set and nothing else can take the remainder.
+func bindFixed(ctx *CompilerContext, args []callArg, fixed []argSlot, params []types.Type, exact bool) []value.Value {
+	checked := map[int]bool{}
+	vals := make([]value.Value, 0, len(fixed))
+	for i, slot := range fixed {
+		arg := args[slot.arg]
+		v := arg.v
+		if slot.elem >= 0 {
+			if !checked[slot.arg] {
+				checked[slot.arg] = true
+				need := 0
+				for _, s := range fixed {
+					if s.arg == slot.arg {
+						need++
+					}
+				}
+				checkSpreadLen(ctx, v, need, exact)
+			}
+			elem, _ := ctx.arrayElemType(v.Type())
+			data := arrayField(ctx.builder, v, arrayDataField)
+			ptr := ctx.builder.NewGetElementPtr(elem, data, constant.NewInt(types.I64, int64(slot.elem)))
+			v = ctx.builder.NewLoad(elem, ptr)
+		}
+		if i < len(params) {
+			v = convertValue(ctx, v, params[i])
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+// checkSpreadLen aborts unless arr has need elements, or at least need when
+// exact is false.
+func checkSpreadLen(ctx *CompilerContext, arr value.Value, need int, exact bool) {
+	n := arrayField(ctx.builder, arr, arrayLenField)
+	want := constant.NewInt(types.I64, int64(need))
+	pred := enum.IPredUGE
+	if exact {
+		pred = enum.IPredEQ
+	}
+	ok := ctx.builder.NewICmp(pred, n, want)
+	okBlock := ctx.NewBlock("spread.ok")
+	failBlock := ctx.NewBlock("spread.fail")
+	ctx.builder.NewCondBr(ok, okBlock, failBlock)
+	failBlock.NewCall(spreadFailFunc(ctx), sourceLocation(ctx, 0), n, want)
+	failBlock.NewUnreachable()
+	ctx.builder = okBlock
+}
+
+func spreadFailFunc(ctx *CompilerContext) *ir.Func {
+	where := ir.NewParam("where", i8Ptr)
+	n := ir.NewParam("len", types.I64)
+	want := ir.NewParam("want", types.I64)
+	fn, fresh := newRuntimeFunc(ctx, "aether.spread_fail", types.Void, where, n, want)
+	if !fresh {
+		return fn
+	}
+	fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoReturn, enum.FuncAttrCold, enum.FuncAttrNoInline)
+	rtPanic(ctx, fn.NewBlock("entry"), "cannot spread %lld elements into %lld parameters", where, n, want)
+	return fn
+}
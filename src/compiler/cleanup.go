@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/value"
+)
+
+// dce removes the instructions of fn that have no effect and whose results
+// nothing with an effect uses, directly or through other instructions.
+func dce(fn *ir.Func) {
+	live := make(map[ir.Instruction]bool)
+	var work []ir.Instruction
+	use := func(ops []*value.Value) {
+		for _, op := range ops {
+			if inst, ok := (*op).(ir.Instruction); ok && !live[inst] {
+				live[inst] = true
+				work = append(work, inst)
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Insts {
+			if !isPure(inst) {
+				live[inst] = true
+				work = append(work, inst)
+			}
+		}
+		if b.Term != nil {
+			use(b.Term.Operands())
+		}
+	}
+	for len(work) > 0 {
+		inst := work[len(work)-1]
+		work = work[:len(work)-1]
+		use(inst.Operands())
+	}
+	dead := make(map[ir.Instruction]bool)
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Insts {
+			if !live[inst] {
+				dead[inst] = true
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		removeInsts(b, dead)
+	}
+}
+
+// isPure reports whether inst only computes its result, so that it can go
+// when the result is not used.
+func isPure(inst ir.Instruction) bool {
+	if _, _, ok := binaryOperands(inst); ok {
+		return true
+	}
+	switch inst := inst.(type) {
+	case *ir.InstLoad:
+		return !inst.Volatile
+	case *ir.InstICmp, *ir.InstFCmp, *ir.InstSelect, *ir.InstPhi, *ir.InstGetElementPtr, *ir.InstAlloca,
+		*ir.InstExtractValue, *ir.InstInsertValue, *ir.InstFNeg,
+		*ir.InstTrunc, *ir.InstZExt, *ir.InstSExt, *ir.InstFPTrunc, *ir.InstFPExt, *ir.InstFPToUI, *ir.InstFPToSI,
+		*ir.InstUIToFP, *ir.InstSIToFP, *ir.InstPtrToInt, *ir.InstIntToPtr, *ir.InstBitCast:
+		return true
+	}
+	return false
+}
+
+// simplifyCFG removes the blocks of fn that cannot be reached, merges each
+// block that is only ever jumped to from one other into it, and sends jumps
+// to blocks that only jump on straight to where they go.
+func simplifyCFG(fn *ir.Func) {
+	for changed := true; changed; {
+		removeUnreachable(fn)
+		changed = mergeBlocks(fn) || forwardJumps(fn)
+	}
+}
+
+// mergeBlocks merges a block into its only predecessor, when that ends in a
+// jump to it, and reports whether it did.
+func mergeBlocks(fn *ir.Func) bool {
+	preds, _ := blockGraph(fn)
+	for i, b := range fn.Blocks[1:] {
+		if len(preds[b]) != 1 {
+			continue
+		}
+		p := preds[b][0]
+		if _, ok := p.Term.(*ir.TermBr); !ok || p == b {
+			continue
+		}
+		repl := make(map[value.Value]value.Value)
+		for _, phi := range phis(b) {
+			repl[phi] = phi.Incs[0].X
+		}
+		p.Insts = append(p.Insts, b.Insts[len(repl):]...)
+		p.Term = b.Term
+		for _, s := range termSuccs(b.Term) {
+			for _, phi := range phis(s) {
+				for _, inc := range phi.Incs {
+					if inc.Pred == b {
+						inc.Pred = p
+					}
+				}
+			}
+		}
+		fn.Blocks = append(fn.Blocks[:i+1], fn.Blocks[i+2:]...)
+		replaceUses(fn, repl)
+		return true
+	}
+	return false
+}
+
+// forwardJumps points the branches to an empty block that jumps straight on
+// to where it jumps, when that takes no phis, and reports whether it did.
+// The empty block is then unreachable.
+func forwardJumps(fn *ir.Func) bool {
+	changed := false
+	preds, _ := blockGraph(fn)
+	for _, b := range fn.Blocks[1:] {
+		br, ok := b.Term.(*ir.TermBr)
+		if !ok || len(b.Insts) > 0 || len(br.Metadata) > 0 {
+			continue
+		}
+		to := br.Target.(*ir.Block)
+		if to == b || len(phis(to)) > 0 {
+			continue
+		}
+		for _, p := range preds[b] {
+			if retarget(p.Term, b, to) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// retarget points the edges of term to from at to instead, and reports
+// whether term is a branch it knows how to change.
+func retarget(term ir.Terminator, from, to *ir.Block) bool {
+	swap := func(target *value.Value) {
+		if *target == from {
+			*target = to
+		}
+	}
+	// Terminators cache their successors, so the caches are cleared too.
+	switch term := term.(type) {
+	case *ir.TermBr:
+		swap(&term.Target)
+		term.Successors = nil
+	case *ir.TermCondBr:
+		swap(&term.TargetTrue)
+		swap(&term.TargetFalse)
+		term.Successors = nil
+	case *ir.TermSwitch:
+		swap(&term.TargetDefault)
+		for _, c := range term.Cases {
+			swap(&c.Target)
+		}
+		term.Successors = nil
+	default:
+		return false
+	}
+	return true
+}
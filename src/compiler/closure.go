@@ -0,0 +1,448 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A lambda compiles to a function taking its environment as the first
+// parameter, and a closure value is the pair { fn, env }. The environment
+// holds copies of the captured variables, except for the variables that
+// are assigned again, whose cells it holds so that the lambda and the
+// enclosing function see each other's assignments. It lives on the stack
+// when the closure cannot outlive the enclosing call and on the heap
+// otherwise. A
+// heap environment is a counted object owning the values it holds; one on
+// the stack has a header that is never counted, and only borrows them.
+
+// closureType returns the closure type for functions with signature sig,
+// whose first parameter is the environment.
+func (c *CompilerContext) closureType(sig *types.FuncType) *types.StructType {
+	key := typeKey(sig)
+	if st, ok := c.closureTypes[key]; ok {
+		return st
+	}
+	st := types.NewStruct(types.NewPointer(sig), i8Ptr)
+	c.module.NewTypeDef("aether.closure."+key, st)
+	c.closureTypes[key] = st
+	c.closureSigs[st] = sig
+	return st
+}
+
+// closureSig reports the signature of the function inside a closure type.
+func (c *CompilerContext) closureSig(t types.Type) (*types.FuncType, bool) {
+	st, ok := t.(*types.StructType)
+	if !ok {
+		return nil, false
+	}
+	sig, ok := c.closureSigs[st]
+	return sig, ok
+}
+
+// freeVariables lists, in order of first use, the names a lambda body
+// refers to that belong to local variables of the enclosing function.
+func freeVariables(body *parser.Block, ctx *CompilerContext) []string {
+	var names []string
+	seen := map[string]bool{}
+	use := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if v, ok := ctx.GetSymbol(name); ok && isLocalSlot(v) {
+			names = append(names, name)
+		}
+	}
+	parser.Inspect(body, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Function:
+			// Named functions do not capture.
+			return false
+		case *parser.Identifier:
+			use(n.Value)
+		case *parser.Spread:
+			use(n.Name)
+		}
+		return true
+	})
+	return names
+}
+
+// isVariable reports whether v is the storage of a local or module-level
+// variable.
+func isVariable(v value.Value) bool {
+	_, global := v.(*ir.Global)
+	return global || isLocalSlot(v)
+}
+
+// isLocalSlot reports whether v is the storage of a local variable.
+func isLocalSlot(v value.Value) bool {
+	switch v.(type) {
+	case *ir.InstAlloca, *ir.InstGetElementPtr:
+		return true
+	}
+	return false
+}
+
+// closureEscapes reports whether the closure bound to name may outlive the
+// current call. Only a binding that is assigned once and otherwise only
+// called stays on the stack.
+func closureEscapes(ctx *CompilerContext, name string) bool {
+	if ctx.currentBody == nil {
+		return true
+	}
+	uses, calls, assigns := 0, 0, 0
+	parser.Inspect(ctx.currentBody, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.Identifier:
+			if n.Value == name {
+				uses++
+			}
+		case *parser.Spread:
+			if n.Name == name {
+				uses++
+			}
+		case *parser.Call:
+			if ident, ok := n.Function.(*parser.Identifier); ok && ident.Value == name {
+				calls++
+			}
+		case *parser.Assignment:
+			for _, target := range n.Names {
+				if target.Value == name {
+					assigns++
+				}
+			}
+		}
+		return true
+	})
+	return assigns != 1 || uses != calls+assigns
+}
+
+// closureOnStack reports whether the closure assigned by s can keep its
+// environment on the stack. A module-level closure outlives the
+// initializer that creates it.
+func closureOnStack(ctx *CompilerContext, s *parser.Assignment) bool {
+	return len(s.Names) == 1 && !atModuleLevel(ctx, s.Names[0].Value) && !closureEscapes(ctx, s.Names[0].Value)
+}
+
+// compileLambda compiles a block literal into a closure value.
+func compileLambda(body *parser.Block, ctx *CompilerContext, onStack bool) value.Value {
+	captured := freeVariables(body, ctx)
+	vals := make([]value.Value, len(captured))
+	fieldTypes := make([]types.Type, len(captured))
+	shared := make([]bool, len(captured))
+	for i, name := range captured {
+		slot, _ := ctx.GetSymbol(name)
+		if cell, ok := ctx.cellOf(slot); ok {
+			vals[i], fieldTypes[i], shared[i] = cell, cell.Type(), true
+			continue
+		}
+		elem := slot.Type().(*types.PointerType).ElemType
+		vals[i] = ctx.builder.NewLoad(elem, slot)
+		fieldTypes[i] = elem
+	}
+
+	// A stack environment borrows the captured values, so the variables
+	// must keep them for as long as the closure can run. The function
+	// keeps its cells until it returns.
+	for i, name := range captured {
+		if ctx.isCounted(fieldTypes[i]) && !shared[i] && !ctx.ownership.BorrowsCapture(body, name) {
+			onStack = false
+		}
+	}
+	var env value.Value = constant.NewNull(i8Ptr)
+	envType := types.NewStruct(fieldTypes...)
+	if len(captured) > 0 {
+		envPtr := newEnv(ctx, envType, onStack)
+		for i, v := range vals {
+			if !onStack && ctx.isCounted(fieldTypes[i]) {
+				ctx.take(v)
+			}
+			storeField(ctx, envPtr, i, v)
+		}
+		env = ctx.builder.NewBitCast(envPtr, i8Ptr)
+	}
+
+	envParam := ir.NewParam("env", i8Ptr)
+	fn := ctx.module.NewFunc(closureFuncName(ctx, "lambda"), types.I32, envParam)
+	fn.Linkage = enum.LinkageInternal
+	pf := &pendingFunc{state: funcCompiling}
+	ctx.pending[fn] = pf
+	withFunction(ctx, fn, func() {
+		ctx.currentBody = body
+		ctx.EnterScope()
+		if len(captured) > 0 {
+			envPtr := ctx.builder.NewBitCast(envParam, types.NewPointer(envType))
+			for i, name := range captured {
+				if shared[i] {
+					ctx.SetSymbol(name, cellSlot(ctx, loadField(ctx, envPtr, i)))
+					continue
+				}
+				ctx.SetSymbol(name, fieldPtr(ctx, envPtr, i))
+			}
+		}
+		for _, stmt := range body.Statements {
+			compileStmt(stmt, ctx)
+		}
+		if ctx.builder.Term == nil {
+			ctx.leaveFunction(nil)
+			ctx.builder.NewRet(zeroValue(fn.Sig.RetType))
+		}
+		ctx.ExitScope()
+	})
+	pf.state = funcDone
+	return ctx.own(makeClosure(ctx, fn, env))
+}
+
+// newEnv allocates an environment of type envType, on the stack or as a
+// counted object on the heap, and returns a pointer to it.
+func newEnv(ctx *CompilerContext, envType *types.StructType, onStack bool) value.Value {
+	if !onStack {
+		obj := rcAlloc(ctx, ctx.builder, sizeOf(envType), fieldsDrop(ctx, envType))
+		return ctx.builder.NewBitCast(obj, types.NewPointer(envType))
+	}
+	hdr := ctx.rcHeader()
+	slot := ctx.NewLocal("env", types.NewStruct(hdr, envType))
+	zero := constant.NewInt(types.I32, 0)
+	ctx.builder.NewStore(constant.NewStruct(hdr, constant.NewInt(types.I64, -1), constant.NewNull(dropType)),
+		ctx.builder.NewGetElementPtr(slot.ElemType, slot, zero, zero))
+	return ctx.builder.NewGetElementPtr(slot.ElemType, slot, zero, constant.NewInt(types.I32, 1))
+}
+
+// makeClosure pairs fn with env.
+func makeClosure(ctx *CompilerContext, fn *ir.Func, env value.Value) value.Value {
+	st := ctx.closureType(fn.Sig)
+	if c, ok := env.(constant.Constant); ok {
+		return constant.NewStruct(st, fn, c)
+	}
+	var closure value.Value = constant.NewUndef(st)
+	closure = ctx.builder.NewInsertValue(closure, fn, 0)
+	return ctx.builder.NewInsertValue(closure, env, 1)
+}
+
+// functionClosure returns the named function fn used as a value: a closure
+// without an environment, whose function passes its arguments on to fn.
+func functionClosure(ctx *CompilerContext, fn *ir.Func) value.Value {
+	wrapper, ok := ctx.funcClosures[fn]
+	if !ok {
+		params := []*ir.Param{ir.NewParam("env", i8Ptr)}
+		args := make([]value.Value, len(fn.Params))
+		for i, p := range fn.Params {
+			param := ir.NewParam(p.Name(), p.Typ)
+			params = append(params, param)
+			args[i] = param
+		}
+		wrapper = ctx.module.NewFunc(ctx.uniqueGlobal(fn.Name()+".closure"), fn.Sig.RetType, params...)
+		wrapper.Linkage = enum.LinkageInternal
+		entry := wrapper.NewBlock("entry")
+		call := entry.NewCall(fn, args...)
+		if fn.Sig.RetType.Equal(types.Void) {
+			entry.NewRet(nil)
+		} else {
+			entry.NewRet(call)
+		}
+		ctx.funcClosures[fn] = wrapper
+	}
+	return makeClosure(ctx, wrapper, constant.NewNull(i8Ptr))
+}
+
+// closureFuncName names the function behind a closure created in the
+// current function, such as main.lambda.0.
+func closureFuncName(ctx *CompilerContext, kind string) string {
+	outer := kind
+	if ctx.current_func != nil {
+		outer = strings.TrimPrefix(ctx.current_func.Name(), "__") + "." + kind
+	}
+	return ctx.uniqueGlobal(outer)
+}
+
+// compilePartialApplication lowers f(a, _, c) to a closure taking one
+// parameter per placeholder. The other arguments, and f itself when it is a
+// closure, are evaluated now and kept in the environment.
+func compilePartialApplication(e *parser.PartialApplication, ctx *CompilerContext, onStack bool) value.Value {
+	args := make([]callArg, len(e.Args))
+	for i, expr := range e.Args {
+		if isPlaceholder(expr) {
+			continue
+		}
+		arg, ok := compileCallArg(expr, ctx)
+		if !ok {
+			return nil
+		}
+		args[i] = arg
+	}
+	callee := resolveCallee(e.Function, ctx)
+	if callee == nil {
+		return nil
+	}
+	callee, params, variadic, ret, ok := calleeSignature(ctx, callee, args)
+	if !ok {
+		return nil
+	}
+	holeTypes := placeholderTypes(ctx, args, params, variadic)
+
+	// The environment holds the callee unless it is a plain function,
+	// followed by the bound arguments in order.
+	var envVals []value.Value
+	if _, isFunc := callee.(*ir.Func); !isFunc {
+		envVals = append(envVals, callee)
+	}
+	for _, arg := range args {
+		if arg.v != nil {
+			envVals = append(envVals, arg.v)
+		}
+	}
+	// Bound values can be temporaries, which only a heap environment can
+	// keep alive.
+	fieldTypes := make([]types.Type, len(envVals))
+	for i, v := range envVals {
+		fieldTypes[i] = v.Type()
+		if ctx.isCounted(v.Type()) {
+			onStack = false
+		}
+	}
+	envType := types.NewStruct(fieldTypes...)
+	var env value.Value = constant.NewNull(i8Ptr)
+	if len(envVals) > 0 {
+		envPtr := newEnv(ctx, envType, onStack)
+		for i, v := range envVals {
+			if !onStack && ctx.isCounted(v.Type()) {
+				ctx.take(v)
+			}
+			storeField(ctx, envPtr, i, v)
+		}
+		env = ctx.builder.NewBitCast(envPtr, i8Ptr)
+	}
+
+	envParam := ir.NewParam("env", i8Ptr)
+	fnParams := []*ir.Param{envParam}
+	for i, t := range holeTypes {
+		fnParams = append(fnParams, ir.NewParam(fmt.Sprintf("arg%d", i), t))
+	}
+	fn := ctx.module.NewFunc(closureFuncName(ctx, "partial"), ret, fnParams...)
+	fn.Linkage = enum.LinkageInternal
+	withFunction(ctx, fn, func() {
+		var fields []value.Value
+		if len(envVals) > 0 {
+			envPtr := ctx.builder.NewBitCast(envParam, types.NewPointer(envType))
+			for i := range envVals {
+				fields = append(fields, loadField(ctx, envPtr, i))
+			}
+		}
+		target := callee
+		if _, isFunc := callee.(*ir.Func); !isFunc {
+			target, fields = fields[0], fields[1:]
+		}
+		callArgs := make([]callArg, len(args))
+		holes := fnParams[1:]
+		for i, arg := range args {
+			if arg.v == nil {
+				callArgs[i] = callArg{v: holes[0]}
+				holes = holes[1:]
+			} else {
+				callArgs[i] = callArg{v: fields[0], spread: arg.spread}
+				fields = fields[1:]
+			}
+		}
+		result := emitCall(ctx, target, callArgs)
+		if result == nil {
+			ctx.leaveFunction(nil)
+			ctx.builder.NewRet(zeroValue(ret))
+			return
+		}
+		result = convertValue(ctx, result, ret)
+		ctx.leaveFunction(result)
+		ctx.builder.NewRet(result)
+	})
+	return ctx.own(makeClosure(ctx, fn, env))
+}
+
+func isPlaceholder(expr parser.Expression) bool {
+	ident, ok := expr.(*parser.Identifier)
+	return ok && ident.Value == "_"
+}
+
+// placeholderTypes returns the types of the parameters that the
+// placeholders in args stand for. Placeholders passed through C varargs
+// are ints.
+func placeholderTypes(ctx *CompilerContext, args []callArg, params []types.Type, variadic bool) []types.Type {
+	nFixed := len(params)
+	if variadic {
+		nFixed--
+	}
+	fixed, rest := planArgs(args, nFixed)
+	var out []types.Type
+	for i, slot := range fixed {
+		if args[slot.arg].v == nil {
+			out = append(out, params[i])
+		}
+	}
+	for _, part := range rest {
+		if args[part.arg].v != nil {
+			continue
+		}
+		var t types.Type = types.I32
+		if variadic {
+			t, _ = ctx.arrayElemType(params[nFixed])
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// callClosure calls closure with args, passing its environment first.
+func callClosure(ctx *CompilerContext, closure value.Value, args []value.Value) value.Value {
+	sig, _ := ctx.closureSig(closure.Type())
+	fn := ctx.builder.NewExtractValue(closure, 0)
+	env := ctx.builder.NewExtractValue(closure, 1)
+	callArgs := []value.Value{env}
+	for i, arg := range args {
+		if i+1 < len(sig.Params) {
+			arg = convertValue(ctx, arg, sig.Params[i+1])
+		}
+		callArgs = append(callArgs, arg)
+	}
+	return ctx.builder.NewCall(fn, callArgs...)
+}
+
+// compileArrayMap calls closure once per element of arr and collects the
+// results, which the new array takes over from the calls, into it. A
+// function that takes a parameter gets the element; a block lambda, which
+// takes none, is just called once per element.
+func compileArrayMap(ctx *CompilerContext, arr, closure value.Value) value.Value {
+	sig, _ := ctx.closureSig(closure.Type())
+	elem, _ := ctx.arrayElemType(arr.Type())
+	resultType := sig.RetType
+	out := ctx.builder.NewCall(arrayNewFunc(ctx, resultType), constant.NewInt(types.I64, 0))
+	counter := ctx.NewLocal("map.idx", types.I64)
+	ctx.builder.NewStore(constant.NewInt(types.I64, 0), counter)
+	cond := ctx.NewBlock("map.cond")
+	body := ctx.NewBlock("map.body")
+	end := ctx.NewBlock("map.end")
+	ctx.builder.NewBr(cond)
+
+	i := cond.NewLoad(types.I64, counter)
+	cond.NewCondBr(cond.NewICmp(enum.IPredSLT, i, arrayField(cond, arr, arrayLenField)), body, end)
+
+	ctx.builder = body
+	var args []value.Value
+	if len(sig.Params) > 1 {
+		data := arrayField(body, arr, arrayDataField)
+		args = append(args, body.NewLoad(elem, body.NewGetElementPtr(elem, data, i)))
+	}
+	result := callClosure(ctx, closure, args)
+	ctx.builder.NewCall(arrayPushFunc(ctx, resultType), out, result)
+	ctx.builder.NewStore(ctx.builder.NewAdd(i, constant.NewInt(types.I64, 1)), counter)
+	ctx.builder.NewBr(cond)
+
+	ctx.builder = end
+	return ctx.own(out)
+}
@@ -0,0 +1,174 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/enum"
+)
+
+// Codegen is how the IR of a module becomes machine code: the target llc
+// compiles for, and the stack protector and sanitizers. Those two work by
+// function attributes, which CompileModule adds to every function it
+// defines; sanitizers also need opt to instrument the module before llc
+// runs.
+type Codegen struct {
+	// Triple is the LLVM target triple, as returned by TargetTriple.
+	Triple string
+	// CPU and Features are passed to llc as -mcpu and -mattr; "" leaves
+	// them to llc.
+	CPU      string
+	Features string
+	// RelocModel and CodeModel are passed to llc as -relocation-model and
+	// -code-model; "" leaves them to llc.
+	RelocModel string
+	CodeModel  string
+	// StackProtector is none, basic, strong or all; "" is none.
+	StackProtector string
+	// Sanitizers are address, thread or memory.
+	Sanitizers []string
+}
+
+var targetTriples = map[string]string{
+	"linux/amd64":   "x86_64-unknown-linux-gnu",
+	"linux/arm64":   "aarch64-unknown-linux-gnu",
+	"linux/386":     "i386-unknown-linux-gnu",
+	"linux/arm":     "armv7-unknown-linux-gnueabihf",
+	"darwin/amd64":  "x86_64-apple-macosx10.15.0",
+	"darwin/arm64":  "arm64-apple-macosx11.0.0",
+	"windows/amd64": "x86_64-pc-windows-msvc",
+	"windows/arm64": "aarch64-pc-windows-msvc",
+	"windows/386":   "i686-pc-windows-msvc",
+}
+
+// TargetTriple returns the LLVM triple for a target given as Go names its
+// operating systems and architectures.
+func TargetTriple(goos, goarch string) (string, error) {
+	if triple, ok := targetTriples[goos+"/"+goarch]; ok {
+		return triple, nil
+	}
+	return "", fmt.Errorf("unsupported target %s-%s", goos, goarch)
+}
+
+var sanitizerAttrs = map[string]enum.FuncAttr{
+	"address": enum.FuncAttrSanitizeAddress,
+	"thread":  enum.FuncAttrSanitizeThread,
+	"memory":  enum.FuncAttrSanitizeMemory,
+}
+
+var sanitizerPasses = map[string]string{
+	"address": "asan-module",
+	"thread":  "tsan-module,function(tsan)",
+	"memory":  "msan-module,function(msan)",
+}
+
+// Check reports the first setting llc does not take, or combination that
+// cannot work.
+func (c Codegen) Check() error {
+	switch c.RelocModel {
+	case "", "static", "pic", "dynamic-no-pic":
+	default:
+		return fmt.Errorf("unknown relocation model '%s' (use static, pic or dynamic-no-pic)", c.RelocModel)
+	}
+	arch := strings.SplitN(c.Triple, "-", 2)[0]
+	switch c.CodeModel {
+	case "", "small", "medium", "large":
+	case "tiny":
+		if arch != "aarch64" && arch != "arm64" {
+			return fmt.Errorf("code model 'tiny' is only supported on arm64")
+		}
+	case "kernel":
+		if arch != "x86_64" {
+			return fmt.Errorf("code model 'kernel' is only supported on amd64")
+		}
+	default:
+		return fmt.Errorf("unknown code model '%s' (use tiny, small, kernel, medium or large)", c.CodeModel)
+	}
+	switch c.StackProtector {
+	case "", "none", "basic", "strong", "all":
+	default:
+		return fmt.Errorf("unknown stack protector mode '%s' (use none, basic, strong or all)", c.StackProtector)
+	}
+	for _, f := range strings.Split(c.Features, ",") {
+		if f != "" && f[0] != '+' && f[0] != '-' {
+			return fmt.Errorf("target feature '%s' must start with + or -, as in +%s", f, f)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, s := range c.Sanitizers {
+		if s == "undefined" {
+			return fmt.Errorf("the undefined behavior sanitizer is not supported; debug builds (-O0 or --debug-info) check overflow, bounds and division at run time instead")
+		}
+		if _, ok := sanitizerAttrs[s]; !ok {
+			return fmt.Errorf("unknown sanitizer '%s' (use address, thread or memory)", s)
+		}
+		seen[s] = true
+	}
+	if len(seen) > 1 {
+		return fmt.Errorf("sanitizers %s cannot be combined; use one at a time", strings.Join(c.Sanitizers, " and "))
+	}
+	return nil
+}
+
+// FuncAttrs returns the attributes every function needs.
+func (c Codegen) FuncAttrs() []enum.FuncAttr {
+	var attrs []enum.FuncAttr
+	switch c.StackProtector {
+	case "basic":
+		attrs = append(attrs, enum.FuncAttrSSP)
+	case "strong":
+		attrs = append(attrs, enum.FuncAttrSSPStrong)
+	case "all":
+		attrs = append(attrs, enum.FuncAttrSSPReq)
+	}
+	for _, s := range c.Sanitizers {
+		attrs = append(attrs, sanitizerAttrs[s])
+	}
+	return attrs
+}
+
+// SanitizerPasses returns the opt pipeline that instruments a module for
+// the sanitizers, or "" when there are none.
+func (c Codegen) SanitizerPasses() string {
+	var passes []string
+	for _, s := range c.Sanitizers {
+		passes = append(passes, sanitizerPasses[s])
+	}
+	return strings.Join(passes, ",")
+}
+
+// LLCArgs returns the llc flags for the settings.
+func (c Codegen) LLCArgs() []string {
+	var args []string
+	if c.Triple != "" {
+		args = append(args, "-mtriple="+c.Triple)
+	}
+	if c.CPU != "" {
+		args = append(args, "-mcpu="+c.CPU)
+	}
+	if c.Features != "" {
+		args = append(args, "-mattr="+c.Features)
+	}
+	if c.RelocModel != "" {
+		args = append(args, "-relocation-model="+c.RelocModel)
+	}
+	if c.CodeModel != "" {
+		args = append(args, "-code-model="+c.CodeModel)
+	}
+	return args
+}
+
+// addFuncAttrs adds attrs to the functions m defines.
+func addFuncAttrs(m *ir.Module, attrs []enum.FuncAttr) {
+	for _, fn := range m.Funcs {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+		for _, attr := range attrs {
+			if !hasFuncAttr(fn, attr) {
+				fn.FuncAttrs = append(fn.FuncAttrs, attr)
+			}
+		}
+	}
+}
@@ -1,14 +1,52 @@
 package compiler
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
+	"aether/lib/utils"
 	analysis "aether/src/analysis"
 	"aether/src/parser"
 
 	"github.com/llir/llvm/ir/constant"
-	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 )
 
+// Options controls how a single source file is lowered to LLVM IR.
+type Options struct {
+	// ModuleName is the module the file belongs to; "main" produces the
+	// program entry point.
+	ModuleName string
+	// Entry marks the file the program starts in, whatever its module is
+	// named. Its body runs in main, or before a main it defines.
+	Entry bool
+	// SourceFile is the path reported by runtime errors in debug builds.
+	SourceFile string
+	// Debug enables source locations in runtime error messages and a report
+	// of the objects still allocated at exit.
+	Debug bool
+	// ModuleSymbols holds the exported symbols of the imported modules.
+	ModuleSymbols map[string]map[string]interface{}
+	// InitOrder lists the modules whose initializers main runs before
+	// anything else, dependencies first.
+	InitOrder []string
+	// Live names the top-level functions to compile, as found by
+	// analysis.BuildCallGraph; the others never run and are left out. Nil
+	// compiles every function.
+	Live map[string]bool
+	// Pipeline is the optimization passes CompileModule runs over the IR;
+	// the zero Pipeline runs none.
+	Pipeline Pipeline
+	// Codegen is the target and code generation settings the module is
+	// compiled for; CompileModule records the triple and adds the function
+	// attributes they need.
+	Codegen Codegen
+	// DebugInfo has CompileModule describe the source to debuggers: the
+	// lines of every function and its variables.
+	DebugInfo bool
+}
+
 func Compile(prog *parser.Program) string {
 	return CompileWithOptions(prog, "main")
 }
@@ -18,8 +56,85 @@ func CompileWithOptions(prog *parser.Program, moduleName string) string {
 }
 
 func CompileWithOptionsAndModules(prog *parser.Program, moduleName string, moduleSymbols map[string]map[string]interface{}) string {
-	ctx := NewCompilerContext(moduleName)
+	return CompileProgram(prog, Options{ModuleName: moduleName, ModuleSymbols: moduleSymbols})
+}
+
+func CompileProgram(prog *parser.Program, opts Options) string {
+	ctx := NewCompilerContext(opts.ModuleName)
+	defer ctx.Dispose()
+	lowerProgram(ctx, prog, opts)
+	return ctx.GetModule().String()
+}
+
+// CompileModule compiles prog like CompileProgram, and verifies the IR
+// before returning it. Codegen that panics, meets code the checkers should
+// have rejected, or emits IR the verifier rejects, is a bug in the compiler
+// rather than in prog; it is reported as an internal compiler error at the
+// statement being compiled, and no IR is returned. The IR is then
+// optimized with opts.Pipeline, given the attributes opts.Codegen needs
+// and, with opts.DebugInfo, debug info, and verified again.
+func CompileModule(prog *parser.Program, opts Options) (llvmIR string, errs []utils.ParseError) {
+	ctx := NewCompilerContext(opts.ModuleName)
 	defer ctx.Dispose()
+	stage := "code generation"
+	defer func() {
+		if r := recover(); r != nil {
+			llvmIR = ""
+			errs = []utils.ParseError{internalError(opts, ctx.pos(), fmt.Sprintf("%s crashed: %v", stage, r))}
+		}
+	}()
+	lowerProgram(ctx, prog, opts)
+	errs = append(errs, ctx.bugs...)
+	for _, e := range Verify(ctx.GetModule()) {
+		errs = append(errs, internalError(opts, ctx.origin(e), e.Message))
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+
+	// The passes work on the module as a whole, not on a statement.
+	stage = "optimization"
+	ctx.stmts = nil
+	Optimize(ctx.GetModule(), opts.Pipeline)
+	ctx.GetModule().TargetTriple = opts.Codegen.Triple
+	addFuncAttrs(ctx.GetModule(), opts.Codegen.FuncAttrs())
+	if opts.DebugInfo {
+		emitDebugInfo(ctx)
+	}
+	for _, e := range Verify(ctx.GetModule()) {
+		errs = append(errs, internalError(opts, ctx.origin(e), "after optimization, "+e.Message))
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+	return ctx.GetModule().String(), nil
+}
+
+// internalError reports a compiler bug found while compiling the code at
+// pos of opts.SourceFile.
+func internalError(opts Options, pos parser.Pos, msg string) utils.ParseError {
+	err := utils.ParseError{
+		Kind:    utils.InternalError,
+		Message: msg,
+		File:    opts.SourceFile,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Fix:     "This is a bug in the compiler, not in your code. Please report it with the code above; rewriting that statement may work around it.",
+	}
+	if content, readErr := os.ReadFile(opts.SourceFile); readErr == nil && pos.Line > 0 {
+		if lines := strings.Split(string(content), "\n"); pos.Line <= len(lines) {
+			err.Snippet = strings.TrimRight(lines[pos.Line-1], "\r")
+		}
+	}
+	return err
+}
+
+func lowerProgram(ctx *CompilerContext, prog *parser.Program, opts Options) {
+	moduleName := opts.ModuleName
+	ctx.options = opts
+	ctx.types = analysis.InferTypes(prog, opts.ModuleSymbols)
+	ctx.consts = analysis.EvalConstants(prog, opts.ModuleSymbols)
+	ctx.ownership = analysis.FindOwnership(prog, opts.ModuleSymbols)
 
 	ast := parser.ProgramToAST(prog)
 	analysisResult := analysis.AnalyzeAST(ast)
@@ -27,11 +142,12 @@ func CompileWithOptionsAndModules(prog *parser.Program, moduleName string, modul
 	for _, include := range analysisResult.CIncludes {
 		ctx.AddLibrary(include.Header)
 	}
-	if moduleSymbols != nil {
-		for moduleName, symbols := range moduleSymbols {
+	if opts.ModuleSymbols != nil {
+		for moduleName, symbols := range opts.ModuleSymbols {
 			moduleInfo := &ModuleInfo{
 				Name:    moduleName,
 				Symbols: make(map[string]value.Value),
+				Exports: symbols,
 			}
 			for symbolName, symbolValue := range symbols {
 				if str, ok := symbolValue.(string); ok {
@@ -41,27 +157,56 @@ func CompileWithOptionsAndModules(prog *parser.Program, moduleName string, modul
 			ctx.SetModule(moduleName, moduleInfo)
 		}
 	}
-	if moduleName == "main" {
+
+	// Declare every function and struct up front so uses may precede
+	// definitions.
+	var topLevel []parser.Statement
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*parser.Function); ok && fn.Name != nil && fn.Name.Value != "" {
+			if opts.Live == nil || opts.Live[fn.Name.Value] {
+				declareFunction(fn, ctx)
+			}
+		} else if f, ok := stmt.(*parser.ForeignFunction); ok {
+			compileForeign(f, ctx)
+		} else if def, ok := stmt.(*parser.StructDef); ok {
+			ctx.structDefs[def.Name.Value] = def
+		} else {
+			topLevel = append(topLevel, stmt)
+		}
+	}
+	_, hasMain := ctx.GetSymbol("main")
+	if (opts.Entry || moduleName == "main") && !hasMain {
 		mainFn := createMainFunction(ctx)
 		entry := addEntryBlock(ctx, mainFn)
 		setInsertPoint(ctx, entry)
-
-		for _, stmt := range prog.Statements {
+		callModuleInits(ctx)
+		// main is the initializer of the entry module. Its top-level
+		// bindings that functions see are globals.
+		ctx.moduleInit = mainFn
+		ctx.funcNames = analysis.Resolve(prog, "", "", opts.ModuleSymbols).UsedInFunctions()
+		ctx.currentBody = &parser.Block{Statements: topLevel}
+		for _, stmt := range topLevel {
 			compileStmt(stmt, ctx)
 		}
-
 		createMainReturn(ctx)
 	} else {
-		dummyFn := ctx.module.NewFunc("__module_"+moduleName, types.I32)
-		entry := dummyFn.NewBlock("entry")
-		ctx.builder = entry
-
-		for _, stmt := range prog.Statements {
-			compileStmt(stmt, ctx)
+		if hasMain {
+			// The body of a module defining main runs after the other
+			// initializers, as main starts.
+			ctx.options.InitOrder = append(opts.InitOrder[:len(opts.InitOrder):len(opts.InitOrder)], moduleName)
+		}
+		// A build passes the exports of every module, with the types
+		// their importers fixed.
+		exports, ok := opts.ModuleSymbols[moduleName]
+		if !ok {
+			exports = analysis.ModuleExports(prog, opts.ModuleSymbols)
+		}
+		exportFunctions(ctx, exports)
+		topLevel = exportGlobals(ctx, exports, topLevel)
+		compileModuleInit(ctx, exports, topLevel)
+		if opts.Debug {
+			compileModuleFini(ctx)
 		}
-
-		entry.NewRet(constant.NewInt(types.I32, 0))
 	}
-
-	return ctx.GetModule().String()
+	compilePendingFunctions(ctx)
 }
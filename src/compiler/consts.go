@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"aether/src/analysis"
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+)
+
+// constValue is the constant for c, of the type compileExpr gives the same
+// value, or nil for an array, which lives on the heap.
+func constValue(ctx *CompilerContext, c analysis.Const) constant.Constant {
+	switch c.Kind {
+	case analysis.ConstInt:
+		if c.Wide {
+			return constant.NewInt(types.I64, c.Int)
+		}
+		return constant.NewInt(types.I32, c.Int)
+	case analysis.ConstFloat:
+		return constant.NewFloat(types.Double, c.Float)
+	case analysis.ConstBool:
+		return constant.NewBool(c.Bool)
+	case analysis.ConstString:
+		return stringLiteral(ctx, c.Str)
+	}
+	return nil
+}
+
+// foldedConstant is the value of expr when constant evaluation folds it:
+// an operator, len or .length, an element of a constant array or a use of
+// an immutable binding. It is nil for anything else, literals included,
+// which compile to constants anyway.
+func foldedConstant(ctx *CompilerContext, expr parser.Expression) constant.Constant {
+	switch expr.(type) {
+	case *parser.Call, *parser.PropertyAccess, *parser.ArrayIndex, *parser.Identifier:
+	default:
+		return nil
+	}
+	c, ok := ctx.consts.ValueOf(expr)
+	if !ok {
+		return nil
+	}
+	return constValue(ctx, c)
+}
+
+// defineConstGlobal compiles the top-level assignment of an immutable
+// binding whose value is known into a constant global holding that value,
+// with nothing left for the module initializer to do. It returns false for
+// any other assignment.
+func defineConstGlobal(ctx *CompilerContext, s *parser.Assignment) bool {
+	if len(s.Names) != 1 || !atModuleLevel(ctx, s.Names[0].Value) {
+		return false
+	}
+	name := s.Names[0].Value
+	if _, defined := ctx.GetSymbol(name); defined {
+		return false
+	}
+	c, ok := ctx.consts.Binding(s.Names[0])
+	if !ok {
+		return false
+	}
+	init := constValue(ctx, c)
+	if init == nil {
+		return false
+	}
+	g := defineModuleVar(ctx, name, init.Type())
+	g.Init = init
+	g.Immutable = true
+	ctx.SetSymbol(name, g)
+	return true
+}
+
+// constArrayData returns a private global holding the elements of e when
+// they are constants of one type, for a new array to copy, or nil.
+func constArrayData(ctx *CompilerContext, e *parser.Array) *ir.Global {
+	c, ok := ctx.consts.ValueOf(e)
+	if !ok || len(c.Elems) == 0 {
+		return nil
+	}
+	elems := make([]constant.Constant, len(c.Elems))
+	for i, el := range c.Elems {
+		elems[i] = constValue(ctx, el)
+		if elems[i] == nil || !elems[i].Type().Equal(elems[0].Type()) {
+			return nil
+		}
+	}
+	data := constant.NewArray(types.NewArray(uint64(len(elems)), elems[0].Type()), elems...)
+	g := ctx.module.NewGlobalDef(ctx.uniqueGlobal(".array"), data)
+	g.Linkage = enum.LinkagePrivate
+	g.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
+	g.Immutable = true
+	return g
+}
@@ -1,7 +1,15 @@
 package compiler
 
 import (
+	"fmt"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+	"aether/src/parser"
+
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 )
 
@@ -13,11 +21,99 @@ type CompilerContext struct {
 	current_func *ir.Func
 	modules      map[string]*ModuleInfo
 	libraries    []string
+	options      Options
+	loops        []loopTarget
+	localNames   map[string]int
+	arrayTypes   map[string]*types.StructType
+	arrayElems   map[*types.StructType]types.Type
+	cstrings     map[string]*ir.Global
+	globalNames  map[string]int
+	pending      map[*ir.Func]*pendingFunc
+	pendingOrder []*ir.Func
+	strType      *types.StructType
+	structDefs   map[string]*parser.StructDef
+	structs      map[*types.StructType]*structInfo
+	closureTypes map[string]*types.StructType
+	closureSigs  map[*types.StructType]*types.FuncType
+	// funcClosures maps the named functions used as values to the
+	// functions their closures call, see functionClosure.
+	funcClosures map[*ir.Func]*ir.Func
+	currentBody  *parser.Block
+	// exports and moduleInit are set while compiling a module; its
+	// top-level bindings become globals. main is the initializer of the
+	// entry module, whose bindings stay locals of main unless one of the
+	// functions in funcNames names them.
+	exports    map[string]interface{}
+	moduleInit *ir.Func
+	funcNames  map[string]bool
+	// unsigned holds the integer values, and the variables and struct
+	// fields holding them, whose type is unsigned, as well as the C
+	// functions returning one. Division, comparison and widening consult
+	// it since LLVM integers carry no sign.
+	unsigned map[value.Value]bool
+	// types holds the inferred types of the program. They are used where
+	// nothing else says what a type should be, such as for the elements
+	// of an empty array literal.
+	types *analysis.TypeTable
+	// consts evaluates the expressions whose value is known at compile
+	// time, which become constant globals and array data.
+	consts *analysis.Constants
+	// stmts holds the positions of the statements being compiled,
+	// innermost last. origins maps the instructions, terminators and
+	// blocks emitted so far to the statement that emitted them, so that
+	// internal compiler errors can point at source; mapped tracks how far
+	// each function has been mapped. bugs holds the internal compiler
+	// errors found during code generation, see unchecked.
+	stmts   []parser.Pos
+	origins map[interface{}]parser.Pos
+	mapped  map[*ir.Func]*funcOrigins
+	bugs    []utils.ParseError
+	// vars maps the stack slots of source variables to the variable, for
+	// debug info.
+	vars map[*ir.InstAlloca]debugVar
+	// ownership tells which bindings only borrow their value. temps holds
+	// the counted values that the statements being compiled made and
+	// nothing took over yet, locals the slots of the current function that
+	// own their value, and borrowed the variables that do not. moduleVars
+	// are the module-level variables owning theirs. shared holds the cells
+	// of the current function and cells maps the slot of each variable
+	// in a cell to the cell.
+	ownership  *analysis.Ownership
+	temps      []value.Value
+	locals     []*ir.InstAlloca
+	borrowed   map[value.Value]bool
+	moduleVars []*ir.Global
+	rcType     *types.StructType
+	literals   map[string]constant.Constant
+	shared     []value.Value
+	cells      map[value.Value]value.Value
+	cellTypes  map[string]*types.StructType
+}
+
+// funcOrigins is how far the code of a function is mapped to source: the
+// number of its blocks seen, those of them that may still get code since
+// they have no terminator yet, and how many instructions of each are
+// mapped.
+type funcOrigins struct {
+	blocks  int
+	open    []*ir.Block
+	scanned map[*ir.Block]int
+}
+
+// loopTarget is where break and continue jump to inside the innermost loop,
+// and how many values the statements around the loop own.
+type loopTarget struct {
+	breakBlock    *ir.Block
+	continueBlock *ir.Block
+	temps         int
 }
 
 type ModuleInfo struct {
 	Name    string
 	Symbols map[string]value.Value
+	// Exports describes what the module exports, as returned by
+	// analysis.ModuleExports. Symbols are declared from it on first use.
+	Exports map[string]interface{}
 }
 
 func NewCompilerContext(module_name string) *CompilerContext {
@@ -31,7 +127,36 @@ func NewCompilerContext(module_name string) *CompilerContext {
 		current_func: nil,
 		modules:      make(map[string]*ModuleInfo),
 		libraries:    []string{},
+		localNames:   make(map[string]int),
+		arrayTypes:   make(map[string]*types.StructType),
+		arrayElems:   make(map[*types.StructType]types.Type),
+		cstrings:     make(map[string]*ir.Global),
+		globalNames:  make(map[string]int),
+		pending:      make(map[*ir.Func]*pendingFunc),
+		structDefs:   make(map[string]*parser.StructDef),
+		structs:      make(map[*types.StructType]*structInfo),
+		closureTypes: make(map[string]*types.StructType),
+		closureSigs:  make(map[*types.StructType]*types.FuncType),
+		funcClosures: make(map[*ir.Func]*ir.Func),
+		unsigned:     make(map[value.Value]bool),
+		origins:      make(map[interface{}]parser.Pos),
+		mapped:       make(map[*ir.Func]*funcOrigins),
+		vars:         make(map[*ir.InstAlloca]debugVar),
+		borrowed:     make(map[value.Value]bool),
+		literals:     make(map[string]constant.Constant),
+		cells:        make(map[value.Value]value.Value),
+		cellTypes:    make(map[string]*types.StructType),
+	}
+}
+
+// loadVar loads the value of a variable, which is unsigned when the
+// variable is.
+func (c *CompilerContext) loadVar(elem types.Type, ptr value.Value) value.Value {
+	v := c.builder.NewLoad(elem, ptr)
+	if c.unsigned[ptr] {
+		c.unsigned[v] = true
 	}
+	return v
 }
 
 func (c *CompilerContext) EnterScope() {
@@ -63,16 +188,166 @@ func (c *CompilerContext) SetModule(moduleName string, moduleInfo *ModuleInfo) {
 }
 
 func (c *CompilerContext) GetModuleSymbol(moduleName, symbolName string) (value.Value, bool) {
-	if module, exists := c.modules[moduleName]; exists {
-		if symbol, exists := module.Symbols[symbolName]; exists {
-			return symbol, true
-		}
+	module, exists := c.modules[moduleName]
+	if !exists {
+		return nil, false
 	}
-	return nil, false
+	if symbol, exists := module.Symbols[symbolName]; exists {
+		return symbol, true
+	}
+	symbol := declareImport(c, module, symbolName)
+	if symbol == nil {
+		return nil, false
+	}
+	module.Symbols[symbolName] = symbol
+	return symbol, true
 }
 
 func (c *CompilerContext) SetCurrentFunction(func_val *ir.Func) {
 	c.current_func = func_val
+	c.localNames = make(map[string]int)
+	// Locals must not take the names of the parameters, such as the env
+	// of a lambda.
+	for _, p := range func_val.Params {
+		c.localNames[p.Name()] = 1
+	}
+}
+
+// NewBlock appends a block to the current function. Names are made unique
+// per function since llir does not rename duplicate labels.
+func (c *CompilerContext) NewBlock(name string) *ir.Block {
+	block := c.current_func.NewBlock(c.uniqueLocal(name))
+	c.origins[block] = c.pos()
+	return block
+}
+
+// NewLocal allocates a stack slot in the entry block of the current function,
+// so that loops do not grow the stack on every iteration.
+func (c *CompilerContext) NewLocal(name string, typ types.Type) *ir.InstAlloca {
+	alloca := ir.NewAlloca(typ)
+	alloca.SetName(c.uniqueLocal(name))
+	entry := c.current_func.Blocks[0]
+	entry.Insts = append([]ir.Instruction{alloca}, entry.Insts...)
+	// The block is mapped from the end, so slots prepended to it are
+	// mapped here.
+	c.origins[alloca] = c.pos()
+	return alloca
+}
+
+// recordVar records that slot holds the source variable name, declared by
+// the statement being compiled; arg is the number of the parameter it holds,
+// from 1, or 0 for a local.
+func (c *CompilerContext) recordVar(slot *ir.InstAlloca, name string, arg int) {
+	c.vars[slot] = debugVar{name: name, arg: arg, pos: c.pos()}
+}
+
+// pos is the position of the innermost statement being compiled.
+func (c *CompilerContext) pos() parser.Pos {
+	if len(c.stmts) == 0 {
+		return parser.Pos{}
+	}
+	return c.stmts[len(c.stmts)-1]
+}
+
+// unchecked reports code that the checkers should have rejected before it
+// reached code generation, such as a call with too many arguments, as an
+// internal compiler error at the statement being compiled. The caller
+// leaves the code out.
+func (c *CompilerContext) unchecked(format string, args ...interface{}) {
+	c.bugs = append(c.bugs, internalError(c.options, c.pos(), fmt.Sprintf(format, args...)))
+}
+
+// uncompiled reports expr, which did not compile, as the operand of what.
+// The checker reports undefined names, so that is a bug, unless one was
+// reported for expr since there were bugs of them.
+func (c *CompilerContext) uncompiled(what string, expr parser.Expression, bugs int) {
+	if len(c.bugs) > bugs {
+		return
+	}
+	if ident, ok := expr.(*parser.Identifier); ok {
+		c.unchecked("%s %s, which is not defined", what, ident.Value)
+		return
+	}
+	c.unchecked("%s an expression that does not compile", what)
+}
+
+// mapOrigins maps the code of fn that is not mapped yet to pos(). Blocks
+// are mapped as they are created, by NewBlock, except those made directly.
+func (c *CompilerContext) mapOrigins(fn *ir.Func) {
+	if fn == nil {
+		return
+	}
+	f := c.mapped[fn]
+	if f == nil {
+		f = &funcOrigins{scanned: make(map[*ir.Block]int)}
+		c.mapped[fn] = f
+	}
+	f.open = append(f.open, fn.Blocks[f.blocks:]...)
+	f.blocks = len(fn.Blocks)
+	pos := c.pos()
+	open := f.open[:0]
+	for _, b := range f.open {
+		if _, ok := c.origins[b]; !ok {
+			c.origins[b] = pos
+		}
+		for _, inst := range b.Insts[f.scanned[b]:] {
+			if _, ok := c.origins[inst]; !ok {
+				c.origins[inst] = pos
+			}
+		}
+		f.scanned[b] = len(b.Insts)
+		if b.Term == nil {
+			open = append(open, b)
+		} else if _, ok := c.origins[b.Term]; !ok {
+			c.origins[b.Term] = pos
+		}
+	}
+	f.open = open
+}
+
+// origin is the position of the code a verifier error is about: that of
+// its instruction, else of its block, else of the start of its function.
+func (c *CompilerContext) origin(e IRError) parser.Pos {
+	if pos, ok := c.origins[e.At]; ok && e.At != nil {
+		return pos
+	}
+	if e.Block != nil {
+		return c.origins[e.Block]
+	}
+	if len(e.Func.Blocks) > 0 {
+		return c.origins[e.Func.Blocks[0]]
+	}
+	return parser.Pos{}
+}
+
+func (c *CompilerContext) uniqueLocal(name string) string {
+	n := c.localNames[name]
+	c.localNames[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%d", name, n)
+}
+
+func (c *CompilerContext) uniqueGlobal(name string) string {
+	n := c.globalNames[name]
+	c.globalNames[name] = n + 1
+	return fmt.Sprintf("%s.%d", name, n)
+}
+
+func (c *CompilerContext) PushLoop(breakBlock, continueBlock *ir.Block) {
+	c.loops = append(c.loops, loopTarget{breakBlock: breakBlock, continueBlock: continueBlock, temps: len(c.temps)})
+}
+
+func (c *CompilerContext) PopLoop() {
+	c.loops = c.loops[:len(c.loops)-1]
+}
+
+func (c *CompilerContext) CurrentLoop() (loopTarget, bool) {
+	if len(c.loops) == 0 {
+		return loopTarget{}, false
+	}
+	return c.loops[len(c.loops)-1], true
 }
 
 func (c *CompilerContext) GetCurrentFunction() *ir.Func {
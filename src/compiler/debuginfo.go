@@ -0,0 +1,395 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	"github.com/llir/llvm/ir/types"
+)
+
+// Debug info is emitted once the module is optimized, from what the
+// compiler already knows about each instruction: the statement it came
+// from, as recorded for internal compiler errors, and the variables whose
+// stack slots are left. Code made by optimization, such as inlined bodies,
+// has no statement and takes the location of the code before it.
+
+// debugVar is a source variable kept in a stack slot.
+type debugVar struct {
+	name string
+	// arg is the number of the parameter the variable holds, from 1, or 0
+	// for a local.
+	arg int
+	pos parser.Pos
+}
+
+// debugLoc is a line and column within a function.
+type debugLoc struct {
+	line, column int64
+	scope        *metadata.DISubprogram
+}
+
+type debugInfo struct {
+	ctx     *CompilerContext
+	file    *metadata.DIFile
+	unit    *metadata.DICompileUnit
+	types   map[string]metadata.Field
+	locs    map[debugLoc]*metadata.DILocation
+	declare *ir.Func
+	ptrBits uint64
+}
+
+// emitDebugInfo describes the module to debuggers: a compile unit for the
+// source file, a subprogram for each function compiled from it, a location
+// for each of their instructions and the variables held in stack slots.
+// Runtime helpers have no source and are left undescribed.
+func emitDebugInfo(ctx *CompilerContext) {
+	m := ctx.module
+	d := &debugInfo{
+		ctx:     ctx,
+		types:   make(map[string]metadata.Field),
+		locs:    make(map[debugLoc]*metadata.DILocation),
+		ptrBits: 64,
+	}
+	switch arch := strings.SplitN(ctx.options.Codegen.Triple, "-", 2)[0]; arch {
+	case "i386", "i686", "armv7":
+		d.ptrBits = 32
+	}
+
+	name, dir := ctx.options.ModuleName+".ae", "."
+	if src := ctx.options.SourceFile; src != "" {
+		if abs, err := filepath.Abs(src); err == nil {
+			src = abs
+		}
+		name, dir = filepath.Base(src), filepath.Dir(src)
+	}
+	d.file = d.def(&metadata.DIFile{MetadataID: -1, Filename: name, Directory: dir}).(*metadata.DIFile)
+	d.unit = d.def(&metadata.DICompileUnit{
+		MetadataID:   -1,
+		Distinct:     true,
+		Language:     enum.DwarfLangC99,
+		File:         d.file,
+		Producer:     "aether",
+		IsOptimized:  len(ctx.options.Pipeline.Passes) > 0,
+		EmissionKind: enum.EmissionKindFullDebug,
+	}).(*metadata.DICompileUnit)
+	m.NamedMetadataDefs["llvm.dbg.cu"] = &metadata.NamedDef{Name: "llvm.dbg.cu", Nodes: []metadata.Node{d.unit}}
+
+	// Windows debuggers read CodeView rather than DWARF.
+	format := d.moduleFlag(7, "Dwarf Version", 4)
+	if strings.Contains(ctx.options.Codegen.Triple, "windows") {
+		format = d.moduleFlag(2, "CodeView", 1)
+	}
+	flags := m.NamedMetadataDefs["llvm.module.flags"]
+	if flags == nil {
+		flags = &metadata.NamedDef{Name: "llvm.module.flags"}
+		m.NamedMetadataDefs[flags.Name] = flags
+	}
+	flags.Nodes = append(flags.Nodes, format, d.moduleFlag(2, "Debug Info Version", 3))
+
+	for _, fn := range m.Funcs {
+		d.describeFunc(fn)
+	}
+}
+
+// def adds md to the module and returns it.
+func (d *debugInfo) def(md metadata.Definition) metadata.Definition {
+	d.ctx.module.MetadataDefs = append(d.ctx.module.MetadataDefs, md)
+	return md
+}
+
+func (d *debugInfo) moduleFlag(behavior int64, name string, value int64) *metadata.Tuple {
+	return d.def(&metadata.Tuple{MetadataID: -1, Fields: []metadata.Field{
+		constant.NewInt(types.I32, behavior),
+		&metadata.String{Value: name},
+		constant.NewInt(types.I32, value),
+	}}).(*metadata.Tuple)
+}
+
+// describeFunc gives fn a subprogram and its instructions locations, and
+// declares its variables.
+func (d *debugInfo) describeFunc(fn *ir.Func) {
+	if len(fn.Blocks) == 0 || fn.Linkage == enum.LinkageLinkOnceODR {
+		return
+	}
+	start, ok := d.funcPos(fn)
+	if !ok {
+		return
+	}
+	flags := enum.DISPFlagDefinition
+	if fn.Linkage == enum.LinkageInternal {
+		flags |= enum.DISPFlagLocalToUnit
+	}
+	if d.unit.IsOptimized {
+		flags |= enum.DISPFlagOptimized
+	}
+	sp := d.def(&metadata.DISubprogram{
+		MetadataID: -1,
+		Distinct:   true,
+		Scope:      d.file,
+		Name:       fn.Name(),
+		File:       d.file,
+		Line:       int64(start.Line),
+		Type:       d.funcType(fn),
+		ScopeLine:  int64(start.Line),
+		SPFlags:    flags,
+		Unit:       d.unit,
+	}).(*metadata.DISubprogram)
+	fn.Metadata = append(fn.Metadata, &metadata.Attachment{Name: "dbg", Node: sp})
+
+	pos := start
+	for _, b := range fn.Blocks {
+		insts := make([]ir.Instruction, 0, len(b.Insts))
+		for _, inst := range b.Insts {
+			if p, ok := d.ctx.origins[inst]; ok && p.Line > 0 {
+				pos = p
+			}
+			attachLoc(inst, d.loc(sp, pos))
+			insts = append(insts, inst)
+			if slot, ok := inst.(*ir.InstAlloca); ok {
+				if v, ok := d.ctx.vars[slot]; ok {
+					insts = append(insts, d.declareVar(sp, slot, v))
+				}
+			}
+		}
+		b.Insts = insts
+		if p, ok := d.ctx.origins[b.Term]; ok && p.Line > 0 {
+			pos = p
+		}
+		attachLoc(b.Term, d.loc(sp, pos))
+	}
+}
+
+// funcPos is where fn starts in the source: where its entry block was
+// made, or else its first instruction that has a position.
+func (d *debugInfo) funcPos(fn *ir.Func) (parser.Pos, bool) {
+	if p, ok := d.ctx.origins[fn.Blocks[0]]; ok && p.Line > 0 {
+		return p, true
+	}
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Insts {
+			if p, ok := d.ctx.origins[inst]; ok && p.Line > 0 {
+				return p, true
+			}
+		}
+	}
+	return parser.Pos{}, false
+}
+
+func (d *debugInfo) loc(sp *metadata.DISubprogram, pos parser.Pos) *metadata.DILocation {
+	key := debugLoc{line: int64(pos.Line), column: int64(pos.Column), scope: sp}
+	loc, ok := d.locs[key]
+	if !ok {
+		loc = d.def(&metadata.DILocation{MetadataID: -1, Line: key.line, Column: key.column, Scope: sp}).(*metadata.DILocation)
+		d.locs[key] = loc
+	}
+	return loc
+}
+
+// declareVar returns the llvm.dbg.declare call telling the debugger that v
+// lives in slot.
+func (d *debugInfo) declareVar(sp *metadata.DISubprogram, slot *ir.InstAlloca, v debugVar) *ir.InstCall {
+	if d.declare == nil {
+		d.declare = d.ctx.module.NewFunc("llvm.dbg.declare", types.Void,
+			ir.NewParam("", types.Metadata), ir.NewParam("", types.Metadata), ir.NewParam("", types.Metadata))
+		d.declare.FuncAttrs = append(d.declare.FuncAttrs, enum.FuncAttrNoUnwind, enum.FuncAttrReadNone, enum.FuncAttrSpeculatable)
+	}
+	variable := d.def(&metadata.DILocalVariable{
+		MetadataID: -1,
+		Scope:      sp,
+		Name:       v.name,
+		Arg:        uint64(v.arg),
+		File:       d.file,
+		Line:       int64(v.pos.Line),
+		Type:       d.typ(slot.ElemType, d.ctx.unsigned[slot]),
+	})
+	call := ir.NewCall(d.declare,
+		&metadata.Value{Value: slot},
+		&metadata.Value{Value: variable},
+		&metadata.Value{Value: &metadata.DIExpression{MetadataID: -1}})
+	attachLoc(call, d.loc(sp, v.pos))
+	return call
+}
+
+// attachLoc attaches the debug location loc to inst, an instruction or
+// terminator.
+func attachLoc(inst interface{}, loc *metadata.DILocation) {
+	field := reflect.ValueOf(inst).Elem().FieldByName("Metadata")
+	if field.IsValid() {
+		field.Set(reflect.Append(field, reflect.ValueOf(&metadata.Attachment{Name: "dbg", Node: loc})))
+	}
+}
+
+func (d *debugInfo) funcType(fn *ir.Func) *metadata.DISubroutineType {
+	fields := []metadata.Field{d.typ(fn.Sig.RetType, d.ctx.unsigned[fn])}
+	for _, p := range fn.Params {
+		fields = append(fields, d.typ(p.Typ, false))
+	}
+	return d.def(&metadata.DISubroutineType{
+		MetadataID: -1,
+		Types:      d.def(&metadata.Tuple{MetadataID: -1, Fields: fields}).(*metadata.Tuple),
+	}).(*metadata.DISubroutineType)
+}
+
+// typ describes t by its Aether name. Arrays, strings, structs and tuples
+// are structures with named fields; void and the types Aether has no name
+// for are null.
+func (d *debugInfo) typ(t types.Type, unsigned bool) metadata.Field {
+	key := t.String()
+	if unsigned {
+		key += " unsigned"
+	}
+	if md, ok := d.types[key]; ok {
+		return md
+	}
+	size, align := d.layout(t)
+	var md metadata.Field = &metadata.NullLit{}
+	switch t := t.(type) {
+	case *types.IntType:
+		basic := &metadata.DIBasicType{MetadataID: -1, Tag: enum.DwarfTagBaseType, Size: size, Encoding: enum.DwarfAttEncodingSigned}
+		switch {
+		case t.BitSize == 1:
+			basic.Name, basic.Encoding = "bool", enum.DwarfAttEncodingBoolean
+		case unsigned:
+			basic.Name, basic.Encoding = fmt.Sprintf("u%d", t.BitSize), enum.DwarfAttEncodingUnsigned
+		case t.BitSize == 32:
+			basic.Name = "int"
+		default:
+			basic.Name = fmt.Sprintf("i%d", t.BitSize)
+		}
+		md = d.def(basic)
+	case *types.FloatType:
+		name := "f32"
+		if t.Kind == types.FloatKindDouble {
+			name = "float"
+		}
+		md = d.def(&metadata.DIBasicType{MetadataID: -1, Tag: enum.DwarfTagBaseType, Name: name, Size: size, Encoding: enum.DwarfAttEncodingFloat})
+	case *types.PointerType:
+		ptr := &metadata.DIDerivedType{MetadataID: -1, Tag: enum.DwarfTagPointerType, Size: size}
+		// A struct may point to itself.
+		d.types[key] = d.def(ptr)
+		ptr.BaseType = d.typ(t.ElemType, unsigned)
+		return ptr
+	case *types.StructType:
+		st := &metadata.DICompositeType{MetadataID: -1, Tag: enum.DwarfTagStructureType, File: d.file, Size: size, Align: align}
+		d.types[key] = d.def(st)
+		name, fields := d.structFields(t)
+		st.Name = name
+		offsets := d.offsets(t)
+		members := &metadata.Tuple{MetadataID: -1}
+		for i, field := range t.Fields {
+			fieldSize, _ := d.layout(field)
+			members.Fields = append(members.Fields, d.def(&metadata.DIDerivedType{
+				MetadataID: -1,
+				Tag:        enum.DwarfTagMember,
+				Name:       fields[i],
+				Scope:      st,
+				File:       d.file,
+				BaseType:   d.typ(field, d.fieldUnsigned(t, i)),
+				Size:       fieldSize,
+				Offset:     offsets[i],
+			}))
+		}
+		st.Elements = d.def(members).(*metadata.Tuple)
+		return st
+	}
+	d.types[key] = md
+	return md
+}
+
+// structFields names a struct type and its fields the way the source does.
+func (d *debugInfo) structFields(t *types.StructType) (string, []string) {
+	if info, ok := d.ctx.structs[t]; ok {
+		return info.name, info.fields
+	}
+	if d.ctx.isString(t) {
+		return "string", []string{"data", "len"}
+	}
+	if elem, ok := d.ctx.arrayElems[t]; ok {
+		return "[" + d.typeName(elem) + "]", []string{"data", "len", "cap"}
+	}
+	if _, ok := d.ctx.closureSigs[t]; ok {
+		return "closure", []string{"fn", "env"}
+	}
+	names := make([]string, len(t.Fields))
+	parts := make([]string, len(t.Fields))
+	for i, field := range t.Fields {
+		names[i] = fmt.Sprintf("_%d", i)
+		parts[i] = d.typeName(field)
+	}
+	return "(" + strings.Join(parts, ", ") + ")", names
+}
+
+func (d *debugInfo) fieldUnsigned(t *types.StructType, i int) bool {
+	info, ok := d.ctx.structs[t]
+	return ok && i < len(info.unsigned) && info.unsigned[i]
+}
+
+func (d *debugInfo) typeName(t types.Type) string {
+	switch md := d.typ(t, false).(type) {
+	case *metadata.DIBasicType:
+		return md.Name
+	case *metadata.DICompositeType:
+		return md.Name
+	case *metadata.DIDerivedType:
+		return "ptr"
+	}
+	return t.String()
+}
+
+// layout returns the size and alignment of t in bits, as laid out by the
+// C ABI of the target.
+func (d *debugInfo) layout(t types.Type) (size, align uint64) {
+	switch t := t.(type) {
+	case *types.IntType:
+		size = 8
+		for size < t.BitSize {
+			size *= 2
+		}
+		return size, size
+	case *types.FloatType:
+		if t.Kind == types.FloatKindDouble {
+			return 64, 64
+		}
+		return 32, 32
+	case *types.PointerType:
+		return d.ptrBits, d.ptrBits
+	case *types.ArrayType:
+		size, align = d.layout(t.ElemType)
+		return size * t.Len, align
+	case *types.StructType:
+		align = 8
+		offsets := d.offsets(t)
+		for i, field := range t.Fields {
+			fieldSize, fieldAlign := d.layout(field)
+			if fieldAlign > align {
+				align = fieldAlign
+			}
+			size = offsets[i] + fieldSize
+		}
+		return (size + align - 1) / align * align, align
+	}
+	return 0, 8
+}
+
+// offsets returns the offsets in bits of the fields of t.
+func (d *debugInfo) offsets(t *types.StructType) []uint64 {
+	offsets := make([]uint64, len(t.Fields))
+	var offset uint64
+	for i, field := range t.Fields {
+		size, align := d.layout(field)
+		if !t.Packed {
+			offset = (offset + align - 1) / align * align
+		}
+		offsets[i] = offset
+		offset += size
+	}
+	return offsets
+}
@@ -1,6 +1,10 @@
 package compiler
 
 import (
+	"math"
+	"strconv"
+	"strings"
+
 	"aether/src/parser"
 
 	"github.com/llir/llvm/ir"
@@ -10,13 +14,33 @@ import (
 )
 
 func compileExpr(expr parser.Expression, ctx *CompilerContext) value.Value {
+	if c := foldedConstant(ctx, expr); c != nil {
+		return c
+	}
 	switch e := expr.(type) {
 	case *parser.Identifier:
 		val, ok := ctx.GetSymbol(e.Value)
-		if ok {
-			return ctx.builder.NewLoad(val.Type(), val)
+		if !ok {
+			switch e.Value {
+			case "true":
+				return constant.True
+			case "false":
+				return constant.False
+			}
+			return nil
 		}
-		return nil
+		switch v := val.(type) {
+		case *ir.InstAlloca:
+			return ctx.loadVar(v.ElemType, v)
+		case *ir.InstGetElementPtr:
+			return ctx.loadVar(v.Type().(*types.PointerType).ElemType, v)
+		case *ir.Global:
+			return ctx.loadVar(v.ContentType, v)
+		case *ir.Func:
+			ensureFunctionCompiled(ctx, v, nil)
+			return functionClosure(ctx, v)
+		}
+		return val
 	case *parser.Literal:
 		switch v := e.Value.(type) {
 		case int:
@@ -24,7 +48,10 @@ func compileExpr(expr parser.Expression, ctx *CompilerContext) value.Value {
 		case float64:
 			return constant.NewFloat(types.Double, v)
 		case string:
-			return constant.NewCharArrayFromString(v)
+			if e.Kind == parser.NumberLiteral {
+				return numberConstant(v)
+			}
+			return stringLiteral(ctx, v)
 		case bool:
 			if v {
 				return constant.NewInt(types.I1, 1)
@@ -33,31 +60,9 @@ func compileExpr(expr parser.Expression, ctx *CompilerContext) value.Value {
 		}
 		return nil
 	case *parser.Array:
-		elems := make([]constant.Constant, len(e.Elements))
-		for i, el := range e.Elements {
-			val := compileExpr(el, ctx)
-			if constVal, ok := val.(constant.Constant); ok {
-				elems[i] = constVal
-			} else {
-				// If not a constant, we need to create a global variable
-				// For now, just use a default value
-				elems[i] = constant.NewInt(types.I32, 0)
-			}
-		}
-		arrType := types.NewArray(uint64(len(elems)), types.I32)
-		return constant.NewArray(arrType, elems...)
+		return compileArrayLiteral(e, ctx)
 	case *parser.Call:
-		if ident, ok := e.Function.(*parser.Identifier); ok {
-			if isStdlibFunction(ident.Value) {
-				return compileStdlibCall(ident.Value, e.Args, ctx)
-			}
-		}
-		fn := compileExpr(e.Function, ctx)
-		args := make([]value.Value, len(e.Args))
-		for i, arg := range e.Args {
-			args[i] = compileExpr(arg, ctx)
-		}
-		return ctx.builder.NewCall(fn, args...)
+		return compileCall(e, ctx)
 	case *parser.PropertyAccess:
 		obj := compileExpr(e.Object, ctx)
 		if obj == nil {
@@ -65,32 +70,50 @@ func compileExpr(expr parser.Expression, ctx *CompilerContext) value.Value {
 			if moduleIdent, ok := e.Object.(*parser.Identifier); ok {
 				// Use proper module resolution
 				if symbol, exists := ctx.GetModuleSymbol(moduleIdent.Value, e.Property.Value); exists {
+					switch s := symbol.(type) {
+					case *ir.Global:
+						return ctx.builder.NewLoad(s.ContentType, s)
+					case *ir.Func:
+						return functionClosure(ctx, s)
+					}
 					return symbol
 				}
+				if _, imported := ctx.modules[moduleIdent.Value]; imported {
+					ctx.unchecked("module %s has no exported member %s", moduleIdent.Value, e.Property.Value)
+					return nil
+				}
 			}
 		}
-		return ctx.builder.NewExtractValue(obj, 0)
-	case *parser.ArrayIndex:
-		array := compileExpr(e.Array, ctx)
-		index := compileExpr(e.Index, ctx)
-		if array == nil || index == nil {
+		if obj == nil {
 			return nil
 		}
-		// For now, return the array itself - proper array indexing will need more complex LLVM code
-		return array
-	case *parser.PartialApplication:
-		fn := compileExpr(e.Function, ctx)
-		args := make([]value.Value, len(e.Args))
-		for i, arg := range e.Args {
-			args[i] = compileExpr(arg, ctx)
+		if _, ok := ctx.arrayElemType(obj.Type()); ok && e.Property.Value == "length" {
+			return compileArrayLen(ctx, obj)
+		}
+		if ctx.isString(obj.Type()) && e.Property.Value == "length" {
+			return ctx.builder.NewTrunc(stringLen(ctx.builder, obj), types.I32)
 		}
-		return ctx.builder.NewCall(fn, args...)
+		if info, ok := ctx.structInfoOf(obj.Type()); ok {
+			if index := info.fieldIndex(e.Property.Value); index >= 0 {
+				return loadField(ctx, obj, index)
+			}
+			return nil
+		}
+		return ctx.builder.NewExtractValue(obj, 0)
+	case *parser.ArrayIndex:
+		return compileArrayIndex(e, ctx)
+	case *parser.Slice:
+		return compileSlice(e, ctx)
+	case *parser.Tuple:
+		return compileTuple(e, ctx)
+	case *parser.Cast:
+		return compileCast(e, ctx)
+	case *parser.PartialApplication:
+		return compilePartialApplication(e, ctx, false)
 	case *parser.StructInstantiation:
-		// TODO: Proper struct codegen. For now, return a dummy value.
-		return constant.NewInt(types.I32, 42)
+		return compileStructInstantiation(e, ctx)
 	case *parser.Block:
-		// TODO: Proper lambda/block codegen. For now, return a dummy value.
-		return constant.NewInt(types.I32, 99)
+		return compileLambda(e, ctx, false)
 	}
 	return nil
 }
@@ -106,20 +129,187 @@ func compileStdlibCall(funcName string, args []parser.Expression, ctx *CompilerC
 		return ctx.builder.NewCall(symbol, compiledArgs...)
 	}
 
-	// Fallback to printf for print function
+	// Fallback to the runtime formatter for print function
 	if funcName == "print" {
-		if len(args) == 0 {
-			return constant.NewInt(types.I32, 0)
+		return compilePrint(args, ctx)
+	}
+
+	return constant.NewInt(types.I32, 0)
+}
+
+// resolveCallee returns the function called by fn. Calls to names that are
+// not defined in this module are declared as external C functions returning
+// int that accept any arguments.
+func resolveCallee(fn parser.Expression, ctx *CompilerContext) value.Value {
+	if f := moduleFunction(ctx, fn); f != nil {
+		return f
+	}
+	ident, ok := fn.(*parser.Identifier)
+	if !ok {
+		return compileExpr(fn, ctx)
+	}
+	if val, ok := ctx.GetSymbol(ident.Value); ok {
+		if f, ok := val.(*ir.Func); ok {
+			return f
 		}
-		arg := compileExpr(args[0], ctx)
-		if str, ok := arg.(*constant.CharArray); ok {
-			printfFunc := getOrCreatePrintfFunction(ctx)
-			return ctx.builder.NewCall(printfFunc, str)
+		return compileExpr(fn, ctx)
+	}
+	if f := lookupFunc(ctx, ident.Value); f != nil {
+		return f
+	}
+	return getOrCreateExtern(ctx, ident.Value, types.I32, true)
+}
+
+// moduleFunction returns the function of an imported module that fn, as
+// in mathx.add, names, which calls call directly rather than as a value.
+func moduleFunction(ctx *CompilerContext, fn parser.Expression) *ir.Func {
+	prop, ok := fn.(*parser.PropertyAccess)
+	if !ok {
+		return nil
+	}
+	module, ok := prop.Object.(*parser.Identifier)
+	if !ok {
+		return nil
+	}
+	if _, isVar := ctx.GetSymbol(module.Value); isVar {
+		return nil
+	}
+	sym, _ := ctx.GetModuleSymbol(module.Value, prop.Property.Value)
+	f, _ := sym.(*ir.Func)
+	return f
+}
+
+// compileBuiltinCall handles the functions every program has without an
+// import. handled is false when name is not a builtin.
+func compileBuiltinCall(name string, args []parser.Expression, ctx *CompilerContext) (v value.Value, handled bool) {
+	switch name {
+	case "print":
+		return compilePrint(args, ctx), true
+	case "len":
+		if len(args) != 1 {
+			return nil, false
+		}
+		arr := compileExpr(args[0], ctx)
+		if arr == nil {
+			return nil, true
+		}
+		if ctx.isString(arr.Type()) {
+			return ctx.builder.NewTrunc(stringLen(ctx.builder, arr), types.I32), true
+		}
+		if _, ok := ctx.arrayElemType(arr.Type()); !ok {
+			ctx.unchecked("len of %s, which is neither a string nor an array", arr.Type())
+			return nil, true
+		}
+		return compileArrayLen(ctx, arr), true
+	case "append":
+		if len(args) != 2 {
+			return nil, false
+		}
+		arr := compileExpr(args[0], ctx)
+		elem := compileExpr(args[1], ctx)
+		if arr == nil || elem == nil {
+			return nil, true
+		}
+		if _, ok := ctx.arrayElemType(arr.Type()); !ok {
+			ctx.unchecked("append to %s, which is not an array", arr.Type())
+			return nil, true
 		}
-		return constant.NewInt(types.I32, 0)
+		return compileArrayPush(ctx, arr, elem), true
+	case "copy":
+		if len(args) != 1 {
+			return nil, false
+		}
+		arr := compileExpr(args[0], ctx)
+		if arr == nil {
+			return nil, true
+		}
+		elem, ok := ctx.arrayElemType(arr.Type())
+		if !ok {
+			ctx.unchecked("copy of %s, which is not an array", arr.Type())
+			return nil, true
+		}
+		n := arrayField(ctx.builder, arr, arrayLenField)
+		return ctx.own(ctx.builder.NewCall(arraySliceFunc(ctx, elem), arr, constant.NewInt(types.I64, 0), n, sourceLocation(ctx, 0))), true
 	}
+	return nil, false
+}
 
-	return constant.NewInt(types.I32, 0)
+// compileMethodCall handles the built-in methods of runtime values such as
+// xs.push(v). handled is false for ordinary property calls.
+func compileMethodCall(prop *parser.PropertyAccess, args []parser.Expression, ctx *CompilerContext) (v value.Value, handled bool) {
+	if ident, ok := prop.Object.(*parser.Identifier); ok {
+		if _, isVar := ctx.GetSymbol(ident.Value); !isVar {
+			return nil, false
+		}
+	}
+	switch prop.Property.Value {
+	case "map":
+		if len(args) != 1 {
+			return nil, false
+		}
+		obj := compileExpr(prop.Object, ctx)
+		if obj == nil {
+			return nil, true
+		}
+		if _, ok := ctx.arrayElemType(obj.Type()); !ok {
+			ctx.unchecked("map on %s, which is not an array", obj.Type())
+			return nil, true
+		}
+		var fn value.Value
+		if block, ok := args[0].(*parser.Block); ok {
+			// The closure is only called during the map, so its
+			// environment can stay on the stack.
+			fn = compileLambda(block, ctx, true)
+		} else {
+			fn = compileExpr(args[0], ctx)
+		}
+		if fn == nil {
+			return nil, true
+		}
+		if _, ok := ctx.closureSig(fn.Type()); !ok {
+			ctx.unchecked("map with %s, which is not a function", fn.Type())
+			return nil, true
+		}
+		return compileArrayMap(ctx, obj, fn), true
+	case "push", "append":
+		if len(args) != 1 {
+			return nil, false
+		}
+		obj := compileExpr(prop.Object, ctx)
+		if obj == nil {
+			return nil, true
+		}
+		if _, ok := ctx.arrayElemType(obj.Type()); !ok {
+			ctx.unchecked("%s on %s, which is not an array", prop.Property.Value, obj.Type())
+			return nil, true
+		}
+		elem := compileExpr(args[0], ctx)
+		if elem == nil {
+			return nil, true
+		}
+		return compileArrayPush(ctx, obj, elem), true
+	}
+	return nil, false
+}
+
+// numberConstant parses a number literal. Integers that do not fit an int
+// become i64 and literals with a fractional part become float.
+func numberConstant(lit string) value.Value {
+	if strings.Contains(lit, ".") {
+		f, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil
+		}
+		return constant.NewFloat(types.Double, f)
+	}
+	n, err := strconv.ParseInt(lit, 10, 64)
+	if err != nil {
+		return nil
+	}
+	if n > math.MaxInt32 || n < math.MinInt32 {
+		return constant.NewInt(types.I64, n)
+	}
+	return constant.NewInt(types.I32, n)
 }
 
 func getOrCreatePrintfFunction(ctx *CompilerContext) *ir.Func {
@@ -0,0 +1,292 @@
+package compiler
+
+import (
+	"math/big"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// fold replaces the instructions of fn whose result is known, from
+// constant operands or identities such as x + 0, by that result, and
+// branches on constants by jumps, until nothing more folds. The
+// instructions folded away have no effects, so they are dropped.
+func fold(fn *ir.Func) {
+	for changed := true; changed; {
+		changed = false
+		repl := make(map[value.Value]value.Value)
+		dead := make(map[ir.Instruction]bool)
+		for _, b := range fn.Blocks {
+			for _, inst := range b.Insts {
+				if v := foldInst(inst); v != nil {
+					// Folding to a value folded away this round could make
+					// a cycle of replacements; it waits for the next.
+					if vi, ok := v.(ir.Instruction); ok && dead[vi] {
+						continue
+					}
+					repl[inst.(value.Value)] = v
+					dead[inst] = true
+				}
+			}
+			if foldBranch(b) {
+				changed = true
+			}
+		}
+		if len(dead) > 0 {
+			changed = true
+			replaceUses(fn, repl)
+			for _, b := range fn.Blocks {
+				removeInsts(b, dead)
+			}
+		}
+	}
+}
+
+// foldInst returns the value inst always has, or nil.
+func foldInst(inst ir.Instruction) value.Value {
+	switch inst := inst.(type) {
+	case *ir.InstICmp:
+		x, ok1 := inst.X.(*constant.Int)
+		y, ok2 := inst.Y.(*constant.Int)
+		if ok1 && ok2 {
+			return constant.NewBool(compareInts(inst.Pred, x, y))
+		}
+	case *ir.InstSelect:
+		if c, ok := inst.Cond.(*constant.Int); ok {
+			if c.X.Sign() != 0 {
+				return inst.ValueTrue
+			}
+			return inst.ValueFalse
+		}
+		if inst.ValueTrue == inst.ValueFalse {
+			return inst.ValueTrue
+		}
+	case *ir.InstZExt:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return intConstant(inst.To.(*types.IntType), unsignedValue(x))
+		}
+	case *ir.InstSExt:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return intConstant(inst.To.(*types.IntType), signedValue(x))
+		}
+	case *ir.InstTrunc:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return intConstant(inst.To.(*types.IntType), x.X)
+		}
+	case *ir.InstPhi:
+		// A phi that takes one value on every path, besides itself, is that
+		// value.
+		var only value.Value
+		for _, inc := range inst.Incs {
+			if inc.X == inst || inc.X == only {
+				continue
+			}
+			if only != nil {
+				return nil
+			}
+			only = inc.X
+		}
+		if only == nil {
+			return constant.NewUndef(inst.Typ)
+		}
+		return only
+	default:
+		if x, y, ok := binaryOperands(inst); ok {
+			return foldBinary(inst, x, y)
+		}
+	}
+	return nil
+}
+
+// foldBinary folds integer arithmetic on constants, and the operations
+// with 0 or 1 whose result does not depend on the other operand.
+func foldBinary(inst ir.Instruction, x, y value.Value) value.Value {
+	t, ok := x.Type().(*types.IntType)
+	if !ok {
+		return nil
+	}
+	cx, xConst := x.(*constant.Int)
+	cy, yConst := y.(*constant.Int)
+	if yConst && !xConst {
+		switch inst.(type) {
+		case *ir.InstAdd, *ir.InstSub, *ir.InstOr, *ir.InstXor, *ir.InstShl, *ir.InstLShr, *ir.InstAShr:
+			if cy.X.Sign() == 0 {
+				return x
+			}
+		case *ir.InstMul, *ir.InstSDiv, *ir.InstUDiv:
+			if cy.X.Cmp(big.NewInt(1)) == 0 {
+				return x
+			}
+		}
+		if _, ok := inst.(*ir.InstMul); ok && cy.X.Sign() == 0 {
+			return cy
+		}
+		if _, ok := inst.(*ir.InstAnd); ok && cy.X.Sign() == 0 {
+			return cy
+		}
+		return nil
+	}
+	if !xConst || !yConst {
+		return nil
+	}
+	r := new(big.Int)
+	switch inst.(type) {
+	case *ir.InstAdd:
+		r.Add(cx.X, cy.X)
+	case *ir.InstSub:
+		r.Sub(cx.X, cy.X)
+	case *ir.InstMul:
+		r.Mul(cx.X, cy.X)
+	case *ir.InstSDiv, *ir.InstSRem:
+		a, b := signedValue(cx), signedValue(cy)
+		// Division by zero, and of the smallest value by -1, is undefined;
+		// it is left for the program to fail at.
+		min := new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), uint(t.BitSize-1)))
+		if b.Sign() == 0 || a.Cmp(min) == 0 && b.Cmp(big.NewInt(-1)) == 0 {
+			return nil
+		}
+		if _, ok := inst.(*ir.InstSDiv); ok {
+			r.Quo(a, b)
+		} else {
+			r.Rem(a, b)
+		}
+	case *ir.InstUDiv, *ir.InstURem:
+		a, b := unsignedValue(cx), unsignedValue(cy)
+		if b.Sign() == 0 {
+			return nil
+		}
+		if _, ok := inst.(*ir.InstUDiv); ok {
+			r.Quo(a, b)
+		} else {
+			r.Rem(a, b)
+		}
+	case *ir.InstAnd:
+		r.And(unsignedValue(cx), unsignedValue(cy))
+	case *ir.InstOr:
+		r.Or(unsignedValue(cx), unsignedValue(cy))
+	case *ir.InstXor:
+		r.Xor(unsignedValue(cx), unsignedValue(cy))
+	case *ir.InstShl, *ir.InstLShr, *ir.InstAShr:
+		n := unsignedValue(cy)
+		if n.Cmp(big.NewInt(int64(t.BitSize))) >= 0 {
+			return nil
+		}
+		switch inst.(type) {
+		case *ir.InstShl:
+			r.Lsh(cx.X, uint(n.Uint64()))
+		case *ir.InstLShr:
+			r.Rsh(unsignedValue(cx), uint(n.Uint64()))
+		default:
+			r.Rsh(signedValue(cx), uint(n.Uint64()))
+		}
+	default:
+		return nil
+	}
+	return intConstant(t, r)
+}
+
+func compareInts(pred enum.IPred, x, y *constant.Int) bool {
+	var c int
+	switch pred {
+	case enum.IPredUGT, enum.IPredUGE, enum.IPredULT, enum.IPredULE:
+		c = unsignedValue(x).Cmp(unsignedValue(y))
+	default:
+		c = signedValue(x).Cmp(signedValue(y))
+	}
+	switch pred {
+	case enum.IPredEQ:
+		return c == 0
+	case enum.IPredNE:
+		return c != 0
+	case enum.IPredSGT, enum.IPredUGT:
+		return c > 0
+	case enum.IPredSGE, enum.IPredUGE:
+		return c >= 0
+	case enum.IPredSLT, enum.IPredULT:
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
+
+// intConstant wraps x to the width of t. Constants are kept signed, as the
+// compiler emits them, except for i1, whose values are 0 and 1.
+func intConstant(t *types.IntType, x *big.Int) *constant.Int {
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(t.BitSize))
+	v := new(big.Int).Mod(x, mod)
+	if t.BitSize > 1 && v.Cmp(new(big.Int).Rsh(mod, 1)) >= 0 {
+		v.Sub(v, mod)
+	}
+	return &constant.Int{Typ: t, X: v}
+}
+
+func unsignedValue(c *constant.Int) *big.Int {
+	return new(big.Int).Mod(c.X, new(big.Int).Lsh(big.NewInt(1), uint(c.Typ.BitSize)))
+}
+
+func signedValue(c *constant.Int) *big.Int {
+	v := unsignedValue(c)
+	if half := new(big.Int).Lsh(big.NewInt(1), uint(c.Typ.BitSize-1)); v.Cmp(half) >= 0 {
+		v.Sub(v, new(big.Int).Lsh(half, 1))
+	}
+	return v
+}
+
+// foldBranch turns a conditional branch or switch on a constant into a
+// jump, and reports whether it did.
+func foldBranch(b *ir.Block) bool {
+	var taken *ir.Block
+	switch term := b.Term.(type) {
+	case *ir.TermCondBr:
+		c, ok := term.Cond.(*constant.Int)
+		if !ok {
+			return false
+		}
+		taken = term.TargetFalse.(*ir.Block)
+		if c.X.Sign() != 0 {
+			taken = term.TargetTrue.(*ir.Block)
+		}
+	case *ir.TermSwitch:
+		x, ok := term.X.(*constant.Int)
+		if !ok {
+			return false
+		}
+		taken = term.TargetDefault.(*ir.Block)
+		for _, c := range term.Cases {
+			if cx, ok := c.X.(*constant.Int); ok && cx.X.Cmp(x.X) == 0 {
+				taken = c.Target.(*ir.Block)
+				break
+			}
+		}
+	default:
+		return false
+	}
+	// Each edge to a block has its own phi entry, so the entries for the
+	// edges dropped go, and one stays for the jump.
+	done := make(map[*ir.Block]bool)
+	for _, s := range termSuccs(b.Term) {
+		if done[s] {
+			continue
+		}
+		done[s] = true
+		for _, phi := range phis(s) {
+			kept := phi.Incs[:0]
+			keep := s == taken
+			for _, inc := range phi.Incs {
+				if inc.Pred == b {
+					if !keep {
+						continue
+					}
+					keep = false
+				}
+				kept = append(kept, inc)
+			}
+			phi.Incs = kept
+		}
+	}
+	b.NewBr(taken)
+	return true
+}
@@ -0,0 +1,142 @@
+package compiler
+
+import (
+	"sort"
+
+	"aether/src/analysis"
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Foreign functions are declared with their C signature and called through
+// the C ABI. A parameter or return type left out is int, as in C.
+
+// cType maps a C type name, or a built-in sized type name, to its LLVM
+// type on the LP64 targets we support. str is passed as a C string.
+func cType(name string) (types.Type, bool) {
+	switch name {
+	case "", "int", "uint":
+		return types.I32, true
+	case "void":
+		return types.Void, true
+	case "char", "uchar", "byte":
+		return types.I8, true
+	case "short", "ushort":
+		return types.I16, true
+	case "long", "ulong", "size_t", "ssize_t":
+		return types.I64, true
+	case "float":
+		return types.Float, true
+	case "double":
+		return types.Double, true
+	case "cstr", "str":
+		return i8Ptr, true
+	}
+	if t, ok := sizedType(name); ok {
+		return t, true
+	}
+	return types.I32, false
+}
+
+// cExtension is the attribute the C ABI wants on an integer narrower than
+// int: callers widen it according to its sign, and _Bool is zero-extended.
+func cExtension(name string, t types.Type) (enum.ParamAttr, bool) {
+	it, ok := t.(*types.IntType)
+	if !ok || it.BitSize >= 32 {
+		return 0, false
+	}
+	if it.BitSize == 1 || isUnsignedType(name) {
+		return enum.ParamAttrZeroExt, true
+	}
+	return enum.ParamAttrSignExt, true
+}
+
+// declareForeign declares a C function, or returns the existing
+// declaration of that name, which the runtime may already have made.
+func declareForeign(ctx *CompilerContext, name string, params []*parser.Identifier, ret string, variadic bool) *ir.Func {
+	if fn := lookupFunc(ctx, name); fn != nil {
+		return fn
+	}
+	irParams := make([]*ir.Param, len(params))
+	for i, p := range params {
+		t, _ := cType(p.Type)
+		irParams[i] = ir.NewParam(p.Value, t)
+		if ext, ok := cExtension(p.Type, t); ok {
+			irParams[i].Attrs = append(irParams[i].Attrs, ext)
+		}
+	}
+	retType, _ := cType(ret)
+	fn := ctx.module.NewFunc(name, retType, irParams...)
+	fn.Sig.Variadic = variadic
+	if ext, ok := cExtension(ret, retType); ok {
+		if ext == enum.ParamAttrZeroExt {
+			fn.ReturnAttrs = append(fn.ReturnAttrs, enum.ReturnAttrZeroExt)
+		} else {
+			fn.ReturnAttrs = append(fn.ReturnAttrs, enum.ReturnAttrSignExt)
+		}
+	}
+	if isUnsignedType(ret) {
+		ctx.unsigned[fn] = true
+	}
+	return fn
+}
+
+// foreignParams rebuilds the parameters of a foreign function exported by
+// another module.
+func foreignParams(info analysis.FunctionInfo) []*parser.Identifier {
+	params := make([]*parser.Identifier, len(info.Parameters))
+	for i, p := range info.Parameters {
+		params[i] = &parser.Identifier{Value: p.Name, Type: p.Type}
+	}
+	return params
+}
+
+// bindForeignImports makes the foreign functions of an imported module
+// callable without qualification, as C headers do.
+func bindForeignImports(ctx *CompilerContext, module *ModuleInfo) {
+	names := make([]string, 0, len(module.Exports))
+	for name, export := range module.Exports {
+		if info, ok := export.(analysis.FunctionInfo); ok && info.Foreign {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, taken := ctx.scopes[0][name]; taken {
+			continue
+		}
+		if fn, ok := ctx.GetModuleSymbol(module.Name, name); ok {
+			ctx.scopes[0][name] = fn
+		}
+	}
+}
+
+func compileForeign(f *parser.ForeignFunction, ctx *CompilerContext) {
+	fn := declareForeign(ctx, f.Name.Value, f.Params, f.ReturnType, f.Variadic)
+	ctx.scopes[0][f.Name.Value] = fn
+}
+
+// cVararg applies the C default argument promotions to a value passed
+// through varargs: strings become char pointers, small integers int and
+// floats double.
+func cVararg(ctx *CompilerContext, v value.Value) value.Value {
+	v = cValue(ctx, v)
+	switch t := v.Type().(type) {
+	case *types.IntType:
+		if t.BitSize == 1 {
+			return ctx.builder.NewZExt(v, types.I32)
+		}
+		if t.BitSize < 32 {
+			return ctx.builder.NewSExt(v, types.I32)
+		}
+	case *types.FloatType:
+		if t.Kind == types.FloatKindFloat {
+			return ctx.builder.NewFPExt(v, types.Double)
+		}
+	}
+	return v
+}
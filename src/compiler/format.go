@@ -0,0 +1,254 @@
+package compiler
+
+import (
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The formatter renders any value into a string builder, which is simply an
+// array of bytes. print and the .. operator are both built on it. Arrays and
+// structs get one formatting function per type so nested values recurse
+// through calls instead of growing the caller.
+
+func newStringBuilder(ctx *CompilerContext) value.Value {
+	return ctx.builder.NewCall(arrayNewFunc(ctx, types.I8), constant.NewInt(types.I64, 0))
+}
+
+// writeBytes appends n bytes at data to sb.
+func writeBytes(ctx *CompilerContext, sb, data, n value.Value) {
+	ctx.builder.NewCall(arrayExtendFunc(ctx, types.I8), sb, data, n)
+}
+
+func writeLiteral(ctx *CompilerContext, sb value.Value, s string) {
+	writeBytes(ctx, sb, stringConstant(ctx, s), constant.NewInt(types.I64, int64(len(s))))
+}
+
+// builderToString copies the contents of sb into a new string and releases
+// the builder.
+func builderToString(ctx *CompilerContext, sb value.Value) value.Value {
+	b := ctx.builder
+	b.NewCall(arrayPushFunc(ctx, types.I8), sb, constant.NewInt(types.I8, 0))
+	size := arrayField(b, sb, arrayLenField)
+	data := rcAlloc(ctx, b, size, constant.NewNull(dropType))
+	b.NewCall(rtMemcpy(ctx), data, b.NewBitCast(arrayField(b, sb, arrayDataField), i8Ptr), size)
+	release(ctx, b, sb)
+	var s value.Value = constant.NewUndef(ctx.stringType())
+	s = b.NewInsertValue(s, data, 0)
+	return b.NewInsertValue(s, b.NewSub(size, constant.NewInt(types.I64, 1)), 1)
+}
+
+// formatValue appends the text form of v to sb. quoted wraps strings in
+// double quotes, which is how they appear inside arrays and structs.
+func formatValue(ctx *CompilerContext, sb, v value.Value, quoted bool) {
+	b := ctx.builder
+	switch t := v.Type().(type) {
+	case *types.IntType:
+		if t.BitSize == 1 {
+			s := b.NewSelect(v, stringLiteral(ctx, "true"), stringLiteral(ctx, "false"))
+			writeBytes(ctx, sb, stringData(b, s), stringLen(b, s))
+			return
+		}
+		if ctx.unsigned[v] {
+			b.NewCall(formatUintFunc(ctx), sb, convertValue(ctx, v, types.I64))
+			return
+		}
+		b.NewCall(formatIntFunc(ctx), sb, convertValue(ctx, v, types.I64))
+		return
+	case *types.FloatType:
+		b.NewCall(formatFloatFunc(ctx), sb, convertValue(ctx, v, types.Double))
+		return
+	}
+	if ctx.isString(v.Type()) {
+		if quoted {
+			writeLiteral(ctx, sb, "\"")
+		}
+		writeBytes(ctx, sb, stringData(b, v), stringLen(b, v))
+		if quoted {
+			writeLiteral(ctx, sb, "\"")
+		}
+		return
+	}
+	if st, ok := v.Type().(*types.StructType); ok && isTuple(st) {
+		writeLiteral(ctx, sb, "(")
+		for i := range st.Fields {
+			if i > 0 {
+				writeLiteral(ctx, sb, ", ")
+			}
+			formatValue(ctx, sb, ctx.builder.NewExtractValue(v, uint64(i)), true)
+		}
+		writeLiteral(ctx, sb, ")")
+		return
+	}
+	if _, ok := ctx.arrayElemType(v.Type()); ok {
+		b.NewCall(formatArrayFunc(ctx, v.Type()), sb, v)
+		return
+	}
+	if _, ok := ctx.structInfoOf(v.Type()); ok {
+		b.NewCall(formatStructFunc(ctx, v.Type()), sb, v)
+		return
+	}
+	writeLiteral(ctx, sb, "<"+v.Type().LLString()+">")
+}
+
+// compilePrint writes its arguments separated by spaces and followed by a
+// newline to stdout.
+func compilePrint(args []parser.Expression, ctx *CompilerContext) value.Value {
+	bugs := len(ctx.bugs)
+	vals := make([]callArg, 0, len(args))
+	for _, expr := range args {
+		arg, ok := compileCallArg(expr, ctx)
+		if !ok {
+			ctx.uncompiled("print of", expr, bugs)
+			return nil
+		}
+		vals = append(vals, arg)
+	}
+	sb := newStringBuilder(ctx)
+	for i, v := range vals {
+		if i > 0 {
+			writeLiteral(ctx, sb, " ")
+		}
+		if v.spread {
+			ctx.builder.NewCall(formatElemsFunc(ctx, v.v.Type(), " ", false), sb, v.v)
+			continue
+		}
+		formatValue(ctx, sb, v.v, false)
+	}
+	writeLiteral(ctx, sb, "\n")
+	b := ctx.builder
+	data := arrayField(b, sb, arrayDataField)
+	n := b.NewTrunc(arrayField(b, sb, arrayLenField), types.I32)
+	b.NewCall(getOrCreatePrintfFunction(ctx), stringConstant(ctx, "%.*s"), n, data)
+	release(ctx, b, sb)
+	return constant.NewInt(types.I32, 0)
+}
+
+// formatNumberFunc defines a helper that prints a number with snprintf.
+func formatNumberFunc(ctx *CompilerContext, name string, typ types.Type, verb string) *ir.Func {
+	sbType := ctx.arrayType(types.I8)
+	sb := ir.NewParam("sb", sbType)
+	v := ir.NewParam("v", typ)
+	fn, fresh := newRuntimeFunc(ctx, name, types.Void, sb, v)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	bufType := types.NewArray(32, types.I8)
+	buf := entry.NewAlloca(bufType)
+	zero := constant.NewInt(types.I64, 0)
+	ptr := entry.NewGetElementPtr(bufType, buf, zero, zero)
+	snprintf := getOrCreateExtern(ctx, "snprintf", types.I32, true, i8Ptr, types.I64, i8Ptr)
+	n := entry.NewCall(snprintf, ptr, constant.NewInt(types.I64, 32), stringConstant(ctx, verb), v)
+	entry.NewCall(arrayExtendFunc(ctx, types.I8), sb, ptr, entry.NewSExt(n, types.I64))
+	entry.NewRet(nil)
+	return fn
+}
+
+func formatIntFunc(ctx *CompilerContext) *ir.Func {
+	return formatNumberFunc(ctx, "aether.fmt.int", types.I64, "%lld")
+}
+
+func formatUintFunc(ctx *CompilerContext) *ir.Func {
+	return formatNumberFunc(ctx, "aether.fmt.uint", types.I64, "%llu")
+}
+
+func formatFloatFunc(ctx *CompilerContext) *ir.Func {
+	return formatNumberFunc(ctx, "aether.fmt.float", types.Double, "%g")
+}
+
+// formatArrayFunc defines aether.fmt.<T>(sb, arr), rendering [a, b, c].
+func formatArrayFunc(ctx *CompilerContext, arrType types.Type) *ir.Func {
+	sb := ir.NewParam("sb", ctx.arrayType(types.I8))
+	arr := ir.NewParam("arr", arrType)
+	fn, fresh := newRuntimeFunc(ctx, "aether.fmt."+typeKey(arrType), types.Void, sb, arr)
+	if !fresh {
+		return fn
+	}
+	withFunction(ctx, fn, func() {
+		writeLiteral(ctx, sb, "[")
+		ctx.builder.NewCall(formatElemsFunc(ctx, arrType, ", ", true), sb, arr)
+		writeLiteral(ctx, sb, "]")
+		ctx.builder.NewRet(nil)
+	})
+	return fn
+}
+
+// formatElemsFunc defines a helper writing the elements of an array
+// separated by sep. print(...xs) uses it with unquoted strings.
+func formatElemsFunc(ctx *CompilerContext, arrType types.Type, sep string, quoted bool) *ir.Func {
+	elem, _ := ctx.arrayElemType(arrType)
+	sb := ir.NewParam("sb", ctx.arrayType(types.I8))
+	arr := ir.NewParam("arr", arrType)
+	name := "aether.fmt.elems." + typeKey(arrType)
+	if !quoted {
+		name = "aether.fmt.spread." + typeKey(arrType)
+	}
+	fn, fresh := newRuntimeFunc(ctx, name, types.Void, sb, arr)
+	if !fresh {
+		return fn
+	}
+	withFunction(ctx, fn, func() {
+		counter := ctx.NewLocal("i", types.I64)
+		ctx.builder.NewStore(constant.NewInt(types.I64, 0), counter)
+		cond := ctx.NewBlock("cond")
+		body := ctx.NewBlock("body")
+		sepBlock := ctx.NewBlock("sep")
+		elemBlock := ctx.NewBlock("elem")
+		end := ctx.NewBlock("end")
+		ctx.builder.NewBr(cond)
+
+		i := cond.NewLoad(types.I64, counter)
+		cond.NewCondBr(cond.NewICmp(enum.IPredSLT, i, arrayField(cond, arr, arrayLenField)), body, end)
+
+		body.NewCondBr(body.NewICmp(enum.IPredEQ, i, constant.NewInt(types.I64, 0)), elemBlock, sepBlock)
+		ctx.builder = sepBlock
+		writeLiteral(ctx, sb, sep)
+		sepBlock.NewBr(elemBlock)
+
+		ctx.builder = elemBlock
+		data := arrayField(elemBlock, arr, arrayDataField)
+		formatValue(ctx, sb, elemBlock.NewLoad(elem, elemBlock.NewGetElementPtr(elem, data, i)), quoted)
+		ctx.builder.NewStore(ctx.builder.NewAdd(i, constant.NewInt(types.I64, 1)), counter)
+		ctx.builder.NewBr(cond)
+
+		end.NewRet(nil)
+	})
+	return fn
+}
+
+// formatStructFunc defines aether.fmt.<T>(sb, s), rendering Name { a: 1 }.
+func formatStructFunc(ctx *CompilerContext, ptrType types.Type) *ir.Func {
+	info, _ := ctx.structInfoOf(ptrType)
+	sb := ir.NewParam("sb", ctx.arrayType(types.I8))
+	obj := ir.NewParam("obj", ptrType)
+	fn, fresh := newRuntimeFunc(ctx, "aether.fmt."+typeKey(ptrType), types.Void, sb, obj)
+	if !fresh {
+		return fn
+	}
+	withFunction(ctx, fn, func() {
+		if info.name != "" {
+			writeLiteral(ctx, sb, info.name+" ")
+		}
+		if len(info.fields) == 0 {
+			writeLiteral(ctx, sb, "{}")
+			ctx.builder.NewRet(nil)
+			return
+		}
+		writeLiteral(ctx, sb, "{ ")
+		for i, field := range info.fields {
+			if i > 0 {
+				writeLiteral(ctx, sb, ", ")
+			}
+			writeLiteral(ctx, sb, field+": ")
+			formatValue(ctx, sb, loadField(ctx, obj, i), true)
+		}
+		writeLiteral(ctx, sb, " }")
+		ctx.builder.NewRet(nil)
+	})
+	return fn
+}
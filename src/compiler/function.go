@@ -1,10 +1,372 @@
 package compiler
 
 import (
+	"fmt"
+	"strings"
+
+	"aether/src/parser"
+
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Function bodies are compiled lazily. A function is declared with int
+// parameters, and the first call site fixes the types of parameters that
+// have no annotation before the body is compiled. A call that passes other
+// types to those parameters gets an instance of its own, a copy of the
+// function compiled for them. The first return statement fixes the return
+// type. Functions that are never called are compiled at the end with their
+// declared types.
+
+type funcState int
+
+const (
+	funcPending funcState = iota
+	funcCompiling
+	funcDone
 )
 
+type pendingFunc struct {
+	decl          *parser.Function
+	state         funcState
+	retTypeFixed  bool
+	annotatedArgs []bool
+	// variadic is set when the last parameter collects the extra
+	// arguments into an array.
+	variadic bool
+	// instances are the copies of the function compiled for other
+	// argument types.
+	instances []*ir.Func
+}
+
+func declareFunction(decl *parser.Function, ctx *CompilerContext) *ir.Func {
+	params := make([]*ir.Param, len(decl.Params))
+	annotated := make([]bool, len(decl.Params))
+	variadic := false
+	inferred := ctx.types.Params(decl)
+	for i, p := range decl.Params {
+		typ, ok := typeFromAnnotation(ctx, p.Type)
+		if !ok {
+			// Calls still specialize an unannotated parameter, but a
+			// function that is never called keeps its inferred type.
+			name := inferred[i]
+			if p.IsVararg {
+				name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+			}
+			if t, known := typeFromAnnotation(ctx, name); known {
+				typ = t
+			}
+		}
+		if p.IsVararg {
+			// ...rest: T receives the extra arguments as an array of T.
+			typ = ctx.arrayType(typ)
+			variadic = i == len(decl.Params)-1
+		}
+		params[i] = ir.NewParam(p.Value, typ)
+		annotated[i] = ok
+	}
+	fn := ctx.module.NewFunc(decl.Name.Value, types.I32, params...)
+	ctx.SetSymbol(decl.Name.Value, fn)
+	ctx.pending[fn] = &pendingFunc{decl: decl, annotatedArgs: annotated, retTypeFixed: decl.Name.Value == "main", variadic: variadic}
+	ctx.pendingOrder = append(ctx.pendingOrder, fn)
+	return fn
+}
+
+// typeFromAnnotation maps a parameter annotation to an LLVM type. Arrays are
+// spelled [T], tuples (A, B), closures func(A, ...[B]): R and structs by
+// their names. Unknown or missing annotations default to int.
+func typeFromAnnotation(ctx *CompilerContext, name string) (types.Type, bool) {
+	switch name {
+	case "string", "str":
+		return ctx.stringType(), true
+	case "int":
+		return types.I32, true
+	case "float":
+		return types.Double, true
+	}
+	if t, ok := sizedType(name); ok {
+		return t, true
+	}
+	n := len(name)
+	switch {
+	case n >= 2 && name[0] == '[' && name[n-1] == ']':
+		elem, ok := typeFromAnnotation(ctx, name[1:n-1])
+		return ctx.arrayType(elem), ok
+	case n >= 2 && name[0] == '(' && name[n-1] == ')':
+		parts := splitTypeList(name[1 : n-1])
+		fields := make([]types.Type, len(parts))
+		for i, part := range parts {
+			t, ok := typeFromAnnotation(ctx, part)
+			if !ok {
+				return types.I32, false
+			}
+			fields[i] = t
+		}
+		return types.NewStruct(fields...), true
+	case strings.HasPrefix(name, "func("):
+		return closureFromAnnotation(ctx, name)
+	}
+	if info, ok := namedStruct(ctx, name); ok {
+		return types.NewPointer(info.typ), true
+	}
+	return types.I32, false
+}
+
+// closureFromAnnotation maps func(A, ...[B]): R to the closure type of
+// functions taking the environment, then A and the array [B].
+func closureFromAnnotation(ctx *CompilerContext, name string) (types.Type, bool) {
+	depth := 0
+	for i := len("func"); i < len(name); i++ {
+		switch name[i] {
+		case '(', '[':
+			depth++
+			continue
+		case ')', ']':
+			depth--
+		}
+		if depth > 0 {
+			continue
+		}
+		ret, ok := strings.CutPrefix(name[i+1:], ": ")
+		if !ok {
+			return types.I32, false
+		}
+		retType, ok := typeFromAnnotation(ctx, ret)
+		if ret == "void" {
+			retType, ok = types.Void, true
+		}
+		if !ok {
+			return types.I32, false
+		}
+		params := []types.Type{i8Ptr}
+		if list := name[len("func("):i]; list != "" {
+			for _, p := range splitTypeList(list) {
+				t, ok := typeFromAnnotation(ctx, strings.TrimPrefix(p, "..."))
+				if !ok {
+					return types.I32, false
+				}
+				params = append(params, t)
+			}
+		}
+		return ctx.closureType(types.NewFunc(retType, params...)), true
+	}
+	return types.I32, false
+}
+
+// splitTypeList splits "A, (B, C), [D]" at the commas that are not nested
+// in brackets.
+func splitTypeList(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// ensureFunctionCompiled compiles fn if its body is still pending, using
+// argTypes for parameters without annotations.
+func ensureFunctionCompiled(ctx *CompilerContext, fn *ir.Func, argTypes []types.Type) {
+	pf, ok := ctx.pending[fn]
+	if !ok || pf.state != funcPending {
+		return
+	}
+	changed := false
+	for i, t := range argTypes {
+		if i < len(fn.Params) && t != nil && !pf.annotatedArgs[i] && !fn.Params[i].Typ.Equal(t) {
+			fn.Params[i].Typ = t
+			changed = true
+		}
+	}
+	if changed {
+		refreshSignature(fn)
+	}
+	compileFunctionBody(ctx, fn, pf)
+}
+
+// instanceFor returns the function to call with argTypes: fn while its body
+// is pending or when its unannotated parameters have those types, and
+// otherwise the instance of fn for them, which is declared on first use.
+// Exported functions keep their exported signature and so get instances
+// too when called inside their module with other types.
+func instanceFor(ctx *CompilerContext, fn *ir.Func, argTypes []types.Type) *ir.Func {
+	pf := ctx.pending[fn]
+	if pf.decl == nil || pf.state == funcPending {
+		return fn
+	}
+	open := make([]bool, len(pf.decl.Params))
+	for i, p := range pf.decl.Params {
+		_, annotated := typeFromAnnotation(ctx, p.Type)
+		open[i] = !annotated
+	}
+	accepts := func(f *ir.Func) bool {
+		for i, t := range argTypes {
+			if t != nil && i < len(open) && open[i] && !f.Params[i].Typ.Equal(t) {
+				return false
+			}
+		}
+		return true
+	}
+	if accepts(fn) {
+		return fn
+	}
+	for _, inst := range pf.instances {
+		if accepts(inst) {
+			return inst
+		}
+	}
+	params := make([]*ir.Param, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = ir.NewParam(p.Name(), p.Typ)
+	}
+	inst := ctx.module.NewFunc(fmt.Sprintf("%s.%d", fn.Name(), len(pf.instances)+1), types.I32, params...)
+	inst.Linkage = enum.LinkageInternal
+	annotated := make([]bool, len(open))
+	for i := range open {
+		annotated[i] = !open[i]
+	}
+	ctx.pending[inst] = &pendingFunc{decl: pf.decl, annotatedArgs: annotated, variadic: pf.variadic}
+	pf.instances = append(pf.instances, inst)
+	return inst
+}
+
+func refreshSignature(fn *ir.Func) {
+	paramTypes := make([]types.Type, len(fn.Params))
+	for i, p := range fn.Params {
+		paramTypes[i] = p.Typ
+	}
+	sig := types.NewFunc(fn.Sig.RetType, paramTypes...)
+	sig.Variadic = fn.Sig.Variadic
+	fn.Sig = sig
+	fn.Typ = nil
+	fn.Type()
+}
+
+func compileFunctionBody(ctx *CompilerContext, fn *ir.Func, pf *pendingFunc) {
+	pf.state = funcCompiling
+	// Bodies are often compiled from a call site; what is left over, such
+	// as spilling the parameters, belongs to the definition. The position
+	// is popped only on success, so that a panic still knows where it was.
+	depth := len(ctx.stmts)
+	if pf.decl.Name != nil && pf.decl.Name.Pos.Line != 0 {
+		ctx.stmts = append(ctx.stmts, pf.decl.Name.Pos)
+	}
+	withFunction(ctx, fn, func() {
+		ctx.currentBody = pf.decl.Body
+		if fn.Name() == "main" {
+			callModuleInits(ctx)
+		}
+		ctx.EnterScope()
+		for i, param := range fn.Params {
+			var decl parser.Node
+			if i < len(pf.decl.Params) {
+				decl = pf.decl.Params[i]
+			}
+			slot := ctx.localSlot(decl, param.Name(), param.Typ, i+1)
+			ctx.store(param, slot)
+			ctx.SetSymbol(param.Name(), slot)
+			if i < len(pf.decl.Params) && isUnsignedType(pf.decl.Params[i].Type) {
+				ctx.unsigned[slot] = true
+			}
+		}
+		if pf.decl.Body != nil {
+			for _, stmt := range pf.decl.Body.Statements {
+				compileStmt(stmt, ctx)
+			}
+		}
+		if ctx.builder.Term == nil {
+			ctx.leaveFunction(nil)
+			ctx.builder.NewRet(zeroValue(fn.Sig.RetType))
+		}
+		ctx.ExitScope()
+	})
+	ctx.stmts = ctx.stmts[:depth]
+	pf.state = funcDone
+}
+
+// withFunction runs body with the builder positioned in a new entry block of
+// fn, restoring the state of the enclosing function afterwards. Only the
+// global scope stays visible, since locals of the enclosing function live in
+// another stack frame.
+func withFunction(ctx *CompilerContext, fn *ir.Func, body func()) {
+	savedBuilder, savedFunc, savedNames, savedLoops := ctx.builder, ctx.current_func, ctx.localNames, ctx.loops
+	savedScopes, savedBody := ctx.scopes, ctx.currentBody
+	savedTemps, savedLocals, savedShared := ctx.temps, ctx.locals, ctx.shared
+	ctx.SetCurrentFunction(fn)
+	ctx.loops = nil
+	ctx.temps, ctx.locals, ctx.shared = nil, nil, nil
+	ctx.scopes = []map[string]value.Value{ctx.scopes[0]}
+	ctx.builder = ctx.NewBlock("entry")
+	body()
+	ctx.mapOrigins(fn)
+	ctx.builder, ctx.current_func, ctx.localNames, ctx.loops = savedBuilder, savedFunc, savedNames, savedLoops
+	ctx.scopes, ctx.currentBody = savedScopes, savedBody
+	ctx.temps, ctx.locals, ctx.shared = savedTemps, savedLocals, savedShared
+}
+
+// compilePendingFunctions compiles the functions no call site reached,
+// starting with main so that its calls can specialise the rest.
+func compilePendingFunctions(ctx *CompilerContext) {
+	if main := lookupFunc(ctx, "main"); main != nil {
+		ensureFunctionCompiled(ctx, main, nil)
+	}
+	for _, fn := range ctx.pendingOrder {
+		ensureFunctionCompiled(ctx, fn, nil)
+	}
+}
+
+// compileReturn emits a return from the current function. The first return
+// of a function without a fixed signature decides its return type, and
+// whether it is unsigned.
+func compileReturn(ctx *CompilerContext, val value.Value) {
+	fn := ctx.current_func
+	if val == nil {
+		ctx.leaveFunction(nil)
+		ctx.builder.NewRet(zeroValue(fn.Sig.RetType))
+		return
+	}
+	if pf, ok := ctx.pending[fn]; ok && !pf.retTypeFixed {
+		pf.retTypeFixed = true
+		if !fn.Sig.RetType.Equal(val.Type()) {
+			fn.Sig.RetType = val.Type()
+			refreshSignature(fn)
+		}
+		if ctx.unsigned[val] {
+			ctx.unsigned[fn] = true
+		}
+	}
+	ret := convertValue(ctx, val, fn.Sig.RetType)
+	ctx.leaveFunction(ret)
+	ctx.builder.NewRet(ret)
+}
+
+func zeroValue(t types.Type) value.Value {
+	switch t := t.(type) {
+	case *types.VoidType:
+		return nil
+	case *types.IntType:
+		return constant.NewInt(t, 0)
+	case *types.FloatType:
+		return constant.NewFloat(t, 0)
+	case *types.PointerType:
+		return constant.NewNull(t)
+	}
+	return constant.NewZeroInitializer(t)
+}
+
 func createMainFunction(ctx *CompilerContext) *ir.Func {
 	mainFn := ctx.module.NewFunc("main", types.I32)
 	ctx.SetCurrentFunction(mainFn)
@@ -0,0 +1,317 @@
+package compiler
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// inlineBudget is how many instructions inline adds to one function at
+// most, so that chains of small functions cannot blow it up.
+const inlineBudget = 2000
+
+// inline replaces the calls in m to functions of at most threshold
+// instructions by their bodies. Functions that can reach a call to
+// themselves are left alone, so inlining always ends.
+func inline(m *ir.Module, threshold int) {
+	recursive := recursiveFuncs(m)
+	inlinable := func(caller, callee *ir.Func) bool {
+		return callee != caller && len(callee.Blocks) > 0 && !callee.Sig.Variadic &&
+			!recursive[callee] && !hasFuncAttr(callee, enum.FuncAttrNoInline) &&
+			funcSize(callee) <= threshold
+	}
+	for _, fn := range m.Funcs {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+		names := localNames(fn)
+		repl := make(map[value.Value]value.Value)
+		budget := inlineBudget
+		// The blocks of an inlined body come right after the call, so they
+		// are looked at for calls in turn.
+		for bi := 0; bi < len(fn.Blocks); bi++ {
+			b := fn.Blocks[bi]
+			for i, inst := range b.Insts {
+				call, ok := inst.(*ir.InstCall)
+				if !ok {
+					continue
+				}
+				callee, ok := call.Callee.(*ir.Func)
+				if !ok || !inlinable(fn, callee) || funcSize(callee) > budget || len(call.Args) != len(callee.Params) {
+					continue
+				}
+				budget -= funcSize(callee)
+				inlineCall(fn, bi, i, callee, names, repl)
+				break
+			}
+		}
+		replaceUses(fn, repl)
+	}
+}
+
+// inlineCall inlines the call at instruction i of block bi of fn to
+// callee. The block is split after the call; the rest of it continues
+// after the inlined body, and the value the body returns replaces the
+// call, through repl.
+func inlineCall(fn *ir.Func, bi, i int, callee *ir.Func, names map[string]bool, repl map[value.Value]value.Value) {
+	b := fn.Blocks[bi]
+	call := b.Insts[i].(*ir.InstCall)
+	cont := &ir.Block{Parent: fn}
+	cont.SetName(uniqueName(names, callee.Name()+".cont"))
+	cont.Insts = append([]ir.Instruction(nil), b.Insts[i+1:]...)
+	cont.Term = b.Term
+	for _, s := range termSuccs(cont.Term) {
+		for _, phi := range phis(s) {
+			for _, inc := range phi.Incs {
+				if inc.Pred == b {
+					inc.Pred = cont
+				}
+			}
+		}
+	}
+	b.Insts = b.Insts[:i]
+
+	vmap := make(map[value.Value]value.Value)
+	for j, p := range callee.Params {
+		vmap[p] = call.Args[j]
+	}
+	var body []*ir.Block
+	for _, cb := range callee.Blocks {
+		nb := &ir.Block{Parent: fn}
+		if name := localName(cb); name != "" {
+			nb.SetName(uniqueName(names, callee.Name()+"."+name))
+		}
+		vmap[cb] = nb
+		body = append(body, nb)
+	}
+	for k, cb := range callee.Blocks {
+		nb := body[k]
+		for _, inst := range cb.Insts {
+			c := cloneIR(inst).(ir.Instruction)
+			if v, ok := inst.(value.Value); ok {
+				renameClone(c, names, callee.Name()+"."+localName(v))
+				vmap[v] = c.(value.Value)
+			}
+			nb.Insts = append(nb.Insts, c)
+		}
+		nb.Term = cloneIR(cb.Term).(ir.Terminator)
+	}
+
+	// Operands are remapped once every value has its clone, as phis can
+	// refer to values defined further down.
+	var rets []*ir.Incoming
+	for _, nb := range body {
+		for _, inst := range nb.Insts {
+			remapOperands(inst.Operands(), vmap)
+		}
+		remapOperands(nb.Term.Operands(), vmap)
+		if ret, ok := nb.Term.(*ir.TermRet); ok {
+			if ret.X != nil {
+				rets = append(rets, ir.NewIncoming(ret.X, nb))
+			}
+			nb.NewBr(cont)
+		}
+	}
+
+	// Fixed-size stack slots go to the entry of fn, so that the inlined body
+	// does not grow the stack each time a loop runs it.
+	var slots []ir.Instruction
+	kept := body[0].Insts[:0]
+	for _, inst := range body[0].Insts {
+		if a, ok := inst.(*ir.InstAlloca); ok && (a.NElems == nil || isConstant(a.NElems)) {
+			slots = append(slots, inst)
+		} else {
+			kept = append(kept, inst)
+		}
+	}
+	body[0].Insts = kept
+	if len(slots) > 0 {
+		entry := fn.Blocks[0]
+		n := 0
+		for n < len(entry.Insts) {
+			if _, ok := entry.Insts[n].(*ir.InstAlloca); !ok {
+				break
+			}
+			n++
+		}
+		insts := append(append(append([]ir.Instruction(nil), entry.Insts[:n]...), slots...), entry.Insts[n:]...)
+		entry.Insts = insts
+	}
+
+	b.NewBr(body[0])
+	if !call.Type().Equal(types.Void) {
+		switch len(rets) {
+		case 0:
+			repl[call] = constant.NewUndef(call.Type())
+		case 1:
+			repl[call] = rets[0].X
+		default:
+			phi := &ir.InstPhi{Typ: call.Type(), Incs: rets}
+			phi.SetName(uniqueName(names, callee.Name()+".result"))
+			cont.Insts = append([]ir.Instruction{phi}, cont.Insts...)
+			repl[call] = phi
+		}
+	}
+
+	blocks := append([]*ir.Block(nil), fn.Blocks[:bi+1]...)
+	blocks = append(blocks, body...)
+	blocks = append(blocks, cont)
+	fn.Blocks = append(blocks, fn.Blocks[bi+1:]...)
+}
+
+// cloneIR returns a copy of an instruction or terminator that shares no
+// lists with it, so that its operands can be changed on their own.
+// Terminators also drop their cached successors.
+func cloneIR(x interface{}) interface{} {
+	orig := reflect.ValueOf(x).Elem()
+	c := reflect.New(orig.Type())
+	c.Elem().Set(orig)
+	s := c.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		if s.Type().Field(i).Name == "Successors" {
+			f.Set(reflect.Zero(f.Type()))
+			continue
+		}
+		if f.Kind() != reflect.Slice || f.IsNil() {
+			continue
+		}
+		list := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+		reflect.Copy(list, f)
+		for j := 0; j < list.Len(); j++ {
+			switch e := list.Index(j).Interface().(type) {
+			case *ir.Incoming:
+				inc := *e
+				list.Index(j).Set(reflect.ValueOf(&inc))
+			case *ir.Case:
+				cs := *e
+				list.Index(j).Set(reflect.ValueOf(&cs))
+			}
+		}
+		f.Set(list)
+	}
+	return c.Interface()
+}
+
+func remapOperands(ops []*value.Value, vmap map[value.Value]value.Value) {
+	for _, op := range ops {
+		if v, ok := vmap[*op]; ok {
+			*op = v
+		}
+	}
+}
+
+type localValue interface {
+	IsUnnamed() bool
+	Name() string
+	SetName(name string)
+	SetID(id int64)
+}
+
+// renameClone gives a named clone a name of its own in the function it is
+// inlined into, and has an unnamed one numbered afresh.
+func renameClone(x interface{}, names map[string]bool, name string) {
+	l, ok := x.(localValue)
+	if !ok {
+		return
+	}
+	if l.IsUnnamed() {
+		l.SetID(0)
+		return
+	}
+	l.SetName(uniqueName(names, name))
+}
+
+// localName is the name of a block or value, or "" when it has none.
+func localName(x interface{}) string {
+	if l, ok := x.(localValue); ok && !l.IsUnnamed() {
+		return l.Name()
+	}
+	return ""
+}
+
+// localNames returns the names used by the parameters, blocks and values of
+// fn, which share one namespace.
+func localNames(fn *ir.Func) map[string]bool {
+	names := make(map[string]bool)
+	add := func(x interface{}) {
+		if name := localName(x); name != "" {
+			names[name] = true
+		}
+	}
+	for _, p := range fn.Params {
+		add(p)
+	}
+	for _, b := range fn.Blocks {
+		add(b)
+		for _, inst := range b.Insts {
+			add(inst)
+		}
+	}
+	return names
+}
+
+func uniqueName(names map[string]bool, base string) string {
+	name := base
+	for n := 1; names[name]; n++ {
+		name = fmt.Sprintf("%s.%d", base, n)
+	}
+	names[name] = true
+	return name
+}
+
+func isConstant(v value.Value) bool {
+	_, ok := v.(constant.Constant)
+	return ok
+}
+
+func funcSize(fn *ir.Func) int {
+	n := 0
+	for _, b := range fn.Blocks {
+		n += len(b.Insts) + 1
+	}
+	return n
+}
+
+// recursiveFuncs returns the functions of m that can reach a call to
+// themselves through direct calls.
+func recursiveFuncs(m *ir.Module) map[*ir.Func]bool {
+	calls := make(map[*ir.Func][]*ir.Func)
+	for _, fn := range m.Funcs {
+		for _, b := range fn.Blocks {
+			for _, inst := range b.Insts {
+				if call, ok := inst.(*ir.InstCall); ok {
+					if callee, ok := call.Callee.(*ir.Func); ok {
+						calls[fn] = append(calls[fn], callee)
+					}
+				}
+			}
+		}
+	}
+	recursive := make(map[*ir.Func]bool)
+	for _, fn := range m.Funcs {
+		seen := make(map[*ir.Func]bool)
+		work := append([]*ir.Func(nil), calls[fn]...)
+		for len(work) > 0 {
+			g := work[len(work)-1]
+			work = work[:len(work)-1]
+			if g == fn {
+				recursive[fn] = true
+				break
+			}
+			if !seen[g] {
+				seen[g] = true
+				work = append(work, calls[g]...)
+			}
+		}
+	}
+	return recursive
+}
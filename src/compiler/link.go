@@ -0,0 +1,203 @@
+package compiler
+
+import (
+	"path/filepath"
+	"strings"
+
+	analysis "aether/src/analysis"
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Modules are linked by name. A module defines its top-level functions and
+// exported bindings as <module>.<name>, and an importer declares the ones it
+// uses. Both sides take the signatures from analysis.ModuleExports, so they
+// agree without seeing each other's IR.
+//
+// The top-level statements of a module run in its initializer, and the
+// bindings they make are globals. The entry point calls the initializers
+// of all modules once, dependencies first, before its own code. In debug
+// builds it also calls their finalizers, in the opposite order, as it
+// returns; they release the values of the globals.
+//
+// The entry module's top-level statements run in main itself. Its bindings
+// are globals only when a function or lambda uses them.
+
+func mangleName(module, name string) string {
+	return module + "." + name
+}
+
+func moduleInitName(module string) string {
+	return "__module_" + module
+}
+
+func moduleFiniName(module string) string {
+	return "__module_" + module + ".fini"
+}
+
+// importedModuleName is the module an import path refers to: its file name
+// without the extension.
+func importedModuleName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// exportFunctions gives the functions of the module being compiled their
+// mangled names and fixes their signatures to the exported ones. The entry
+// point keeps its name.
+func exportFunctions(ctx *CompilerContext, exports map[string]interface{}) {
+	for name, export := range exports {
+		info, ok := export.(analysis.FunctionInfo)
+		if !ok || name == "main" {
+			continue
+		}
+		sym, _ := ctx.GetSymbol(name)
+		fn, ok := sym.(*ir.Func)
+		if !ok || ctx.pending[fn] == nil {
+			continue
+		}
+		pf := ctx.pending[fn]
+		fn.SetName(mangleName(ctx.options.ModuleName, name))
+		for i, p := range info.Parameters {
+			if i < len(fn.Params) {
+				fn.Params[i].Typ, _ = typeFromAnnotation(ctx, p.Type)
+				pf.annotatedArgs[i] = true
+			}
+		}
+		fn.Sig.RetType, _ = typeFromAnnotation(ctx, info.ReturnType)
+		pf.retTypeFixed = true
+		refreshSignature(fn)
+	}
+}
+
+// exportGlobals defines the exported bindings among stmts that are
+// initialized with a literal, and returns the statements left for the
+// module initializer.
+func exportGlobals(ctx *CompilerContext, exports map[string]interface{}, stmts []parser.Statement) []parser.Statement {
+	rest := stmts[:0:0]
+	for _, stmt := range stmts {
+		if assign, ok := stmt.(*parser.Assignment); ok && len(assign.Names) == 1 {
+			name := assign.Names[0].Value
+			info, exported := exports[name].(analysis.VariableInfo)
+			if lit, ok := assign.Value.(*parser.Literal); ok && exported && exportGlobal(ctx, name, info, lit) {
+				continue
+			}
+		}
+		rest = append(rest, stmt)
+	}
+	return rest
+}
+
+// exportGlobal defines an exported binding as a global initialized with
+// lit. It returns false when lit is not a constant of the exported type,
+// and the assignment is then compiled like any other statement.
+func exportGlobal(ctx *CompilerContext, name string, info analysis.VariableInfo, lit *parser.Literal) bool {
+	if _, defined := ctx.GetSymbol(name); defined {
+		return false
+	}
+	init, ok := compileExpr(lit, ctx).(constant.Constant)
+	if !ok {
+		return false
+	}
+	t, _ := typeFromAnnotation(ctx, info.Type)
+	if !init.Type().Equal(t) {
+		return false
+	}
+	g := ctx.module.NewGlobalDef(mangleName(ctx.options.ModuleName, name), init)
+	ctx.SetSymbol(name, g)
+	return true
+}
+
+// declareImport declares the export name of module, or returns nil when
+// the module has no such export. Imported functions are called like Aether
+// functions whose bodies are already compiled.
+func declareImport(ctx *CompilerContext, module *ModuleInfo, name string) value.Value {
+	switch info := module.Exports[name].(type) {
+	case analysis.FunctionInfo:
+		if info.Foreign {
+			return declareForeign(ctx, name, foreignParams(info), info.ReturnType, info.Variadic)
+		}
+		params := make([]*ir.Param, len(info.Parameters))
+		for i, p := range info.Parameters {
+			t, _ := typeFromAnnotation(ctx, p.Type)
+			params[i] = ir.NewParam(p.Name, t)
+		}
+		ret, _ := typeFromAnnotation(ctx, info.ReturnType)
+		fn := ctx.module.NewFunc(mangleName(module.Name, name), ret, params...)
+		if isUnsignedType(info.ReturnType) {
+			ctx.unsigned[fn] = true
+		}
+		n := len(info.Parameters)
+		ctx.pending[fn] = &pendingFunc{
+			state:         funcDone,
+			retTypeFixed:  true,
+			annotatedArgs: make([]bool, n),
+			variadic:      n > 0 && info.Parameters[n-1].Variadic,
+		}
+		return fn
+	case analysis.VariableInfo:
+		t, _ := typeFromAnnotation(ctx, info.Type)
+		g := ctx.module.NewGlobal(mangleName(module.Name, name), t)
+		g.Linkage = enum.LinkageExternal
+		if isUnsignedType(info.Type) {
+			ctx.unsigned[g] = true
+		}
+		return g
+	}
+	return nil
+}
+
+// compileModuleInit compiles the top-level statements of the module into
+// its initializer.
+func compileModuleInit(ctx *CompilerContext, exports map[string]interface{}, stmts []parser.Statement) {
+	fn := ctx.module.NewFunc(moduleInitName(ctx.options.ModuleName), types.Void)
+	ctx.exports = exports
+	ctx.moduleInit = fn
+	ctx.SetCurrentFunction(fn)
+	ctx.builder = fn.NewBlock("entry")
+	ctx.currentBody = &parser.Block{Statements: stmts}
+	for _, stmt := range stmts {
+		compileStmt(stmt, ctx)
+	}
+	if ctx.builder.Term == nil {
+		ctx.leaveFunction(nil)
+		ctx.builder.NewRet(nil)
+	}
+}
+
+// atModuleLevel reports whether a binding of name made now is a top-level
+// binding of a module, as opposed to a local of the initializer or a
+// function.
+func atModuleLevel(ctx *CompilerContext, name string) bool {
+	if ctx.moduleInit == nil || ctx.current_func != ctx.moduleInit || len(ctx.scopes) != 1 {
+		return false
+	}
+	return ctx.funcNames == nil || ctx.funcNames[name]
+}
+
+// defineModuleVar creates the global behind a top-level binding. Exported
+// bindings of the exported type get their mangled name; the others are
+// internal to the module.
+func defineModuleVar(ctx *CompilerContext, name string, t types.Type) *ir.Global {
+	mangled := mangleName(ctx.options.ModuleName, name)
+	if info, ok := ctx.exports[name].(analysis.VariableInfo); ok {
+		if want, _ := typeFromAnnotation(ctx, info.Type); want.Equal(t) {
+			return ctx.module.NewGlobalDef(mangled, constant.NewZeroInitializer(t))
+		}
+	}
+	g := ctx.module.NewGlobalDef(ctx.uniqueGlobal(mangled), constant.NewZeroInitializer(t))
+	g.Linkage = enum.LinkageInternal
+	return g
+}
+
+// callModuleInits runs the initializers of the modules in
+// Options.InitOrder.
+func callModuleInits(ctx *CompilerContext) {
+	for _, module := range ctx.options.InitOrder {
+		ctx.builder.NewCall(getOrCreateExtern(ctx, moduleInitName(module), types.Void, false))
+	}
+}
@@ -0,0 +1,257 @@
+package compiler
+
+import (
+	"sort"
+
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A match compiles to a decision tree. Consecutive arms with integer
+// literal patterns become one switch whose default continues with the next
+// arm. Every other arm tests its pattern and falls through to the following
+// arm when the test fails. Arms end in a shared continuation block.
+
+// patternBinding is a variable bound by a pattern. A ...rest pattern binds
+// the elements of the array v from index from on, which are only copied
+// once the whole pattern has matched.
+type patternBinding struct {
+	decl parser.Node
+	name string
+	v    value.Value
+	from value.Value
+}
+
+func compileMatch(s *parser.Match, ctx *CompilerContext) {
+	subject := compileExpr(s.Expr, ctx)
+	if subject == nil {
+		return
+	}
+	end := ctx.NewBlock("match.end")
+	for i := 0; i < len(s.Cases); {
+		if n := switchRun(subject, s.Cases[i:]); n > 0 {
+			compileSwitchArms(ctx, subject, s.Cases[i:i+n], end)
+			i += n
+			continue
+		}
+		c := s.Cases[i]
+		next := ctx.NewBlock("match.next")
+		var binds []patternBinding
+		if matchPattern(ctx, c.Pattern, subject, next, &binds) {
+			compileArm(ctx, c.Body, binds, end)
+		} else {
+			ctx.builder.NewBr(next)
+		}
+		ctx.builder = next
+		i++
+	}
+	branchTo(ctx, end)
+	ctx.builder = end
+}
+
+// switchRun counts the arms at the start of cases whose patterns are
+// integer literals that can be switched on.
+func switchRun(subject value.Value, cases []*parser.Case) int {
+	t, ok := subject.Type().(*types.IntType)
+	if !ok || t.BitSize == 1 {
+		return 0
+	}
+	n := 0
+	for _, c := range cases {
+		if _, ok := intPattern(c.Pattern, t); !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func intPattern(pat parser.Expression, t *types.IntType) (*constant.Int, bool) {
+	lit, ok := pat.(*parser.Literal)
+	if !ok || lit.Kind != parser.NumberLiteral {
+		return nil, false
+	}
+	s, ok := lit.Value.(string)
+	if !ok {
+		return nil, false
+	}
+	c, ok := numberConstant(s).(*constant.Int)
+	if !ok {
+		return nil, false
+	}
+	return constant.NewInt(t, c.X.Int64()), true
+}
+
+// compileSwitchArms emits a switch over arms with integer literal patterns.
+// A value repeated in a later arm can never reach it, so only the first
+// arm gets the case.
+func compileSwitchArms(ctx *CompilerContext, subject value.Value, arms []*parser.Case, end *ir.Block) {
+	t := subject.Type().(*types.IntType)
+	next := ctx.NewBlock("match.next")
+	sw := ctx.builder.NewSwitch(subject, next)
+	seen := map[int64]bool{}
+	for _, c := range arms {
+		val, _ := intPattern(c.Pattern, t)
+		if seen[val.X.Int64()] {
+			continue
+		}
+		seen[val.X.Int64()] = true
+		block := ctx.NewBlock("match.case")
+		sw.Cases = append(sw.Cases, ir.NewCase(val, block))
+		ctx.builder = block
+		compileArm(ctx, c.Body, nil, end)
+	}
+	ctx.builder = next
+}
+
+// compileArm compiles an arm body in a new scope holding its bindings.
+func compileArm(ctx *CompilerContext, body *parser.Block, binds []patternBinding, end *ir.Block) {
+	ctx.EnterScope()
+	for _, b := range binds {
+		v := b.v
+		if b.from != nil {
+			elem, _ := ctx.arrayElemType(v.Type())
+			length := arrayField(ctx.builder, v, arrayLenField)
+			v = ctx.own(ctx.builder.NewCall(arraySliceFunc(ctx, elem), v, b.from, length, sourceLocation(ctx, 0)))
+		}
+		slot := ctx.localSlot(b.decl, b.name, v.Type(), 0)
+		ctx.store(v, slot)
+		ctx.SetSymbol(b.name, slot)
+	}
+	compileBlock(body, ctx)
+	ctx.releaseScope()
+	ctx.ExitScope()
+	branchTo(ctx, end)
+}
+
+// matchPattern emits the tests of pat against v, branching to fail when one
+// does not hold, and leaves the builder where the pattern has matched.
+// It returns false when pat can never match a value of v's type.
+func matchPattern(ctx *CompilerContext, pat parser.Expression, v value.Value, fail *ir.Block, binds *[]patternBinding) bool {
+	switch p := pat.(type) {
+	case *parser.Identifier:
+		switch p.Value {
+		case "_":
+			return true
+		case "true", "false":
+			if t, ok := v.Type().(*types.IntType); !ok || t.BitSize != 1 {
+				return false
+			}
+			want := constant.NewBool(p.Value == "true")
+			testPattern(ctx, ctx.builder.NewICmp(enum.IPredEQ, v, want), fail)
+			return true
+		}
+		*binds = append(*binds, patternBinding{decl: p, name: p.Value, v: v})
+		return true
+	case *parser.Literal:
+		cond := literalTest(ctx, p, v)
+		if cond == nil {
+			return false
+		}
+		testPattern(ctx, cond, fail)
+		return true
+	case *parser.Array:
+		return matchArrayPattern(ctx, p, v, fail, binds)
+	case *parser.StructInstantiation:
+		info, ok := ctx.structInfoOf(v.Type())
+		if !ok {
+			return false
+		}
+		names := make([]string, 0, len(p.Fields))
+		for name := range p.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			index := info.fieldIndex(name)
+			if index < 0 {
+				return false
+			}
+			if !matchPattern(ctx, p.Fields[name], loadField(ctx, v, index), fail, binds) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// literalTest compares v with a literal pattern, or returns nil when the
+// types cannot be compared.
+func literalTest(ctx *CompilerContext, lit *parser.Literal, v value.Value) value.Value {
+	if lit.Kind == parser.StringLiteral {
+		if !ctx.isString(v.Type()) {
+			return nil
+		}
+		s, _ := lit.Value.(string)
+		return compileStringCompare(ctx, "==", v, stringLiteral(ctx, s))
+	}
+	s, ok := lit.Value.(string)
+	if !ok {
+		return nil
+	}
+	want := numberConstant(s)
+	if want == nil {
+		return nil
+	}
+	switch t := v.Type().(type) {
+	case *types.IntType:
+		if t.BitSize == 1 {
+			return nil
+		}
+		if _, isInt := want.Type().(*types.IntType); !isInt {
+			return ctx.builder.NewFCmp(enum.FPredOEQ, convertValue(ctx, v, types.Double), want)
+		}
+		return ctx.builder.NewICmp(enum.IPredEQ, v, convertValue(ctx, want, t))
+	case *types.FloatType:
+		return ctx.builder.NewFCmp(enum.FPredOEQ, v, convertValue(ctx, want, t))
+	}
+	return nil
+}
+
+// matchArrayPattern matches [p0, p1, ...rest]: the length must equal the
+// number of element patterns, or be at least that with a rest pattern.
+func matchArrayPattern(ctx *CompilerContext, p *parser.Array, v value.Value, fail *ir.Block, binds *[]patternBinding) bool {
+	elem, ok := ctx.arrayElemType(v.Type())
+	if !ok {
+		return false
+	}
+	elems := p.Elements
+	var rest *parser.Spread
+	if n := len(elems); n > 0 {
+		if s, ok := elems[n-1].(*parser.Spread); ok {
+			rest, elems = s, elems[:n-1]
+		}
+	}
+	length := arrayField(ctx.builder, v, arrayLenField)
+	want := constant.NewInt(types.I64, int64(len(elems)))
+	pred := enum.IPredEQ
+	if rest != nil {
+		pred = enum.IPredUGE
+	}
+	testPattern(ctx, ctx.builder.NewICmp(pred, length, want), fail)
+	for i, sub := range elems {
+		data := arrayField(ctx.builder, v, arrayDataField)
+		ptr := ctx.builder.NewGetElementPtr(elem, data, constant.NewInt(types.I64, int64(i)))
+		if !matchPattern(ctx, sub, ctx.builder.NewLoad(elem, ptr), fail, binds) {
+			return false
+		}
+	}
+	if rest != nil && rest.Name != "" {
+		*binds = append(*binds, patternBinding{decl: rest, name: rest.Name, v: v, from: want})
+	}
+	return true
+}
+
+// testPattern continues in a new block when cond holds and jumps to fail
+// otherwise.
+func testPattern(ctx *CompilerContext, cond value.Value, fail *ir.Block) {
+	ok := ctx.NewBlock("match.test")
+	ctx.builder.NewCondBr(cond, ok, fail)
+	ctx.builder = ok
+}
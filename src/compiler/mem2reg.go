@@ -0,0 +1,199 @@
+package compiler
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/value"
+)
+
+// mem2reg turns the stack slots of fn that are only loaded and stored,
+// never passed anywhere, into SSA values. Every variable is compiled to
+// such a slot; phis are placed where paths that stored different values
+// meet, at the dominance frontiers of the stores, and each load becomes
+// the value stored last on the way to it.
+func mem2reg(fn *ir.Func) {
+	removeUnreachable(fn)
+	entry := fn.Blocks[0]
+	slots := promotableSlots(fn)
+	if len(slots) == 0 {
+		return
+	}
+	preds, _ := blockGraph(fn)
+	idom := dominators(entry, preds)
+	children := make(map[*ir.Block][]*ir.Block)
+	for _, b := range fn.Blocks[1:] {
+		children[idom[b]] = append(children[idom[b]], b)
+	}
+	frontier := dominanceFrontiers(fn, preds, idom)
+
+	// Place the phis, in the order of the slots for stable output.
+	placed := make(map[*ir.InstPhi]*ir.InstAlloca)
+	for _, inst := range entry.Insts {
+		slot, ok := inst.(*ir.InstAlloca)
+		if !ok || !slots[slot] {
+			continue
+		}
+		var work []*ir.Block
+		stores := make(map[*ir.Block]bool)
+		for _, b := range fn.Blocks {
+			for _, inst := range b.Insts {
+				if st, ok := inst.(*ir.InstStore); ok && st.Dst == slot && !stores[b] {
+					stores[b] = true
+					work = append(work, b)
+				}
+			}
+		}
+		hasPhi := make(map[*ir.Block]bool)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, f := range frontier[b] {
+				if hasPhi[f] {
+					continue
+				}
+				hasPhi[f] = true
+				phi := &ir.InstPhi{Typ: slot.ElemType}
+				f.Insts = append([]ir.Instruction{phi}, f.Insts...)
+				placed[phi] = slot
+				if !stores[f] {
+					work = append(work, f)
+				}
+			}
+		}
+	}
+
+	// Rename along the dominator tree: current holds the value each slot
+	// has at this point, and undo how to restore it when leaving a block.
+	type saved struct {
+		slot  *ir.InstAlloca
+		value value.Value
+	}
+	current := make(map[*ir.InstAlloca]value.Value)
+	var undo []saved
+	set := func(slot *ir.InstAlloca, v value.Value) {
+		undo = append(undo, saved{slot, current[slot]})
+		current[slot] = v
+	}
+	valueOf := func(slot *ir.InstAlloca) value.Value {
+		if v := current[slot]; v != nil {
+			return v
+		}
+		return constant.NewUndef(slot.ElemType)
+	}
+	repl := make(map[value.Value]value.Value)
+	dead := make(map[ir.Instruction]bool)
+	var rename func(b *ir.Block)
+	rename = func(b *ir.Block) {
+		mark := len(undo)
+		for _, inst := range b.Insts {
+			switch inst := inst.(type) {
+			case *ir.InstPhi:
+				if slot, ok := placed[inst]; ok {
+					set(slot, inst)
+				}
+			case *ir.InstLoad:
+				if slot, ok := inst.Src.(*ir.InstAlloca); ok && slots[slot] {
+					repl[inst] = valueOf(slot)
+					dead[inst] = true
+				}
+			case *ir.InstStore:
+				if slot, ok := inst.Dst.(*ir.InstAlloca); ok && slots[slot] {
+					v := inst.Src
+					for r, ok := repl[v]; ok; r, ok = repl[v] {
+						v = r
+					}
+					set(slot, v)
+					dead[inst] = true
+				}
+			}
+		}
+		for _, s := range termSuccs(b.Term) {
+			for _, phi := range phis(s) {
+				if slot, ok := placed[phi]; ok {
+					phi.Incs = append(phi.Incs, ir.NewIncoming(valueOf(slot), b))
+				}
+			}
+		}
+		for _, c := range children[b] {
+			rename(c)
+		}
+		for len(undo) > mark {
+			u := undo[len(undo)-1]
+			undo = undo[:len(undo)-1]
+			current[u.slot] = u.value
+		}
+	}
+	rename(entry)
+
+	for slot := range slots {
+		dead[slot] = true
+	}
+	for _, b := range fn.Blocks {
+		removeInsts(b, dead)
+	}
+	replaceUses(fn, repl)
+}
+
+// promotableSlots returns the allocas of the entry block of fn that are
+// only loaded from and stored to, as a whole.
+func promotableSlots(fn *ir.Func) map[*ir.InstAlloca]bool {
+	slots := make(map[*ir.InstAlloca]bool)
+	for _, inst := range fn.Blocks[0].Insts {
+		if a, ok := inst.(*ir.InstAlloca); ok && a.NElems == nil {
+			slots[a] = true
+		}
+	}
+	escape := func(v value.Value) {
+		if a, ok := v.(*ir.InstAlloca); ok {
+			delete(slots, a)
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Insts {
+			switch inst := inst.(type) {
+			case *ir.InstLoad:
+				if a, ok := inst.Src.(*ir.InstAlloca); ok && !a.ElemType.Equal(inst.ElemType) {
+					delete(slots, a)
+				}
+			case *ir.InstStore:
+				escape(inst.Src)
+				if a, ok := inst.Dst.(*ir.InstAlloca); ok && !a.ElemType.Equal(inst.Src.Type()) {
+					delete(slots, a)
+				}
+			default:
+				for _, op := range inst.Operands() {
+					escape(*op)
+				}
+			}
+		}
+		if b.Term != nil {
+			for _, op := range b.Term.Operands() {
+				escape(*op)
+			}
+		}
+	}
+	return slots
+}
+
+// dominanceFrontiers returns, for each block b, the blocks where b stops
+// dominating: those with a predecessor b dominates that b does not
+// strictly dominate themselves.
+func dominanceFrontiers(fn *ir.Func, preds map[*ir.Block][]*ir.Block, idom map[*ir.Block]*ir.Block) map[*ir.Block][]*ir.Block {
+	frontier := make(map[*ir.Block][]*ir.Block)
+	for _, b := range fn.Blocks {
+		if len(preds[b]) < 2 {
+			continue
+		}
+		for _, p := range preds[b] {
+			for runner := p; runner != idom[b] && runner != nil; runner = idom[runner] {
+				if !containsBlock(frontier[runner], b) {
+					frontier[runner] = append(frontier[runner], b)
+				}
+				if idom[runner] == runner {
+					break
+				}
+			}
+		}
+	}
+	return frontier
+}
@@ -39,14 +39,16 @@ func (m *Module) GetOptimizationLevel() string {
 	return m.optimizationLevel
 }
 
-func (m *Module) ApplyOptimizations() {
-	if strings.Contains(m.optimizationLevel, "O0") {
-		return
+// ApplyOptimizations runs the passes of the module's optimization level,
+// given as "default<O2>" or just "2", over its IR.
+func (m *Module) ApplyOptimizations() error {
+	level := strings.TrimSuffix(strings.TrimPrefix(m.optimizationLevel, "default<"), ">")
+	pipeline, err := ParsePipeline(strings.TrimPrefix(level, "O"))
+	if err != nil {
+		return err
 	}
-
-	// Note: llir/llvm doesn't have built-in optimization passes like the C API
-	// You would need to use a separate tool like 'opt' to apply optimizations
-	// For now, we'll just return without doing anything
+	Optimize(m.irModule, pipeline)
+	return nil
 }
 
 func (m *Module) String() string {
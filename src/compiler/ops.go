@@ -0,0 +1,399 @@
+package compiler
+
+import (
+	"fmt"
+
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The parser represents operators as calls to an identifier holding the
+// operator symbol, e.g. a + b is Call{Function: "+", Args: [a, b]}.
+//
+// Debug builds check integer arithmetic at run time, like array indices:
+// signed overflow and division by zero abort with the line of the operator.
+
+func isOperator(name string) bool {
+	switch name {
+	case "+", "-", "*", "/", "%", "^", "==", "!=", "<", ">", "<=", ">=", "!", "..":
+		return true
+	}
+	return false
+}
+
+func compileOperator(op string, line int, args []parser.Expression, ctx *CompilerContext) value.Value {
+	if len(args) == 1 {
+		operand := compileExpr(args[0], ctx)
+		if operand == nil {
+			return nil
+		}
+		return compileUnary(op, operand, line, ctx)
+	}
+	if len(args) != 2 {
+		return nil
+	}
+	left := compileExpr(args[0], ctx)
+	right := compileExpr(args[1], ctx)
+	if left == nil || right == nil {
+		return nil
+	}
+	return compileBinary(op, left, right, line, ctx)
+}
+
+func compileUnary(op string, operand value.Value, line int, ctx *CompilerContext) value.Value {
+	switch op {
+	case "-":
+		if isFloat(operand.Type()) {
+			return ctx.builder.NewFNeg(operand)
+		}
+		zero := constant.NewInt(operand.Type().(*types.IntType), 0)
+		if ctx.options.Debug && !ctx.unsigned[operand] {
+			return checkedArith(ctx, op, zero, operand, line)
+		}
+		return ctx.builder.NewSub(zero, operand)
+	case "!", "!=":
+		return ctx.builder.NewXor(toBool(ctx, operand), constant.True)
+	}
+	return nil
+}
+
+func compileBinary(op string, left, right value.Value, line int, ctx *CompilerContext) value.Value {
+	if op == ".." {
+		return compileConcat(ctx, left, right)
+	}
+	if ctx.isString(left.Type()) && ctx.isString(right.Type()) {
+		return compileStringCompare(ctx, op, left, right)
+	}
+	if _, ok := left.Type().(*types.PointerType); ok && left.Type().Equal(right.Type()) {
+		switch op {
+		case "==":
+			return ctx.builder.NewICmp(enum.IPredEQ, left, right)
+		case "!=":
+			return ctx.builder.NewICmp(enum.IPredNE, left, right)
+		}
+		return nil
+	}
+	typ := operandType(left, right)
+	if !isNumeric(typ) {
+		return nil
+	}
+	unsigned := unsignedOperands(ctx, left, right, typ)
+	left = convertValue(ctx, left, typ)
+	right = convertValue(ctx, right, typ)
+	if unsigned {
+		return compileUnsigned(ctx, op, left, right, line)
+	}
+	if ctx.options.Debug && !isFloat(typ) {
+		switch op {
+		case "+", "-", "*":
+			return checkedArith(ctx, op, left, right, line)
+		case "/", "%":
+			checkDivision(ctx, left, right, true, line)
+		}
+	}
+	b := ctx.builder
+	if isFloat(typ) {
+		switch op {
+		case "+":
+			return b.NewFAdd(left, right)
+		case "-":
+			return b.NewFSub(left, right)
+		case "*":
+			return b.NewFMul(left, right)
+		case "/":
+			return b.NewFDiv(left, right)
+		case "%":
+			return b.NewFRem(left, right)
+		case "==":
+			return b.NewFCmp(enum.FPredOEQ, left, right)
+		case "!=":
+			return b.NewFCmp(enum.FPredUNE, left, right)
+		case "<":
+			return b.NewFCmp(enum.FPredOLT, left, right)
+		case ">":
+			return b.NewFCmp(enum.FPredOGT, left, right)
+		case "<=":
+			return b.NewFCmp(enum.FPredOLE, left, right)
+		case ">=":
+			return b.NewFCmp(enum.FPredOGE, left, right)
+		}
+		return nil
+	}
+	switch op {
+	case "+":
+		return b.NewAdd(left, right)
+	case "-":
+		return b.NewSub(left, right)
+	case "*":
+		return b.NewMul(left, right)
+	case "/":
+		return b.NewSDiv(left, right)
+	case "%":
+		return b.NewSRem(left, right)
+	case "^":
+		return b.NewXor(left, right)
+	case "==":
+		return b.NewICmp(enum.IPredEQ, left, right)
+	case "!=":
+		return b.NewICmp(enum.IPredNE, left, right)
+	case "<":
+		return b.NewICmp(enum.IPredSLT, left, right)
+	case ">":
+		return b.NewICmp(enum.IPredSGT, left, right)
+	case "<=":
+		return b.NewICmp(enum.IPredSLE, left, right)
+	case ">=":
+		return b.NewICmp(enum.IPredSGE, left, right)
+	}
+	return nil
+}
+
+// compileUnsigned applies an integer operator to unsigned operands of the
+// same type. Their arithmetic wraps around, so debug builds only check
+// division.
+func compileUnsigned(ctx *CompilerContext, op string, left, right value.Value, line int) value.Value {
+	if ctx.options.Debug && (op == "/" || op == "%") {
+		checkDivision(ctx, left, right, false, line)
+	}
+	b := ctx.builder
+	var v value.Value
+	switch op {
+	case "+":
+		v = b.NewAdd(left, right)
+	case "-":
+		v = b.NewSub(left, right)
+	case "*":
+		v = b.NewMul(left, right)
+	case "/":
+		v = b.NewUDiv(left, right)
+	case "%":
+		v = b.NewURem(left, right)
+	case "^":
+		v = b.NewXor(left, right)
+	case "==":
+		return b.NewICmp(enum.IPredEQ, left, right)
+	case "!=":
+		return b.NewICmp(enum.IPredNE, left, right)
+	case "<":
+		return b.NewICmp(enum.IPredULT, left, right)
+	case ">":
+		return b.NewICmp(enum.IPredUGT, left, right)
+	case "<=":
+		return b.NewICmp(enum.IPredULE, left, right)
+	case ">=":
+		return b.NewICmp(enum.IPredUGE, left, right)
+	default:
+		return nil
+	}
+	ctx.unsigned[v] = true
+	return v
+}
+
+// overflowIntrinsics names the LLVM intrinsics that add, subtract and
+// multiply signed integers and tell whether the result overflowed.
+var overflowIntrinsics = map[string]string{"+": "sadd", "-": "ssub", "*": "smul"}
+
+// checkedArith applies op to the signed integers left and right, aborting
+// when the result does not fit their type.
+func checkedArith(ctx *CompilerContext, op string, left, right value.Value, line int) value.Value {
+	t := left.Type().(*types.IntType)
+	name := fmt.Sprintf("llvm.%s.with.overflow.i%d", overflowIntrinsics[op], t.BitSize)
+	fn := getOrCreateExtern(ctx, name, types.NewStruct(t, types.I1), false, t, t)
+	r := ctx.builder.NewCall(fn, left, right)
+	arithCheck(ctx, ctx.builder.NewExtractValue(r, 1), "integer overflow", line)
+	return ctx.builder.NewExtractValue(r, 0)
+}
+
+// checkDivision aborts when left / right is undefined: when right is zero,
+// or for signed integers when left is the smallest value and right -1.
+func checkDivision(ctx *CompilerContext, left, right value.Value, signed bool, line int) {
+	t := right.Type().(*types.IntType)
+	arithCheck(ctx, ctx.builder.NewICmp(enum.IPredEQ, right, constant.NewInt(t, 0)), "integer divide by zero", line)
+	if signed {
+		b := ctx.builder
+		min := b.NewICmp(enum.IPredEQ, left, constant.NewInt(t, -1<<(t.BitSize-1)))
+		minusOne := b.NewICmp(enum.IPredEQ, right, constant.NewInt(t, -1))
+		arithCheck(ctx, b.NewAnd(min, minusOne), "integer overflow", line)
+	}
+}
+
+// arithCheck aborts with msg when failed is true and continues in a new
+// block otherwise.
+func arithCheck(ctx *CompilerContext, failed value.Value, msg string, line int) {
+	ok := ctx.NewBlock("arith.ok")
+	fail := ctx.NewBlock("arith.fail")
+	ctx.builder.NewCondBr(failed, fail, ok)
+	fail.NewCall(arithFailFunc(ctx), sourceLocation(ctx, line), stringConstant(ctx, msg))
+	fail.NewUnreachable()
+	ctx.builder = ok
+}
+
+// operandType is the type both operands of a binary operator are
+// converted to. A literal takes the type of the other operand, so that
+// b + 1 stays a u8 when b is one; otherwise the wider type wins.
+func operandType(left, right value.Value) types.Type {
+	if isLiteralOf(right, left.Type()) {
+		return left.Type()
+	}
+	if isLiteralOf(left, right.Type()) {
+		return right.Type()
+	}
+	return widerType(left.Type(), right.Type())
+}
+
+// isLiteralOf reports whether v is a numeric constant that can take the
+// numeric type t: integers become integers and floats floats.
+func isLiteralOf(v value.Value, t types.Type) bool {
+	switch v.(type) {
+	case *constant.Int:
+		it, ok := t.(*types.IntType)
+		return ok && it.BitSize > 1 && !v.Type().Equal(types.I1)
+	case *constant.Float:
+		return isFloat(t)
+	}
+	return false
+}
+
+// unsignedOperands reports whether an operator on left and right, both
+// converted to typ, works on unsigned integers. As in C, an unsigned
+// operand makes the operation unsigned unless the other operand is wider.
+func unsignedOperands(ctx *CompilerContext, left, right value.Value, typ types.Type) bool {
+	if t, ok := typ.(*types.IntType); !ok || t.BitSize == 1 {
+		return false
+	}
+	return (ctx.unsigned[left] && left.Type().Equal(typ)) || (ctx.unsigned[right] && right.Type().Equal(typ))
+}
+
+// compileCast lowers v as T. Integers are truncated or extended according
+// to the signedness of v, floats and integers convert numerically, and
+// anything nonzero becomes true. A cast always yields a new value so that
+// the sign of the result does not leak into other uses of v.
+func compileCast(e *parser.Cast, ctx *CompilerContext) value.Value {
+	v := compileExpr(e.Expr, ctx)
+	if v == nil {
+		return nil
+	}
+	to, ok := typeFromAnnotation(ctx, e.Type)
+	if !ok || !isNumeric(to) || !isNumeric(v.Type()) {
+		return nil
+	}
+	b := ctx.builder
+	var r value.Value
+	switch {
+	case to.Equal(types.I1):
+		r = toBool(ctx, v)
+	case v.Type().Equal(to):
+		r = b.NewBitCast(v, to)
+	case isFloat(v.Type()) && isUnsignedType(e.Type):
+		r = b.NewFPToUI(v, to)
+	default:
+		r = convertValue(ctx, v, to)
+	}
+	if r == v {
+		r = b.NewBitCast(v, to)
+	}
+	if isUnsignedType(e.Type) {
+		ctx.unsigned[r] = true
+	}
+	return r
+}
+
+func isFloat(t types.Type) bool {
+	_, ok := t.(*types.FloatType)
+	return ok
+}
+
+func isNumeric(t types.Type) bool {
+	_, isInt := t.(*types.IntType)
+	return isInt || isFloat(t)
+}
+
+// widerType picks the type both operands are converted to: floats win over
+// integers and wider types over narrower ones.
+func widerType(a, b types.Type) types.Type {
+	if a.Equal(b) {
+		return a
+	}
+	af, _ := a.(*types.FloatType)
+	bf, _ := b.(*types.FloatType)
+	ai, _ := a.(*types.IntType)
+	bi, _ := b.(*types.IntType)
+	switch {
+	case af != nil && bf != nil:
+		if af.Kind == types.FloatKindDouble {
+			return a
+		}
+		return b
+	case af != nil && bi != nil:
+		return a
+	case bf != nil && ai != nil:
+		return b
+	case ai != nil && bi != nil:
+		if ai.BitSize >= bi.BitSize {
+			return a
+		}
+		return b
+	}
+	return a
+}
+
+// convertValue converts v to typ where a numeric conversion exists and
+// returns v unchanged otherwise.
+func convertValue(ctx *CompilerContext, v value.Value, typ types.Type) value.Value {
+	from := v.Type()
+	if from.Equal(typ) {
+		return v
+	}
+	b := ctx.builder
+	if ctx.isString(from) && typ.Equal(i8Ptr) {
+		return stringData(b, v)
+	}
+	fromInt, fromIsInt := from.(*types.IntType)
+	toInt, toIsInt := typ.(*types.IntType)
+	switch {
+	case fromIsInt && toIsInt:
+		if fromInt.BitSize < toInt.BitSize {
+			if fromInt.BitSize == 1 || ctx.unsigned[v] {
+				return b.NewZExt(v, typ)
+			}
+			return b.NewSExt(v, typ)
+		}
+		if toInt.BitSize == 1 {
+			return toBool(ctx, v)
+		}
+		return b.NewTrunc(v, typ)
+	case fromIsInt && isFloat(typ):
+		if ctx.unsigned[v] {
+			return b.NewUIToFP(v, typ)
+		}
+		return b.NewSIToFP(v, typ)
+	case isFloat(from) && toIsInt:
+		return b.NewFPToSI(v, typ)
+	case isFloat(from) && isFloat(typ):
+		if from.(*types.FloatType).Kind == types.FloatKindDouble {
+			return b.NewFPTrunc(v, typ)
+		}
+		return b.NewFPExt(v, typ)
+	}
+	return v
+}
+
+// toBool turns a value into an i1 truth value, treating zero as false.
+func toBool(ctx *CompilerContext, v value.Value) value.Value {
+	switch t := v.Type().(type) {
+	case *types.IntType:
+		if t.BitSize == 1 {
+			return v
+		}
+		return ctx.builder.NewICmp(enum.IPredNE, v, constant.NewInt(t, 0))
+	case *types.FloatType:
+		return ctx.builder.NewFCmp(enum.FPredUNE, v, constant.NewFloat(t, 0))
+	case *types.PointerType:
+		return ctx.builder.NewICmp(enum.IPredNE, v, constant.NewNull(t))
+	}
+	return constant.True
+}
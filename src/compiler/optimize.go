@@ -0,0 +1,319 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The optimizer runs passes over the IR of a module in process, before it
+// is written out for llc:
+//
+//	mem2reg      turns stack slots that are only loaded and stored into SSA values
+//	fold         folds instructions and branches on constants, and propagates the results
+//	inline       inlines calls to small functions that are not recursive
+//	dce          removes instructions whose results are never used
+//	simplifycfg  removes unreachable blocks and merges blocks that always follow each other
+//
+// The -O level decides which passes run and how small a function must be to
+// be inlined, much as with clang. Unrolling and vectorizing loops are left
+// to LLVM; a pipeline can mark the loops of a module so that LLVM does
+// neither, and its functions so that LLVM does not inline them either.
+
+// Pipeline is the passes run over a module, and their settings.
+type Pipeline struct {
+	// Passes are run in order, each over every function of the module.
+	Passes []string
+	// InlineThreshold is the size, in instructions, up to which inline
+	// inlines a function.
+	InlineThreshold int
+	// NoInline skips inline and marks every function noinline.
+	NoInline bool
+	// NoUnroll and NoVectorize mark every loop so that LLVM does not
+	// unroll or vectorize it.
+	NoUnroll    bool
+	NoVectorize bool
+}
+
+// ParsePipeline returns the pipeline of an -O level: "0" to "3", "s" to
+// optimize for size or "z" to do so even at the cost of speed.
+func ParsePipeline(level string) (Pipeline, error) {
+	cleanup := []string{"mem2reg", "fold", "dce", "simplifycfg"}
+	full := []string{"mem2reg", "fold", "simplifycfg", "inline", "fold", "dce", "simplifycfg"}
+	switch level {
+	case "0":
+		return Pipeline{}, nil
+	case "1":
+		return Pipeline{Passes: cleanup}, nil
+	case "2":
+		return Pipeline{Passes: full, InlineThreshold: 40}, nil
+	case "3":
+		return Pipeline{Passes: full, InlineThreshold: 120}, nil
+	case "s":
+		return Pipeline{Passes: full, InlineThreshold: 15}, nil
+	case "z":
+		return Pipeline{Passes: full, InlineThreshold: 5}, nil
+	}
+	return Pipeline{}, fmt.Errorf("unknown optimization level '%s' (use 0, 1, 2, 3, s or z)", level)
+}
+
+// funcPasses are the passes that work on one function at a time.
+var funcPasses = map[string]func(fn *ir.Func){
+	"mem2reg":     mem2reg,
+	"fold":        fold,
+	"dce":         dce,
+	"simplifycfg": simplifyCFG,
+}
+
+// Optimize runs p over m.
+func Optimize(m *ir.Module, p Pipeline) {
+	for _, pass := range p.Passes {
+		if pass == "inline" {
+			if !p.NoInline {
+				inline(m, p.InlineThreshold)
+			}
+			continue
+		}
+		run, ok := funcPasses[pass]
+		if !ok {
+			panic(fmt.Sprintf("unknown optimization pass %q", pass))
+		}
+		for _, fn := range m.Funcs {
+			if len(fn.Blocks) > 0 {
+				run(fn)
+			}
+		}
+	}
+	for _, fn := range m.Funcs {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+		if p.NoInline && !hasFuncAttr(fn, enum.FuncAttrNoInline) {
+			fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoInline)
+		}
+		if p.NoUnroll || p.NoVectorize {
+			markLoops(m, fn, p.NoUnroll, p.NoVectorize)
+		}
+		resetLocalIDs(fn)
+	}
+}
+
+func hasFuncAttr(fn *ir.Func, attr enum.FuncAttr) bool {
+	for _, a := range fn.FuncAttrs {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// markLoops attaches llvm.loop metadata to the branches that close the
+// loops of fn, disabling unrolling and vectorization as asked.
+func markLoops(m *ir.Module, fn *ir.Func, noUnroll, noVectorize bool) {
+	preds, _ := blockGraph(fn)
+	idom := dominators(fn.Blocks[0], preds)
+	loops := make(map[*ir.Block]*metadata.Tuple)
+	for _, b := range fn.Blocks {
+		if b.Term == nil {
+			continue
+		}
+		for _, s := range termSuccs(b.Term) {
+			if s == nil || !dominates(idom, s, b) {
+				continue
+			}
+			loop := loops[s]
+			if loop == nil {
+				loop = &metadata.Tuple{MetadataID: -1, Distinct: true}
+				loop.Fields = []metadata.Field{loop}
+				m.MetadataDefs = append(m.MetadataDefs, loop)
+				if noUnroll {
+					loop.Fields = append(loop.Fields, loopHint(m, &metadata.String{Value: "llvm.loop.unroll.disable"}))
+				}
+				if noVectorize {
+					loop.Fields = append(loop.Fields, loopHint(m, &metadata.String{Value: "llvm.loop.vectorize.enable"}, constant.False))
+				}
+				loops[s] = loop
+			}
+			attachment := &metadata.Attachment{Name: "llvm.loop", Node: loop}
+			switch term := b.Term.(type) {
+			case *ir.TermBr:
+				term.Metadata = append(term.Metadata, attachment)
+			case *ir.TermCondBr:
+				term.Metadata = append(term.Metadata, attachment)
+			case *ir.TermSwitch:
+				term.Metadata = append(term.Metadata, attachment)
+			}
+			break
+		}
+	}
+}
+
+func loopHint(m *ir.Module, fields ...metadata.Field) *metadata.Tuple {
+	hint := &metadata.Tuple{MetadataID: -1, Fields: fields}
+	m.MetadataDefs = append(m.MetadataDefs, hint)
+	return hint
+}
+
+// blockGraph returns the predecessors and successors of the blocks of fn,
+// one entry per edge.
+func blockGraph(fn *ir.Func) (preds, succs map[*ir.Block][]*ir.Block) {
+	preds = make(map[*ir.Block][]*ir.Block)
+	succs = make(map[*ir.Block][]*ir.Block)
+	for _, b := range fn.Blocks {
+		if b.Term == nil {
+			continue
+		}
+		for _, s := range termSuccs(b.Term) {
+			if s != nil {
+				preds[s] = append(preds[s], b)
+				succs[b] = append(succs[b], s)
+			}
+		}
+	}
+	return preds, succs
+}
+
+// replaceUses makes every operand of fn that repl maps refer to what it
+// maps to instead, following chains of replacements.
+func replaceUses(fn *ir.Func, repl map[value.Value]value.Value) {
+	if len(repl) == 0 {
+		return
+	}
+	resolve := func(v value.Value) value.Value {
+		for {
+			r, ok := repl[v]
+			if !ok {
+				return v
+			}
+			v = r
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, inst := range b.Insts {
+			for _, op := range inst.Operands() {
+				if _, ok := repl[*op]; ok {
+					*op = resolve(*op)
+				}
+			}
+		}
+		if b.Term != nil {
+			for _, op := range b.Term.Operands() {
+				if _, ok := repl[*op]; ok {
+					*op = resolve(*op)
+				}
+			}
+		}
+	}
+}
+
+// removeInsts drops the instructions of b in dead.
+func removeInsts(b *ir.Block, dead map[ir.Instruction]bool) {
+	kept := b.Insts[:0]
+	for _, inst := range b.Insts {
+		if !dead[inst] {
+			kept = append(kept, inst)
+		}
+	}
+	for i := len(kept); i < len(b.Insts); i++ {
+		b.Insts[i] = nil
+	}
+	b.Insts = kept
+}
+
+// phis returns the phis at the start of b.
+func phis(b *ir.Block) []*ir.InstPhi {
+	var out []*ir.InstPhi
+	for _, inst := range b.Insts {
+		phi, ok := inst.(*ir.InstPhi)
+		if !ok {
+			break
+		}
+		out = append(out, phi)
+	}
+	return out
+}
+
+// dropIncoming removes the values the phis of b take when coming from
+// pred.
+func dropIncoming(b, pred *ir.Block) {
+	for _, phi := range phis(b) {
+		kept := phi.Incs[:0]
+		for _, inc := range phi.Incs {
+			if inc.Pred != pred {
+				kept = append(kept, inc)
+			}
+		}
+		phi.Incs = kept
+	}
+}
+
+// removeUnreachable drops the blocks of fn that cannot be reached from its
+// entry, along with the values phis take from them.
+func removeUnreachable(fn *ir.Func) {
+	reached := map[*ir.Block]bool{fn.Blocks[0]: true}
+	work := []*ir.Block{fn.Blocks[0]}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		if b.Term == nil {
+			continue
+		}
+		for _, s := range termSuccs(b.Term) {
+			if s != nil && !reached[s] {
+				reached[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	if len(reached) == len(fn.Blocks) {
+		return
+	}
+	kept := fn.Blocks[:0]
+	var dropped []*ir.Block
+	for _, b := range fn.Blocks {
+		if reached[b] {
+			kept = append(kept, b)
+		} else {
+			dropped = append(dropped, b)
+		}
+	}
+	fn.Blocks = kept
+	for _, d := range dropped {
+		if d.Term == nil {
+			continue
+		}
+		for _, s := range termSuccs(d.Term) {
+			if s != nil && reached[s] {
+				dropIncoming(s, d)
+			}
+		}
+	}
+}
+
+// resetLocalIDs clears the IDs of the unnamed values and blocks of fn, so
+// that llir numbers them afresh when the module is printed.
+func resetLocalIDs(fn *ir.Func) {
+	type local interface {
+		IsUnnamed() bool
+		SetID(id int64)
+	}
+	reset := func(v interface{}) {
+		if l, ok := v.(local); ok && l.IsUnnamed() {
+			l.SetID(0)
+		}
+	}
+	for _, p := range fn.Params {
+		reset(p)
+	}
+	for _, b := range fn.Blocks {
+		reset(b)
+		for _, inst := range b.Insts {
+			reset(inst)
+		}
+		reset(b.Term)
+	}
+}
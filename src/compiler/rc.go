@@ -0,0 +1,599 @@
+package compiler
+
+import (
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Reference counting frees the heap values a program stops using. Array
+// headers, structs, closure environments and the bytes of strings are
+// allocated by aether.rc.alloc behind a header { i64 count, void (i8*)*
+// drop }, and a value points just past the header. When aether.rc.release
+// drops the count to zero it calls drop, which releases what the object
+// holds, and frees the object. String literals and environments on the
+// stack have a negative count and are never freed.
+//
+// Ownership follows implicit borrowing. A variable owns its value, and so
+// does the array, struct or environment holding one: storing a value
+// retains it and releases the value it replaces. A value an expression
+// makes, such as a new array or what a call returns, belongs to the
+// statement until a store takes it over, and is released at the end of the
+// statement otherwise. Everything else is borrowed: arguments, values read
+// from variables, elements and fields, and the bindings analysis.Ownership
+// finds to only borrow, which are not counted at all. A block releases the
+// variables it declares when it ends, and a function all of its variables
+// when it returns. A variable a lambda captures and assigns lives in a
+// cell, a counted object holding just the variable, which the function and
+// the environments of its lambdas share.
+//
+// Every build counts the objects alive, which costs an add next to each
+// malloc and free. Debug builds release the module-level variables when
+// main returns and report the objects still alive on stderr.
+
+// rcHeader returns the header in front of every counted object.
+func (c *CompilerContext) rcHeader() *types.StructType {
+	if c.rcType == nil {
+		c.rcType = types.NewStruct(types.I64, dropType)
+		c.module.NewTypeDef("aether.rc", c.rcType)
+	}
+	return c.rcType
+}
+
+// dropType is the type of the drop function of an object, which gets the
+// object.
+var dropType = types.NewPointer(types.NewFunc(types.Void, i8Ptr))
+
+// isCounted reports whether values of type t refer to counted objects:
+// strings, arrays, structs, closures and cells, and tuples holding one.
+func (c *CompilerContext) isCounted(t types.Type) bool {
+	if c.isString(t) {
+		return true
+	}
+	if _, ok := c.arrayElemType(t); ok {
+		return true
+	}
+	if _, ok := c.structInfoOf(t); ok {
+		return true
+	}
+	if _, ok := c.closureSig(t); ok {
+		return true
+	}
+	if _, ok := c.cellElem(t); ok {
+		return true
+	}
+	if st, ok := t.(*types.StructType); ok && isTuple(st) {
+		for _, f := range st.Fields {
+			if c.isCounted(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rcObject returns the object v refers to as an i8*.
+func rcObject(ctx *CompilerContext, block *ir.Block, v value.Value) value.Value {
+	if ctx.isString(v.Type()) {
+		return stringData(block, v)
+	}
+	if _, ok := ctx.closureSig(v.Type()); ok {
+		return block.NewExtractValue(v, 1)
+	}
+	return block.NewBitCast(v, i8Ptr)
+}
+
+// retain and release count a reference to every object v refers to more
+// or less, in block. Constants only refer to objects that are never freed.
+func retain(ctx *CompilerContext, block *ir.Block, v value.Value) {
+	rcUpdate(ctx, block, v, rcRetainFunc(ctx))
+}
+
+func release(ctx *CompilerContext, block *ir.Block, v value.Value) {
+	rcUpdate(ctx, block, v, rcReleaseFunc(ctx))
+}
+
+func rcUpdate(ctx *CompilerContext, block *ir.Block, v value.Value, fn *ir.Func) {
+	if _, ok := v.(constant.Constant); ok || !ctx.isCounted(v.Type()) {
+		return
+	}
+	if st, ok := v.Type().(*types.StructType); ok && isTuple(st) {
+		for i, f := range st.Fields {
+			if ctx.isCounted(f) {
+				rcUpdate(ctx, block, block.NewExtractValue(v, uint64(i)), fn)
+			}
+		}
+		return
+	}
+	block.NewCall(fn, rcObject(ctx, block, v))
+}
+
+// rcAlloc allocates a counted object of size bytes with a count of one.
+// drop is called with the object before it is freed, and may be null.
+func rcAlloc(ctx *CompilerContext, block *ir.Block, size value.Value, drop constant.Constant) value.Value {
+	return block.NewCall(rcAllocFunc(ctx), size, drop)
+}
+
+// rcHeaderOf returns the header of the object at obj.
+func rcHeaderOf(ctx *CompilerContext, block *ir.Block, obj value.Value) value.Value {
+	hdr := ctx.rcHeader()
+	return block.NewGetElementPtr(hdr, block.NewBitCast(obj, types.NewPointer(hdr)), constant.NewInt(types.I64, -1))
+}
+
+func rcField(ctx *CompilerContext, block *ir.Block, hdr value.Value, field int64) value.Value {
+	return block.NewGetElementPtr(ctx.rcHeader(), hdr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// rcLive returns the number of objects alive, which every module shares.
+func rcLive(ctx *CompilerContext) *ir.Global {
+	for _, g := range ctx.module.Globals {
+		if g.Name() == "aether.rc.live" {
+			return g
+		}
+	}
+	g := ctx.module.NewGlobalDef("aether.rc.live", constant.NewInt(types.I64, 0))
+	g.Linkage = enum.LinkageLinkOnceODR
+	return g
+}
+
+func countLive(ctx *CompilerContext, block *ir.Block, delta int64) {
+	live := rcLive(ctx)
+	n := block.NewLoad(types.I64, live)
+	block.NewStore(block.NewAdd(n, constant.NewInt(types.I64, delta)), live)
+}
+
+// rcAllocFunc returns aether.rc.alloc(size, drop).
+func rcAllocFunc(ctx *CompilerContext) *ir.Func {
+	size := ir.NewParam("size", types.I64)
+	drop := ir.NewParam("drop", dropType)
+	fn, fresh := newRuntimeFunc(ctx, "aether.rc.alloc", i8Ptr, size, drop)
+	if !fresh {
+		return fn
+	}
+	hdrType := ctx.rcHeader()
+	entry := fn.NewBlock("entry")
+	raw := rtAlloc(ctx, entry, entry.NewAdd(size, sizeOf(hdrType)))
+	hdr := entry.NewBitCast(raw, types.NewPointer(hdrType))
+	entry.NewStore(constant.NewInt(types.I64, 1), rcField(ctx, entry, hdr, 0))
+	entry.NewStore(drop, rcField(ctx, entry, hdr, 1))
+	countLive(ctx, entry, 1)
+	obj := entry.NewGetElementPtr(hdrType, hdr, constant.NewInt(types.I64, 1))
+	entry.NewRet(entry.NewBitCast(obj, i8Ptr))
+	return fn
+}
+
+// rcRetainFunc returns aether.rc.retain(obj), which counts one more
+// reference to obj unless it is null or never freed.
+func rcRetainFunc(ctx *CompilerContext) *ir.Func {
+	obj := ir.NewParam("obj", i8Ptr)
+	fn, fresh := newRuntimeFunc(ctx, "aether.rc.retain", types.Void, obj)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	counted := fn.NewBlock("counted")
+	inc := fn.NewBlock("inc")
+	done := fn.NewBlock("done")
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, obj, constant.NewNull(i8Ptr)), done, counted)
+
+	count := rcField(ctx, counted, rcHeaderOf(ctx, counted, obj), 0)
+	n := counted.NewLoad(types.I64, count)
+	counted.NewCondBr(counted.NewICmp(enum.IPredSLT, n, constant.NewInt(types.I64, 0)), done, inc)
+
+	inc.NewStore(inc.NewAdd(n, constant.NewInt(types.I64, 1)), count)
+	inc.NewBr(done)
+
+	done.NewRet(nil)
+	return fn
+}
+
+// rcReleaseFunc returns aether.rc.release(obj), which counts one reference
+// to obj less and drops and frees it when that was the last one.
+func rcReleaseFunc(ctx *CompilerContext) *ir.Func {
+	obj := ir.NewParam("obj", i8Ptr)
+	fn, fresh := newRuntimeFunc(ctx, "aether.rc.release", types.Void, obj)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	counted := fn.NewBlock("counted")
+	shared := fn.NewBlock("shared")
+	dec := fn.NewBlock("dec")
+	last := fn.NewBlock("last")
+	drop := fn.NewBlock("drop")
+	free := fn.NewBlock("free")
+	done := fn.NewBlock("done")
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, obj, constant.NewNull(i8Ptr)), done, counted)
+
+	hdr := rcHeaderOf(ctx, counted, obj)
+	count := rcField(ctx, counted, hdr, 0)
+	n := counted.NewLoad(types.I64, count)
+	counted.NewCondBr(counted.NewICmp(enum.IPredEQ, n, constant.NewInt(types.I64, 1)), last, shared)
+
+	shared.NewCondBr(shared.NewICmp(enum.IPredSLT, n, constant.NewInt(types.I64, 0)), done, dec)
+
+	dec.NewStore(dec.NewSub(n, constant.NewInt(types.I64, 1)), count)
+	dec.NewBr(done)
+
+	dropFn := last.NewLoad(dropType, rcField(ctx, last, hdr, 1))
+	last.NewCondBr(last.NewICmp(enum.IPredEQ, dropFn, constant.NewNull(dropType)), free, drop)
+
+	drop.NewCall(dropFn, obj)
+	drop.NewBr(free)
+
+	free.NewCall(rtFree(ctx), free.NewBitCast(hdr, i8Ptr))
+	countLive(ctx, free, -1)
+	free.NewBr(done)
+
+	done.NewRet(nil)
+	return fn
+}
+
+// rcReportFunc returns aether.rc.report(), which writes how many objects
+// are still alive to stderr unless none are.
+func rcReportFunc(ctx *CompilerContext) *ir.Func {
+	fn, fresh := newRuntimeFunc(ctx, "aether.rc.report", types.Void)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	leaked := fn.NewBlock("leaked")
+	done := fn.NewBlock("done")
+	n := entry.NewLoad(types.I64, rcLive(ctx))
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, n, constant.NewInt(types.I64, 0)), done, leaked)
+
+	leaked.NewCall(rtFflush(ctx), constant.NewNull(i8Ptr))
+	leaked.NewCall(rtDprintf(ctx), constant.NewInt(types.I32, 2), stringConstant(ctx, "leak check: %lld objects still allocated at exit\n"), n)
+	leaked.NewBr(done)
+
+	done.NewRet(nil)
+	return fn
+}
+
+// forEachElem emits a loop over the n elements at data, which runs body in
+// the loop for each of them, and returns the block after the loop.
+func forEachElem(fn *ir.Func, block *ir.Block, elem types.Type, data, n value.Value, body func(b *ir.Block, v value.Value)) *ir.Block {
+	cond := fn.NewBlock("cond")
+	loop := fn.NewBlock("loop")
+	done := fn.NewBlock("done")
+	block.NewBr(cond)
+
+	i := cond.NewPhi(ir.NewIncoming(constant.NewInt(types.I64, 0), block))
+	cond.NewCondBr(cond.NewICmp(enum.IPredULT, i, n), loop, done)
+
+	body(loop, loop.NewLoad(elem, loop.NewGetElementPtr(elem, data, i)))
+	next := loop.NewAdd(i, constant.NewInt(types.I64, 1))
+	i.Incs = append(i.Incs, ir.NewIncoming(next, loop))
+	loop.NewBr(cond)
+	return done
+}
+
+// elemsRetainFunc returns aether.retain.<T>(data, n), which retains the n
+// elements at data after they were copied into another array.
+func elemsRetainFunc(ctx *CompilerContext, elem types.Type) *ir.Func {
+	data := ir.NewParam("data", types.NewPointer(elem))
+	n := ir.NewParam("n", types.I64)
+	fn, fresh := newRuntimeFunc(ctx, "aether.retain."+typeKey(elem), types.Void, data, n)
+	if !fresh {
+		return fn
+	}
+	done := forEachElem(fn, fn.NewBlock("entry"), elem, data, n, func(b *ir.Block, v value.Value) {
+		retain(ctx, b, v)
+	})
+	done.NewRet(nil)
+	return fn
+}
+
+// arrayDropFunc returns aether.drop.<array type>(obj), which releases the
+// elements of an array and frees their storage.
+func arrayDropFunc(ctx *CompilerContext, elem types.Type) *ir.Func {
+	arrType := ctx.arrayType(elem)
+	obj := ir.NewParam("obj", i8Ptr)
+	fn, fresh := newRuntimeFunc(ctx, "aether.drop."+typeKey(arrType.ElemType), types.Void, obj)
+	if !fresh {
+		return fn
+	}
+	block := fn.NewBlock("entry")
+	arr := block.NewBitCast(obj, arrType)
+	data := arrayField(block, arr, arrayDataField)
+	if ctx.isCounted(elem) {
+		block = forEachElem(fn, block, elem, data, arrayField(block, arr, arrayLenField), func(b *ir.Block, v value.Value) {
+			release(ctx, b, v)
+		})
+	}
+	block.NewCall(rtFree(ctx), block.NewBitCast(data, i8Ptr))
+	block.NewRet(nil)
+	return fn
+}
+
+// fieldsDrop returns the drop function of a struct or environment of type
+// st, aether.drop.<T>(obj), which releases its fields. It is null when no
+// field needs releasing.
+func fieldsDrop(ctx *CompilerContext, st *types.StructType) constant.Constant {
+	counted := false
+	for _, f := range st.Fields {
+		counted = counted || ctx.isCounted(f)
+	}
+	if !counted {
+		return constant.NewNull(dropType)
+	}
+	obj := ir.NewParam("obj", i8Ptr)
+	fn, fresh := newRuntimeFunc(ctx, "aether.drop."+typeKey(st), types.Void, obj)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	ptr := entry.NewBitCast(obj, types.NewPointer(st))
+	for i, f := range st.Fields {
+		if ctx.isCounted(f) {
+			field := entry.NewGetElementPtr(st, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			release(ctx, entry, entry.NewLoad(f, field))
+		}
+	}
+	entry.NewRet(nil)
+	return fn
+}
+
+// immortal defines a never freed object holding data: a global with a
+// negative count in its header.
+func immortal(ctx *CompilerContext, name string, data constant.Constant) *ir.Global {
+	hdr := constant.NewStruct(ctx.rcHeader(), constant.NewInt(types.I64, -1), constant.NewNull(dropType))
+	glob := ctx.module.NewGlobalDef(ctx.uniqueGlobal(name), constant.NewStruct(types.NewStruct(ctx.rcHeader(), data.Type()), hdr, data))
+	glob.Linkage = enum.LinkagePrivate
+	glob.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
+	glob.Immutable = true
+	return glob
+}
+
+// own records that the statement being compiled owns v, a value it just
+// made, and returns v.
+func (c *CompilerContext) own(v value.Value) value.Value {
+	if _, ok := v.(constant.Constant); ok || v == nil || !c.isCounted(v.Type()) {
+		return v
+	}
+	c.temps = append(c.temps, v)
+	return v
+}
+
+// take makes v the value of a variable, element or field, which owns it
+// from then on. A value the statement owns moves there; anything else is
+// retained.
+func (c *CompilerContext) take(v value.Value) {
+	for i := len(c.temps) - 1; i >= 0; i-- {
+		if c.temps[i] == v {
+			c.temps = append(c.temps[:i], c.temps[i+1:]...)
+			return
+		}
+	}
+	retain(c, c.builder, v)
+}
+
+// hold has the statement being compiled own a reference to v, for code
+// that may release what v refers to before it is done with v.
+func (c *CompilerContext) hold(v value.Value) {
+	if _, ok := v.(constant.Constant); ok || !c.isCounted(v.Type()) {
+		return
+	}
+	for _, t := range c.temps {
+		if t == v {
+			return
+		}
+	}
+	retain(c, c.builder, v)
+	c.temps = append(c.temps, v)
+}
+
+// holdArg holds v for a call borrowing it. The callee cannot assign the
+// variables of the caller, but it may replace an element, a field, a global
+// or a variable shared with a lambda it was read from.
+func (c *CompilerContext) holdArg(v value.Value) {
+	if load, ok := v.(*ir.InstLoad); ok {
+		if _, local := load.Src.(*ir.InstAlloca); local {
+			return
+		}
+	}
+	c.hold(v)
+}
+
+// releaseTemps releases the values made since len(temps) was mark that
+// nothing took over, for leaving the statements that made them early.
+// endTemps releases them where those statements end, and forgets them.
+func (c *CompilerContext) releaseTemps(mark int) {
+	for _, v := range c.temps[mark:] {
+		release(c, c.builder, v)
+	}
+}
+
+func (c *CompilerContext) endTemps(mark int) {
+	if c.builder != nil && c.builder.Term == nil {
+		c.releaseTemps(mark)
+	}
+	c.temps = c.temps[:mark]
+}
+
+// store stores v into the variable, element or field at ptr, which takes
+// it over and releases the value it held. Bindings that borrow just hold
+// v.
+func (c *CompilerContext) store(v, ptr value.Value) {
+	if !c.isCounted(v.Type()) || c.borrowed[ptr] {
+		c.builder.NewStore(v, ptr)
+		return
+	}
+	old := c.builder.NewLoad(v.Type(), ptr)
+	c.take(v)
+	c.builder.NewStore(v, ptr)
+	release(c, c.builder, old)
+}
+
+// ownedLocal allocates the slot of a variable that owns its value. The slot
+// starts out empty, so that the first store has nothing to release, and the
+// function releases what it holds when it returns.
+func (c *CompilerContext) ownedLocal(name string, t types.Type) *ir.InstAlloca {
+	slot := c.NewLocal(name, t)
+	if !c.isCounted(t) {
+		return slot
+	}
+	entry := c.current_func.Blocks[0]
+	init := ir.NewStore(zeroValue(t), slot)
+	entry.Insts = append([]ir.Instruction{slot, init}, entry.Insts[1:]...)
+	c.origins[init] = c.pos()
+	c.locals = append(c.locals, slot)
+	return slot
+}
+
+// localSlot allocates the slot of the parameter or variable name that decl
+// declares: a cell when it is shared with lambdas, a slot that borrows or
+// one that owns its value otherwise. arg is as for recordVar.
+func (c *CompilerContext) localSlot(decl parser.Node, name string, t types.Type, arg int) value.Value {
+	slotName := name
+	if arg > 0 {
+		slotName += ".addr"
+	}
+	if c.ownership.Shared(decl) {
+		return c.sharedLocal(name, t)
+	}
+	var slot *ir.InstAlloca
+	if id, ok := decl.(*parser.Identifier); ok && c.ownership.Borrows(id) {
+		slot = c.borrowedLocal(slotName, t)
+	} else {
+		slot = c.ownedLocal(slotName, t)
+	}
+	c.recordVar(slot, name, arg)
+	return slot
+}
+
+// cellType returns the type of the cells holding variables of type t.
+func (c *CompilerContext) cellType(t types.Type) *types.StructType {
+	key := typeKey(t)
+	if st, ok := c.cellTypes[key]; ok {
+		return st
+	}
+	st := types.NewStruct(t)
+	c.module.NewTypeDef("aether.cell."+key, st)
+	c.cellTypes[key] = st
+	return st
+}
+
+// cellElem reports the type of the variable that a pointer of type t to a
+// cell holds.
+func (c *CompilerContext) cellElem(t types.Type) (types.Type, bool) {
+	ptr, ok := t.(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+	st, ok := ptr.ElemType.(*types.StructType)
+	if !ok || c.cellTypes[typeKey(st.Fields[0])] != st {
+		return nil, false
+	}
+	return st.Fields[0], true
+}
+
+// sharedLocal allocates a cell for a variable of type t that lambdas share,
+// and returns a pointer to the variable in it. The cell is made on entry to
+// the function, which releases it when it returns, so every assignment and
+// every lambda uses the same one.
+func (c *CompilerContext) sharedLocal(name string, t types.Type) value.Value {
+	st := c.cellType(t)
+	zero := constant.NewInt(types.I32, 0)
+	obj := ir.NewCall(rcAllocFunc(c), sizeOf(st), fieldsDrop(c, st))
+	cell := ir.NewBitCast(obj, types.NewPointer(st))
+	cell.SetName(c.uniqueLocal(name + ".cell"))
+	slot := ir.NewGetElementPtr(st, cell, zero, zero)
+	init := ir.NewStore(zeroValue(t), slot)
+	entry := c.current_func.Blocks[0]
+	insts := []ir.Instruction{obj, cell, slot, init}
+	entry.Insts = append(insts, entry.Insts...)
+	for _, inst := range insts {
+		c.origins[inst] = c.pos()
+	}
+	c.cells[slot] = cell
+	c.shared = append(c.shared, cell)
+	return slot
+}
+
+// cellOf returns the cell holding the variable at slot, if it is shared.
+func (c *CompilerContext) cellOf(slot value.Value) (value.Value, bool) {
+	cell, ok := c.cells[slot]
+	return cell, ok
+}
+
+// cellSlot returns a pointer to the variable in cell, for a lambda that
+// got cell from its environment.
+func cellSlot(ctx *CompilerContext, cell value.Value) value.Value {
+	st := cell.Type().(*types.PointerType).ElemType
+	zero := constant.NewInt(types.I32, 0)
+	slot := ctx.builder.NewGetElementPtr(st, cell, zero, zero)
+	ctx.cells[slot] = cell
+	return slot
+}
+
+// borrowedLocal allocates the slot of a variable that only borrows its
+// value.
+func (c *CompilerContext) borrowedLocal(name string, t types.Type) *ir.InstAlloca {
+	slot := c.NewLocal(name, t)
+	c.borrowed[slot] = true
+	return slot
+}
+
+// releaseScope releases the variables the innermost scope declared, as it
+// ends, and empties them for the function not to release them again.
+func (c *CompilerContext) releaseScope() {
+	if c.builder.Term != nil {
+		return
+	}
+	declared := make(map[value.Value]bool)
+	for _, v := range c.scopes[len(c.scopes)-1] {
+		declared[v] = true
+	}
+	for _, slot := range c.locals {
+		if declared[slot] {
+			release(c, c.builder, c.builder.NewLoad(slot.ElemType, slot))
+			c.builder.NewStore(zeroValue(slot.ElemType), slot)
+		}
+	}
+}
+
+// leaveFunction releases what the current function owns before it returns
+// ret, which goes to the caller instead: the values of the statements
+// being compiled and the variables. Returning from main ends the program,
+// so debug builds release the module-level variables of the program and
+// report leaks there.
+func (c *CompilerContext) leaveFunction(ret value.Value) {
+	if ret != nil && c.isCounted(ret.Type()) {
+		c.take(ret)
+	}
+	c.releaseTemps(0)
+	for _, slot := range c.locals {
+		release(c, c.builder, c.builder.NewLoad(slot.ElemType, slot))
+	}
+	for _, cell := range c.shared {
+		release(c, c.builder, cell)
+	}
+	if c.current_func.Name() != "main" || !c.options.Debug {
+		return
+	}
+	if c.moduleInit == c.current_func {
+		for _, g := range c.moduleVars {
+			release(c, c.builder, c.builder.NewLoad(g.ContentType, g))
+		}
+	}
+	for i := len(c.options.InitOrder) - 1; i >= 0; i-- {
+		c.builder.NewCall(getOrCreateExtern(c, moduleFiniName(c.options.InitOrder[i]), types.Void, false))
+	}
+	c.builder.NewCall(rcReportFunc(c))
+}
+
+// compileModuleFini defines the function releasing the module-level
+// variables of the module, which main calls in debug builds.
+func compileModuleFini(ctx *CompilerContext) {
+	fn := ctx.module.NewFunc(moduleFiniName(ctx.options.ModuleName), types.Void)
+	entry := fn.NewBlock("entry")
+	for _, g := range ctx.moduleVars {
+		release(ctx, entry, entry.NewLoad(g.ContentType, g))
+	}
+	entry.NewRet(nil)
+}
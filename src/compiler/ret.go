@@ -6,7 +6,8 @@ import (
 )
 
 func createMainReturn(ctx *CompilerContext) {
-	if ctx.builder != nil {
+	if ctx.builder != nil && ctx.builder.Term == nil {
+		ctx.leaveFunction(nil)
 		ctx.builder.NewRet(constant.NewInt(types.I32, 0))
 	}
 }
@@ -0,0 +1,138 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The runtime support code is emitted straight into each module as
+// linkonce_odr functions, so the linker keeps a single copy and no separate
+// runtime library has to be shipped. Only libc is required.
+
+var i8Ptr = types.NewPointer(types.I8)
+
+// getOrCreateExtern returns the declaration of an external (libc) function,
+// declaring it on first use.
+func getOrCreateExtern(ctx *CompilerContext, name string, ret types.Type, variadic bool, params ...types.Type) *ir.Func {
+	if fn := lookupFunc(ctx, name); fn != nil {
+		return fn
+	}
+	irParams := make([]*ir.Param, len(params))
+	for i, t := range params {
+		irParams[i] = ir.NewParam("", t)
+	}
+	fn := ctx.module.NewFunc(name, ret, irParams...)
+	fn.Sig.Variadic = variadic
+	return fn
+}
+
+func lookupFunc(ctx *CompilerContext, name string) *ir.Func {
+	for _, fn := range ctx.module.Funcs {
+		if fn.Name() == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// newRuntimeFunc defines a runtime helper with linkonce_odr linkage. The
+// returned bool is false when the helper already exists and its body must not
+// be generated again.
+func newRuntimeFunc(ctx *CompilerContext, name string, ret types.Type, params ...*ir.Param) (*ir.Func, bool) {
+	if fn := lookupFunc(ctx, name); fn != nil {
+		return fn, false
+	}
+	fn := ctx.module.NewFunc(name, ret, params...)
+	fn.Linkage = enum.LinkageLinkOnceODR
+	return fn, true
+}
+
+func rtMalloc(ctx *CompilerContext) *ir.Func {
+	return getOrCreateExtern(ctx, "malloc", i8Ptr, false, types.I64)
+}
+
+func rtRealloc(ctx *CompilerContext) *ir.Func {
+	return getOrCreateExtern(ctx, "realloc", i8Ptr, false, i8Ptr, types.I64)
+}
+
+func rtMemcpy(ctx *CompilerContext) *ir.Func {
+	return getOrCreateExtern(ctx, "memcpy", i8Ptr, false, i8Ptr, i8Ptr, types.I64)
+}
+
+func rtFree(ctx *CompilerContext) *ir.Func {
+	return getOrCreateExtern(ctx, "free", types.Void, false, i8Ptr)
+}
+
+func rtAbort(ctx *CompilerContext) *ir.Func {
+	fn := getOrCreateExtern(ctx, "abort", types.Void, false)
+	if len(fn.FuncAttrs) == 0 {
+		fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoReturn)
+	}
+	return fn
+}
+
+func rtFflush(ctx *CompilerContext) *ir.Func {
+	return getOrCreateExtern(ctx, "fflush", types.I32, false, i8Ptr)
+}
+
+func rtDprintf(ctx *CompilerContext) *ir.Func {
+	return getOrCreateExtern(ctx, "dprintf", types.I32, true, types.I32, i8Ptr)
+}
+
+// rtAlloc allocates size bytes of heap memory. Every runtime allocation goes
+// through here so the allocation strategy can change in one place.
+func rtAlloc(ctx *CompilerContext, block *ir.Block, size value.Value) value.Value {
+	return block.NewCall(rtMalloc(ctx), size)
+}
+
+// sizeOf returns the allocation size of t as an i64 constant expression.
+func sizeOf(t types.Type) constant.Constant {
+	ptr := types.NewPointer(t)
+	gep := constant.NewGetElementPtr(t, constant.NewNull(ptr), constant.NewInt(types.I32, 1))
+	return constant.NewPtrToInt(gep, types.I64)
+}
+
+// stringConstant returns an i8* to a private NUL-terminated copy of s.
+func stringConstant(ctx *CompilerContext, s string) constant.Constant {
+	glob, ok := ctx.cstrings[s]
+	if !ok {
+		data := constant.NewCharArrayFromString(s + "\x00")
+		glob = ctx.module.NewGlobalDef(ctx.uniqueGlobal(".str"), data)
+		glob.Linkage = enum.LinkagePrivate
+		glob.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
+		glob.Immutable = true
+		ctx.cstrings[s] = glob
+	}
+	zero := constant.NewInt(types.I64, 0)
+	return constant.NewGetElementPtr(glob.ContentType, glob, zero, zero)
+}
+
+// rtPanic flushes stdout, writes "<where>msg" to stderr and aborts. The
+// format receives the extra arguments after where, so msg may contain printf
+// verbs.
+func rtPanic(ctx *CompilerContext, block *ir.Block, msg string, where value.Value, args ...value.Value) {
+	block.NewCall(rtFflush(ctx), constant.NewNull(i8Ptr))
+	callArgs := []value.Value{constant.NewInt(types.I32, 2), stringConstant(ctx, "%s"+msg+"\n"), where}
+	callArgs = append(callArgs, args...)
+	block.NewCall(rtDprintf(ctx), callArgs...)
+	block.NewCall(rtAbort(ctx))
+	block.NewUnreachable()
+}
+
+// sourceLocation describes line for runtime error messages. Release builds
+// omit it so binaries do not embed source paths.
+func sourceLocation(ctx *CompilerContext, line int) constant.Constant {
+	if !ctx.options.Debug || line == 0 {
+		return stringConstant(ctx, "")
+	}
+	file := ctx.options.SourceFile
+	if file == "" {
+		file = ctx.options.ModuleName
+	}
+	return stringConstant(ctx, fmt.Sprintf("%s:%d: ", file, line))
+}
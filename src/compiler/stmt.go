@@ -1,103 +1,261 @@
 package compiler
 
 import (
+	"aether/src/analysis"
 	"aether/src/parser"
 
+	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
 )
 
+// compileStmt compiles stmt and maps the code it emits to its position,
+// unless a statement nested in it emitted that code. What the enclosing
+// statement emitted before it, such as the condition of a loop, is mapped
+// to the enclosing statement first. The values stmt makes are released
+// where it ends.
 func compileStmt(stmt parser.Statement, ctx *CompilerContext) {
+	pos := analysis.NodePos(stmt)
+	if pos.Line == 0 {
+		pos = ctx.pos()
+	}
+	if len(ctx.stmts) > 0 {
+		ctx.mapOrigins(ctx.current_func)
+	}
+	ctx.stmts = append(ctx.stmts, pos)
+	mark := len(ctx.temps)
+	emitStmt(stmt, ctx)
+	ctx.endTemps(mark)
+	ctx.mapOrigins(ctx.current_func)
+	ctx.stmts = ctx.stmts[:len(ctx.stmts)-1]
+}
+
+func emitStmt(stmt parser.Statement, ctx *CompilerContext) {
 	switch s := stmt.(type) {
 	case *parser.Assignment:
-		val := compileExpr(s.Value, ctx)
-		// For now, only support single assignment for codegen
-		if len(s.Names) > 0 {
-			alloca := ctx.builder.NewAlloca(val.Type())
-			alloca.SetName(s.Names[0].Value)
-			ctx.builder.NewStore(val, alloca)
-			ctx.SetSymbol(s.Names[0].Value, alloca)
+		if len(s.Names) > 1 {
+			compileTupleAssignment(s.Names, s.Value, ctx)
+			return
+		}
+		if defineConstGlobal(ctx, s) {
+			return
+		}
+		var val value.Value
+		switch v := s.Value.(type) {
+		case *parser.Block:
+			val = compileLambda(v, ctx, closureOnStack(ctx, s))
+		case *parser.PartialApplication:
+			val = compilePartialApplication(v, ctx, closureOnStack(ctx, s))
+		default:
+			val = compileExpr(s.Value, ctx)
+		}
+		if len(s.Names) > 0 && val != nil {
+			assignVariable(ctx, s.Names[0], val)
 		}
+	case *parser.ElementAssignment:
+		compileElementAssignment(s, ctx)
 	case *parser.Function:
-		fn := ctx.module.NewFunc(s.Name.Value, types.I32)
-		ctx.SetSymbol(s.Name.Value, fn)
-		block := fn.NewBlock("entry")
-		ctx.builder = block
-		for _, stmt := range s.Body.Statements {
-			compileStmt(stmt, ctx)
+		if s.Name != nil && s.Name.Value != "" {
+			declareFunction(s, ctx)
 		}
-		ctx.builder.NewRet(constant.NewInt(types.I32, 0))
+	case *parser.ForeignFunction:
+		compileForeign(s, ctx)
 	case *parser.StructDef:
-		// Structs are not codegen'd directly in LLVM IR here
+		// The LLVM type is created by the first instantiation.
+		ctx.structDefs[s.Name.Value] = s
 	case *parser.If:
+		mark := len(ctx.temps)
 		cond := compileExpr(s.Condition, ctx)
-		parent := ctx.current_func
-		thenBlock := parent.NewBlock("then")
-		elseBlock := parent.NewBlock("else")
-		mergeBlock := parent.NewBlock("merge")
-		ctx.builder.NewCondBr(cond, thenBlock, elseBlock)
-		ctx.builder = thenBlock
-		for _, stmt := range s.Consequence.Statements {
-			compileStmt(stmt, ctx)
+		if cond == nil {
+			return
 		}
-		ctx.builder.NewBr(mergeBlock)
+		ctx.endTemps(mark)
+		thenBlock := ctx.NewBlock("then")
+		elseBlock := ctx.NewBlock("else")
+		mergeBlock := ctx.NewBlock("merge")
+		ctx.builder.NewCondBr(toBool(ctx, cond), thenBlock, elseBlock)
+		ctx.builder = thenBlock
+		compileBlock(s.Consequence, ctx)
+		branchTo(ctx, mergeBlock)
 		ctx.builder = elseBlock
 		if s.Alternative != nil {
-			for _, stmt := range s.Alternative.Statements {
-				compileStmt(stmt, ctx)
-			}
+			compileBlock(s.Alternative, ctx)
 		}
-		ctx.builder.NewBr(mergeBlock)
+		branchTo(ctx, mergeBlock)
 		ctx.builder = mergeBlock
 	case *parser.While:
-		parent := ctx.current_func
-		condBlock := parent.NewBlock("while.cond")
-		bodyBlock := parent.NewBlock("while.body")
-		endBlock := parent.NewBlock("while.end")
+		condBlock := ctx.NewBlock("while.cond")
+		bodyBlock := ctx.NewBlock("while.body")
+		endBlock := ctx.NewBlock("while.end")
 		ctx.builder.NewBr(condBlock)
 		ctx.builder = condBlock
+		mark := len(ctx.temps)
 		cond := compileExpr(s.Condition, ctx)
-		ctx.builder.NewCondBr(cond, bodyBlock, endBlock)
-		ctx.builder = bodyBlock
-		for _, stmt := range s.Body.Statements {
-			compileStmt(stmt, ctx)
+		if cond == nil {
+			cond = constant.False
 		}
-		ctx.builder.NewBr(condBlock)
+		ctx.endTemps(mark)
+		ctx.builder.NewCondBr(toBool(ctx, cond), bodyBlock, endBlock)
+		ctx.builder = bodyBlock
+		ctx.PushLoop(endBlock, condBlock)
+		compileBlock(s.Body, ctx)
+		ctx.PopLoop()
+		branchTo(ctx, condBlock)
 		ctx.builder = endBlock
 	case *parser.Repeat:
 		// Not implemented: repeat
 	case *parser.For:
-		// Not implemented: for
+		compileFor(s, ctx)
 	case *parser.Block:
-		for _, stmt := range s.Statements {
-			compileStmt(stmt, ctx)
-		}
+		compileBlock(s, ctx)
 	case *parser.Match:
-		// TODO: Proper match/case codegen. For now, just compile all case bodies.
-		for _, c := range s.Cases {
-			compileStmt(c.Body, ctx)
-		}
+		compileMatch(s, ctx)
 	case *parser.Break:
-		// TODO: Proper break codegen. For now, do nothing.
+		if loop, ok := ctx.CurrentLoop(); ok {
+			ctx.releaseTemps(loop.temps)
+			ctx.builder.NewBr(loop.breakBlock)
+			ctx.builder = ctx.NewBlock("after.break")
+		}
 	case *parser.Continue:
-		// TODO: Proper continue codegen. For now, do nothing.
+		if loop, ok := ctx.CurrentLoop(); ok {
+			ctx.releaseTemps(loop.temps)
+			ctx.builder.NewBr(loop.continueBlock)
+			ctx.builder = ctx.NewBlock("after.continue")
+		}
 	case *parser.Return:
+		var val value.Value
 		if s.Value != nil {
-			val := compileExpr(s.Value, ctx)
-			ctx.builder.NewRet(val)
-		} else {
-			ctx.builder.NewRet(constant.NewInt(types.I32, 0))
+			val = compileExpr(s.Value, ctx)
 		}
+		compileReturn(ctx, val)
+		// Anything after a return is unreachable but still needs a block.
+		ctx.builder = ctx.NewBlock("after.return")
 	case *parser.Import:
-		// Handle imports by making symbols available
-		moduleName := s.Name.Value
+		// The module's exports are resolved through ctx.modules, keyed by
+		// the file name of the import path; an alias shares its entry.
+		module, ok := ctx.modules[importedModuleName(s.Name.Value)]
+		if !ok {
+			break
+		}
 		if s.As != nil && s.As.Value != "" {
-			moduleName = s.As.Value
+			ctx.SetModule(s.As.Value, module)
 		}
-		// Create a dummy value for the module symbol
-		dummyValue := constant.NewInt(types.I32, 0)
-		ctx.SetSymbol(moduleName, dummyValue)
+		bindForeignImports(ctx, module)
 	case *parser.ExpressionStatement:
 		compileExpr(s.Expr, ctx)
+	default:
+		// Calls and other expressions used as statements.
+		if expr, ok := stmt.(parser.Expression); ok {
+			compileExpr(expr, ctx)
+		}
 	}
 }
+
+// compileBlock compiles the statements of b in a new lexical scope.
+func compileBlock(b *parser.Block, ctx *CompilerContext) {
+	if b == nil {
+		return
+	}
+	ctx.EnterScope()
+	for _, stmt := range b.Statements {
+		compileStmt(stmt, ctx)
+	}
+	ctx.releaseScope()
+	ctx.ExitScope()
+}
+
+// branchTo ends the current block with a jump to target unless it already
+// ends in a terminator such as return or break.
+func branchTo(ctx *CompilerContext, target *ir.Block) {
+	if ctx.builder.Term == nil {
+		ctx.builder.NewBr(target)
+	}
+}
+
+// assignVariable stores val into name. Reassigning with a value of another
+// type introduces a fresh binding that shadows the old one. New bindings at
+// the top level of a module are globals.
+func assignVariable(ctx *CompilerContext, ident *parser.Identifier, val value.Value) {
+	name := ident.Value
+	if existing, ok := ctx.GetSymbol(name); ok && isVariable(existing) {
+		if existing.Type().(*types.PointerType).ElemType.Equal(val.Type()) {
+			ctx.store(val, existing)
+			return
+		}
+	}
+	var slot value.Value
+	if atModuleLevel(ctx, name) {
+		g := defineModuleVar(ctx, name, val.Type())
+		if ctx.ownership.Borrows(ident) {
+			ctx.borrowed[g] = true
+		} else if ctx.isCounted(val.Type()) {
+			ctx.moduleVars = append(ctx.moduleVars, g)
+		}
+		slot = g
+	} else {
+		slot = ctx.localSlot(ident, name, val.Type(), 0)
+	}
+	if ctx.unsigned[val] {
+		ctx.unsigned[slot] = true
+	}
+	ctx.store(val, slot)
+	ctx.SetSymbol(name, slot)
+}
+
+// compileFor lowers for v in xs and for i, v in xs over an array. The loop
+// holds the array, so that the body may assign xs.
+func compileFor(s *parser.For, ctx *CompilerContext) {
+	arr := compileExpr(s.Iterable, ctx)
+	if arr == nil {
+		return
+	}
+	elem, ok := ctx.arrayElemType(arr.Type())
+	if !ok {
+		return
+	}
+	ctx.hold(arr)
+	counter := ctx.NewLocal("for.idx", types.I64)
+	ctx.builder.NewStore(constant.NewInt(types.I64, 0), counter)
+	condBlock := ctx.NewBlock("for.cond")
+	bodyBlock := ctx.NewBlock("for.body")
+	incBlock := ctx.NewBlock("for.inc")
+	endBlock := ctx.NewBlock("for.end")
+	ctx.builder.NewBr(condBlock)
+
+	ctx.builder = condBlock
+	i := condBlock.NewLoad(types.I64, counter)
+	length := arrayField(condBlock, arr, arrayLenField)
+	condBlock.NewCondBr(condBlock.NewICmp(enum.IPredSLT, i, length), bodyBlock, endBlock)
+
+	ctx.builder = bodyBlock
+	ctx.EnterScope()
+	data := arrayField(bodyBlock, arr, arrayDataField)
+	v := bodyBlock.NewLoad(elem, bodyBlock.NewGetElementPtr(elem, data, i))
+	if s.Value != nil {
+		slot := ctx.localSlot(s.Value, s.Value.Value, elem, 0)
+		ctx.store(v, slot)
+		ctx.SetSymbol(s.Value.Value, slot)
+	}
+	if s.Index != nil {
+		slot := ctx.localSlot(s.Index, s.Index.Value, types.I32, 0)
+		ctx.store(bodyBlock.NewTrunc(i, types.I32), slot)
+		ctx.SetSymbol(s.Index.Value, slot)
+	}
+	ctx.PushLoop(endBlock, incBlock)
+	if s.Body != nil {
+		for _, stmt := range s.Body.Statements {
+			compileStmt(stmt, ctx)
+		}
+	}
+	ctx.PopLoop()
+	ctx.releaseScope()
+	ctx.ExitScope()
+	branchTo(ctx, incBlock)
+
+	next := incBlock.NewAdd(incBlock.NewLoad(types.I64, counter), constant.NewInt(types.I64, 1))
+	incBlock.NewStore(next, counter)
+	incBlock.NewBr(condBlock)
+	ctx.builder = endBlock
+}
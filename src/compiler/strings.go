@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Strings are { i8* data, i64 len } values. The bytes are immutable and
+// always followed by a NUL, so data can be handed to C functions as is.
+// They are a counted object, which literals keep in a global that is never
+// freed.
+
+func (c *CompilerContext) stringType() *types.StructType {
+	if c.strType == nil {
+		c.strType = types.NewStruct(i8Ptr, types.I64)
+		c.module.NewTypeDef("aether.string", c.strType)
+	}
+	return c.strType
+}
+
+func (c *CompilerContext) isString(t types.Type) bool {
+	return c.strType != nil && t.Equal(c.strType)
+}
+
+// stringLiteral returns s as a constant string value.
+func stringLiteral(ctx *CompilerContext, s string) constant.Constant {
+	data, ok := ctx.literals[s]
+	if !ok {
+		glob := immortal(ctx, ".lit", constant.NewCharArrayFromString(s+"\x00"))
+		zero := constant.NewInt(types.I32, 0)
+		data = constant.NewGetElementPtr(glob.ContentType, glob, zero, constant.NewInt(types.I32, 1), zero)
+		ctx.literals[s] = data
+	}
+	return constant.NewStruct(ctx.stringType(), data, constant.NewInt(types.I64, int64(len(s))))
+}
+
+func stringData(block *ir.Block, s value.Value) value.Value {
+	return block.NewExtractValue(s, 0)
+}
+
+func stringLen(block *ir.Block, s value.Value) value.Value {
+	return block.NewExtractValue(s, 1)
+}
+
+// compileConcat lowers a .. b. Two arrays of the same type concatenate into
+// a new array; otherwise operands that are not strings are formatted the
+// same way print shows them.
+func compileConcat(ctx *CompilerContext, left, right value.Value) value.Value {
+	if elem, ok := ctx.arrayElemType(left.Type()); ok && left.Type().Equal(right.Type()) {
+		b := ctx.builder
+		out := b.NewCall(arrayNewFunc(ctx, elem), constant.NewInt(types.I64, 0))
+		extend := arrayExtendFunc(ctx, elem)
+		for _, arr := range []value.Value{left, right} {
+			b.NewCall(extend, out, arrayField(b, arr, arrayDataField), arrayField(b, arr, arrayLenField))
+		}
+		return ctx.own(out)
+	}
+	sb := newStringBuilder(ctx)
+	formatValue(ctx, sb, left, false)
+	formatValue(ctx, sb, right, false)
+	return ctx.own(builderToString(ctx, sb))
+}
+
+// compileStringCompare lowers comparisons where both operands are strings.
+// Strings compare bytewise, and a shorter prefix orders first.
+func compileStringCompare(ctx *CompilerContext, op string, left, right value.Value) value.Value {
+	cmp := ctx.builder.NewCall(stringCompareFunc(ctx), left, right)
+	zero := constant.NewInt(types.I32, 0)
+	preds := map[string]enum.IPred{
+		"==": enum.IPredEQ, "!=": enum.IPredNE,
+		"<": enum.IPredSLT, ">": enum.IPredSGT,
+		"<=": enum.IPredSLE, ">=": enum.IPredSGE,
+	}
+	pred, ok := preds[op]
+	if !ok {
+		return nil
+	}
+	return ctx.builder.NewICmp(pred, cmp, zero)
+}
+
+// stringCompareFunc returns aether.string.compare(a, b), which is negative,
+// zero or positive like memcmp.
+func stringCompareFunc(ctx *CompilerContext) *ir.Func {
+	st := ctx.stringType()
+	a := ir.NewParam("a", st)
+	b := ir.NewParam("b", st)
+	fn, fresh := newRuntimeFunc(ctx, "aether.string.compare", types.I32, a, b)
+	if !fresh {
+		return fn
+	}
+	entry := fn.NewBlock("entry")
+	byLength := fn.NewBlock("by.length")
+	differ := fn.NewBlock("differ")
+
+	alen := stringLen(entry, a)
+	blen := stringLen(entry, b)
+	shorter := entry.NewSelect(entry.NewICmp(enum.IPredULT, alen, blen), alen, blen)
+	memcmp := getOrCreateExtern(ctx, "memcmp", types.I32, false, i8Ptr, i8Ptr, types.I64)
+	res := entry.NewCall(memcmp, stringData(entry, a), stringData(entry, b), shorter)
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, res, constant.NewInt(types.I32, 0)), byLength, differ)
+
+	differ.NewRet(res)
+
+	less := byLength.NewICmp(enum.IPredULT, alen, blen)
+	greater := byLength.NewICmp(enum.IPredUGT, alen, blen)
+	sign := byLength.NewSub(byLength.NewZExt(greater, types.I32), byLength.NewZExt(less, types.I32))
+	byLength.NewRet(sign)
+	return fn
+}
+
+// cValue converts v for passing to a C function: strings decay to their
+// NUL-terminated data pointer.
+func cValue(ctx *CompilerContext, v value.Value) value.Value {
+	if ctx.isString(v.Type()) {
+		return stringData(ctx.builder, v)
+	}
+	return v
+}
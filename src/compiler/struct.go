@@ -0,0 +1,223 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+
+	analysis "aether/src/analysis"
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Struct values are pointers to heap allocated LLVM structs, like arrays,
+// and counted objects owning their fields.
+// Field order follows the struct definition; anonymous struct literals
+// order their fields by name.
+
+type structInfo struct {
+	name     string
+	fields   []string
+	typ      *types.StructType
+	unsigned []bool
+}
+
+func (s *structInfo) fieldIndex(name string) int {
+	for i, f := range s.fields {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// structInfoOf reports the struct behind a struct pointer type.
+func (c *CompilerContext) structInfoOf(t types.Type) (*structInfo, bool) {
+	ptr, ok := t.(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+	st, ok := ptr.ElemType.(*types.StructType)
+	if !ok {
+		return nil, false
+	}
+	info, ok := c.structs[st]
+	return info, ok
+}
+
+// structType returns the LLVM type for a struct with the given layout,
+// creating it on first use. key identifies the layout, and unsigned marks
+// the fields declared with an unsigned type.
+func (c *CompilerContext) structType(key, name string, fields []string, fieldTypes []types.Type, unsigned []bool) *structInfo {
+	for _, info := range c.structs {
+		if info.typ.Name() == key {
+			return info
+		}
+	}
+	st := types.NewStruct(fieldTypes...)
+	c.module.NewTypeDef(key, st)
+	info := &structInfo{name: name, fields: fields, typ: st, unsigned: unsigned}
+	c.structs[st] = info
+	return info
+}
+
+// namedStruct returns the struct declared as name in the module, or
+// exported by one of the modules it may import. Fields without an
+// annotation take their inferred types. The type is created before its
+// fields, so that they may refer to it.
+func namedStruct(ctx *CompilerContext, name string) (*structInfo, bool) {
+	key := "struct." + name
+	for _, info := range ctx.structs {
+		if info.typ.Name() == key {
+			return info, true
+		}
+	}
+	var fields, annotations []string
+	if def := ctx.structDefs[name]; def != nil {
+		for _, f := range def.Fields {
+			t := f.Type
+			if t == "" {
+				t = ctx.types.Field(name, f.Name.Value)
+			}
+			fields = append(fields, f.Name.Value)
+			annotations = append(annotations, t)
+		}
+	} else if def, ok := importedStruct(ctx, name); ok {
+		for _, f := range def.Order {
+			fields = append(fields, f)
+			annotations = append(annotations, def.Fields[f])
+		}
+	} else {
+		return nil, false
+	}
+	st := types.NewStruct()
+	ctx.module.NewTypeDef(key, st)
+	info := &structInfo{name: name, fields: fields, typ: st, unsigned: make([]bool, len(fields))}
+	ctx.structs[st] = info
+	for i, annotation := range annotations {
+		t, _ := typeFromAnnotation(ctx, annotation)
+		st.Fields = append(st.Fields, t)
+		info.unsigned[i] = isUnsignedType(annotation)
+	}
+	return info, true
+}
+
+// importedStruct returns the struct name as a module the program is built
+// with exports it.
+func importedStruct(ctx *CompilerContext, name string) (analysis.TypeInfo, bool) {
+	modules := make([]string, 0, len(ctx.options.ModuleSymbols))
+	for module := range ctx.options.ModuleSymbols {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		if info, ok := ctx.options.ModuleSymbols[module][name].(analysis.TypeInfo); ok {
+			return info, true
+		}
+	}
+	return analysis.TypeInfo{}, false
+}
+
+func compileStructInstantiation(e *parser.StructInstantiation, ctx *CompilerContext) value.Value {
+	var fields []string
+	var annotations []string
+	name := ""
+	if e.TypeName != nil {
+		name = e.TypeName.Value
+	}
+	def := ctx.structDefs[name]
+	if def != nil {
+		for _, f := range def.Fields {
+			fields = append(fields, f.Name.Value)
+			annotations = append(annotations, f.Type)
+		}
+	} else {
+		for f := range e.Fields {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		annotations = make([]string, len(fields))
+	}
+
+	vals := make([]value.Value, len(fields))
+	fieldTypes := make([]types.Type, len(fields))
+	unsigned := make([]bool, len(fields))
+	for i, f := range fields {
+		unsigned[i] = isUnsignedType(annotations[i])
+		if expr, ok := e.Fields[f]; ok {
+			if vals[i] = compileExpr(expr, ctx); vals[i] == nil {
+				return nil
+			}
+		}
+		typ, annotated := typeFromAnnotation(ctx, annotations[i])
+		if !annotated && vals[i] != nil {
+			typ = vals[i].Type()
+		}
+		fieldTypes[i] = typ
+	}
+
+	var key string
+	if def != nil {
+		key = "struct." + name
+	} else {
+		parts := make([]string, len(fields))
+		for i, f := range fields {
+			parts[i] = f + "." + typeKey(fieldTypes[i])
+		}
+		key = "struct.anon." + strings.Join(parts, ".")
+	}
+	info := ctx.structType(key, name, fields, fieldTypes, unsigned)
+
+	ptrType := types.NewPointer(info.typ)
+	obj := ctx.builder.NewBitCast(rcAlloc(ctx, ctx.builder, sizeOf(info.typ), fieldsDrop(ctx, info.typ)), ptrType)
+	for i, fieldType := range info.typ.Fields {
+		v := vals[i]
+		if v == nil {
+			v = zeroValue(fieldType)
+		}
+		v = convertValue(ctx, v, fieldType)
+		if ctx.isCounted(fieldType) {
+			ctx.take(v)
+		}
+		storeField(ctx, obj, i, v)
+	}
+	return ctx.own(obj)
+}
+
+func fieldPtr(ctx *CompilerContext, obj value.Value, index int) value.Value {
+	st := obj.Type().(*types.PointerType).ElemType
+	return ctx.builder.NewGetElementPtr(st, obj, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(index)))
+}
+
+func loadField(ctx *CompilerContext, obj value.Value, index int) value.Value {
+	st := obj.Type().(*types.PointerType).ElemType.(*types.StructType)
+	v := ctx.builder.NewLoad(st.Fields[index], fieldPtr(ctx, obj, index))
+	if info, ok := ctx.structInfoOf(obj.Type()); ok && index < len(info.unsigned) && info.unsigned[index] {
+		ctx.unsigned[v] = true
+	}
+	return v
+}
+
+func storeField(ctx *CompilerContext, obj value.Value, index int, v value.Value) {
+	ctx.builder.NewStore(v, fieldPtr(ctx, obj, index))
+}
+
+// compileFieldAssignment lowers obj.field = value.
+func compileFieldAssignment(target *parser.PropertyAccess, val parser.Expression, ctx *CompilerContext) {
+	obj := compileExpr(target.Object, ctx)
+	v := compileExpr(val, ctx)
+	if obj == nil || v == nil {
+		return
+	}
+	info, ok := ctx.structInfoOf(obj.Type())
+	if !ok {
+		return
+	}
+	index := info.fieldIndex(target.Property.Value)
+	if index < 0 {
+		return
+	}
+	ctx.store(convertValue(ctx, v, info.typ.Fields[index]), fieldPtr(ctx, obj, index))
+}
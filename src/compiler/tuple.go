@@ -0,0 +1,136 @@
+package compiler
+
+import (
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Tuples are unnamed LLVM structs passed by value. A function that returns
+// x, y returns { X, Y }, and a, b = f() takes the fields apart again.
+
+// isTuple reports whether t is a tuple type. Every other struct value the
+// compiler creates has a name.
+func isTuple(t types.Type) bool {
+	st, ok := t.(*types.StructType)
+	return ok && st.Name() == ""
+}
+
+func compileTuple(e *parser.Tuple, ctx *CompilerContext) value.Value {
+	vals, ok := compileTupleElements(e.Elements, ctx)
+	if !ok {
+		return nil
+	}
+	return makeTuple(ctx, vals)
+}
+
+func compileTupleElements(exprs []parser.Expression, ctx *CompilerContext) ([]value.Value, bool) {
+	vals := make([]value.Value, len(exprs))
+	for i, expr := range exprs {
+		if vals[i] = compileExpr(expr, ctx); vals[i] == nil {
+			return nil, false
+		}
+	}
+	return vals, true
+}
+
+func makeTuple(ctx *CompilerContext, vals []value.Value) value.Value {
+	fieldTypes := make([]types.Type, len(vals))
+	for i, v := range vals {
+		fieldTypes[i] = v.Type()
+	}
+	var tuple value.Value = constant.NewUndef(types.NewStruct(fieldTypes...))
+	for i, v := range vals {
+		tuple = ctx.builder.NewInsertValue(tuple, v, uint64(i))
+	}
+	return tuple
+}
+
+// compileTupleAssignment lowers a, b = ... . Every value on the right is
+// computed before any name is assigned, so a, b = b, a swaps.
+func compileTupleAssignment(names []*parser.Identifier, val parser.Expression, ctx *CompilerContext) {
+	var vals []value.Value
+	ok := true
+	switch e := val.(type) {
+	case *parser.Tuple:
+		vals, ok = compileTupleElements(e.Elements, ctx)
+	case *parser.Array:
+		vals, ok = compileTupleElements(e.Elements, ctx)
+	default:
+		v := compileExpr(val, ctx)
+		if v == nil {
+			return
+		}
+		if vals = unpackValue(ctx, v, len(names)); vals == nil {
+			ctx.unchecked("assignment of %s to %d names", v.Type(), len(names))
+			return
+		}
+	}
+	if !ok {
+		return
+	}
+	if len(vals) != len(names) {
+		ctx.unchecked("assignment to %d names: expected %d values, found %d", len(names), len(names), len(vals))
+		return
+	}
+	// The first stores may release what the values refer to, as in
+	// a, b = b, a.
+	for _, v := range vals {
+		ctx.hold(v)
+	}
+	for i, name := range names {
+		assignVariable(ctx, name, vals[i])
+	}
+}
+
+// unpackValue splits a tuple or an array into n values. Arrays are checked
+// at run time to have exactly n elements.
+func unpackValue(ctx *CompilerContext, v value.Value, n int) []value.Value {
+	if isTuple(v.Type()) {
+		st := v.Type().(*types.StructType)
+		if len(st.Fields) != n {
+			return nil
+		}
+		vals := make([]value.Value, n)
+		for i := range vals {
+			vals[i] = ctx.builder.NewExtractValue(v, uint64(i))
+		}
+		return vals
+	}
+	elem, ok := ctx.arrayElemType(v.Type())
+	if !ok {
+		return nil
+	}
+	length := arrayField(ctx.builder, v, arrayLenField)
+	want := constant.NewInt(types.I64, int64(n))
+	okBlock := ctx.NewBlock("unpack.ok")
+	failBlock := ctx.NewBlock("unpack.fail")
+	ctx.builder.NewCondBr(ctx.builder.NewICmp(enum.IPredEQ, length, want), okBlock, failBlock)
+	failBlock.NewCall(unpackFailFunc(ctx), sourceLocation(ctx, 0), length, want)
+	failBlock.NewUnreachable()
+	ctx.builder = okBlock
+	data := arrayField(okBlock, v, arrayDataField)
+	vals := make([]value.Value, n)
+	for i := range vals {
+		ptr := okBlock.NewGetElementPtr(elem, data, constant.NewInt(types.I64, int64(i)))
+		vals[i] = okBlock.NewLoad(elem, ptr)
+	}
+	return vals
+}
+
+func unpackFailFunc(ctx *CompilerContext) *ir.Func {
+	where := ir.NewParam("where", i8Ptr)
+	n := ir.NewParam("len", types.I64)
+	want := ir.NewParam("want", types.I64)
+	fn, fresh := newRuntimeFunc(ctx, "aether.unpack_fail", types.Void, where, n, want)
+	if !fresh {
+		return fn
+	}
+	fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoReturn, enum.FuncAttrCold, enum.FuncAttrNoInline)
+	rtPanic(ctx, fn.NewBlock("entry"), "cannot unpack %lld values into %lld names", where, n, want)
+	return fn
+}
@@ -29,3 +29,37 @@ func Float() types.Type {
 func Double() types.Type {
 	return types.Double
 }
+
+// sizedType returns the LLVM type of a built-in sized type name. LLVM
+// integers carry no sign, so u8..u64 share the types of i8..i64 and the
+// compiler keeps track of which values are unsigned.
+func sizedType(name string) (types.Type, bool) {
+	switch name {
+	case "i8", "u8":
+		return types.I8, true
+	case "i16", "u16":
+		return types.I16, true
+	case "i32", "u32":
+		return types.I32, true
+	case "i64", "u64":
+		return types.I64, true
+	case "f32":
+		return types.Float, true
+	case "f64":
+		return types.Double, true
+	case "bool":
+		return types.I1, true
+	case "ptr":
+		return i8Ptr, true
+	}
+	return nil, false
+}
+
+// isUnsignedType reports whether a built-in or C type name is unsigned.
+func isUnsignedType(name string) bool {
+	switch name {
+	case "u8", "u16", "u32", "u64", "uint", "uchar", "byte", "ushort", "ulong", "size_t":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,490 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The verifier checks the IR of a module before it is written out for llc.
+// Codegen bugs, such as a block left without a terminator, a store of the
+// wrong type or a value used on a path that does not define it, would
+// otherwise show up as an llc error about IR the user never wrote. Each
+// problem is tied to the function, block and instruction at fault, and
+// CompileModule maps those back to the Aether statement that emitted them.
+
+// IRError is a problem the verifier found in a function. Block and At are
+// the block and the instruction or terminator at fault, when there is one.
+type IRError struct {
+	Func    *ir.Func
+	Block   *ir.Block
+	At      interface{}
+	Message string
+}
+
+func (e IRError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Func.Ident(), e.Message)
+}
+
+// Verify checks the functions of m: that names are unique, that signatures
+// agree with parameters, that every block ends in a terminator branching to
+// blocks of the same function, that operands have the types their
+// instructions take, and that every value is defined on all paths to its
+// uses.
+func Verify(m *ir.Module) []IRError {
+	var errs []IRError
+	names := make(map[string]bool)
+	for _, g := range m.Globals {
+		names[g.Name()] = true
+	}
+	for _, fn := range m.Funcs {
+		if names[fn.Name()] {
+			errs = append(errs, IRError{Func: fn, Message: fmt.Sprintf("%s is defined more than once", fn.Ident())})
+		}
+		names[fn.Name()] = true
+		errs = append(errs, verifyFunc(fn)...)
+	}
+	return errs
+}
+
+// irDef is where a value is defined: its block and its index there, the
+// terminator coming after every instruction.
+type irDef struct {
+	block *ir.Block
+	index int
+}
+
+type funcVerifier struct {
+	fn    *ir.Func
+	errs  []IRError
+	defs  map[value.Value]irDef
+	preds map[*ir.Block][]*ir.Block
+	idom  map[*ir.Block]*ir.Block
+}
+
+func verifyFunc(fn *ir.Func) []IRError {
+	v := &funcVerifier{fn: fn, defs: make(map[value.Value]irDef), preds: make(map[*ir.Block][]*ir.Block)}
+	if fn.Sig == nil {
+		v.fail(nil, nil, "has no signature")
+		return v.errs
+	}
+	if len(fn.Params) != len(fn.Sig.Params) {
+		v.fail(nil, nil, "has %d parameters but its signature lists %d", len(fn.Params), len(fn.Sig.Params))
+	} else {
+		for i, p := range fn.Params {
+			if !p.Typ.Equal(fn.Sig.Params[i]) {
+				v.fail(nil, nil, "parameter %s is %s but its signature says %s", p.Ident(), p.Typ, fn.Sig.Params[i])
+			}
+		}
+	}
+	if ptr, ok := fn.Type().(*types.PointerType); !ok || !ptr.ElemType.Equal(fn.Sig) {
+		v.fail(nil, nil, "has type %s, which does not match its signature %s", fn.Type(), fn.Sig)
+	}
+	if len(fn.Blocks) == 0 {
+		return v.errs
+	}
+	_ = fn.AssignIDs()
+
+	ours := make(map[*ir.Block]bool)
+	for _, b := range fn.Blocks {
+		ours[b] = true
+	}
+	for _, b := range fn.Blocks {
+		for i, inst := range b.Insts {
+			if val, ok := inst.(value.Value); ok {
+				v.defs[val] = irDef{b, i}
+			}
+		}
+		if b.Term == nil {
+			v.fail(b, nil, "block %s does not end in a terminator", b.Ident())
+			continue
+		}
+		for _, succ := range termSuccs(b.Term) {
+			switch {
+			case succ == nil:
+				v.fail(b, b.Term, "branches to a missing block")
+			case !ours[succ]:
+				v.fail(b, b.Term, "branches to %s, a block of another function", succ.Ident())
+			default:
+				v.preds[succ] = append(v.preds[succ], b)
+			}
+		}
+	}
+	if entry := fn.Blocks[0]; len(v.preds[entry]) > 0 {
+		v.fail(entry, nil, "entry block %s is branched to from %s", entry.Ident(), v.preds[entry][0].Ident())
+	}
+	v.idom = dominators(fn.Blocks[0], v.preds)
+
+	for _, b := range fn.Blocks {
+		for i, inst := range b.Insts {
+			v.checkOperands(b, i, inst, inst.Operands())
+			v.checkInst(b, inst)
+		}
+		if b.Term != nil {
+			v.checkOperands(b, len(b.Insts), b.Term, b.Term.Operands())
+			v.checkTerm(b, b.Term)
+		}
+	}
+	return v.errs
+}
+
+func (v *funcVerifier) fail(b *ir.Block, at interface{}, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if at != nil {
+		msg = fmt.Sprintf("%s: %s", describeIR(at), msg)
+	}
+	v.errs = append(v.errs, IRError{Func: v.fn, Block: b, At: at, Message: fmt.Sprintf("in %s, %s", v.fn.Ident(), msg)})
+}
+
+// termSuccs returns the targets of term, with nil for a missing one.
+func termSuccs(term ir.Terminator) (succs []*ir.Block) {
+	defer func() {
+		if recover() != nil {
+			succs = []*ir.Block{nil}
+		}
+	}()
+	for _, s := range term.Succs() {
+		if s == nil {
+			return []*ir.Block{nil}
+		}
+		succs = append(succs, s)
+	}
+	return succs
+}
+
+// describeIR renders an instruction or terminator for a message, falling
+// back to its kind when it is too broken to print.
+func describeIR(at interface{}) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", at), "*ir.Inst"))
+		}
+	}()
+	s = at.(ir.LLStringer).LLString()
+	if len(s) > 80 {
+		s = s[:77] + "..."
+	}
+	return s
+}
+
+// checkOperands checks that the operands of the instruction at index i of
+// b are present and defined on every path to it.
+func (v *funcVerifier) checkOperands(b *ir.Block, i int, at interface{}, ops []*value.Value) {
+	for n, op := range ops {
+		if op == nil || *op == nil {
+			v.fail(b, at, "operand %d is missing", n+1)
+			continue
+		}
+		switch x := (*op).(type) {
+		case *ir.Param:
+			if !hasParam(v.fn, x) {
+				v.fail(b, at, "uses %s, a parameter of another function", x.Ident())
+			}
+		case ir.Instruction:
+			def, ok := v.defs[x.(value.Value)]
+			if !ok {
+				v.fail(b, at, "uses %s, which is not defined in this function", x.(value.Value).Ident())
+				continue
+			}
+			use := b
+			if phi, ok := at.(*ir.InstPhi); ok {
+				// A phi uses its incoming value at the end of the block
+				// it comes from.
+				pred, _ := phi.Incs[n/2].Pred.(*ir.Block)
+				if pred == nil {
+					continue
+				}
+				use, i = pred, len(pred.Insts)+1
+			}
+			if !v.reachable(use) {
+				continue
+			}
+			if def.block == use && def.index >= i || def.block != use && !dominates(v.idom, def.block, use) {
+				v.fail(b, at, "uses %s, which is not defined on every path to it", x.(value.Value).Ident())
+			}
+		}
+	}
+}
+
+func hasParam(fn *ir.Func, p *ir.Param) bool {
+	for _, q := range fn.Params {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *funcVerifier) checkInst(b *ir.Block, inst ir.Instruction) {
+	if hasNilOperand(inst.Operands()) {
+		return
+	}
+	switch inst := inst.(type) {
+	case *ir.InstStore:
+		ptr, ok := inst.Dst.Type().(*types.PointerType)
+		if !ok {
+			v.fail(b, inst, "stores to %s, which is not a pointer", inst.Dst.Type())
+		} else if !ptr.ElemType.Equal(inst.Src.Type()) {
+			v.fail(b, inst, "stores %s through a pointer to %s", inst.Src.Type(), ptr.ElemType)
+		}
+	case *ir.InstLoad:
+		ptr, ok := inst.Src.Type().(*types.PointerType)
+		if !ok {
+			v.fail(b, inst, "loads from %s, which is not a pointer", inst.Src.Type())
+		} else if !ptr.ElemType.Equal(inst.ElemType) {
+			v.fail(b, inst, "loads %s through a pointer to %s", inst.ElemType, ptr.ElemType)
+		}
+	case *ir.InstGetElementPtr:
+		ptr, ok := inst.Src.Type().(*types.PointerType)
+		if !ok {
+			v.fail(b, inst, "indexes %s, which is not a pointer", inst.Src.Type())
+		} else if !ptr.ElemType.Equal(inst.ElemType) {
+			v.fail(b, inst, "indexes %s through a pointer to %s", inst.ElemType, ptr.ElemType)
+		}
+	case *ir.InstCall:
+		v.checkCall(b, inst)
+	case *ir.InstICmp:
+		v.sameType(b, inst, inst.X, inst.Y)
+	case *ir.InstFCmp:
+		v.sameType(b, inst, inst.X, inst.Y)
+	case *ir.InstSelect:
+		if !inst.Cond.Type().Equal(types.I1) {
+			v.fail(b, inst, "selects on %s instead of i1", inst.Cond.Type())
+		}
+		v.sameType(b, inst, inst.ValueTrue, inst.ValueFalse)
+	case *ir.InstPhi:
+		preds := v.preds[b]
+		for _, inc := range inst.Incs {
+			if !inc.X.Type().Equal(inst.Typ) {
+				v.fail(b, inst, "has an incoming %s where %s is expected", inc.X.Type(), inst.Typ)
+			}
+			if pred, ok := inc.Pred.(*ir.Block); !ok || !containsBlock(preds, pred) {
+				v.fail(b, inst, "has an incoming value from %s, which does not branch here", inc.Pred.Ident())
+			}
+		}
+		if len(inst.Incs) != len(preds) {
+			v.fail(b, inst, "has %d incoming values but %s has %d predecessors", len(inst.Incs), b.Ident(), len(preds))
+		}
+	case *ir.InstTrunc:
+		v.resize(b, inst, inst.From, inst.To, false)
+	case *ir.InstZExt:
+		v.resize(b, inst, inst.From, inst.To, true)
+	case *ir.InstSExt:
+		v.resize(b, inst, inst.From, inst.To, true)
+	default:
+		if x, y, ok := binaryOperands(inst); ok {
+			v.sameType(b, inst, x, y)
+		}
+	}
+}
+
+func hasNilOperand(ops []*value.Value) bool {
+	for _, op := range ops {
+		if op == nil || *op == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func containsBlock(blocks []*ir.Block, b *ir.Block) bool {
+	for _, c := range blocks {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
+
+// binaryOperands returns the operands of an arithmetic or bitwise
+// instruction, which must have the same type.
+func binaryOperands(inst ir.Instruction) (x, y value.Value, ok bool) {
+	switch inst := inst.(type) {
+	case *ir.InstAdd:
+		return inst.X, inst.Y, true
+	case *ir.InstSub:
+		return inst.X, inst.Y, true
+	case *ir.InstMul:
+		return inst.X, inst.Y, true
+	case *ir.InstSDiv:
+		return inst.X, inst.Y, true
+	case *ir.InstUDiv:
+		return inst.X, inst.Y, true
+	case *ir.InstSRem:
+		return inst.X, inst.Y, true
+	case *ir.InstURem:
+		return inst.X, inst.Y, true
+	case *ir.InstFAdd:
+		return inst.X, inst.Y, true
+	case *ir.InstFSub:
+		return inst.X, inst.Y, true
+	case *ir.InstFMul:
+		return inst.X, inst.Y, true
+	case *ir.InstFDiv:
+		return inst.X, inst.Y, true
+	case *ir.InstFRem:
+		return inst.X, inst.Y, true
+	case *ir.InstAnd:
+		return inst.X, inst.Y, true
+	case *ir.InstOr:
+		return inst.X, inst.Y, true
+	case *ir.InstXor:
+		return inst.X, inst.Y, true
+	case *ir.InstShl:
+		return inst.X, inst.Y, true
+	case *ir.InstLShr:
+		return inst.X, inst.Y, true
+	case *ir.InstAShr:
+		return inst.X, inst.Y, true
+	}
+	return nil, nil, false
+}
+
+func (v *funcVerifier) sameType(b *ir.Block, at interface{}, x, y value.Value) {
+	if !x.Type().Equal(y.Type()) {
+		v.fail(b, at, "combines %s with %s", x.Type(), y.Type())
+	}
+}
+
+// resize checks an integer truncation, or an extension when grow is set.
+func (v *funcVerifier) resize(b *ir.Block, at interface{}, from value.Value, to types.Type, grow bool) {
+	src, ok1 := from.Type().(*types.IntType)
+	dst, ok2 := to.(*types.IntType)
+	switch {
+	case !ok1 || !ok2:
+		v.fail(b, at, "converts %s to %s, which are not both integers", from.Type(), to)
+	case grow && src.BitSize >= dst.BitSize:
+		v.fail(b, at, "extends %s to %s, which is not wider", src, dst)
+	case !grow && src.BitSize <= dst.BitSize:
+		v.fail(b, at, "truncates %s to %s, which is not narrower", src, dst)
+	}
+}
+
+func (v *funcVerifier) checkCall(b *ir.Block, call *ir.InstCall) {
+	ptr, ok := call.Callee.Type().(*types.PointerType)
+	var sig *types.FuncType
+	if ok {
+		sig, ok = ptr.ElemType.(*types.FuncType)
+	}
+	if !ok {
+		v.fail(b, call, "calls %s, which is not a function", call.Callee.Type())
+		return
+	}
+	callee := call.Callee.Ident()
+	if len(call.Args) < len(sig.Params) || len(call.Args) > len(sig.Params) && !sig.Variadic {
+		v.fail(b, call, "passes %d arguments to %s, which takes %d", len(call.Args), callee, len(sig.Params))
+	}
+	for i, arg := range call.Args {
+		if i < len(sig.Params) && !arg.Type().Equal(sig.Params[i]) {
+			v.fail(b, call, "passes %s as argument %d of %s, which takes %s", arg.Type(), i+1, callee, sig.Params[i])
+		}
+	}
+	if call.Typ != nil && !call.Typ.Equal(sig.RetType) {
+		v.fail(b, call, "expects %s from %s, which returns %s", call.Typ, callee, sig.RetType)
+	}
+}
+
+func (v *funcVerifier) checkTerm(b *ir.Block, term ir.Terminator) {
+	if hasNilOperand(term.Operands()) {
+		return
+	}
+	switch term := term.(type) {
+	case *ir.TermRet:
+		ret := v.fn.Sig.RetType
+		switch {
+		case term.X == nil && !ret.Equal(types.Void):
+			v.fail(b, term, "returns nothing from a function returning %s", ret)
+		case term.X != nil && !term.X.Type().Equal(ret):
+			v.fail(b, term, "returns %s from a function returning %s", term.X.Type(), ret)
+		}
+	case *ir.TermCondBr:
+		if !term.Cond.Type().Equal(types.I1) {
+			v.fail(b, term, "branches on %s instead of i1", term.Cond.Type())
+		}
+	case *ir.TermSwitch:
+		for _, c := range term.Cases {
+			if !c.X.Type().Equal(term.X.Type()) {
+				v.fail(b, term, "has a case of type %s in a switch on %s", c.X.Type(), term.X.Type())
+			}
+		}
+	}
+}
+
+// dominators returns the immediate dominator of every block reachable from
+// entry, the entry being its own, following Cooper, Harvey and Kennedy.
+func dominators(entry *ir.Block, preds map[*ir.Block][]*ir.Block) map[*ir.Block]*ir.Block {
+	var order []*ir.Block
+	number := make(map[*ir.Block]int)
+	seen := make(map[*ir.Block]bool)
+	var visit func(b *ir.Block)
+	visit = func(b *ir.Block) {
+		seen[b] = true
+		if b.Term != nil {
+			for _, s := range termSuccs(b.Term) {
+				if s != nil && !seen[s] {
+					visit(s)
+				}
+			}
+		}
+		number[b] = len(order)
+		order = append(order, b)
+	}
+	visit(entry)
+
+	idom := map[*ir.Block]*ir.Block{entry: entry}
+	intersect := func(a, b *ir.Block) *ir.Block {
+		for a != b {
+			for number[a] < number[b] {
+				a = idom[a]
+			}
+			for number[b] < number[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 2; i >= 0; i-- {
+			b := order[i]
+			var dom *ir.Block
+			for _, p := range preds[b] {
+				if idom[p] == nil {
+					continue
+				}
+				if dom == nil {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+			if dom != nil && idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+	return idom
+}
+
+func (v *funcVerifier) reachable(b *ir.Block) bool {
+	return v.idom[b] != nil
+}
+
+// dominates reports whether every path from the entry to b goes through a,
+// given the immediate dominators returned by dominators.
+func dominates(idom map[*ir.Block]*ir.Block, a, b *ir.Block) bool {
+	for idom[b] != nil {
+		if a == b {
+			return true
+		}
+		if idom[b] == b {
+			return false
+		}
+		b = idom[b]
+	}
+	return false
+}
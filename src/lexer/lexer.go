@@ -1,5 +1,7 @@
 package lexer
 
+import "strings"
+
 type Lexer struct {
 	Input        string
 	Position     int
@@ -239,18 +241,48 @@ func (l *Lexer) readNumber() string {
 	for isDigit(l.Ch) {
 		l.readChar()
 	}
+	// A fractional part needs a digit after the dot so that 1..2 still
+	// lexes as a concatenation.
+	if l.Ch == '.' && isDigit(l.peekChar()) {
+		l.readChar()
+		for isDigit(l.Ch) {
+			l.readChar()
+		}
+	}
 	return l.Input[pos:l.Position]
 }
 
+// readString reads a double quoted string and returns its contents with
+// escape sequences resolved.
 func (l *Lexer) readString() string {
 	l.readChar()
-	pos := l.Position
+	var sb strings.Builder
 	for l.Ch != '"' && l.Ch != 0 {
+		if l.Ch == '\\' {
+			l.readChar()
+			switch l.Ch {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '0':
+				sb.WriteByte(0)
+			case 0:
+				return sb.String()
+			default:
+				// \\, \" and unknown escapes keep the escaped character.
+				sb.WriteByte(l.Ch)
+			}
+			l.readChar()
+			continue
+		}
+		sb.WriteByte(l.Ch)
 		l.readChar()
 	}
-	s := l.Input[pos:l.Position]
 	l.readChar()
-	return s
+	return sb.String()
 }
 
 func (l *Lexer) readCComment() string {
@@ -72,6 +72,7 @@ const (
 	UNDERSCORE TokenType = "UNDERSCORE"
 	BREAK      TokenType = "BREAK"
 	CONTINUE   TokenType = "CONTINUE"
+	FOREIGN    TokenType = "FOREIGN"
 )
 
 var KEYWORDS = map[string]TokenType{
@@ -95,6 +96,7 @@ var KEYWORDS = map[string]TokenType{
 	"package": PACKAGE,
 	"break":   BREAK,
 	"continue": CONTINUE,
+	"foreign": FOREIGN,
 }
 
 const (
@@ -118,6 +120,8 @@ var Precedences = map[TokenType]int{
 	MINUS:    SUM,
 	DIVIDE:   PRODUCT,
 	MULTIPLY: PRODUCT,
+	ASTERISK: PRODUCT,
+	SLASH:    PRODUCT,
 	MODULO:   PRODUCT,
 	EXPONENT: PRODUCT,
 	CONCAT:   SUM,
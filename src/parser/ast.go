@@ -38,6 +38,19 @@ func (f *Function) node()       {}
 func (f *Function) statement()  {}
 func (f *Function) expression() {}
 
+// ForeignFunction declares a C function: foreign func puts(s: cstr): int.
+// Parameter and return types are C type names, and Variadic marks a
+// trailing ... for C varargs.
+type ForeignFunction struct {
+	Name       *Identifier   `json:"name"`
+	Params     []*Identifier `json:"params"`
+	ReturnType string        `json:"returnType,omitempty"`
+	Variadic   bool          `json:"variadic,omitempty"`
+}
+
+func (f *ForeignFunction) node()      {}
+func (f *ForeignFunction) statement() {}
+
 type StructDef struct {
 	Name   *Identifier `json:"name"`
 	Fields []*Field    `json:"fields"`
@@ -122,6 +135,7 @@ type Identifier struct {
 	Value    string `json:"value"`
 	Type     string `json:"type"`
 	IsVararg bool   `json:"is_vararg,omitempty"`
+	Pos
 }
 
 func (i *Identifier) node()       {}
@@ -130,14 +144,26 @@ func (i *Identifier) statement()  {}
 
 type Literal struct {
 	Value interface{} `json:"value"`
+	Kind  LiteralType `json:"literal_type,omitempty"`
+	Pos
 }
 
+// LiteralType records which token a literal was read from, since the parser
+// keeps every literal value as its raw source text.
+type LiteralType string
+
+const (
+	NumberLiteral LiteralType = "number"
+	StringLiteral LiteralType = "string"
+)
+
 func (l *Literal) node()       {}
 func (l *Literal) expression() {}
 func (l *Literal) statement()  {}
 
 type Array struct {
 	Elements []Expression `json:"elements"`
+	Pos
 }
 
 func (a *Array) node()       {}
@@ -165,15 +191,56 @@ func (p *PropertyAccess) statement()  {}
 type ArrayIndex struct {
 	Array Expression `json:"array"`
 	Index Expression `json:"index"`
+	Pos
 }
 
 func (a *ArrayIndex) node()       {}
 func (a *ArrayIndex) expression() {}
 func (a *ArrayIndex) statement()  {}
 
+// Slice is xs[low:high]; either bound may be nil.
+type Slice struct {
+	Array Expression `json:"array"`
+	Low   Expression `json:"low,omitempty"`
+	High  Expression `json:"high,omitempty"`
+	Pos
+}
+
+func (s *Slice) node()       {}
+func (s *Slice) expression() {}
+
+// Cast converts a value to a built-in type, e.g. n as u8.
+type Cast struct {
+	Expr Expression `json:"expr"`
+	Type string     `json:"type"`
+	Pos
+}
+
+func (c *Cast) node()       {}
+func (c *Cast) expression() {}
+
+// Tuple is a comma separated list of values, as on the right of
+// a, b = 1, 2 or in return x, y.
+type Tuple struct {
+	Elements []Expression `json:"elements"`
+}
+
+func (t *Tuple) node()       {}
+func (t *Tuple) expression() {}
+
+// ElementAssignment stores into an element of an array, e.g. xs[0] = 1.
+type ElementAssignment struct {
+	Target Expression `json:"target"`
+	Value  Expression `json:"value"`
+}
+
+func (e *ElementAssignment) node()      {}
+func (e *ElementAssignment) statement() {}
+
 type StructInstantiation struct {
 	TypeName *Identifier            `json:"type_name"`
 	Fields   map[string]Expression `json:"fields"`
+	Pos
 }
 
 func (s *StructInstantiation) node()       {}
@@ -189,8 +256,11 @@ func (p *PartialApplication) node()       {}
 func (p *PartialApplication) expression() {}
 func (p *PartialApplication) statement()  {}
 
+// Spread is ...name. Pos is the position of the name, or of the ... when
+// there is none.
 type Spread struct {
 	Name string
+	Pos
 }
 
 func (s *Spread) expression()    {}
@@ -198,11 +268,19 @@ func (s *Spread) node()          {}
 func (s *Spread) Statement()     {}
 func (s *Spread) String() string { return "..." + s.Name }
 
+// Pos is the source position a node was parsed at. Line and Column are
+// 1-based, matching lexer.Token; a zero Pos means the position is unknown.
+type Pos struct {
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+}
+
 type NodeKind string
 
 const (
 	TranslationUnitKind    NodeKind = "TranslationUnit"
 	FunctionDeclKind       NodeKind = "FunctionDecl"
+	ForeignFunctionKind    NodeKind = "ForeignFunctionDecl"
 	ParamKind              NodeKind = "Param"
 	BlockKind              NodeKind = "Block"
 	ReturnKind             NodeKind = "Return"
@@ -229,6 +307,10 @@ const (
 	BreakKind              NodeKind = "Break"
 	ContinueKind           NodeKind = "Continue"
 	SpreadKind             NodeKind = "Spread"
+	SliceKind              NodeKind = "Slice"
+	ElementAssignmentKind  NodeKind = "ElementAssignment"
+	TupleKind              NodeKind = "Tuple"
+	CastKind               NodeKind = "Cast"
 )
 
 type ASTNode struct {
@@ -295,6 +377,23 @@ func expressionToASTNode(e Expression) *ASTNode {
 			Left:     expressionToASTNode(expr.Array),
 			Right:    expressionToASTNode(expr.Index),
 		}
+	case *Tuple:
+		return &ASTNode{
+			NodeKind: TupleKind,
+			Inner:    mapArgsToASTNodes(expr.Elements),
+		}
+	case *Slice:
+		return &ASTNode{
+			NodeKind: SliceKind,
+			Left:     expressionToASTNode(expr.Array),
+			Inner:    []*ASTNode{expressionToASTNode(expr.Low), expressionToASTNode(expr.High)},
+		}
+	case *Cast:
+		return &ASTNode{
+			NodeKind: CastKind,
+			Left:     expressionToASTNode(expr.Expr),
+			Value:    expr.Type,
+		}
 	case *StructInstantiation:
 		fields := make([]*ASTNode, 0, len(expr.Fields))
 		for name, value := range expr.Fields {
@@ -437,6 +536,12 @@ func statementToASTNode(s Statement) *ASTNode {
 			Params:   names, // or Inner: names,
 			Right:    expressionToASTNode(stmt.Value),
 		}
+	case *ElementAssignment:
+		return &ASTNode{
+			NodeKind: ElementAssignmentKind,
+			Left:     expressionToASTNode(stmt.Target),
+			Right:    expressionToASTNode(stmt.Value),
+		}
 	case *Function:
 		params := make([]*ASTNode, len(stmt.Params))
 		for i, param := range stmt.Params {
@@ -459,6 +564,20 @@ func statementToASTNode(s Statement) *ASTNode {
 			Params:   params,
 			Body:     blockToASTNode(stmt.Body),
 		}
+	case *ForeignFunction:
+		params := make([]*ASTNode, 0, len(stmt.Params)+1)
+		for _, param := range stmt.Params {
+			params = append(params, &ASTNode{NodeKind: ParamKind, Name: param.Value, Value: param.Type})
+		}
+		if stmt.Variadic {
+			params = append(params, &ASTNode{NodeKind: ParamKind, Name: "..."})
+		}
+		return &ASTNode{
+			NodeKind: ForeignFunctionKind,
+			Name:     stmt.Name.Value,
+			Params:   params,
+			Value:    stmt.ReturnType,
+		}
 	case *StructDef:
 		fields := make([]*ASTNode, len(stmt.Fields))
 		for i, field := range stmt.Fields {
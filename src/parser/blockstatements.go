@@ -68,7 +68,7 @@ func (p *Parser) parseFunc() *Function {
 	}
 	var name *Identifier
 	if p.curToken.Type == lexer.IDENT {
-		name = &Identifier{Value: p.curToken.Literal}
+		name = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		p.nextToken()
 	} else {
 		name = &Identifier{Value: ""}
@@ -84,7 +84,7 @@ func (p *Parser) parseFunc() *Function {
 			if p.curToken.Type == lexer.VARARG {
 				if p.peekToken.Type == lexer.IDENT {
 					p.nextToken() // consume VARARG
-					param := &Identifier{Value: p.curToken.Literal, IsVararg: true}
+					param := &Identifier{Value: p.curToken.Literal, IsVararg: true, Pos: p.curPos()}
 					if !p.expect(lexer.IDENT) {
 						return nil
 					}
@@ -100,7 +100,7 @@ func (p *Parser) parseFunc() *Function {
 				}
 			} else {
 				// Regular parameter
-				param := &Identifier{Value: p.curToken.Literal}
+				param := &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 				if !p.expect(lexer.IDENT) {
 					return nil
 				}
@@ -129,25 +129,95 @@ func (p *Parser) parseFunc() *Function {
 	return &Function{Name: name, Params: params, Body: body}
 }
 
+// parseForeign parses foreign func name(param: ctype, ...): ctype. The
+// declaration has no body.
+func (p *Parser) parseForeign() *ForeignFunction {
+	if !p.expect(lexer.FOREIGN) || !p.expect(lexer.FUNCTION) {
+		return nil
+	}
+	f := &ForeignFunction{Name: &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}, Params: []*Identifier{}}
+	if !p.expect(lexer.IDENT) || !p.expect(lexer.LPAREN) {
+		return nil
+	}
+	for p.curToken.Type != lexer.RPAREN && p.curToken.Type != lexer.EOF {
+		if f.Variadic {
+			p.addError(utils.ParseError{
+				Kind:    utils.InvalidSyntax,
+				Message: "... must be the last parameter of a foreign function",
+				Line:    p.curToken.Line,
+				Column:  p.curToken.Column,
+			})
+			return nil
+		}
+		if p.curToken.Type == lexer.VARARG {
+			f.Variadic = true
+			p.nextToken()
+		} else {
+			param := &Identifier{Value: p.curToken.Literal}
+			if !p.expect(lexer.IDENT) {
+				return nil
+			}
+			if p.curToken.Type == lexer.COLON {
+				p.nextToken()
+				if param.Type = p.parseCType(); param.Type == "" {
+					return nil
+				}
+			}
+			f.Params = append(f.Params, param)
+		}
+		if p.curToken.Type == lexer.COMMA {
+			p.nextToken()
+		}
+	}
+	if !p.expect(lexer.RPAREN) {
+		return nil
+	}
+	if p.curToken.Type == lexer.COLON {
+		p.nextToken()
+		if f.ReturnType = p.parseCType(); f.ReturnType == "" {
+			return nil
+		}
+	}
+	return f
+}
+
+// cTypes are the type names a foreign function may use.
+var cTypes = map[string]bool{
+	"int": true, "uint": true, "i32": true, "u32": true,
+	"char": true, "uchar": true, "byte": true, "i8": true, "u8": true,
+	"short": true, "ushort": true, "i16": true, "u16": true,
+	"long": true, "ulong": true, "size_t": true, "ssize_t": true, "i64": true, "u64": true,
+	"float": true, "f32": true, "double": true, "f64": true,
+	"bool": true, "cstr": true, "str": true, "ptr": true, "void": true,
+}
+
+// parseCType reads a C type name, returning "" after reporting an error.
+func (p *Parser) parseCType() string {
+	tok := p.curToken
+	if !p.expect(lexer.IDENT) {
+		return ""
+	}
+	if !cTypes[tok.Literal] {
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: fmt.Sprintf("unknown C type %q", tok.Literal),
+			Line:    tok.Line,
+			Column:  tok.Column,
+		})
+		return ""
+	}
+	return tok.Literal
+}
+
 func (p *Parser) parseMatch() *Match {
 	if !p.expect(lexer.MATCH) {
 		return nil
 	}
-	var expr Expression
-	switch p.curToken.Type {
-	case lexer.IDENT:
-		expr = &Identifier{Value: p.curToken.Literal}
-		p.nextToken()
-	case lexer.NUMBER:
-		expr = &Literal{Value: p.curToken.Literal}
-		p.nextToken()
-	case lexer.STRING:
-		expr = &Literal{Value: p.curToken.Literal}
-		p.nextToken()
-	default:
+	expr := p.parseHeaderExpression()
+	if expr == nil {
 		p.addError(utils.ParseError{
 			Kind:    utils.InvalidSyntax,
-			Message: "expected identifier or literal after match",
+			Message: "expected expression after match",
 			Line:    p.curToken.Line,
 			Column:  p.curToken.Column,
 		})
@@ -200,21 +270,35 @@ func (p *Parser) parsePattern() Expression {
 	switch p.curToken.Type {
 	case lexer.IDENT:
 		// Regular identifier pattern
-		pat := &Identifier{Value: p.curToken.Literal}
+		pat := &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		p.nextToken()
 		return pat
 
 	case lexer.UNDERSCORE:
 		// Wildcard pattern
-		pat := &Identifier{Value: "_"}
+		pat := &Identifier{Value: "_", Pos: p.curPos()}
 		p.nextToken()
 		return pat
 
 	case lexer.NUMBER, lexer.STRING:
 		// Literal pattern
-		pat := &Literal{Value: p.curToken.Literal}
+		return p.parseLiteral()
+
+	case lexer.MINUS:
+		// Negative number pattern
 		p.nextToken()
-		return pat
+		if p.curToken.Type != lexer.NUMBER {
+			p.addError(utils.ParseError{
+				Kind:    utils.InvalidSyntax,
+				Message: "expected number after - in pattern",
+				Line:    p.curToken.Line,
+				Column:  p.curToken.Column,
+			})
+			return nil
+		}
+		lit := p.parseLiteral()
+		lit.Value = "-" + lit.Value.(string)
+		return lit
 
 	case lexer.LBRACKET:
 		return p.parseArrayPattern()
@@ -233,18 +317,77 @@ func (p *Parser) parsePattern() Expression {
 	}
 }
 
+// parseStructPattern parses { x, y: pattern }, matching a struct by field
+// names. A field without a pattern binds a variable of the same name.
 func (p *Parser) parseStructPattern() Expression {
-	// For now, just parse as a simple identifier
-	// TODO: Implement proper struct pattern parsing
-	pat := &Identifier{Value: p.curToken.Literal}
-	p.nextToken()
-	return pat
+	p.nextToken() // consume {
+	fields := map[string]Expression{}
+	for p.curToken.Type != lexer.RBRACE && p.curToken.Type != lexer.EOF {
+		if p.curToken.Type != lexer.IDENT {
+			p.addError(utils.ParseError{
+				Kind:    utils.InvalidSyntax,
+				Message: "expected field name in struct pattern",
+				Line:    p.curToken.Line,
+				Column:  p.curToken.Column,
+			})
+			return nil
+		}
+		name := p.curToken.Literal
+		p.nextToken()
+		var pat Expression = &Identifier{Value: name}
+		if p.curToken.Type == lexer.COLON {
+			p.nextToken()
+			if pat = p.parsePattern(); pat == nil {
+				return nil
+			}
+		}
+		fields[name] = pat
+		if p.curToken.Type != lexer.COMMA {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expect(lexer.RBRACE) {
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: "expected } to close struct pattern",
+			Line:    p.curToken.Line,
+			Column:  p.curToken.Column,
+		})
+		return nil
+	}
+	return &StructInstantiation{Fields: fields}
 }
 
+// parseArrayPattern parses [a, 0, ...rest]. A spread may only come last and
+// matches the remaining elements.
 func (p *Parser) parseArrayPattern() Expression {
-	// For now, just parse as a simple identifier
-	// TODO: Implement proper array pattern parsing
-	pat := &Identifier{Value: p.curToken.Literal}
-	p.nextToken()
-	return pat
+	p.nextToken() // consume [
+	elems := []Expression{}
+	for p.curToken.Type != lexer.RBRACKET && p.curToken.Type != lexer.EOF {
+		var pat Expression
+		if p.curToken.Type == lexer.VARARG {
+			pat = p.parseSpread()
+		} else {
+			pat = p.parsePattern()
+		}
+		if pat == nil {
+			return nil
+		}
+		elems = append(elems, pat)
+		if _, rest := pat.(*Spread); rest || p.curToken.Type != lexer.COMMA {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expect(lexer.RBRACKET) {
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: "expected ] to close array pattern",
+			Line:    p.curToken.Line,
+			Column:  p.curToken.Column,
+		})
+		return nil
+	}
+	return &Array{Elements: elems}
 }
@@ -5,6 +5,16 @@ import (
 	"aether/src/lexer"
 )
 
+// parseHeaderExpression parses the expression in front of a control
+// statement body, where `x {` opens the body instead of a struct literal.
+func (p *Parser) parseHeaderExpression() Expression {
+	saved := p.noStructLiteral
+	p.noStructLiteral = true
+	expr := p.parseExpression()
+	p.noStructLiteral = saved
+	return expr
+}
+
 func (p *Parser) parseFor() *For {
 	if !p.expect(lexer.FOR) {
 		return nil
@@ -13,7 +23,7 @@ func (p *Parser) parseFor() *For {
 	var value *Identifier
 	if p.peekToken.Type == lexer.COMMA {
 		// for i, v in ...
-		value = &Identifier{Value: p.curToken.Literal}
+		value = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		if !p.expect(lexer.IDENT) {
 			return nil
 		}
@@ -21,13 +31,13 @@ func (p *Parser) parseFor() *For {
 			return nil
 		}
 		index = value
-		value = &Identifier{Value: p.curToken.Literal}
+		value = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		if !p.expect(lexer.IDENT) {
 			return nil
 		}
 	} else {
 		// for v in ...
-		value = &Identifier{Value: p.curToken.Literal}
+		value = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		if !p.expect(lexer.IDENT) {
 			return nil
 		}
@@ -35,7 +45,7 @@ func (p *Parser) parseFor() *For {
 	if !p.expect(lexer.IN) {
 		return nil
 	}
-	iterable := p.parseExpression()
+	iterable := p.parseHeaderExpression()
 	body := p.parseBlock()
 	return &For{Index: index, Value: value, Iterable: iterable, Body: body}
 }
@@ -44,7 +54,7 @@ func (p *Parser) parseIf() *If {
 	if !p.expect(lexer.IF) {
 		return nil
 	}
-	cond := p.parseExpression()
+	cond := p.parseHeaderExpression()
 	if cond == nil {
 		p.addError(utils.ParseError{
 			Kind:    utils.InvalidSyntax,
@@ -85,7 +95,7 @@ func (p *Parser) parseWhile() *While {
 	if !p.expect(lexer.WHILE) {
 		return nil
 	}
-	cond := p.parseExpression()
+	cond := p.parseHeaderExpression()
 	if cond == nil {
 		p.addError(utils.ParseError{
 			Kind:    utils.InvalidSyntax,
@@ -112,7 +122,7 @@ func (p *Parser) parseRepeat() *Repeat {
 	if !p.expect(lexer.REPEAT) {
 		return nil
 	}
-	count := p.parseExpression()
+	count := p.parseHeaderExpression()
 	if count == nil {
 		p.addError(utils.ParseError{
 			Kind:    utils.InvalidSyntax,
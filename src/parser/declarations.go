@@ -8,7 +8,7 @@ func (p *Parser) parseStruct() *StructDef {
 	if !p.expect(lexer.STRUCT) {
 		return nil
 	}
-	name := &Identifier{Value: p.curToken.Literal}
+	name := &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 	if !p.expect(lexer.IDENT) {
 		return nil
 	}
@@ -17,7 +17,7 @@ func (p *Parser) parseStruct() *StructDef {
 	}
 	fields := []*Field{}
 	for p.curToken.Type != lexer.RBRACE && p.curToken.Type != lexer.EOF {
-		fieldName := &Identifier{Value: p.curToken.Literal}
+		fieldName := &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		if !p.expect(lexer.IDENT) {
 			return nil
 		}
@@ -53,27 +53,33 @@ func (p *Parser) parsePrimary() Expression {
 			return nil
 		}
 		// Only parse struct instantiation if the next token is LBRACE and we're not in a match context
-		if p.peekToken.Type == lexer.LBRACE && !p.isParsingMatch {
+		if p.peekToken.Type == lexer.LBRACE && !p.isParsingMatch && !p.noStructLiteral {
 			expr = p.parseStructInstantiation()
 		} else {
-			expr = &Identifier{Value: p.curToken.Literal}
+			expr = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 			p.nextToken()
 		}
 	case lexer.NUMBER, lexer.STRING:
-		expr = &Literal{Value: p.curToken.Literal}
-		p.nextToken()
+		expr = p.parseLiteral()
 	case lexer.LBRACKET:
 		expr = p.parseArray()
 	case lexer.LBRACE:
-		// Try to parse as anonymous struct first, then lambda if that fails
-		saveToken := p.curToken
-		structExpr := p.parseAnonymousStruct()
-		if structExpr != nil {
-			expr = structExpr
+		// { name: value ... } and {} are anonymous structs, anything else
+		// is a lambda. Decide before consuming tokens, since a failed
+		// struct parse cannot be rewound.
+		if p.isAnonymousStructStart() {
+			expr = p.parseAnonymousStruct()
 		} else {
-			p.curToken = saveToken // restore if struct parse failed
 			expr = p.parseLambda()
 		}
+	case lexer.UNDERSCORE:
+		// A placeholder argument, as in add(5, _).
+		expr = &Identifier{Value: "_", Pos: p.curPos()}
+		p.nextToken()
+	case lexer.COPY:
+		// The copy keyword names the built-in copy(xs).
+		expr = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
+		p.nextToken()
 	case lexer.FUNCTION:
 		expr = p.parseFunc()
 	case lexer.VARARG:
@@ -107,17 +113,10 @@ func (p *Parser) parsePrimary() Expression {
 			continue
 		}
 		if p.curToken.Type == lexer.LBRACKET {
-			p.nextToken()
-			index := p.parseExpression()
-			if index == nil {
-				p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected expression for array index", Line: p.curToken.Line, Column: p.curToken.Column})
+			expr = p.parseIndexExpr(expr)
+			if expr == nil {
 				return nil
 			}
-			if !p.expect(lexer.RBRACKET) {
-				p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected ] after array index", Line: p.curToken.Line, Column: p.curToken.Column})
-				return nil
-			}
-			expr = &ArrayIndex{Array: expr, Index: index}
 			continue
 		}
 		break
@@ -125,6 +124,52 @@ func (p *Parser) parsePrimary() Expression {
 	return expr
 }
 
+// parseLiteral consumes the current NUMBER or STRING token.
+func (p *Parser) parseLiteral() *Literal {
+	kind := NumberLiteral
+	if p.curToken.Type == lexer.STRING {
+		kind = StringLiteral
+	}
+	lit := &Literal{Value: p.curToken.Literal, Kind: kind, Pos: p.curPos()}
+	p.nextToken()
+	return lit
+}
+
+// parseIndexExpr parses the [index] or [low:high] suffix following arr.
+func (p *Parser) parseIndexExpr(arr Expression) Expression {
+	pos := Pos{Line: p.curToken.Line, Column: p.curToken.Column}
+	p.nextToken()
+	var index Expression
+	if p.curToken.Type != lexer.COLON {
+		index = p.parseExpression()
+		if index == nil {
+			p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected expression for array index", Line: p.curToken.Line, Column: p.curToken.Column})
+			return nil
+		}
+	}
+	if p.curToken.Type == lexer.COLON {
+		p.nextToken()
+		var high Expression
+		if p.curToken.Type != lexer.RBRACKET {
+			high = p.parseExpression()
+			if high == nil {
+				p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected expression for slice bound", Line: p.curToken.Line, Column: p.curToken.Column})
+				return nil
+			}
+		}
+		if !p.expect(lexer.RBRACKET) {
+			p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected ] after slice", Line: p.curToken.Line, Column: p.curToken.Column})
+			return nil
+		}
+		return &Slice{Array: arr, Low: index, High: high, Pos: pos}
+	}
+	if !p.expect(lexer.RBRACKET) {
+		p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected ] after array index", Line: p.curToken.Line, Column: p.curToken.Column})
+		return nil
+	}
+	return &ArrayIndex{Array: arr, Index: index, Pos: pos}
+}
+
 func (p *Parser) parseCallExpr(fn Expression) Expression {
 	if !p.expect(lexer.LPAREN) {
 		p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "expected (", Line: p.curToken.Line, Column: p.curToken.Column})
@@ -139,11 +184,11 @@ func (p *Parser) parseCallExpr(fn Expression) Expression {
 		var arg Expression
 		if p.curToken.Type == lexer.VARARG {
 			if p.peekToken.Type == lexer.IDENT {
-				arg = &Spread{Name: p.peekToken.Literal}
+				arg = &Spread{Name: p.peekToken.Literal, Pos: Pos{Line: p.peekToken.Line, Column: p.peekToken.Column}}
 				p.nextToken()
 				p.nextToken()
 			} else {
-				arg = &Spread{Name: ""}
+				arg = &Spread{Name: "", Pos: p.curPos()}
 				p.nextToken()
 			}
 		} else {
@@ -174,21 +219,22 @@ func (p *Parser) parseCallExpr(fn Expression) Expression {
 }
 
 func (p *Parser) parseSpread() *Spread {
+	pos := p.curPos()
 	if !p.expect(lexer.VARARG) {
 		return nil
 	}
 	if p.curToken.Type == lexer.IDENT {
-		name := p.curToken.Literal
+		name, pos := p.curToken.Literal, p.curPos()
 		p.nextToken()
-		return &Spread{Name: name}
+		return &Spread{Name: name, Pos: pos}
 	}
-	return &Spread{Name: ""}
+	return &Spread{Name: "", Pos: pos}
 }
 
 func (p *Parser) parseCall() Expression {
 	var fn Expression
 	if p.curToken.Type == lexer.IDENT {
-		fn = &Identifier{Value: p.curToken.Literal}
+		fn = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		p.nextToken()
 	} else {
 		fn = p.parseExpression()
@@ -218,12 +264,12 @@ func (p *Parser) parseCall() Expression {
 		}
 		if p.curToken.Type == lexer.VARARG {
 			if p.peekToken.Type == lexer.IDENT {
-				spread := &Spread{Name: p.peekToken.Literal}
+				spread := &Spread{Name: p.peekToken.Literal, Pos: Pos{Line: p.peekToken.Line, Column: p.peekToken.Column}}
 				args = append(args, spread)
 				p.nextToken() // consume ...
 				p.nextToken() // consume ident
 			} else {
-				spread := &Spread{Name: ""}
+				spread := &Spread{Name: "", Pos: p.curPos()}
 				args = append(args, spread)
 				p.nextToken() // consume ...
 			}
@@ -274,6 +320,7 @@ func (p *Parser) parseCall() Expression {
 
 func (p *Parser) parseArray() Expression {
   elems := []Expression{}
+  pos := p.curPos()
   if !p.expect(lexer.LBRACKET) {
     return nil
   }
@@ -281,7 +328,7 @@ func (p *Parser) parseArray() Expression {
   // Handle empty array: [ ]
   if p.curToken.Type == lexer.RBRACKET {
     p.nextToken()
-    return &Array{Elements: elems}
+    return &Array{Elements: elems, Pos: pos}
   }
 
   for p.curToken.Type != lexer.RBRACKET && p.curToken.Type != lexer.EOF {
@@ -314,7 +361,7 @@ func (p *Parser) parseArray() Expression {
   if !p.expect(lexer.RBRACKET) {
     return nil
   }
-  return &Array{Elements: elems}
+  return &Array{Elements: elems, Pos: pos}
 }
 
 func (p *Parser) parseLambda() Expression {
@@ -331,14 +378,14 @@ func (p *Parser) parseBinaryExpr(minPrec int) Expression {
 	for {
 		prec, isOp := lexer.Precedences[p.curToken.Type]
 		if isOp && prec >= minPrec {
-			op := p.curToken.Type
+			op, pos := p.curToken.Type, p.curPos()
 			p.nextToken()
 			right := p.parseBinaryExpr(prec + 1)
 			if left == nil || right == nil {
 				p.addError(utils.ParseError{Kind: utils.InvalidSyntax, Message: "nil in binary expression", Line: p.curToken.Line, Column: p.curToken.Column})
 				return nil
 			}
-			left = &Call{Function: &Identifier{Value: parseLiteralForOperator(op)}, Args: []Expression{left, right}}
+			left = &Call{Function: &Identifier{Value: parseLiteralForOperator(op), Pos: pos}, Args: []Expression{left, right}}
 			continue
 		}
 		if p.curToken.Type == lexer.LPAREN {
@@ -353,27 +400,17 @@ func (p *Parser) parseBinaryExpr(minPrec int) Expression {
 			continue
 		}
 		if p.curToken.Type == lexer.LBRACKET {
-			p.nextToken()
-			index := p.parseExpression()
-			if index == nil {
-				p.addError(utils.ParseError{
-					Kind:    utils.InvalidSyntax,
-					Message: "expected expression for array index",
-					Line:    p.curToken.Line,
-					Column:  p.curToken.Column,
-				})
+			left = p.parseIndexExpr(left)
+			if left == nil {
 				return nil
 			}
-			if !p.expect(lexer.RBRACKET) {
-				p.addError(utils.ParseError{
-					Kind:    utils.InvalidSyntax,
-					Message: "expected ] after array index",
-					Line:    p.curToken.Line,
-					Column:  p.curToken.Column,
-				})
+			continue
+		}
+		if p.curToken.Type == lexer.AS && left != nil {
+			left = p.parseCast(left)
+			if left == nil {
 				return nil
 			}
-			left = &ArrayIndex{Array: left, Index: index}
 			continue
 		}
 		break
@@ -381,13 +418,32 @@ func (p *Parser) parseBinaryExpr(minPrec int) Expression {
 	return left
 }
 
+// parseCast parses the "as T" after expr. It binds tighter than the binary
+// operators, so a + b as u8 converts only b.
+func (p *Parser) parseCast(expr Expression) Expression {
+	pos := Pos{Line: p.curToken.Line, Column: p.curToken.Column}
+	p.nextToken()
+	typ := p.curToken.Literal
+	if p.curToken.Type != lexer.IDENT {
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: "expected a type name after 'as'",
+			Line:    p.curToken.Line,
+			Column:  p.curToken.Column,
+		})
+		return nil
+	}
+	p.nextToken()
+	return &Cast{Expr: expr, Type: typ, Pos: pos}
+}
+
 func (p *Parser) parseExpression() Expression {
 	return p.parseBinaryExpr(0)
 }
 
 func (p *Parser) parseUnary() Expression {
 	if p.curToken.Type == lexer.MINUS || p.curToken.Type == lexer.NOT_EQ {
-		op := p.curToken.Type
+		op, pos := p.curToken.Type, p.curPos()
 		p.nextToken()
 		right := p.parseUnary()
 		if right == nil {
@@ -404,7 +460,7 @@ func (p *Parser) parseUnary() Expression {
 			operator = "!"
 		}
 		return &Call{
-			Function: &Identifier{Value: operator},
+			Function: &Identifier{Value: operator, Pos: pos},
 			Args:     []Expression{right},
 		}
 	}
@@ -427,7 +483,8 @@ func (p *Parser) parsePropertyAccess() *PropertyAccess {
 }
 
 func (p *Parser) parseStructInstantiation() *StructInstantiation {
-	typeName := &Identifier{Value: p.curToken.Literal}
+	pos := p.curPos()
+	typeName := &Identifier{Value: p.curToken.Literal, Pos: pos}
 	p.nextToken()
 	
 	if !p.expect(lexer.LBRACE) {
@@ -498,10 +555,21 @@ func (p *Parser) parseStructInstantiation() *StructInstantiation {
 	return &StructInstantiation{
 		TypeName: typeName,
 		Fields:   fields,
+		Pos:      pos,
+	}
+}
+
+// isAnonymousStructStart reports whether the current { opens an anonymous
+// struct literal rather than a lambda.
+func (p *Parser) isAnonymousStructStart() bool {
+	if p.peekToken.Type == lexer.RBRACE {
+		return true
 	}
+	return p.peekToken.Type == lexer.IDENT && p.peekTokenN(1).Type == lexer.COLON
 }
 
 func (p *Parser) parseAnonymousStruct() *StructInstantiation {
+	pos := p.curPos()
 	if !p.expect(lexer.LBRACE) {
 		return nil
 	}
@@ -514,6 +582,7 @@ func (p *Parser) parseAnonymousStruct() *StructInstantiation {
 		return &StructInstantiation{
 			TypeName: nil,
 			Fields:   fields,
+			Pos:      pos,
 		}
 	}
 	for p.curToken.Type != lexer.RBRACE && p.curToken.Type != lexer.EOF {
@@ -566,5 +635,6 @@ func (p *Parser) parseAnonymousStruct() *StructInstantiation {
 	return &StructInstantiation{
 		TypeName: nil,
 		Fields:   fields,
+		Pos:      pos,
 	}
 }
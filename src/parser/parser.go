@@ -21,7 +21,9 @@ type Parser struct {
 	sourceLines []string
 	currentFile string
 	isParsingMatch bool
-	IsEntryFile bool
+	noStructLiteral bool
+	// includes holds the // #include comments seen so far.
+	includes []Statement
 }
 
 func NewParser(l *lexer.Lexer) *Parser {
@@ -64,9 +66,24 @@ func (p *Parser) recoverAfterError() {
 	}
 }
 
+// curPos is the position of the current token.
+func (p *Parser) curPos() Pos {
+	return Pos{Line: p.curToken.Line, Column: p.curToken.Column}
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	// Include directives name the C headers, and so the libraries, a file
+	// binds to. They are kept for analysis instead of being parsed.
+	for p.peekToken.Type == lexer.C_COMMENT && isIncludeComment(p.peekToken.Literal) {
+		p.includes = append(p.includes, &CComment{Content: strings.TrimSpace(p.peekToken.Literal)})
+		p.peekToken = p.l.NextToken()
+	}
+}
+
+func isIncludeComment(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "#include")
 }
 
 // peekTokenN safely peeks n tokens ahead without advancing the parser state.
@@ -98,42 +115,13 @@ func (p *Parser) expect(t lexer.TokenType) bool {
 	return true
 }
 
+// Parse parses the whole file. Its top-level statements stay in order: in
+// the entry file as in any other module, they are the module's body, which
+// the compiler runs from main or the module initializer.
 func (p *Parser) Parse() *Program {
 	program := &Program{}
 	stmts := p.parseStatementList(lexer.EOF)
-
-	// Separate top-level statements
-	var topLevel []Statement
-	var others []Statement
-	mainExists := false
-	for _, s := range stmts {
-		if fn, ok := s.(*Function); ok && fn.Name != nil && fn.Name.Value == "main" {
-			mainExists = true
-		}
-		if _, ok := s.(*Function); ok {
-			others = append(others, s)
-		} else if _, ok := s.(*StructDef); ok {
-			others = append(others, s)
-		} else if _, ok := s.(*Import); ok {
-			others = append(others, s)
-		} else if _, ok := s.(*Package); ok {
-			others = append(others, s)
-		} else {
-			topLevel = append(topLevel, s)
-		}
-	}
-
-	// Only synthesize main for the entry file
-	if len(topLevel) > 0 && !mainExists && p.IsEntryFile {
-		mainFn := &Function{
-			Name:   &Identifier{Value: "main"},
-			Params: []*Identifier{},
-			Body:   &Block{Statements: topLevel},
-		}
-		others = append(others, mainFn)
-	}
-
-	program.Statements = others
+	program.Statements = append(p.includes, stmts...)
 	return program
 }
 
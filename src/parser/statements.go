@@ -3,7 +3,6 @@ package parser
 import (
 	"aether/lib/utils"
 	"aether/src/lexer"
-	"fmt"
 )
 
 // isAssignmentPattern checks if the current token sequence matches
@@ -44,73 +43,44 @@ tokens := []lexer.Token{p.curToken, p.peekToken}
 }
 
 func (p *Parser) parseTupleAssignment(names []*Identifier) *Assignment {
-	if p.curToken.Type == lexer.ASSIGN {
+	if p.curToken.Type != lexer.ASSIGN {
 		p.nextToken()
-		var value Expression
-		if p.curToken.Type == lexer.LBRACKET {
-			arr := p.parseArray()
-			arrayNode, ok := arr.(*Array)
-			if !ok || arrayNode == nil {
-				p.addError(utils.ParseError{
-					Kind:    utils.InvalidSyntax,
-					Message: "invalid array on right-hand side of tuple assignment",
-					Line:    p.curToken.Line,
-					Column:  p.curToken.Column,
-				})
-				elems := make([]Expression, len(names))
-				for i := range elems {
-					elems[i] = &Literal{Value: nil}
-				}
-				return &Assignment{Names: names, Value: &Array{Elements: elems}}
-			}
-			value = arrayNode
-		} else {
-			elems := []Expression{}
-			first := p.parseExpression()
-			fmt.Println("First right side expression:", first)
-			if first != nil {
-				elems = append(elems, first)
-			}
-			for p.curToken.Type == lexer.COMMA {
-				p.nextToken()
-				expr := p.parseExpression()
-				fmt.Println("Next right side expression:", expr)
-				if expr != nil {
-					elems = append(elems, expr)
-				}
-			}
-			for len(elems) < len(names) {
-				elems = append(elems, &Literal{Value: nil})
-			}
-			if len(elems) > len(names) {
-				elems = elems[:len(names)]
-			}
-			for i := range elems {
-				if elems[i] == nil {
-					elems[i] = &Literal{Value: nil}
-				}
-			}
-			if elems == nil {
-				elems = make([]Expression, len(names))
-				for i := range elems {
-					elems[i] = &Literal{Value: nil}
-				}
-			}
-			value = &Array{Elements: elems}
-		}
-		
-		if p.curToken.Type == lexer.COMMA {
-			p.nextToken()
-		}
-		if p.curToken.Type == lexer.EOF {
-			p.nextToken()
-		}
-		
-		return &Assignment{Names: names, Value: value}
+		return nil
 	}
-	
 	p.nextToken()
-	return nil
+	value := p.parseExpressionList()
+	if value == nil {
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: "expected expression on right-hand side of tuple assignment",
+			Line:    p.curToken.Line,
+			Column:  p.curToken.Column,
+		})
+		return nil
+	}
+	if p.curToken.Type == lexer.EOF {
+		p.nextToken()
+	}
+	return &Assignment{Names: names, Value: value}
+}
+
+// parseExpressionList parses one or more comma separated expressions. A
+// single expression is returned as is and several become a Tuple.
+func (p *Parser) parseExpressionList() Expression {
+	first := p.parseExpression()
+	if first == nil || p.curToken.Type != lexer.COMMA {
+		return first
+	}
+	elems := []Expression{first}
+	for p.curToken.Type == lexer.COMMA {
+		p.nextToken()
+		expr := p.parseExpression()
+		if expr == nil {
+			return nil
+		}
+		elems = append(elems, expr)
+	}
+	return &Tuple{Elements: elems}
 }
 
 // parseStatement parses a single statement, which may be an assignment,
@@ -118,12 +88,12 @@ func (p *Parser) parseTupleAssignment(names []*Identifier) *Assignment {
 func (p *Parser) parseStatement() Statement {
 if p.isAssignmentPattern() {
 	// starts at the first IDENT
-    names := []*Identifier{{Value: p.curToken.Literal}}
+    names := []*Identifier{{Value: p.curToken.Literal, Pos: p.curPos()}}
     
     for p.peekToken.Type == lexer.COMMA {
         p.nextToken()
         p.nextToken()
-        names = append(names, &Identifier{Value: p.curToken.Literal})
+        names = append(names, &Identifier{Value: p.curToken.Literal, Pos: p.curPos()})
     }
     
     p.nextToken()
@@ -132,6 +102,11 @@ if p.isAssignmentPattern() {
 	switch p.curToken.Type {
 	case lexer.FUNCTION:
 		return p.parseFunc()
+	case lexer.FOREIGN:
+		if f := p.parseForeign(); f != nil {
+			return f
+		}
+		return nil
 	case lexer.STRUCT:
 		return p.parseStruct()
 	case lexer.IF:
@@ -194,6 +169,9 @@ if p.isAssignmentPattern() {
 			p.nextToken()
 			return nil
 		}
+		if p.curToken.Type == lexer.ASSIGN {
+			return p.parseElementAssignment(expr)
+		}
 		if stmt, ok := expr.(Statement); ok {
 			return stmt
 		}
@@ -203,8 +181,6 @@ if p.isAssignmentPattern() {
 }
 
 func (p *Parser) parseAssignmentWithNames(names []*Identifier) *Assignment {
-	fmt.Println(p.curToken, p.peekToken)
-	
 	if len(names) > 1 {
 		result := p.parseTupleAssignment(names)
 		if result != nil {
@@ -243,11 +219,39 @@ func (p *Parser) parseAssignmentWithNames(names []*Identifier) *Assignment {
 	return &Assignment{Names: names, Value: value}
 }
 
+// parseElementAssignment parses the value of target = value once target has
+// been read as an expression, e.g. xs[i] = 0 or p.x = 1.
+func (p *Parser) parseElementAssignment(target Expression) Statement {
+	switch target.(type) {
+	case *ArrayIndex, *PropertyAccess:
+	default:
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: "cannot assign to this expression",
+			Line:    p.curToken.Line,
+			Column:  p.curToken.Column,
+		})
+		return nil
+	}
+	p.nextToken()
+	value := p.parseExpression()
+	if value == nil {
+		p.addError(utils.ParseError{
+			Kind:    utils.InvalidSyntax,
+			Message: "expected expression for assignment value",
+			Line:    p.curToken.Line,
+			Column:  p.curToken.Column,
+		})
+		return nil
+	}
+	return &ElementAssignment{Target: target, Value: value}
+}
+
 func (p *Parser) parseReturn() *Return {
 	if !p.expect(lexer.RETURN) {
 		return nil
 	}
-	expr := p.parseExpression()
+	expr := p.parseExpressionList()
 	if expr == nil {
 		p.addError(utils.ParseError{
 			Kind:    utils.InvalidSyntax,
@@ -266,10 +270,10 @@ func (p *Parser) parseImport() *Import {
 	}
 	var name *Identifier
 	if p.curToken.Type == lexer.STRING {
-		name = &Identifier{Value: p.curToken.Literal}
+		name = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		p.nextToken()
 	} else if p.curToken.Type == lexer.IDENT {
-		name = &Identifier{Value: p.curToken.Literal}
+		name = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 		p.nextToken()
 	} else {
 		p.addError(utils.ParseError{
@@ -284,7 +288,7 @@ func (p *Parser) parseImport() *Import {
 	if p.curToken.Type == lexer.AS {
 		p.expect(lexer.AS)
 		if p.curToken.Type == lexer.IDENT || p.curToken.Type == lexer.DOT {
-			as = &Identifier{Value: p.curToken.Literal}
+			as = &Identifier{Value: p.curToken.Literal, Pos: p.curPos()}
 			p.nextToken()
 		} else {
 			p.addError(utils.ParseError{
@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f
+// for every node. If f returns false the children of that node are skipped.
+// Declared names (function names and parameters, struct fields, loop
+// variables) are not visited; assignment targets are.
+func Inspect(node Node, f func(Node) bool) {
+	if IsNilNode(node) || !f(node) {
+		return
+	}
+	for _, child := range children(node) {
+		Inspect(child, f)
+	}
+}
+
+// IsNilNode reports whether node is nil or a nil pointer, which the parser
+// leaves for a statement or expression that failed to parse.
+func IsNilNode(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func children(node Node) []Node {
+	var out []Node
+	add := func(nodes ...Node) {
+		for _, n := range nodes {
+			if !IsNilNode(n) {
+				out = append(out, n)
+			}
+		}
+	}
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			add(s)
+		}
+	case *Assignment:
+		for _, name := range n.Names {
+			add(name)
+		}
+		add(n.Value)
+	case *Function:
+		add(n.Body)
+	case *If:
+		add(n.Condition, n.Consequence, n.Alternative)
+	case *While:
+		add(n.Condition, n.Body)
+	case *Repeat:
+		add(n.Count, n.Body)
+	case *For:
+		add(n.Iterable, n.Body)
+	case *Block:
+		for _, s := range n.Statements {
+			add(s)
+		}
+	case *Return:
+		add(n.Value)
+	case *Array:
+		for _, e := range n.Elements {
+			add(e)
+		}
+	case *Tuple:
+		for _, e := range n.Elements {
+			add(e)
+		}
+	case *Call:
+		add(n.Function)
+		for _, a := range n.Args {
+			add(a)
+		}
+	case *PartialApplication:
+		add(n.Function)
+		for _, a := range n.Args {
+			add(a)
+		}
+	case *PropertyAccess:
+		add(n.Object)
+	case *ArrayIndex:
+		add(n.Array, n.Index)
+	case *Slice:
+		add(n.Array, n.Low, n.High)
+	case *Cast:
+		add(n.Expr)
+	case *ElementAssignment:
+		add(n.Target, n.Value)
+	case *StructInstantiation:
+		names := make([]string, 0, len(n.Fields))
+		for name := range n.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(n.Fields[name])
+		}
+	case *Match:
+		add(n.Expr)
+		for _, c := range n.Cases {
+			add(c)
+		}
+	case *Case:
+		add(n.Pattern, n.Body)
+	case *ExpressionStatement:
+		add(n.Expr)
+	}
+	return out
+}
@@ -1,40 +1,140 @@
 package scheduler
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
-// DetectCycles returns a list of cycles in the dependency graph.
+// DetectCycles returns the cycles in the dependency graph, one for each
+// strongly connected component that has one: a group of files that depend
+// on each other, directly or not, or a file that depends on itself. A cycle
+// is a chain of files in which each depends on the next and the last is the
+// first again, e.g. [a b c a]. It starts at the first file of the group in
+// sorted order and passes through every file of the group, coming back to
+// the start along the way when no single loop does.
 func DetectCycles(graph map[string][]string) [][]string {
 	var cycles [][]string
-	visited := make(map[string]bool)
-	recStack := make(map[string]bool)
-	for file := range graph {
-		if !visited[file] {
-			pathStack := []string{}
-			if detectCycle(file, graph, visited, recStack, pathStack) {
-				cycles = append(cycles, pathStack)
+	for _, comp := range StronglyConnected(graph) {
+		start := comp[0]
+		if len(comp) == 1 {
+			if dependsOn(graph, start, start) {
+				cycles = append(cycles, []string{start, start})
 			}
+			continue
+		}
+		inComp := make(map[string]bool, len(comp))
+		for _, file := range comp {
+			inComp[file] = true
 		}
+		chain := []string{start}
+		onChain := map[string]bool{start: true}
+		for _, file := range comp[1:] {
+			if onChain[file] {
+				continue
+			}
+			path := shortestPath(graph, inComp, chain[len(chain)-1], file)
+			for _, step := range path[1:] {
+				chain = append(chain, step)
+				onChain[step] = true
+			}
+		}
+		path := shortestPath(graph, inComp, chain[len(chain)-1], start)
+		cycles = append(cycles, append(chain, path[1:]...))
 	}
 	return cycles
 }
 
-func detectCycle(path string, deps map[string][]string, visited, recStack map[string]bool, pathStack []string) bool {
-	visited[path] = true
-	recStack[path] = true
-	pathStack = append(pathStack, path)
-	for _, dep := range deps[path] {
-		if !visited[dep] {
-			if detectCycle(dep, deps, visited, recStack, pathStack) {
-				return true
+// StronglyConnected returns the strongly connected components of the
+// dependency graph, found by Tarjan's algorithm. Each component is sorted,
+// and the components are in the order of their first file.
+func StronglyConnected(graph map[string][]string) [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var comps [][]string
+	var visit func(file string)
+	visit = func(file string) {
+		index[file] = len(index)
+		low[file] = index[file]
+		stack = append(stack, file)
+		onStack[file] = true
+		for _, dep := range graph[file] {
+			if _, seen := index[dep]; !seen {
+				visit(dep)
+				low[file] = min(low[file], low[dep])
+			} else if onStack[dep] {
+				low[file] = min(low[file], index[dep])
 			}
-		} else if recStack[dep] {
+		}
+		if low[file] != index[file] {
+			return
+		}
+		var comp []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			comp = append(comp, top)
+			if top == file {
+				break
+			}
+		}
+		sort.Strings(comp)
+		comps = append(comps, comp)
+	}
+	files := make([]string, 0, len(graph))
+	for file := range graph {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if _, seen := index[file]; !seen {
+			visit(file)
+		}
+	}
+	sort.Slice(comps, func(i, j int) bool { return comps[i][0] < comps[j][0] })
+	return comps
+}
+
+func dependsOn(graph map[string][]string, file, dep string) bool {
+	for _, d := range graph[file] {
+		if d == dep {
 			return true
 		}
 	}
-	recStack[path] = false
 	return false
 }
 
+// shortestPath returns the shortest chain of dependencies from one file to
+// another that stays within allowed, both ends included. The files of a
+// strongly connected component always have one.
+func shortestPath(graph map[string][]string, allowed map[string]bool, from, to string) []string {
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		for _, dep := range graph[file] {
+			if _, seen := prev[dep]; seen || !allowed[dep] {
+				continue
+			}
+			prev[dep] = file
+			if dep == to {
+				path := []string{to}
+				for at := file; ; at = prev[at] {
+					path = append([]string{at}, path...)
+					if at == from {
+						return path
+					}
+				}
+			}
+			queue = append(queue, dep)
+		}
+	}
+	return nil
+}
+
 // TopoSort returns a topologically sorted list of files based on the dependency graph.
 func TopoSort(graph map[string][]string) ([]string, error) {
 	visited := make(map[string]bool)
@@ -17,7 +17,13 @@ func RunBatch(jobs []func(), pool *WorkerPool) {
 func RunBatches(jobs map[string]func(), graph map[string][]string, pool *WorkerPool) {
   completed := make(map[string]bool)
   scheduled := make(map[string]bool)
-  total := len(jobs)
+  // Files without a job are up to date; their dependents may start at once.
+  for file := range graph {
+    if jobs[file] == nil {
+      completed[file] = true
+    }
+  }
+  total := len(jobs) + len(completed)
   for len(completed) < total {
     batch := NextBatch(graph, completed, scheduled)
     if len(batch) == 0 {
@@ -0,0 +1,188 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+)
+
+const borrowFuncs = `func read(arr) {
+  print(arr[0])
+}
+func write(arr) {
+  arr[0] = 99
+}
+func twice(arr) {
+  write(arr)
+}
+`
+
+func TestCheckBorrowsSequentialCalls(t *testing.T) {
+	src := borrowFuncs + `x = [1, 2, 3]
+read(x)
+write(x)
+read(x)
+twice(x)`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, nil)
+}
+
+func TestCheckBorrowsAliases(t *testing.T) {
+	src := borrowFuncs + `x = [1, 2, 3]
+y = x
+write(x)
+print(y)
+z = x
+twice(x)
+twice(z)
+w = x
+print(x)
+w.push(4)
+v = x
+v = [0]
+write(x)
+print(v)
+u = x
+write(u)
+print(x)
+print(u)`
+	errs := analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.BorrowConflict, []string{
+		"13:7: cannot borrow y as shared while it is also borrowed as mutable",
+		"16:7: cannot borrow z as mutable more than once at a time",
+		"26:7: cannot borrow x as shared while it is also borrowed as mutable",
+	})
+	if errs[0].Snippet != "print(y)" || errs[0].Fix != "'y' refers to the same value as 'x' since line 11, and line 12 mutates it through 'x'. Use 'x' here instead, or give 'y' its own copy with copy(x)." {
+		t.Errorf("unexpected snippet or fix: %+v", errs[0])
+	}
+	if errs[2].Fix != "'u' refers to the same value as 'x' since line 24, mutates it at line 25 and is used again at line 27. Use 'u' here instead, or give 'u' its own copy with copy(x)." {
+		t.Errorf("unexpected fix: %+v", errs[2])
+	}
+}
+
+func TestCheckBorrowsSpecAlias(t *testing.T) {
+	src := borrowFuncs + `x = [1, 2, 3]
+y = x
+write(x)
+write(y)`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, []string{
+		"13:7: cannot borrow y as mutable more than once at a time",
+	})
+}
+
+func TestCheckBorrowsBranchesAndLoops(t *testing.T) {
+	src := `func f(xs, ok) {
+  a = xs
+  if ok {
+    xs.push(1)
+  } else {
+    print(a)
+  }
+  b = xs
+  while ok {
+    print(b)
+    xs.push(2)
+  }
+}`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, []string{
+		"10:11: cannot borrow b as shared while it is also borrowed as mutable",
+	})
+}
+
+func TestCheckBorrowsClosures(t *testing.T) {
+	src := `func f(xs, n) {
+  show = {
+    print(n)
+  }
+  n = n + 1
+  show()
+  inc = {
+    xs.push(1)
+  }
+  dec = {
+    xs.push(2)
+  }
+  inc()
+  dec()
+  print(xs)
+}`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, []string{
+		"13:3: cannot borrow xs as mutable more than once at a time",
+	})
+}
+
+func TestCheckBorrowsIterationAndArguments(t *testing.T) {
+	src := `func merge(dst, src) {
+  for v in src {
+    dst.push(v)
+  }
+}
+func same(a, b) {
+  return a[0] == b[0]
+}
+func grow(xs) {
+  for v in xs {
+    xs.push(v)
+  }
+  merge(xs, xs)
+  print(same(xs, xs))
+}`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, []string{
+		"11:5: cannot borrow xs as mutable while it is also borrowed as shared",
+		"13:13: cannot borrow xs as shared while it is also borrowed as mutable",
+	})
+}
+
+func TestCheckBorrowsSpreadArguments(t *testing.T) {
+	src := `func sum(a, b) {
+  return a + b
+}
+xs = [1, 2]
+print(sum(...xs))
+ys = xs
+ys.push(3)
+print(sum(...xs))
+print(ys)`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, []string{
+		"8:14: cannot borrow xs as shared while it is also borrowed as mutable",
+	})
+}
+
+func TestCheckBorrowsLifetime(t *testing.T) {
+	src := `func f(ok) {
+  keep = []
+  moved = []
+  if ok {
+    z = [1]
+    keep = z
+    print(z)
+    w = [2]
+    moved = w
+  }
+  print(keep, moved)
+}`
+	expectDiagnostics(t, analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil), utils.BorrowConflict, []string{
+		"6:12: reference to z outlives its scope",
+	})
+}
+
+func TestCheckBorrowsAppendToAlias(t *testing.T) {
+	src := `zs = [1, 2]
+ws = zs
+zs = append(zs, 3)
+print(ws)
+vs = [1]
+us = vs
+vs = vs.append(2)
+print(us)
+xs = [1]
+ys = xs
+xs = [2]
+xs = append(xs, 3)
+print(ys)`
+	errs := analysis.CheckBorrows(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.BorrowConflict, []string{
+		"4:7: cannot borrow ws as shared while it is also borrowed as mutable",
+		"8:7: cannot borrow us as shared while it is also borrowed as mutable",
+	})
+}
@@ -0,0 +1,16 @@
+package analysis_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+)
+
+func TestLinkLibrariesFromIncludes(t *testing.T) {
+	includes := analysis.ParseCIncludes("#include <stdio.h>\n#include <math.h>\n#include <pthread.h>\n#include <complex.h>\n#include \"local.h\"\n#include <curl/curl.h>")
+	got := strings.Join(analysis.LinkLibraries(includes), " ")
+	if got != "m pthread curl" {
+		t.Errorf("expected libraries \"m pthread curl\", got %q", got)
+	}
+}
@@ -0,0 +1,225 @@
+package analysis_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+// source is a module of a test project, listed before the modules that
+// import it.
+type source struct {
+	name, src string
+}
+
+// buildCallGraph parses modules in order, the last one as the entry
+// module, and builds their call graph.
+func buildCallGraph(t *testing.T, library bool, modules ...source) *analysis.CallGraph {
+	t.Helper()
+	imports := make(map[string]map[string]interface{})
+	var project []analysis.ProjectModule
+	for i, m := range modules {
+		entry := i == len(modules)-1
+		p := parser.NewParser(lexer.NewLexer(m.src))
+		prog := p.Parse()
+		if p.Errors.Len() > 0 {
+			t.Fatalf("parser errors in %s: %+v", m.name, p.Errors.ToMessages())
+		}
+		imports[m.name] = analysis.ModuleExports(prog, imports)
+		project = append(project, analysis.ProjectModule{
+			Name:   m.name,
+			File:   m.name + ".ae",
+			Source: m.src,
+			Prog:   prog,
+			Entry:  entry,
+		})
+	}
+	return analysis.BuildCallGraph(project, imports, library)
+}
+
+func reachable(g *analysis.CallGraph) map[string]bool {
+	got := make(map[string]bool)
+	for _, n := range g.Nodes {
+		got[n.ID()] = n.Reachable
+	}
+	return got
+}
+
+func TestCallGraphFollowsModuleCallsAndClosures(t *testing.T) {
+	util := source{"util", `func Double(x) {
+    return twice(x)
+}
+
+func twice(x) {
+    return x + x
+}
+
+func Square(x) {
+    return x * x
+}
+
+func Unused() {
+    return 0
+}`}
+	main := source{"main", `import util
+
+func apply(f, x) {
+    return f(x)
+}
+
+func callback(x) {
+    return x + 1
+}
+
+func never() {
+    return util.Square(2)
+}
+
+sq = func(x) { return util.Double(x) }
+print(apply(sq, 3))
+print(apply(callback, 3))`}
+	got := reachable(buildCallGraph(t, false, util, main))
+	want := map[string]bool{
+		"main":          true,
+		"main.apply":    true,
+		"main.callback": true,
+		"main.never":    false,
+		"util":          true,
+		"util.Double":   true,
+		"util.twice":    true,
+		"util.Square":   false,
+		"util.Unused":   false,
+	}
+	for id, w := range want {
+		if r, ok := got[id]; !ok {
+			t.Errorf("no node %s in %v", id, got)
+		} else if r != w {
+			t.Errorf("%s: reachable = %v, want %v", id, r, w)
+		}
+	}
+}
+
+func TestCallGraphLeavesOutUnusedModules(t *testing.T) {
+	quiet := source{"quiet", `Limit = 10
+
+func Clamp(x) {
+    return x
+}`}
+	loud := source{"loud", `print("loaded")
+
+func Hello() {
+    return 1
+}`}
+	main := source{"main", `import quiet
+import loud
+
+x = 1`}
+	g := buildCallGraph(t, false, quiet, loud, main)
+	if g.ModuleReachable("quiet") {
+		t.Errorf("expected module quiet, which is never used, to be left out")
+	}
+	if !g.ModuleReachable("loud") {
+		t.Errorf("expected module loud, whose body prints, to be linked in")
+	}
+	if live := g.Live("loud"); len(live) != 0 {
+		t.Errorf("expected no live functions in loud, got %v", live)
+	}
+
+	warns := g.Unreachable()
+	var msgs []string
+	for _, w := range warns {
+		msgs = append(msgs, w.Message)
+	}
+	wantMsgs := []string{
+		"function 'Hello' is never called and is left out of the build",
+		"module 'quiet' is imported but never used, and is left out of the build",
+	}
+	if strings.Join(msgs, "\n") != strings.Join(wantMsgs, "\n") {
+		t.Fatalf("warnings = %q, want %q", msgs, wantMsgs)
+	}
+	if w := warns[1]; w.File != "main.ae" || w.Line != 1 || w.Column != 8 {
+		t.Errorf("expected the module warning at its import, main.ae:1:8, got %s:%d:%d", w.File, w.Line, w.Column)
+	}
+	if w := warns[0]; w.File != "loud.ae" || w.Line != 3 {
+		t.Errorf("expected the function warning at its definition, loud.ae:3, got %s:%d", w.File, w.Line)
+	}
+}
+
+func TestCallGraphLibraryRoots(t *testing.T) {
+	lib := source{"shapes", `func Area(w, h) {
+    return scale(w) * h
+}
+
+func scale(x) {
+    return x
+}
+
+func unusedHelper() {
+    return 0
+}`}
+	g := buildCallGraph(t, true, lib)
+	got := reachable(g)
+	if !got["shapes.Area"] || !got["shapes.scale"] {
+		t.Errorf("expected exported functions and what they call to be roots, got %v", got)
+	}
+	if got["shapes.unusedHelper"] {
+		t.Errorf("expected unexported, uncalled functions to stay unreachable")
+	}
+}
+
+func TestCallGraphExport(t *testing.T) {
+	util := source{"util", `func Inc(x) {
+    return x + 1
+}
+
+func Dead() {
+    return 0
+}`}
+	main := source{"main", `import util
+print(util.Inc(1))`}
+	g := buildCallGraph(t, false, util, main)
+
+	dot := g.DOT()
+	for _, want := range []string{
+		"digraph calls {",
+		`"util" [shape=box];`,
+		`"util.Dead" [style=dashed, color=grey];`,
+		`"main" -> "util.Inc";`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("expected DOT to contain %q\n%s", want, dot)
+		}
+	}
+
+	data, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Nodes []struct {
+			ID        string `json:"id"`
+			Reachable bool   `json:"reachable"`
+		} `json:"nodes"`
+		Edges []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	if len(out.Nodes) != 4 {
+		t.Errorf("expected 4 nodes, got %s", data)
+	}
+	found := false
+	for _, e := range out.Edges {
+		found = found || e.From == "main" && e.To == "util.Inc"
+	}
+	if !found {
+		t.Errorf("expected an edge main -> util.Inc in %s", data)
+	}
+}
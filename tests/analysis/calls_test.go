@@ -0,0 +1,169 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+)
+
+func TestCheckCallsFixedParameters(t *testing.T) {
+	src := `func add(a, b) {
+    return a + b
+}
+x = add(1, 2)
+y = add(1, 2, 3)
+z = add(1)
+print(len(x, y))`
+	errs := analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.ArityMismatch, []string{
+		"5:15: too many arguments to 'add': expected 2, found 3",
+		"6:5: not enough arguments to 'add': expected 2, found 1",
+		"7:14: too many arguments to 'len': expected 1, found 2",
+	})
+	if errs[0].Fix != "'add' is declared as add(a, b)." || errs[0].Snippet != "y = add(1, 2, 3)" {
+		t.Errorf("unexpected fix or snippet: %+v", errs[0])
+	}
+}
+
+func TestCheckCallsRestAndSpread(t *testing.T) {
+	src := `func log(level, ...parts) {
+    print(level)
+}
+func pair(a, b) {
+    return a + b
+}
+xs = [1, 2]
+log(1)
+log(1, 2, 3)
+log()
+pair(...xs)
+pair(1, ...xs)
+pair(1, 2, ...xs)
+pair(1, 2, 3, ...xs)`
+	expectDiagnostics(t, analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, nil), utils.ArityMismatch, []string{
+		"10:1: not enough arguments to 'log': expected at least 1, found 0",
+		"13:15: spread 'xs' in call to 'pair' has no parameter left to fill",
+		"14:12: too many arguments to 'pair': expected 2, found at least 3",
+	})
+}
+
+func TestCheckCallsClosures(t *testing.T) {
+	src := `func add(a, b) {
+    return a + b
+}
+inc = add(1, _)
+both = add(_, _)
+plus = add
+greet = {
+    print("hi")
+}
+print(inc(2))
+print(inc(2, 3))
+print(both(2))
+print(plus(1, 2, 3))
+greet(1)
+half = add(1, 2, _)`
+	errs := analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.ArityMismatch, []string{
+		"11:14: too many arguments to 'inc': expected 1, found 2",
+		"12:7: not enough arguments to 'both': expected 2, found 1",
+		"13:18: too many arguments to 'plus': expected 2, found 3",
+		"14:7: too many arguments to 'greet': expected 0, found 1",
+		"15:18: too many arguments to 'add' in partial application: expected 2, found 3",
+	})
+	if errs[0].Fix != "'inc' is add(...), which leaves 1 parameter open." {
+		t.Errorf("unexpected fix: %q", errs[0].Fix)
+	}
+}
+
+func TestCheckCallsInferredCallees(t *testing.T) {
+	src := `func add(a, b) {
+    return a + b
+}
+func mk() {
+    return add(1, _)
+}
+c = mk()
+print(c(5))
+print(c(5, 6))
+print(mk()())`
+	errs := analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.ArityMismatch, []string{
+		"9:12: too many arguments to 'c': expected 1, found 2",
+		"10:7: not enough arguments to 'function': expected 1, found 0",
+	})
+	if errs[0].Fix != "'c' is a function taking 1 argument." {
+		t.Errorf("unexpected fix: %q", errs[0].Fix)
+	}
+}
+
+func TestCheckCallsModules(t *testing.T) {
+	imports := map[string]map[string]interface{}{
+		"geo": {
+			"Area": analysis.FunctionInfo{Name: "Area", Parameters: []analysis.ParameterInfo{{Name: "w"}, {Name: "h"}}},
+			"Sum": analysis.FunctionInfo{Name: "Sum", Parameters: []analysis.ParameterInfo{
+				{Name: "first"}, {Name: "rest", Variadic: true},
+			}},
+			"printf": analysis.FunctionInfo{Name: "printf", Foreign: true, Variadic: true,
+				Parameters: []analysis.ParameterInfo{{Name: "format"}}},
+			"Origin": analysis.VariableInfo{Name: "Origin"},
+		},
+	}
+	src := `import geo as g
+xs = [1, 2]
+a = g.Area(1)
+b = g.Sum(1, 2, 3) + g.Sum()
+c = g.Zrea(1, 2)
+printf("%d %d", 1, 2)
+printf()
+printf(...xs)
+printf("%d", ...xs)`
+	errs := analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, imports)
+	expectDiagnostics(t, errs, utils.ArityMismatch, []string{
+		"3:5: not enough arguments to 'g.Area': expected 2, found 1",
+		"4:22: not enough arguments to 'g.Sum': expected at least 1, found 0",
+		"5:5: module 'g' has no function 'Zrea'; did you mean 'Area'?",
+		"7:1: not enough arguments to 'printf': expected at least 1, found 0",
+		"9:17: cannot spread 'xs' into the C varargs of 'printf'",
+	})
+	if errs[0].Fix != "'g.Area' is declared as g.Area(w, h)." {
+		t.Errorf("unexpected fix: %q", errs[0].Fix)
+	}
+	if errs[3].Fix != "'printf' is declared as printf(format, ...)." {
+		t.Errorf("unexpected fix: %q", errs[3].Fix)
+	}
+}
+
+func TestCheckCallsModuleMembers(t *testing.T) {
+	imports := map[string]map[string]interface{}{
+		"geo": {
+			"Area":   analysis.FunctionInfo{Name: "Area", Parameters: []analysis.ParameterInfo{{Name: "w"}, {Name: "h"}}},
+			"Origin": analysis.VariableInfo{Name: "Origin"},
+		},
+	}
+	src := `import geo
+a = geo.Origin
+b = geo.origin
+c = geo.Orign
+d = geo.Area
+e = geo.Area(1, 2)`
+	errs := analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, imports)
+	expectDiagnostics(t, errs, utils.UndefinedReference, []string{
+		"3:5: module 'geo' has no exported member 'origin'",
+		"4:5: module 'geo' has no exported member 'Orign'; did you mean 'Origin'?",
+	})
+	if want := "Only bindings whose names start with a capital letter are exported; rename 'origin' in the module to export it."; errs[0].Fix != want {
+		t.Errorf("unexpected fix: %q", errs[0].Fix)
+	}
+}
+
+func TestCheckCallsForeignFunctions(t *testing.T) {
+	src := `foreign func puts(s: cstr): int
+foreign func printf(format: cstr, ...): int
+puts("a", "b")
+printf("%d", 1, 2)`
+	expectDiagnostics(t, analysis.CheckCalls(parseProgram(t, src), "main.aeth", src, nil), utils.ArityMismatch, []string{
+		"3:11: too many arguments to 'puts': expected 1, found 2",
+	})
+}
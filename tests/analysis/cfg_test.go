@@ -0,0 +1,219 @@
+package analysis_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/parser"
+)
+
+func expectCFG(t *testing.T, g *analysis.CFG, want string) {
+	t.Helper()
+	if got := g.String(); got != want {
+		t.Errorf("unexpected graph:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCFGBranchesAndLoops(t *testing.T) {
+	graphs := analysis.BuildCFGs(parseProgram(t, `func f(n) {
+    x = 0
+    while n > 0 {
+        if n == 3 {
+            break
+        }
+        x = x + n
+        n = n - 1
+    }
+    for v in [1, 2] {
+        if v == 1 {
+            continue
+        } else {
+            print(v)
+        }
+    }
+    return x
+}`))
+	if len(graphs) != 2 {
+		t.Fatalf("expected graphs for the module and f, got %d", len(graphs))
+	}
+	expectCFG(t, graphs[0], "b0: Function:1 -> b1\nb1:\n")
+	expectCFG(t, graphs[1], `b0: Assignment:2 -> b1
+b1: Call:3 -> b2 b5
+b2: Call:4 -> b3 b4
+b3: -> b5
+b4: Assignment:7 Assignment:8 -> b1
+b5: Array:10 -> b6
+b6: For:10 -> b7 b11
+b7: Call:11 -> b8 b9
+b8: -> b6
+b9: Call:14 -> b10
+b10: -> b6
+b11: Return:17 -> b12
+b12:
+`)
+}
+
+func TestCFGMatchAndUnreachableCode(t *testing.T) {
+	graphs := analysis.BuildCFGs(parseProgram(t, `func f(x) {
+    match x {
+        case 1 { return 1 }
+        case _ { return 0 }
+        case 2 { return 2 }
+    }
+    print("never")
+    while true {
+        print("forever")
+    }
+    print("after")
+}
+g = {
+    print(1)
+}`))
+	if len(graphs) != 3 {
+		t.Fatalf("expected 3 graphs, got %d", len(graphs))
+	}
+	g := graphs[1]
+	expectCFG(t, g, `b0: Identifier:2 Case:3 -> b1 b2
+b1: Return:3 -> b11
+b2: Case:4 -> b3
+b3: Return:4 -> b11
+b4: Case:5 -> b5 b6
+b5: Return:5 -> b11
+b6: -> b7
+b7: Call:7 -> b8
+b8: Identifier:8 -> b9
+b9: Call:9 -> b8
+b10: Call:11 -> b11
+b11:
+`)
+	var dead []int
+	for i, ok := range g.Reachable() {
+		if !ok {
+			dead = append(dead, i)
+		}
+	}
+	if fmt.Sprint(dead) != "[4 5 6 7 8 9 10]" {
+		t.Errorf("unreachable blocks: got %v", dead)
+	}
+	expectCFG(t, graphs[2], "b0: Call:14 -> b1\nb1:\n")
+}
+
+// names returns the names a node assigns and the names it reads.
+func names(n parser.Node) (defs, uses []string) {
+	targets := make(map[parser.Node]bool)
+	if a, ok := n.(*parser.Assignment); ok {
+		for _, name := range a.Names {
+			defs = append(defs, name.Value)
+			targets[name] = true
+		}
+	}
+	parser.Inspect(n, func(n parser.Node) bool {
+		if id, ok := n.(*parser.Identifier); ok && !targets[id] {
+			uses = append(uses, id.Value)
+		}
+		return true
+	})
+	return defs, uses
+}
+
+type nameSet map[string]bool
+
+func (s nameSet) String() string {
+	var out []string
+	for name := range s {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
+
+func TestSolveLiveVariables(t *testing.T) {
+	graphs := analysis.BuildCFGs(parseProgram(t, `func f(n) {
+    x = 1
+    y = 2
+    while n > 0 {
+        x = x + y
+        n = n - 1
+    }
+    return x
+}`))
+	g := graphs[1]
+	in, _ := analysis.Solve(g, analysis.Dataflow[nameSet]{
+		Backward: true,
+		Boundary: nameSet{},
+		Init:     nameSet{},
+		Meet: func(a, b nameSet) nameSet {
+			out := nameSet{}
+			for k := range a {
+				out[k] = true
+			}
+			for k := range b {
+				out[k] = true
+			}
+			return out
+		},
+		Transfer: func(b *analysis.BasicBlock, live nameSet) nameSet {
+			out := nameSet{}
+			for k := range live {
+				out[k] = true
+			}
+			for i := len(b.Nodes) - 1; i >= 0; i-- {
+				defs, uses := names(b.Nodes[i])
+				for _, d := range defs {
+					delete(out, d)
+				}
+				for _, u := range uses {
+					if u != "-" && u != "+" && u != ">" {
+						out[u] = true
+					}
+				}
+			}
+			return out
+		},
+		Equal: func(a, b nameSet) bool { return a.String() == b.String() },
+	})
+	for i, want := range []string{"n", "n x y", "n x y", "x"} {
+		if got := in[i].String(); got != want {
+			t.Errorf("live at the start of b%d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestSolveForwardReachingCount(t *testing.T) {
+	graphs := analysis.BuildCFGs(parseProgram(t, `func f(ok) {
+    if ok {
+        a = 1
+    } else {
+        a = 2
+        b = 3
+    }
+    return a
+}`))
+	g := graphs[1]
+	// Count the assignments on the path with the most of them.
+	_, out := analysis.Solve(g, analysis.Dataflow[int]{
+		Boundary: 0,
+		Init:     0,
+		Meet: func(a, b int) int {
+			if a > b {
+				return a
+			}
+			return b
+		},
+		Transfer: func(b *analysis.BasicBlock, n int) int {
+			for _, node := range b.Nodes {
+				if _, ok := node.(*parser.Assignment); ok {
+					n++
+				}
+			}
+			return n
+		},
+		Equal: func(a, b int) bool { return a == b },
+	})
+	if got := out[g.Exit.Index]; got != 2 {
+		t.Errorf("expected at most 2 assignments on a path, got %d", got)
+	}
+}
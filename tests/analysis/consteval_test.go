@@ -0,0 +1,144 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+	"aether/src/parser"
+)
+
+// assignedValues folds the value of every top-level assignment by name.
+func assignedValues(t *testing.T, src string) map[string]string {
+	t.Helper()
+	prog := parseProgram(t, src)
+	consts := analysis.EvalConstants(prog, nil)
+	values := make(map[string]string)
+	for _, s := range prog.Statements {
+		if a, ok := s.(*parser.Assignment); ok && len(a.Names) == 1 {
+			if v, ok := consts.ValueOf(a.Value); ok {
+				values[a.Names[0].Value] = v.String()
+			}
+		}
+	}
+	return values
+}
+
+func TestEvalConstantsFoldsExpressions(t *testing.T) {
+	values := assignedValues(t, `a = 1 + 2 * 3
+b = 7 / 2 - -7 % 3
+c = 2147483647 + 1
+d = 5000000000 * 2
+e = 1 + 0.5
+f = "ab" .. "cd"
+g = "abc" < "abd"
+h = (1 == 2) == false
+i = len("hello") + [4, 5, 6].length
+j = [1, 2 + 1][1]
+k = 2 ^ 3
+l = 1.0 / 0`)
+	want := map[string]string{
+		"a": "7",
+		"b": "4",
+		"c": "-2147483648",
+		"d": "10000000000",
+		"e": "1.5",
+		"f": `"abcd"`,
+		"g": "true",
+		"h": "true",
+		"i": "8",
+		"j": "3",
+		"k": "1",
+		"l": "+Inf",
+	}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s: got %q, want %q", name, values[name], v)
+		}
+	}
+}
+
+func TestEvalConstantsPropagatesImmutableBindings(t *testing.T) {
+	values := assignedValues(t, `width = 4
+height = width * 2
+area = width * height
+count = 1
+count = count + 1
+total = count * 2
+xs = [1, 2, 3]
+first = xs[0] + len(xs)
+ys = [1, 2]
+ys.push(3)
+n = ys.length
+zs = [1]
+zs[0] = 5
+z = zs[0]
+func f(p) {
+    return p
+}
+q = f(area)
+r = q + 1`)
+	for name, v := range map[string]string{"height": "8", "area": "32", "first": "4"} {
+		if values[name] != v {
+			t.Errorf("%s: got %q, want %q", name, values[name], v)
+		}
+	}
+	for _, name := range []string{"total", "n", "z", "q", "r"} {
+		if v, ok := values[name]; ok {
+			t.Errorf("%s should not fold, got %s", name, v)
+		}
+	}
+}
+
+func TestCheckConstantsDivisionByZero(t *testing.T) {
+	src := `zero = 0
+a = 10 / 0
+b = 10 % zero
+c = 1.5 / 0
+d = 10 / (zero + 1)
+func f(x) {
+    return x / (2 - 2)
+}`
+	errs := analysis.CheckConstants(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.DivisionByZero, []string{
+		"2:10: integer division by zero",
+		"3:10: integer remainder by zero: the divisor is always 0",
+		"7:17: integer division by zero: the divisor is always 0",
+	})
+	if errs[0].Snippet != "a = 10 / 0" {
+		t.Errorf("unexpected snippet: %q", errs[0].Snippet)
+	}
+}
+
+func TestCheckFlowConstantConditions(t *testing.T) {
+	src := `debug = false
+limit = 10
+if debug {
+    print("debug")
+}
+if limit > 5 {
+    print("big")
+} else {
+    print("small")
+}
+while limit < 0 {
+    print(limit)
+}
+if 1 == 1 {
+    print("always")
+}
+x = 3
+x = 4
+if x > 5 {
+    print(x)
+}`
+	warns := analysis.CheckFlow(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, warns, utils.ConstantCondition, []string{
+		"6:4: condition is always true",
+		"11:7: condition is always false",
+		"14:4: condition is always true",
+	})
+	if warns[0].Fix != "The else branch never runs. Remove the if and keep its body." {
+		t.Errorf("unexpected fix: %q", warns[0].Fix)
+	}
+}
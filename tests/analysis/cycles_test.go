@@ -0,0 +1,62 @@
+package analysis_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+	"aether/src/scheduler"
+)
+
+func TestDetectCyclesReturnsChains(t *testing.T) {
+	graph := map[string][]string{
+		"main": {"a", "x"},
+		"a":    {"b"},
+		"b":    {"c", "d"},
+		"c":    {"a"},
+		"d":    {"b"},
+		"x":    {"y"},
+		"y":    {},
+		"self": {"self"},
+	}
+	got := fmt.Sprint(scheduler.DetectCycles(graph))
+	if want := "[[a b c a b d b c a] [self self]]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if cycles := scheduler.DetectCycles(map[string][]string{"a": {"b"}, "b": {}}); len(cycles) != 0 {
+		t.Errorf("expected no cycles, got %v", cycles)
+	}
+}
+
+func TestImportCyclesPointAtImports(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a.ae", "import b\nfunc A() {\n    return 1\n}\n")
+	b := write("b.ae", "import c\nimport a\nfunc B() {\n    return 2\n}\n")
+	c := write("c.ae", "func C() {\n    return 3\n}\n")
+	errs := analysis.ImportCycles(map[string][]string{a: {b}, b: {c, a}, c: {}})
+	if len(errs) != 2 {
+		t.Fatalf("expected an error for each import of the cycle, got %+v", errs)
+	}
+	for i, want := range []struct {
+		file, msg, snippet string
+		line               int
+	}{
+		{a, "import cycle a.ae → b.ae → a.ae: a.ae imports b.ae", "import b", 1},
+		{b, "import cycle a.ae → b.ae → a.ae: b.ae imports a.ae", "import a", 2},
+	} {
+		err := errs[i]
+		if err.Kind != utils.ImportCycle || err.File != want.file || err.Message != want.msg || err.Line != want.line || err.Column != 8 || err.Snippet != want.snippet {
+			t.Errorf("error %d: got %+v", i, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+)
+
+func TestCheckFlowUnreachableCode(t *testing.T) {
+	src := `func f(xs) {
+    for v in xs {
+        if v == 0 {
+            continue
+            print(v)
+        }
+        break
+        print(v)
+        v = v + 1
+    }
+    return 1
+    print("done")
+    while xs {
+        print(xs)
+    }
+}
+func g(x) {
+    while true {
+        print(x)
+    }
+    print("never")
+}
+func h(x) {
+    if x {
+        return 1
+    } else {
+        return 2
+    }
+    print(x)
+}`
+	warns := analysis.CheckFlow(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, warns, utils.UnreachableCode, []string{
+		"5:13: unreachable code after continue",
+		"8:9: unreachable code after break",
+		"12:5: unreachable code after return",
+		"21:5: unreachable code after the endless loop at line 18",
+		"29:5: unreachable code; no branch of the if at line 24 continues past it",
+	})
+	if warns[2].Snippet != `    print("done")` || warns[2].Fix != "The function returns at line 11 before it gets here. Remove the code, or move it before the return." {
+		t.Errorf("unexpected snippet or fix: %+v", warns[2])
+	}
+}
+
+func TestCheckFlowUnreachableMatchArms(t *testing.T) {
+	src := `func f(x) {
+    match x {
+        case 1 { return "one" }
+        case other { return "many" }
+        case 2 { return "two" }
+    }
+    print(x)
+}
+func g(x) {
+    match x {
+        case 1 { print(1) }
+        case 2 { print(x) }
+    }
+    print(x)
+}`
+	expectDiagnostics(t, analysis.CheckFlow(parseProgram(t, src), "main.aeth", src, nil), utils.UnreachableCode, []string{
+		"5:14: unreachable match arm; the arm at line 4 matches every value",
+		"7:5: unreachable code; no arm of the match at line 2 continues past it",
+	})
+}
+
+func TestCheckFlowMissingReturn(t *testing.T) {
+	src := `func sign(x) {
+    if x > 0 {
+        return 1
+    } else {
+        if x < 0 {
+            return -1
+        }
+    }
+}
+func find(xs, y) {
+    for v in xs {
+        if v == y {
+            return v
+        }
+    }
+}
+func clamp(x) {
+    if x > 9 {
+        return 9
+    }
+    return x
+}
+func forever(x) {
+    while true {
+        if x {
+            return 1
+        }
+    }
+}
+func log(x) {
+    print(x)
+}
+pick = {
+    if ready {
+        return 1
+    }
+}
+ready = true`
+	warns := analysis.CheckFlow(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, warns, utils.MissingReturn, []string{
+		"1:6: function 'sign' returns a value on some paths but not on others",
+		"10:6: function 'find' returns a value on some paths but not on others",
+		"34:8: lambda returns a value on some paths but not on others",
+	})
+	if warns[0].Fix != "It returns a value at line 3 but can also reach the end of its body, which returns zero. Return a value on every path." {
+		t.Errorf("unexpected fix: %q", warns[0].Fix)
+	}
+}
+
+func TestCheckFlowUseBeforeAssignment(t *testing.T) {
+	src := `func report() {
+    print(total)
+}
+func reset() {
+    count = 0
+}
+func run() {
+    report()
+}
+print(count)
+if ready {
+    total = 1
+}
+print(total)
+reset()
+print(count)
+run()
+total = 2
+count = 3
+ready = true
+run()`
+	warns := analysis.CheckFlow(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, warns, utils.UnassignedUse, []string{
+		"10:7: 'count' is used before it is assigned",
+		"11:4: 'ready' is used before it is assigned",
+		"14:7: 'total' may be used before it is assigned",
+	})
+	if warns[0].Fix != "'count' is first assigned at line 19; assign it before this point." {
+		t.Errorf("unexpected fix: %q", warns[0].Fix)
+	}
+}
+
+func TestCheckFlowUseBeforeAssignmentThroughCalls(t *testing.T) {
+	src := `func show() {
+    print(limit)
+}
+func twice() {
+    show()
+    show()
+}
+func set() {
+    limit = 5
+}
+for i in [1, 2] {
+    if i == 2 {
+        set()
+    }
+    twice()
+}
+limit = 1
+show()`
+	warns := analysis.CheckFlow(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, warns, utils.UnassignedUse, []string{
+		"15:5: 'limit' may be read by 'twice' before it is assigned",
+	})
+}
@@ -0,0 +1,38 @@
+package analysis_test
+
+import (
+	"fmt"
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+// parseProgram parses src, failing the test on parser errors.
+func parseProgram(t *testing.T, src string) *parser.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src))
+	prog := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	return prog
+}
+
+// expectDiagnostics checks that errs are exactly want, each written as
+// "line:column: message", and that all of them are of the given kind.
+func expectDiagnostics(t *testing.T, errs []utils.ParseError, kind utils.ErrorKind, want []string) {
+	t.Helper()
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %d: %+v", len(want), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Kind != kind {
+			t.Errorf("diagnostic %d has kind %v", i, err.Kind)
+		}
+		if got := fmt.Sprintf("%d:%d: %s", err.Line, err.Column, err.Message); got != want[i] {
+			t.Errorf("diagnostic %d: got %q, want %q", i, got, want[i])
+		}
+	}
+}
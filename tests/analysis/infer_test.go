@@ -0,0 +1,181 @@
+package analysis_test
+
+import (
+	"reflect"
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+func function(prog *parser.Program, name string) *parser.Function {
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*parser.Function); ok && fn.Name.Value == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+func TestInferFunctionSignatures(t *testing.T) {
+	src := `func greet(name) {
+    if name == "" {
+        return "nobody"
+    }
+    return name
+}
+func half(x) {
+    return x / 2.0
+}
+func count(xs) {
+    total = 0
+    for x in xs {
+        total = total + x
+    }
+    return total
+}
+func names() {
+    out = []
+    out.push(greet("bob"))
+    return out
+}
+func small(b: u8) {
+    return b + 1
+}`
+	prog := parseProgram(t, src)
+	table := analysis.InferTypes(prog, nil)
+	for _, tc := range []struct {
+		fn     string
+		params []string
+		ret    string
+	}{
+		{"greet", []string{"string"}, "string"},
+		{"half", []string{"float"}, "float"},
+		{"count", []string{"[int]"}, "int"},
+		{"names", []string{}, "[string]"},
+		{"small", []string{"u8"}, "u8"},
+	} {
+		fn := function(prog, tc.fn)
+		if got := table.Params(fn); !reflect.DeepEqual(got, tc.params) {
+			t.Errorf("%s: params %q, want %q", tc.fn, got, tc.params)
+		}
+		if got := table.Return(fn); got != tc.ret {
+			t.Errorf("%s: return %q, want %q", tc.fn, got, tc.ret)
+		}
+	}
+}
+
+func TestInferLetPolymorphism(t *testing.T) {
+	src := `func id(x) {
+    return x
+}
+a = id(1)
+b = id("s")
+c = id([1.5])`
+	prog := parseProgram(t, src)
+	table := analysis.InferTypes(prog, nil)
+	for name, want := range map[string]string{"a": "int", "b": "string", "c": "[float]"} {
+		if got := table.Variable(name); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+	if got := table.Params(function(prog, "id")); got[0] != "" {
+		t.Errorf("id stays generic, got param %q", got[0])
+	}
+}
+
+func TestInferVariables(t *testing.T) {
+	src := `struct Point {
+    x: u16,
+    y
+}
+func both() {
+    return 1, "one"
+}
+p = Point{x: 1, y: "a"}
+label = p.y
+pair = both()
+first, second = pair
+words = []
+words = append(words, label)
+ratio = 1 as f32
+mask = [1, 2.5]
+big = 5000000000
+sq = {
+    return 3
+}
+nums = [1, 2].map(sq)`
+	table := analysis.InferTypes(parseProgram(t, src), nil)
+	for name, want := range map[string]string{
+		"p":      "Point",
+		"label":  "string",
+		"pair":   "(int, string)",
+		"first":  "int",
+		"second": "string",
+		"words":  "[string]",
+		"ratio":  "f32",
+		"mask":   "[float]",
+		"big":    "i64",
+		"sq":     "",
+		"nums":   "[int]",
+	} {
+		if got := table.Variable(name); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestInferAcrossModules(t *testing.T) {
+	imports := map[string]map[string]interface{}{
+		"geo": {
+			"Area": analysis.FunctionInfo{
+				Name:       "Area",
+				Parameters: []analysis.ParameterInfo{{Name: "w", Type: "float"}, {Name: "h", Type: "float"}},
+				ReturnType: "float",
+			},
+			"Names": analysis.VariableInfo{Name: "Names", Type: "[string]"},
+			"strlen": analysis.FunctionInfo{
+				Name:       "strlen",
+				Parameters: []analysis.ParameterInfo{{Name: "s", Type: "cstr"}},
+				ReturnType: "size_t",
+				Foreign:    true,
+			},
+		},
+	}
+	src := `import geo as g
+a = g.Area(2, 3)
+n = g.Names[0]
+l = strlen("abc")`
+	table := analysis.InferTypes(parseProgram(t, src), imports)
+	for name, want := range map[string]string{"a": "float", "n": "string", "l": "u64"} {
+		if got := table.Variable(name); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestInferSkipsStatementsThatFailedToParse(t *testing.T) {
+	src := `x = 1
+match x {
+    case 1 {
+}
+func f(y) {
+    match y {
+        case {
+    }
+    return y
+}
+z = x + 1`
+	p := parser.NewParser(lexer.NewLexer(src))
+	prog := p.Parse()
+	if p.Errors.Len() == 0 {
+		t.Fatal("expected the malformed match to be a parse error")
+	}
+	if got := analysis.InferTypes(prog, nil).Variable("x"); got != "int" {
+		t.Errorf("x: %q, want %q", got, "int")
+	}
+	if _, ok := analysis.ModuleExports(prog, nil)["f"]; !ok {
+		t.Error("expected f to be exported")
+	}
+}
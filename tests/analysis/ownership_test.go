@@ -0,0 +1,122 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/parser"
+)
+
+func TestOwnershipBorrows(t *testing.T) {
+	src := `func show(s) {
+    t = s
+    print(t)
+}
+func shout(s) {
+    s = s .. "!"
+    u = s
+    return u
+}
+x = [1]
+y = x
+z = x
+z = [2]
+for v in x {
+    print(v)
+}`
+	prog := parseProgram(t, src)
+	o := analysis.FindOwnership(prog, nil)
+	if !o.Borrows(prog.Statements[0].(*parser.Function).Params[0]) {
+		t.Errorf("expected show to borrow s")
+	}
+	if o.Borrows(prog.Statements[1].(*parser.Function).Params[0]) {
+		t.Errorf("expected shout to own s, which it assigns")
+	}
+	for _, tc := range []struct {
+		name      string
+		line, col int
+		borrows   bool
+	}{
+		{"t", 2, 5, true},
+		{"u", 7, 5, false},
+		{"x", 10, 1, false},
+		{"y", 11, 1, true},
+		{"z", 12, 1, false},
+	} {
+		id := identAt(prog, tc.name, tc.line, tc.col)
+		if id == nil {
+			t.Fatalf("no %s at %d:%d", tc.name, tc.line, tc.col)
+		}
+		if got := o.Borrows(id); got != tc.borrows {
+			t.Errorf("%s at %d:%d: Borrows = %v, want %v", tc.name, tc.line, tc.col, got, tc.borrows)
+		}
+	}
+	if o.Borrows(prog.Statements[6].(*parser.For).Value) {
+		t.Errorf("expected the loop variable to own its element")
+	}
+	// A use is not a binding.
+	if o.Borrows(identAt(prog, "t", 3, 11)) {
+		t.Errorf("expected a use of t not to borrow")
+	}
+}
+
+func TestOwnershipBorrowsCaptures(t *testing.T) {
+	src := `a = "x"
+b = "y"
+b = b .. "z"
+f = {
+    print(a, b)
+}`
+	prog := parseProgram(t, src)
+	lambda := prog.Statements[3].(*parser.Assignment).Value.(*parser.Block)
+	o := analysis.FindOwnership(prog, nil)
+	if !o.BorrowsCapture(lambda, "a") {
+		t.Errorf("expected the lambda to borrow a")
+	}
+	if o.BorrowsCapture(lambda, "b") {
+		t.Errorf("expected the lambda to hold b, which is assigned again")
+	}
+	var none *analysis.Ownership
+	if none.Borrows(identAt(prog, "a", 1, 1)) || none.BorrowsCapture(lambda, "a") {
+		t.Errorf("expected a nil Ownership to borrow nothing")
+	}
+}
+
+func TestOwnershipShared(t *testing.T) {
+	src := `func run() {
+    count = 0
+    label = "x"
+    total = 1
+    inc = {
+        count = count + 1
+        print(label)
+    }
+    total = 2
+    inc()
+}
+func bump(k) {
+    g = {
+        k = k * 2
+    }
+    g()
+    return k
+}`
+	prog := parseProgram(t, src)
+	o := analysis.FindOwnership(prog, nil)
+	for _, tc := range []struct {
+		name   string
+		line   int
+		shared bool
+	}{
+		{"count", 2, true},
+		{"label", 3, false},
+		{"total", 4, false},
+	} {
+		if got := o.Shared(identAt(prog, tc.name, tc.line, 5)); got != tc.shared {
+			t.Errorf("%s: Shared = %v, want %v", tc.name, got, tc.shared)
+		}
+	}
+	if !o.Shared(prog.Statements[1].(*parser.Function).Params[0]) {
+		t.Errorf("expected bump to share k with its lambda")
+	}
+}
@@ -0,0 +1,200 @@
+package analysis_test
+
+import (
+	"fmt"
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+	"aether/src/parser"
+)
+
+// identAt returns the identifier named name at line:col.
+func identAt(prog *parser.Program, name string, line, col int) *parser.Identifier {
+	var found *parser.Identifier
+	parser.Inspect(prog, func(n parser.Node) bool {
+		if id, ok := n.(*parser.Identifier); ok && id.Value == name && id.Line == line && id.Column == col {
+			found = id
+		}
+		return found == nil
+	})
+	return found
+}
+
+func TestResolveBindsUsesToDeclarations(t *testing.T) {
+	src := `total = 0
+func add(total, n) {
+    for i, x in [n] {
+        total = total + x + i
+    }
+    return total
+}
+func bump(n) {
+    total = total + n
+}`
+	prog := parseProgram(t, src)
+	res := analysis.Resolve(prog, "main.aeth", src, nil)
+	for _, tc := range []struct {
+		name      string
+		line, col int
+		kind      analysis.SymbolKind
+		declLine  int
+		declCol   int
+	}{
+		{"total", 4, 17, analysis.ParameterSymbol, 2, 10},
+		{"total", 4, 9, analysis.ParameterSymbol, 2, 10},
+		{"x", 4, 25, analysis.VariableSymbol, 3, 12},
+		{"i", 4, 29, analysis.VariableSymbol, 3, 9},
+		{"total", 9, 13, analysis.VariableSymbol, 1, 1},
+		{"total", 9, 5, analysis.VariableSymbol, 1, 1},
+	} {
+		id := identAt(prog, tc.name, tc.line, tc.col)
+		if id == nil {
+			t.Fatalf("no identifier %s at %d:%d", tc.name, tc.line, tc.col)
+		}
+		sym := res.SymbolOf(id)
+		if sym == nil {
+			t.Errorf("%s at %d:%d is not bound", tc.name, tc.line, tc.col)
+			continue
+		}
+		if sym.Kind != tc.kind || sym.Pos.Line != tc.declLine || sym.Pos.Column != tc.declCol {
+			t.Errorf("%s at %d:%d bound to %s at %d:%d, want %s at %d:%d", tc.name, tc.line, tc.col,
+				sym.Kind, sym.Pos.Line, sym.Pos.Column, tc.kind, tc.declLine, tc.declCol)
+		}
+	}
+	if len(res.Errors) > 0 {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
+
+func TestResolveUndefinedNames(t *testing.T) {
+	src := `struct Point {
+    x
+}
+func area(width, height) {
+    return widht * height
+}
+func outer(n) {
+    func inner() {
+        return n
+    }
+    if n > 0 {
+        sign = 1
+    }
+    return sign
+}
+p = Pont{x: 1}
+prnt(area(1, 2))
+print(undefinedThing)`
+	res := analysis.Resolve(parseProgram(t, src), "main.aeth", src, nil)
+	want := []string{
+		"5:12: undefined reference to 'widht'; did you mean 'width'?",
+		"9:16: undefined reference to 'n'; a named function cannot use the parameters of the function around it",
+		"14:12: undefined reference to 'sign'",
+		"16:5: undefined reference to struct 'Pont'; did you mean 'Point'?",
+		"17:1: undefined reference to function 'prnt'; did you mean 'print'?",
+		"18:7: undefined reference to 'undefinedThing'",
+	}
+	expectDiagnostics(t, res.Errors, utils.UndefinedReference, want)
+	if res.Errors[0].Snippet != "    return widht * height" || res.Errors[0].Fix != "Replace 'widht' with 'width'." {
+		t.Errorf("unexpected snippet or fix: %+v", res.Errors[0])
+	}
+}
+
+func TestResolveShadowing(t *testing.T) {
+	src := `count = 0
+func scale(count) {
+    xs = [1]
+    for xs in [2] {
+        print(xs)
+    }
+    match count {
+        case [first, ...xs] { print(first) }
+    }
+    return count
+}`
+	res := analysis.Resolve(parseProgram(t, src), "main.aeth", src, nil)
+	want := []string{
+		"2:12: parameter 'count' shadows the variable 'count' declared at line 1, column 1",
+		"4:9: variable 'xs' shadows the variable 'xs' declared at line 3, column 5",
+		"8:25: variable 'xs' shadows the variable 'xs' declared at line 3, column 5",
+	}
+	expectDiagnostics(t, res.Warnings, utils.ShadowedName, want)
+}
+
+func TestResolveShadowingOnlyEarlierVariables(t *testing.T) {
+	src := `func swap(a, b) {
+    return b, a
+}
+func apply(f, x) {
+    return f(x)
+}
+a, b = swap(1, 2)
+func twice(b, swap) {
+    return b + b
+}`
+	res := analysis.Resolve(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, res.Warnings, utils.ShadowedName, []string{
+		"8:12: parameter 'b' shadows the variable 'b' declared at line 7, column 4",
+		"8:15: parameter 'swap' shadows the function 'swap' declared at line 1, column 6",
+	})
+}
+
+func TestResolveLambdaCaptures(t *testing.T) {
+	src := `func make(n) {
+    base = 10
+    f = {
+        g = {
+            return base + n
+        }
+        return g()
+    }
+    return f
+}`
+	prog := parseProgram(t, src)
+	res := analysis.Resolve(prog, "main.aeth", src, nil)
+	var lambdas []*parser.Block
+	parser.Inspect(prog, func(n parser.Node) bool {
+		if a, ok := n.(*parser.Assignment); ok {
+			if b, ok := a.Value.(*parser.Block); ok {
+				lambdas = append(lambdas, b)
+			}
+		}
+		return true
+	})
+	if len(lambdas) != 2 {
+		t.Fatalf("expected 2 lambdas, got %d", len(lambdas))
+	}
+	for i, lambda := range lambdas {
+		var names []string
+		for _, sym := range res.Captures[lambda] {
+			names = append(names, sym.Name)
+		}
+		if fmt.Sprint(names) != "[base n]" {
+			t.Errorf("lambda %d captures %v, want [base n]", i, names)
+		}
+	}
+}
+
+func TestResolveImports(t *testing.T) {
+	imports := map[string]map[string]interface{}{
+		"geo": {
+			"Area":   analysis.FunctionInfo{Name: "Area"},
+			"strlen": analysis.FunctionInfo{Name: "strlen", Foreign: true},
+		},
+	}
+	src := "import geo as shapes\nx = shapes.Area(1, 2) + strlen(\"a\")\ny = shapez.Area(1, 2)\nputs(\"a\")"
+	res := analysis.Resolve(parseProgram(t, src), "main.aeth", src, imports)
+	want := []string{
+		"3:5: undefined reference to 'shapez'; did you mean 'shapes'?",
+		"4:1: undefined reference to function 'puts'",
+	}
+	expectDiagnostics(t, res.Errors, utils.UndefinedReference, want)
+
+	// Without the exports of geo, puts may be one of its functions.
+	src = "import geo\nputs(\"a\")"
+	res = analysis.Resolve(parseProgram(t, src), "main.aeth", src, nil)
+	if len(res.Errors) > 0 {
+		t.Errorf("expected no errors, got %+v", res.Errors)
+	}
+}
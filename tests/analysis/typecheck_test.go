@@ -0,0 +1,111 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"aether/lib/utils"
+	"aether/src/analysis"
+)
+
+func TestWellTypedProgramPasses(t *testing.T) {
+	src := `struct Point {
+    x: u16,
+    y
+}
+func add(a, b) {
+    return a + b
+}
+func label(p) {
+    return "(" .. p.x .. ")"
+}
+p = Point{x: 1, y: "a"}
+total = add(1, 2.5) + add(2 as u8, 3)
+names = []
+names.push(label(p))
+same = names[0] == "(1)"
+flag = 1 == true`
+	if errs := analysis.CheckTypes(parseProgram(t, src), "main.aeth", src, nil); len(errs) > 0 {
+		t.Errorf("expected no errors, got %+v", errs)
+	}
+}
+
+func TestTypeMismatches(t *testing.T) {
+	src := `struct Point {
+    x: u16
+}
+func add(a, b) {
+    return a + b
+}
+func name(n: int) {
+    if n > 0 {
+        return "pos"
+    }
+    return 0
+}
+p = Point{x: "a", z: 3}
+q = add("x", 1)
+xs = [1, "two"]
+xs.push([2])
+ok = 1 == "one"
+neg = -"a"
+print(name("x"))
+n = 1
+n.push(2)
+m = append(n, 3)`
+	errs := analysis.CheckTypes(parseProgram(t, src), "main.aeth", src, nil)
+	expectDiagnostics(t, errs, utils.TypeMismatch, []string{
+		"11:12: return value of 'name': expected string, found int",
+		"13:14: field 'x' of 'Point': expected u16, found string",
+		"13:22: struct 'Point' has no field 'z'",
+		"14:9: argument 1 of 'add': expected a number, found string",
+		"15:10: array element: expected a number, found string",
+		"16:9: array element: expected a number, found [int]",
+		"17:11: right operand of '==': expected a number, found string",
+		"18:8: operand of '-': expected a number, found string",
+		"19:12: argument 1 of 'name': expected int, found string",
+		"21:1: receiver of 'n.push': expected an array, found int",
+		"22:12: argument 1 of 'append': expected an array, found int",
+	})
+	if errs[0].Snippet != "    return 0" || errs[0].Caret != 12 {
+		t.Errorf("expected the snippet and caret of the return, got %q at %d", errs[0].Snippet, errs[0].Caret)
+	}
+}
+
+func TestTypeMismatchAcrossModules(t *testing.T) {
+	imports := map[string]map[string]interface{}{
+		"geo": {
+			"Area": analysis.FunctionInfo{
+				Name:       "Area",
+				Parameters: []analysis.ParameterInfo{{Name: "w", Type: "float"}, {Name: "h", Type: "float"}},
+				ReturnType: "float",
+			},
+		},
+	}
+	src := "import geo\na = geo.Area(2, \"tall\")"
+	expectDiagnostics(t, analysis.CheckTypes(parseProgram(t, src), "main.aeth", src, imports), utils.TypeMismatch, []string{
+		"2:17: argument 2 of 'geo.Area': expected float, found string",
+	})
+}
+
+func TestCopyTakesArray(t *testing.T) {
+	src := "xs = copy([1, 2])\nn = copy(3)"
+	expectDiagnostics(t, analysis.CheckTypes(parseProgram(t, src), "main.aeth", src, nil), utils.TypeMismatch, []string{
+		"2:10: argument 1 of 'copy': expected an array, found int",
+	})
+}
+
+func TestDestructuringArity(t *testing.T) {
+	src := `func two() {
+    return 1, 2
+}
+a, b = 1, 2, 3
+x, y, z = two()
+p, q = [1, 2, 3]
+m, n = two()
+print(a + b + x + p + m + n)`
+	expectDiagnostics(t, analysis.CheckTypes(parseProgram(t, src), "main.aeth", src, nil), utils.TypeMismatch, []string{
+		"4:1: assignment to 2 names: expected 2 values, found 3",
+		"5:1: assignment to 3 names: expected 3 values, found 2",
+		"6:1: assignment to 2 names: expected 2 values, found 3",
+	})
+}
@@ -0,0 +1,33 @@
+package analysis_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+)
+
+func TestKnownTypeNamesPass(t *testing.T) {
+	src := "struct P {\n    x: u8,\n    next: P\n}\nfunc f(a: i8, b: u64, c: f32, d: str, e: ptr, f: bool, g: int, h: P) {\n    return a as i64\n}"
+	if errs := analysis.CheckTypeNames(parseProgram(t, src), "main.aeth"); len(errs) > 0 {
+		t.Errorf("expected no errors, got %+v", errs)
+	}
+}
+
+func TestUnknownTypeNames(t *testing.T) {
+	src := "struct P {\n    x: u9\n}\nfunc f(a: i128) {\n    return a as string\n}"
+	var msgs []string
+	for _, err := range analysis.CheckTypeNames(parseProgram(t, src), "main.aeth") {
+		msgs = append(msgs, err.Message)
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		"unknown type 'u9' for field 'x' of 'P'",
+		"unknown type 'i128' for parameter 'a' of 'f'",
+		"cannot cast to 'string'",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in\n%s", want, got)
+		}
+	}
+}
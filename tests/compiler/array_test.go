@@ -0,0 +1,92 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+func compileSource(t *testing.T, src string, opts compiler.Options) string {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src))
+	prog := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	if opts.ModuleName == "" {
+		opts.ModuleName = "main"
+	}
+	return verifiedIR(t, prog, opts)
+}
+
+// verifiedIR compiles prog, failing the test on internal compiler errors.
+func verifiedIR(t *testing.T, prog *parser.Program, opts compiler.Options) string {
+	t.Helper()
+	ir, errs := compiler.CompileModule(prog, opts)
+	for _, err := range errs {
+		t.Errorf("internal compiler error at line %d: %s", err.Line, err.Message)
+	}
+	return ir
+}
+
+func TestArrayLiteralUsesRuntimeHeader(t *testing.T) {
+	ir := compileSource(t, "xs = [1, 2, 3]\nxs[0] = 4\nx = xs[1]", compiler.Options{})
+	for _, want := range []string{
+		"%aether.array.i32 = type { i32*, i64, i64 }",
+		"call %aether.array.i32* @aether.array.new.i32(i64 3)",
+		"icmp ult i64",
+		"@aether.bounds_fail",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestArrayLenAppendAndSlice(t *testing.T) {
+	src := "xs = [1, 2]\nxs.push(3)\nxs = append(xs, 4)\nn = len(xs)\nys = xs[1:3]"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"@aether.array.push.i32(%aether.array.i32*",
+		"@aether.array.slice.i32(%aether.array.i32*",
+		"trunc i64",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestCopyDuplicatesArray(t *testing.T) {
+	src := "xs = [1, 2]\nys = copy(xs)\nys[0] = 9\nprint(xs[0])\nprint(ys[0])"
+	ir := compileSource(t, src, compiler.Options{Debug: true})
+	if !strings.Contains(ir, "@aether.array.slice.i32(%aether.array.i32*") {
+		t.Errorf("expected copy to slice the whole array\n%s", ir)
+	}
+	if out := runIR(t, ir); out != "1\n9\n" {
+		t.Errorf("expected the copy to be independent, got %q", out)
+	}
+}
+
+func TestBoundsCheckLocationOnlyInDebug(t *testing.T) {
+	src := "xs = [1]\nx = xs[5]"
+	debug := compileSource(t, src, compiler.Options{SourceFile: "app.ae", Debug: true})
+	if !strings.Contains(debug, "app.ae:2: ") {
+		t.Errorf("expected debug build to embed the source location\n%s", debug)
+	}
+	release := compileSource(t, src, compiler.Options{SourceFile: "app.ae"})
+	if strings.Contains(release, "app.ae") {
+		t.Errorf("expected release build without source paths\n%s", release)
+	}
+}
+
+func TestFunctionParameterTakesArrayType(t *testing.T) {
+	src := "func first(xs) {\nreturn xs[0]\n}\nx = first([7, 8])"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "define i32 @first(%aether.array.i32* %xs)") {
+		t.Errorf("expected first to take an array\n%s", ir)
+	}
+}
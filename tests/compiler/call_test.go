@@ -0,0 +1,69 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+const addSource = "func add(a, b) {\nreturn a + b\n}\n"
+
+func TestPartialApplicationBuildsClosure(t *testing.T) {
+	ir := compileSource(t, addSource+"addTen = add(_, 10)\nx = addTen(5)", compiler.Options{})
+	for _, want := range []string{
+		"define internal i32 @main.partial.0(i8* %env, i32 %arg0)",
+		"call i32 @add(i32 %arg0, i32",
+		"alloca { %aether.rc, { i32 } }",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestReturnedPartialApplicationUsesHeapEnvironment(t *testing.T) {
+	src := addSource + "func makeAdder(n) {\nreturn add(n, _)\n}\ninc = makeAdder(1)\nx = inc(2)"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "define internal i32 @makeAdder.partial.0(i8* %env, i32 %arg0)") {
+		t.Errorf("expected a partial application function\n%s", ir)
+	}
+	if !strings.Contains(ir, "call i8* @aether.rc.alloc(i64 ptrtoint ({ i32 }*") {
+		t.Errorf("expected the returned closure to allocate its environment\n%s", ir)
+	}
+}
+
+func TestVariadicFunctionReceivesArray(t *testing.T) {
+	src := "func count(first, ...rest) {\nreturn len(rest)\n}\nn = count(1, 2, 3)"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"define i32 @count(i32 %first, %aether.array.i32* %rest)",
+		"call %aether.array.i32* @aether.array.new.i32(i64 0)",
+		"@aether.array.push.i32(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestSpreadExpandsArrayIntoCall(t *testing.T) {
+	ir := compileSource(t, addSource+"xs = [3, 4]\nx = add(...xs)", compiler.Options{})
+	for _, want := range []string{
+		"icmp eq i64",
+		"@aether.spread_fail",
+		"cannot spread %lld elements into %lld parameters",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestSpreadIntoVariadicExtendsRestArray(t *testing.T) {
+	src := "func count(...xs) {\nreturn len(xs)\n}\nys = [1, 2]\nn = count(0, ...ys)"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "@aether.array.extend.i32(") {
+		t.Errorf("expected the spread to extend the rest array\n%s", ir)
+	}
+}
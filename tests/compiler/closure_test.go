@@ -0,0 +1,86 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestLambdaCapturesIntoEnvironment(t *testing.T) {
+	src := "func run() {\nx = 10\nf = {\nprint(x + 5)\n}\nf()\n}\nrun()"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"define internal i32 @run.lambda.0(i8* %env)",
+		"%aether.closure.fn.i32.i8ptr = type { i32 (i8*)*, i8* }",
+		"alloca { %aether.rc, { i32 } }",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestEscapingClosureUsesHeapEnvironment(t *testing.T) {
+	src := "func counter() {\nn = 0\nf = {\nn = n + 1\nreturn n\n}\nreturn f\n}\nc = counter()\nc()"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "call i8* @aether.rc.alloc(i64 ptrtoint ({ %aether.cell.i32* }*") {
+		t.Errorf("expected the returned closure to allocate its environment\n%s", ir)
+	}
+	if !strings.Contains(ir, "define %aether.closure.fn.i32.i8ptr @counter()") {
+		t.Errorf("expected counter to return a closure\n%s", ir)
+	}
+}
+
+func TestLambdaSharesAssignedCaptures(t *testing.T) {
+	src := "func run() {\ncount = 0\ninc = {\ncount = count + 1\n}\ninc()\ninc()\nprint(count)\n}\nrun()"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"%count.cell = bitcast i8*",
+		"alloca { %aether.rc, { %aether.cell.i32* } }",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if lambda := funcBody(t, ir, "run.lambda.0"); !strings.Contains(lambda, "store i32") || strings.Contains(lambda, "alloca") {
+		t.Errorf("expected the lambda to assign count in its cell\n%s", lambda)
+	}
+	if out := runIR(t, compileSource(t, src, compiler.Options{Debug: true})); out != "2\n" {
+		t.Errorf("got %q, want %q", out, "2\n")
+	}
+}
+
+func TestEscapingClosureSharesAssignedCaptures(t *testing.T) {
+	src := "func counter() {\nn = 0\nf = {\nn = n + 1\nreturn n\n}\nn = 10\nreturn f\n}\nc = counter()\nc()\nprint(c())"
+	if out := runIR(t, compileSource(t, src, compiler.Options{Debug: true})); out != "12\n" {
+		t.Errorf("got %q, want %q", out, "12\n")
+	}
+}
+
+func TestArrayMapCallsClosurePerElement(t *testing.T) {
+	src := "nums = [1, 2, 3]\nys = nums.map({\nreturn 2\n})"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "call i32 %") || !strings.Contains(ir, "@aether.array.push.i32(") {
+		t.Errorf("expected map to call the closure and collect results\n%s", ir)
+	}
+}
+
+func TestArrayMapPassesEachElement(t *testing.T) {
+	src := "func double(x) {\nreturn x * 2\n}\nfunc add(a, b) {\nreturn a + b\n}\nnums = [1, 2, 3]\nprint(nums.map(double))\nprint(nums.map(add(10, _)))"
+	want := "[2, 4, 6]\n[11, 12, 13]\n"
+	if out := runIR(t, compileSource(t, src, compiler.Options{Debug: true})); out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestNamedFunctionsAsValues(t *testing.T) {
+	src := "func double(x) {\nreturn x * 2\n}\nfunc apply(f, x) {\nreturn f(x)\n}\nf = double\nprint(f(4))\nprint(apply(double, 21))"
+	ir := compileSource(t, src, compiler.Options{Debug: true})
+	if !strings.Contains(ir, "define internal i32 @double.closure.0(i8* %env, i32 %x)") {
+		t.Errorf("expected a function for the closures of double\n%s", ir)
+	}
+	if out := runIR(t, ir); out != "8\n42\n" {
+		t.Errorf("got %q", out)
+	}
+}
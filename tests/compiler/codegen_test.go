@@ -0,0 +1,145 @@
+package compiler_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestCodegenCheckRejectsBadSettings(t *testing.T) {
+	for _, tc := range []struct {
+		codegen compiler.Codegen
+		want    string
+	}{
+		{compiler.Codegen{RelocModel: "ropi"}, "unknown relocation model 'ropi'"},
+		{compiler.Codegen{CodeModel: "huge"}, "unknown code model 'huge'"},
+		{compiler.Codegen{Triple: "x86_64-unknown-linux-gnu", CodeModel: "tiny"}, "only supported on arm64"},
+		{compiler.Codegen{Triple: "aarch64-unknown-linux-gnu", CodeModel: "kernel"}, "only supported on amd64"},
+		{compiler.Codegen{StackProtector: "always"}, "unknown stack protector mode 'always'"},
+		{compiler.Codegen{Features: "+sse4.2,avx"}, "'avx' must start with + or -"},
+		{compiler.Codegen{Sanitizers: []string{"undefined"}}, "undefined behavior sanitizer is not supported"},
+		{compiler.Codegen{Sanitizers: []string{"leak"}}, "unknown sanitizer 'leak'"},
+		{compiler.Codegen{Sanitizers: []string{"address", "thread"}}, "cannot be combined"},
+	} {
+		err := tc.codegen.Check()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: expected an error containing %q, got %v", tc.codegen, tc.want, err)
+		}
+	}
+
+	ok := compiler.Codegen{
+		Triple:         "aarch64-unknown-linux-gnu",
+		Features:       "+neon,-crypto",
+		RelocModel:     "pic",
+		CodeModel:      "tiny",
+		StackProtector: "strong",
+		Sanitizers:     []string{"address"},
+	}
+	if err := ok.Check(); err != nil {
+		t.Errorf("expected %+v to be accepted, got %v", ok, err)
+	}
+}
+
+func TestCodegenLLCArgs(t *testing.T) {
+	c := compiler.Codegen{
+		Triple:     "x86_64-unknown-linux-gnu",
+		CPU:        "skylake",
+		Features:   "+avx2",
+		RelocModel: "static",
+		CodeModel:  "large",
+	}
+	want := []string{
+		"-mtriple=x86_64-unknown-linux-gnu",
+		"-mcpu=skylake",
+		"-mattr=+avx2",
+		"-relocation-model=static",
+		"-code-model=large",
+	}
+	if got := c.LLCArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := (compiler.Codegen{}).LLCArgs(); len(got) != 0 {
+		t.Errorf("expected no flags for the defaults, got %q", got)
+	}
+}
+
+func TestTargetTriple(t *testing.T) {
+	triple, err := compiler.TargetTriple("linux", "arm64")
+	if err != nil || triple != "aarch64-unknown-linux-gnu" {
+		t.Errorf("expected aarch64-unknown-linux-gnu, got %q, %v", triple, err)
+	}
+	if _, err := compiler.TargetTriple("plan9", "mips"); err == nil || !strings.Contains(err.Error(), "unsupported target plan9-mips") {
+		t.Errorf("expected an unsupported target error, got %v", err)
+	}
+}
+
+func TestCompileModuleAddsCodegenAttributes(t *testing.T) {
+	c := compiler.Codegen{
+		Triple:         "x86_64-unknown-linux-gnu",
+		StackProtector: "strong",
+		Sanitizers:     []string{"address"},
+	}
+	if got := c.SanitizerPasses(); got != "asan-module" {
+		t.Errorf("expected the address sanitizer pass, got %q", got)
+	}
+	out := compileSource(t, sumOfSquares, compiler.Options{Codegen: c})
+	if !strings.Contains(out, `target triple = "x86_64-unknown-linux-gnu"`) {
+		t.Errorf("expected the module to carry the target triple\n%s", out)
+	}
+	for _, name := range []string{"sq", "sum", "main"} {
+		def := definition(out, name)
+		header := def[:strings.Index(def, "{")]
+		if !strings.Contains(header, "sspstrong") || !strings.Contains(header, "sanitize_address") {
+			t.Errorf("expected @%s to have sspstrong and sanitize_address\n%s", name, header)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "declare") && strings.Contains(line, "sanitize_address") {
+			t.Errorf("expected declarations to be left alone: %s", line)
+		}
+	}
+}
+
+func TestDebugBuildsCheckArithmetic(t *testing.T) {
+	src := "func f(a, b) {\nreturn a + b\n}\nfunc g(a, b) {\nreturn a / b\n}\nprint(f(1, 2), g(7, 2), -f(3, 4))\n"
+	if out := compileSource(t, src, compiler.Options{}); strings.Contains(out, "with.overflow") || strings.Contains(out, "@aether.arith_fail") {
+		t.Errorf("expected release builds to leave arithmetic unchecked\n%s", out)
+	}
+	out := compileSource(t, src, compiler.Options{Debug: true})
+	for _, want := range []string{"@llvm.sadd.with.overflow.i32(", "@llvm.ssub.with.overflow.i32(i32 0,", "integer divide by zero"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, out)
+		}
+	}
+	if got := runIR(t, out); got != "3 3 -7\n" {
+		t.Errorf("got %q", got)
+	}
+
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli not found")
+	}
+	for _, tc := range []struct{ call, want string }{
+		{"f(2147483647, 1)", "main:2: integer overflow\n"},
+		{"g(7, 0)", "main:5: integer divide by zero\n"},
+		{"g(-2147483647 - 1, -1)", "main:5: integer overflow\n"},
+	} {
+		ir := compileSource(t, strings.Replace(src, "print(f(1, 2), g(7, 2), -f(3, 4))", "print("+tc.call+")", 1), compiler.Options{Debug: true})
+		path := filepath.Join(t.TempDir(), "main.ll")
+		if err := os.WriteFile(path, []byte(ir), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := exec.Command(lli, path).Output()
+		var exit *exec.ExitError
+		// lli adds its own stack dump after the message.
+		if !errors.As(err, &exit) || !strings.HasPrefix(string(exit.Stderr), tc.want) {
+			t.Errorf("%s: expected the program to abort with %q, got %v, %q", tc.call, tc.want, err, got)
+		}
+	}
+}
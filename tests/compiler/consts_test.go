@@ -0,0 +1,37 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestConstantExpressionsAreFolded(t *testing.T) {
+	src := "size = 4 * 8\nname = \"a\" .. \"b\"\nbig = size > 16\nprint(size + 1, name, big)"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"store i32 32, i32* %size",
+		"i32 33",
+		"store i1 true, i1* %big",
+		"c\"ab\\00\"",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "@aether.string.concat") || strings.Contains(ir, "mul i32") {
+		t.Errorf("expected constant operators to be folded\n%s", ir)
+	}
+}
+
+func TestConstantArrayLiteralCopiesData(t *testing.T) {
+	src := "xs = [1.5, 2.0]\nn = 2\nn = n + 1\nys = [n, 1]\nprint(xs, ys)"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "private unnamed_addr constant [2 x double] [double 1.5, double 2.0]") {
+		t.Errorf("expected the constant elements in a global\n%s", ir)
+	}
+	if !strings.Contains(ir, "bitcast ([2 x double]* @.array.0 to i8*)") || strings.Contains(ir, "@.array.1") {
+		t.Errorf("expected only the constant literal to be copied from a global\n%s", ir)
+	}
+}
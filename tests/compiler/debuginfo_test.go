@@ -0,0 +1,69 @@
+package compiler_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestDebugInfoDescribesFunctionsAndVariables(t *testing.T) {
+	out := compileSource(t, sumOfSquares, compiler.Options{SourceFile: "/src/app.ae", DebugInfo: true})
+	for _, want := range []string{
+		`!DIFile(filename: "app.ae", directory: "/src")`,
+		`!llvm.dbg.cu = !{`,
+		`!{i32 2, !"Debug Info Version", i32 3}`,
+		`!DISubprogram(name: "sum", scope: !0, file: !0, line: 4,`,
+		`!DILocalVariable(name: "n", arg: 1,`,
+		`!DILocalVariable(name: "total", scope:`,
+		`call void @llvm.dbg.declare(metadata i32* %total, metadata !`,
+		`name: "int", size: 32, encoding: DW_ATE_signed)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected IR to contain %s\n%s", want, out)
+		}
+	}
+	sum := definition(out, "sum")
+	for _, line := range strings.Split(sum, "\n")[2:] {
+		if strings.HasPrefix(line, "\t") && !strings.Contains(line, "!dbg !") {
+			t.Errorf("expected every instruction of @sum to have a location: %s", line)
+		}
+	}
+	// The loop condition is on line 7, and the statements of its body on
+	// lines 8 and 9.
+	for inst, line := range map[string]string{"icmp slt": "7", "call i32 @sq": "8", "add i32 %7, 1": "9"} {
+		m := regexp.MustCompile(regexp.QuoteMeta(inst) + `.*!dbg (!\d+)`).FindStringSubmatch(sum)
+		if m == nil || !strings.Contains(out, m[1]+" = !DILocation(line: "+line+",") {
+			t.Errorf("expected %s at line %s\n%s", inst, line, out)
+		}
+	}
+}
+
+func TestDebugInfoOffByDefault(t *testing.T) {
+	out := compileSource(t, sumOfSquares, compiler.Options{SourceFile: "app.ae"})
+	if strings.Contains(out, "!dbg") || strings.Contains(out, "llvm.dbg") {
+		t.Errorf("expected no debug info without DebugInfo\n%s", out)
+	}
+}
+
+func TestDebugInfoDescribesStructsAndSkipsRuntime(t *testing.T) {
+	src := "struct Point {\nx: int\ny: i64\n}\np = Point { x: 3, y: 4 }\nxs = [p.x]\nprint(xs)"
+	out := compileSource(t, src, compiler.Options{SourceFile: "app.ae", DebugInfo: true})
+	for _, want := range []string{
+		`!DICompositeType(tag: DW_TAG_structure_type, name: "Point"`,
+		`!DIDerivedType(tag: DW_TAG_member, name: "y", scope: !`,
+		`size: 64, offset: 64)`,
+		`!DICompositeType(tag: DW_TAG_structure_type, name: "[int]"`,
+		`!DILocalVariable(name: "p", scope:`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected IR to contain %s\n%s", want, out)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "define linkonce_odr") && strings.Contains(line, "!dbg") {
+			t.Errorf("expected runtime helpers without debug info: %s", line)
+		}
+	}
+}
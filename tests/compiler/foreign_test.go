@@ -0,0 +1,54 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/compiler"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+func TestForeignFunctionDeclaresCSignature(t *testing.T) {
+	src := "foreign func puts(s: cstr): int\nforeign func isblank(c: char): bool\nputs(\"hi\")\nprint(isblank(32))"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"declare i32 @puts(i8* %s)",
+		"declare zeroext i1 @isblank(i8 signext %c)",
+		"call i32 @puts(i8* ",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestForeignVarargsArePromoted(t *testing.T) {
+	src := "foreign func printf(format: cstr, ...): int\nforeign func sqrtf(x: float): float\nprintf(\"%f %s\\n\", sqrtf(2.0), \"ok\")"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"declare i32 @printf(i8* %format, ...)",
+		"fpext float",
+		"call i32 (i8*, ...) @printf(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestImportedForeignFunctionsAreUnqualified(t *testing.T) {
+	p := parser.NewParser(lexer.NewLexer("// #include <stdio.h>\nforeign func puts(s: cstr): int"))
+	symbols := map[string]map[string]interface{}{"stdio": analysis.ModuleExports(p.Parse(), nil)}
+	src := "import \"stdio\"\nputs(\"hello\")"
+	ir := compileSource(t, src, compiler.Options{ModuleSymbols: symbols})
+	for _, want := range []string{"declare i32 @puts(i8* %s)", "call i32 @puts("} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "stdio.puts") {
+		t.Errorf("foreign functions must keep their C names\n%s", ir)
+	}
+}
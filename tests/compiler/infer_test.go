@@ -0,0 +1,93 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/compiler"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+func TestUncalledFunctionUsesInferredTypes(t *testing.T) {
+	src := "func average(xs) {\n    total = 0.5\n    for x in xs {\n        total = total + x\n    }\n    return total\n}\nprint(1)"
+	ir := compileSource(t, src, compiler.Options{})
+	want := "define double @average(%aether.array.double* %xs)"
+	if !strings.Contains(ir, want) {
+		t.Errorf("expected IR to contain %q\n%s", want, ir)
+	}
+}
+
+func TestEmptyArrayTakesInferredElementType(t *testing.T) {
+	src := "names = []\nnames.push(\"a\")\nprint(len(names))"
+	ir := compileSource(t, src, compiler.Options{})
+	want := "call %aether.array.aether.string* @aether.array.new.aether.string(i64 0)"
+	if !strings.Contains(ir, want) {
+		t.Errorf("expected IR to contain %q\n%s", want, ir)
+	}
+}
+
+func TestEmptyArrayTakesStructAndClosureElements(t *testing.T) {
+	src := `struct Node {
+    label: string
+}
+
+func add(a, b) {
+    return a + b
+}
+
+nodes = []
+nodes = append(nodes, Node { label: "a" .. "b" })
+fs = []
+fs = append(fs, add(1, _))
+print(nodes, fs[0](2))`
+	ir := compileSource(t, src, compiler.Options{Debug: true})
+	for _, want := range []string{
+		"@aether.array.new.struct.Nodeptr(i64 0)",
+		"@aether.array.new.aether.closure.fn.i32.i8ptr.i32(i64 0)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if out := runIR(t, ir); out != "[Node { label: \"ab\" }] 3\n" {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestModuleExportsInferredTypes(t *testing.T) {
+	src := "func Shout(s) {\n    if s == \"\" {\n        return \"?\"\n    }\n    return s\n}\nfunc Scale(x) {\n    return x * 1.5\n}\nNames = []\nNames.push(Shout(\"a\"))"
+	prog := parser.NewParser(lexer.NewLexer(src)).Parse()
+	exports := analysis.ModuleExports(prog, nil)
+	shout := exports["Shout"].(analysis.FunctionInfo)
+	if shout.Parameters[0].Type != "string" || shout.ReturnType != "string" {
+		t.Errorf("Shout: got %+v", shout)
+	}
+	scale := exports["Scale"].(analysis.FunctionInfo)
+	if scale.Parameters[0].Type != "float" || scale.ReturnType != "float" {
+		t.Errorf("Scale: got %+v", scale)
+	}
+	if names, ok := exports["Names"].(analysis.VariableInfo); !ok || names.Type != "[string]" {
+		t.Errorf("Names: got %+v", exports["Names"])
+	}
+}
+
+func TestPolymorphicFunctionGetsInstancePerType(t *testing.T) {
+	src := "func id(x) {\n    return x\n}\nprint(id(1))\nprint(id(\"s\"))\nprint(id(2))"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"define i32 @id(i32 %x)",
+		"define internal %aether.string @id.1(%aether.string %x)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "@id.2") {
+		t.Errorf("expected calls with the same types to share an instance\n%s", ir)
+	}
+	if out := runIR(t, compileSource(t, src, compiler.Options{Debug: true})); out != "1\ns\n2\n" {
+		t.Errorf("got %q, want %q", out, "1\ns\n2\n")
+	}
+}
@@ -0,0 +1,45 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestMatchIntegerLiteralsUseSwitch(t *testing.T) {
+	src := "n = 2\nn = 3\nmatch n {\ncase 0 { print(0) }\ncase 1 { print(1) }\ncase 1 { print(2) }\ncase _ { print(3) }\n}"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "switch i32 %") {
+		t.Fatalf("expected a switch\n%s", ir)
+	}
+	if strings.Count(ir, "i32 1, label %match.case") != 1 {
+		t.Errorf("expected the repeated case value to appear once\n%s", ir)
+	}
+	if !strings.Contains(ir, "match.end:") {
+		t.Errorf("expected arms to merge into match.end\n%s", ir)
+	}
+}
+
+func TestMatchStringPatternComparesThroughRuntime(t *testing.T) {
+	src := "s = \"a\"\nmatch s {\ncase \"a\" { print(1) }\ncase _ { print(2) }\n}"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "call i32 @aether.string.compare(") {
+		t.Errorf("expected string patterns to use aether.string.compare\n%s", ir)
+	}
+}
+
+func TestMatchArrayPatternBindsElements(t *testing.T) {
+	src := "xs = [1, 2, 3]\nmatch xs {\ncase [a, ...rest] { print(a, rest) }\n}"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"icmp uge i64",
+		"%a = alloca i32",
+		"%rest = alloca %aether.array.i32*",
+		"@aether.array.slice.i32(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/analysis"
+	"aether/src/compiler"
+	"aether/src/lexer"
+	"aether/src/parser"
+)
+
+const mathxSource = `func add(a, b) {
+    return a + b
+}
+
+func greet(name: string) {
+    return "hi " .. name
+}
+
+func total(...xs) {
+    s = 0
+    for x in xs {
+        s = s + x
+    }
+    return s
+}`
+
+func mathxExports(t *testing.T) map[string]map[string]interface{} {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(mathxSource))
+	prog := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	return map[string]map[string]interface{}{"mathx": analysis.ModuleExports(prog, nil)}
+}
+
+func TestModuleDefinesMangledFunctions(t *testing.T) {
+	ir := compileSource(t, mathxSource, compiler.Options{ModuleName: "mathx"})
+	for _, want := range []string{
+		"define i32 @mathx.add(i32 %a, i32 %b)",
+		"define %aether.string @mathx.greet(%aether.string %name)",
+		"define i32 @mathx.total(%aether.array.i32* %xs)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestImporterDeclaresUsedFunctions(t *testing.T) {
+	src := "import mathx\nprint(mathx.add(2, 3))\nprint(mathx.greet(\"bob\"))"
+	ir := compileSource(t, src, compiler.Options{ModuleSymbols: mathxExports(t)})
+	for _, want := range []string{
+		"declare i32 @mathx.add(i32 %a, i32 %b)",
+		"declare %aether.string @mathx.greet(%aether.string %name)",
+		"call i32 @mathx.add(i32 2, i32 3)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "@mathx.total") {
+		t.Errorf("unused import should not be declared\n%s", ir)
+	}
+}
+
+func TestImportedVariadicFunctionTakesArray(t *testing.T) {
+	src := "import mathx as m\nprint(m.total(1, 2, 3))"
+	ir := compileSource(t, src, compiler.Options{ModuleSymbols: mathxExports(t)})
+	for _, want := range []string{
+		"declare i32 @mathx.total(%aether.array.i32* %xs)",
+		"@aether.array.push.i32",
+		"call i32 @mathx.total(%aether.array.i32*",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestExportedConstantBecomesGlobal(t *testing.T) {
+	prog := &parser.Program{Statements: []parser.Statement{
+		&parser.Assignment{
+			Names: []*parser.Identifier{{Value: "Version"}},
+			Value: &parser.Literal{Kind: parser.NumberLiteral, Value: "3"},
+		},
+	}}
+	exports := analysis.ModuleExports(prog, nil)
+	ir := verifiedIR(t, prog, compiler.Options{ModuleName: "mathx"})
+	if !strings.Contains(ir, "@mathx.Version = global i32 3") {
+		t.Errorf("expected a global definition\n%s", ir)
+	}
+
+	src := "import mathx\nprint(mathx.Version)"
+	ir = compileSource(t, src, compiler.Options{ModuleSymbols: map[string]map[string]interface{}{"mathx": exports}})
+	for _, want := range []string{
+		"@mathx.Version = external global i32",
+		"load i32, i32* @mathx.Version",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestImporterReportsMissingMembers(t *testing.T) {
+	p := parser.NewParser(lexer.NewLexer("import mathx\nprint(mathx.base)"))
+	prog := p.Parse()
+	_, errs := compiler.CompileModule(prog, compiler.Options{ModuleName: "main", ModuleSymbols: mathxExports(t)})
+	if len(errs) != 1 || errs[0].Line != 2 || errs[0].Message != "module mathx has no exported member base" {
+		t.Fatalf("expected an internal compiler error for the read, got %+v", errs)
+	}
+}
+
+func TestModulesShareStructTypes(t *testing.T) {
+	geoSource := `struct Point {
+    x: int
+    label
+}
+
+func Make(x) {
+    return Point { x: x, label: "p" }
+}
+
+func Norm(p: Point) {
+    return p.x * p.x
+}`
+	p := parser.NewParser(lexer.NewLexer(geoSource))
+	prog := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	symbols := map[string]map[string]interface{}{"geo": analysis.ModuleExports(prog, nil)}
+	ir := verifiedIR(t, prog, compiler.Options{ModuleName: "geo", ModuleSymbols: symbols})
+	for _, want := range []string{
+		"%struct.Point = type { i32, %aether.string }",
+		"define %struct.Point* @geo.Make(i32 %x)",
+		"define i32 @geo.Norm(%struct.Point* %p)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+
+	src := "import geo\np = geo.Make(3)\nprint(geo.Norm(p), p.label)"
+	ir = compileSource(t, src, compiler.Options{ModuleSymbols: symbols})
+	for _, want := range []string{
+		"%struct.Point = type { i32, %aether.string }",
+		"declare %struct.Point* @geo.Make(i32 %x)",
+		"declare i32 @geo.Norm(%struct.Point* %p)",
+		"getelementptr %struct.Point, %struct.Point*",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func compileModule(t *testing.T, src, name string) string {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(src))
+	prog := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	return verifiedIR(t, prog, compiler.Options{ModuleName: name})
+}
+
+func TestModuleTopLevelBindingsAreGlobals(t *testing.T) {
+	src := `calls = 0
+Base = 2 * 21
+
+func bump() {
+    calls = calls + 1
+    return calls + Base
+}
+
+bump()`
+	ir := compileModule(t, src, "counter")
+	for _, want := range []string{
+		"@counter.calls.0 = internal global i32 zeroinitializer",
+		"@counter.Base = constant i32 42",
+		"define void @__module_counter()",
+		"store i32 0, i32* @counter.calls.0",
+		"call i32 @counter.bump()",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if init := ir[strings.Index(ir, "define void @__module_counter()"):]; strings.Contains(init, "@counter.Base") {
+		t.Errorf("expected the constant Base to need no initialization\n%s", ir)
+	}
+	body := ir[strings.Index(ir, "define i32 @counter.bump()"):]
+	if !strings.Contains(body, "load i32, i32* @counter.calls.0") {
+		t.Errorf("expected bump to use the global\n%s", ir)
+	}
+}
+
+func TestEntryFunctionsSeeTopLevelBindings(t *testing.T) {
+	src := `count = 5
+label = "n" .. "="
+
+func show() {
+    print(label, count)
+}
+
+func reset() {
+    count = 0
+}
+
+show()
+reset()
+show()`
+	out := runIR(t, compileSource(t, src, compiler.Options{Debug: true}))
+	if want := "n= 5\nn= 0\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestMainRunsModuleInitializersInOrder(t *testing.T) {
+	ir := compileSource(t, "print(1)", compiler.Options{InitOrder: []string{"util", "mathx"}})
+	util := strings.Index(ir, "call void @__module_util()")
+	mathx := strings.Index(ir, "call void @__module_mathx()")
+	printf := strings.Index(ir, "@aether.fmt.int")
+	if util < 0 || mathx < 0 {
+		t.Fatalf("expected main to call both initializers\n%s", ir)
+	}
+	if !(util < mathx && mathx < printf) {
+		t.Errorf("expected initializers to run in order before the program\n%s", ir)
+	}
+	if !strings.Contains(ir, "declare void @__module_util()") {
+		t.Errorf("expected initializer declarations\n%s", ir)
+	}
+}
+
+func TestLiveLeavesOutUnreachedFunctions(t *testing.T) {
+	src := "func used() {\nreturn 1\n}\nfunc unused() {\nreturn 2\n}\nx = used()"
+	ir := compileSource(t, src, compiler.Options{Live: map[string]bool{"main": true, "used": true}})
+	if !strings.Contains(ir, "define i32 @used()") {
+		t.Errorf("expected the live function to be compiled\n%s", ir)
+	}
+	if strings.Contains(ir, "@unused") {
+		t.Errorf("expected the unreached function to be left out\n%s", ir)
+	}
+}
+
+func TestOpenParametersTakeTheirCallersTypes(t *testing.T) {
+	lib := "func greet(name) {\n    return name\n}\nfunc id(x) {\n    return x\n}\nfunc unused(y) {\n    return y\n}"
+	main := "import mathx\nprint(mathx.greet(\"bob\"))\nprint(len(mathx.id([1, 2])))"
+	parse := func(src string) *parser.Program {
+		p := parser.NewParser(lexer.NewLexer(src))
+		prog := p.Parse()
+		if p.Errors.Len() > 0 {
+			t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+		}
+		return prog
+	}
+	libProg, mainProg := parse(lib), parse(main)
+	symbols := map[string]map[string]interface{}{"mathx": analysis.ModuleExports(libProg, nil)}
+	if id := symbols["mathx"]["id"].(analysis.FunctionInfo); id.Parameters[0].Type != "" || id.ReturnType != "" {
+		t.Errorf("expected id to be exported with open types, got %+v", id)
+	}
+	if errs := analysis.CheckTypes(mainProg, "main.aeth", main, symbols); len(errs) > 0 {
+		t.Errorf("expected open parameters to take any type, got %+v", errs)
+	}
+
+	symbols["main"] = analysis.ModuleExports(mainProg, symbols)
+	analysis.ResolveOpenTypes([]analysis.ProjectModule{
+		{Name: "mathx", Prog: libProg},
+		{Name: "main", Prog: mainProg, Entry: true},
+	}, symbols)
+	for name, want := range map[string]string{"greet": "string", "id": "[int]", "unused": ""} {
+		info := symbols["mathx"][name].(analysis.FunctionInfo)
+		if info.Parameters[0].Type != want || info.ReturnType != want {
+			t.Errorf("%s: expected %q, got %+v", name, want, info)
+		}
+	}
+
+	ir := verifiedIR(t, libProg, compiler.Options{ModuleName: "mathx", ModuleSymbols: symbols})
+	for _, want := range []string{
+		"define %aether.string @mathx.greet(%aether.string %name)",
+		"define %aether.array.i32* @mathx.id(%aether.array.i32* %x)",
+		"define i32 @mathx.unused(i32 %y)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	ir = verifiedIR(t, mainProg, compiler.Options{ModuleName: "main", ModuleSymbols: symbols})
+	if want := "declare %aether.string @mathx.greet(%aether.string %name)"; !strings.Contains(ir, want) {
+		t.Errorf("expected IR to contain %q\n%s", want, ir)
+	}
+
+	other := "import mathx\nprint(mathx.greet(1))"
+	if errs := analysis.CheckTypes(parse(other), "other.aeth", other, symbols); len(errs) != 1 ||
+		errs[0].Message != "argument 1 of 'mathx.greet': expected string, found int" {
+		t.Errorf("expected a caller passing another type to be reported, got %+v", errs)
+	}
+}
@@ -0,0 +1,165 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+)
+
+const sumOfSquares = `func sq(x) {
+return x * x
+}
+func sum(n) {
+total = 0
+i = 0
+while i < n {
+total = total + sq(i)
+i = i + 1
+}
+return total
+}
+print(sum(10))`
+
+func pipeline(t *testing.T, level string) compiler.Pipeline {
+	t.Helper()
+	p, err := compiler.ParsePipeline(level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// definition returns the text of the definition of @name in llvmIR.
+func definition(llvmIR, name string) string {
+	start := strings.Index(llvmIR, " @"+name+"(")
+	if start < 0 {
+		return ""
+	}
+	start = strings.LastIndex(llvmIR[:start], "\ndefine") + 1
+	end := strings.Index(llvmIR[start:], "\n}")
+	return llvmIR[start : start+end+2]
+}
+
+func TestMem2RegTurnsLocalsIntoPhis(t *testing.T) {
+	out := compileSource(t, sumOfSquares, compiler.Options{Pipeline: pipeline(t, "1")})
+	sum := definition(out, "sum")
+	if strings.Contains(sum, "alloca") || strings.Contains(sum, "load") || strings.Contains(sum, "store") {
+		t.Errorf("expected the locals of @sum in registers\n%s", sum)
+	}
+	if !strings.Contains(sum, "phi i32") {
+		t.Errorf("expected phis at the loop header\n%s", sum)
+	}
+}
+
+func TestLevelZeroLeavesIRAlone(t *testing.T) {
+	plain := compileSource(t, sumOfSquares, compiler.Options{})
+	out := compileSource(t, sumOfSquares, compiler.Options{Pipeline: pipeline(t, "0")})
+	if out != plain {
+		t.Errorf("expected -O0 not to change the IR\n%s", out)
+	}
+}
+
+func TestInlineSmallFunctions(t *testing.T) {
+	out := compileSource(t, sumOfSquares, compiler.Options{Pipeline: pipeline(t, "2")})
+	main := definition(out, "main")
+	if strings.Contains(main, "call i32 @sum") || strings.Contains(main, "call i32 @sq") {
+		t.Errorf("expected @sum and @sq inlined into @main\n%s", main)
+	}
+
+	p := pipeline(t, "2")
+	p.NoInline = true
+	out = compileSource(t, sumOfSquares, compiler.Options{Pipeline: p})
+	if !strings.Contains(definition(out, "main"), "call i32 @sum(i32 10)") {
+		t.Errorf("expected --no-inline to keep the call\n%s", out)
+	}
+	if !strings.Contains(out, "define i32 @sq(i32 %x) noinline") {
+		t.Errorf("expected --no-inline to mark functions noinline\n%s", out)
+	}
+}
+
+func TestInlineSkipsRecursiveFunctions(t *testing.T) {
+	src := "func fact(n) {\nif n < 2 {\nreturn 1\n}\nreturn n * fact(n - 1)\n}\nprint(fact(5))"
+	out := compileSource(t, src, compiler.Options{Pipeline: pipeline(t, "3")})
+	if !strings.Contains(definition(out, "main"), "call i32 @fact(i32 5)") {
+		t.Errorf("expected the recursive call to stay\n%s", out)
+	}
+}
+
+func TestNoUnrollAndNoVectorizeMarkLoops(t *testing.T) {
+	p := pipeline(t, "2")
+	p.NoUnroll = true
+	p.NoVectorize = true
+	p.NoInline = true
+	out := compileSource(t, sumOfSquares, compiler.Options{Pipeline: p})
+	if !strings.Contains(definition(out, "sum"), "!llvm.loop !") {
+		t.Errorf("expected the loop branch of @sum to carry llvm.loop\n%s", out)
+	}
+	for _, want := range []string{`!{!"llvm.loop.unroll.disable"}`, `!{!"llvm.loop.vectorize.enable", i1 false}`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected the loop metadata to contain %s\n%s", want, out)
+		}
+	}
+}
+
+func TestFoldConstantsAndBranches(t *testing.T) {
+	m := ir.NewModule()
+	f := m.NewFunc("f", types.I32)
+	entry, then, other := f.NewBlock("entry"), f.NewBlock("then"), f.NewBlock("other")
+	sum := entry.NewAdd(constant.NewInt(types.I32, 2), constant.NewInt(types.I32, 3))
+	wrapped := entry.NewMul(constant.NewInt(types.I8, 100), constant.NewInt(types.I8, 3))
+	big := entry.NewICmp(enum.IPredSGT, sum, constant.NewInt(types.I32, 4))
+	entry.NewCondBr(big, then, other)
+	then.NewRet(sum)
+	widened := other.NewSExt(wrapped, types.I32)
+	other.NewRet(widened)
+
+	compiler.Optimize(m, compiler.Pipeline{Passes: []string{"fold", "dce", "simplifycfg"}})
+	if msgs := verifyMessages(m); len(msgs) > 0 {
+		t.Fatalf("expected valid IR, got %q", msgs)
+	}
+	out := f.LLString()
+	if !strings.Contains(out, "ret i32 5") || strings.Contains(out, "br i1") || strings.Contains(out, "other") {
+		t.Errorf("expected the branch folded and its dead side removed\n%s", out)
+	}
+
+	// 100 * 3 wraps to 44 in i8.
+	m = ir.NewModule()
+	g := m.NewFunc("g", types.I32)
+	b := g.NewBlock("entry")
+	b.NewRet(b.NewSExt(b.NewMul(constant.NewInt(types.I8, 100), constant.NewInt(types.I8, 3)), types.I32))
+	compiler.Optimize(m, compiler.Pipeline{Passes: []string{"fold"}})
+	if out := g.LLString(); !strings.Contains(out, "ret i32 44") {
+		t.Errorf("expected i8 arithmetic to wrap\n%s", out)
+	}
+}
+
+func TestFoldLeavesDivisionByZero(t *testing.T) {
+	m := ir.NewModule()
+	f := m.NewFunc("f", types.I32)
+	entry := f.NewBlock("entry")
+	entry.NewRet(entry.NewSDiv(constant.NewInt(types.I32, 1), constant.NewInt(types.I32, 0)))
+	compiler.Optimize(m, compiler.Pipeline{Passes: []string{"fold", "dce"}})
+	if out := f.LLString(); !strings.Contains(out, "sdiv i32 1, 0") {
+		t.Errorf("expected the division to be left to fail at run time\n%s", out)
+	}
+}
+
+func TestParsePipelineLevels(t *testing.T) {
+	for _, level := range []string{"0", "1", "2", "3", "s", "z"} {
+		if _, err := compiler.ParsePipeline(level); err != nil {
+			t.Errorf("level %s: %v", level, err)
+		}
+	}
+	if _, err := compiler.ParsePipeline("4"); err == nil || !strings.Contains(err.Error(), "unknown optimization level '4'") {
+		t.Errorf("expected an error for -O4, got %v", err)
+	}
+	if s, three := pipeline(t, "s"), pipeline(t, "3"); s.InlineThreshold >= three.InlineThreshold {
+		t.Errorf("expected -Os to inline less than -O3 (%d, %d)", s.InlineThreshold, three.InlineThreshold)
+	}
+}
@@ -0,0 +1,149 @@
+package compiler_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+// funcBody returns the definition of fn in ir.
+func funcBody(t *testing.T, ir, fn string) string {
+	t.Helper()
+	start := strings.Index(ir, "@"+fn+"(")
+	if start < 0 {
+		t.Fatalf("no function %s\n%s", fn, ir)
+	}
+	body := ir[start:]
+	return body[:strings.Index(body, "\n}")]
+}
+
+func TestValuesAreCountedObjects(t *testing.T) {
+	src := "struct P {\nname: string\n}\nxs = [1, 2]\nfunc show() {\np = P { name: \"a\" .. \"b\" }\nf = {\nprint(p)\n}\nf()\n}\nshow()"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"%aether.rc = type { i64, void (i8*)* }",
+		"call i8* @aether.rc.alloc(i64 ptrtoint (%aether.array.i32* getelementptr",
+		"call i8* @aether.rc.alloc(i64 ptrtoint (%struct.P* getelementptr (%struct.P, %struct.P* null, i32 1) to i64), void (i8*)* @aether.drop.struct.P)",
+		"define linkonce_odr void @aether.drop.struct.P(i8* %obj)",
+		"alloca { %aether.rc, { %struct.P* } }",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if !regexp.MustCompile(`@\.lit\.\d+ = private unnamed_addr constant \{ %aether.rc, \[3 x i8\] \} \{ %aether.rc \{ i64 -1, void \(i8\*\)\* null \}, \[3 x i8\] c"ab\\00" \}`).MatchString(ir) {
+		t.Errorf("expected the literal to be an object that is never freed\n%s", ir)
+	}
+}
+
+func TestStoreReleasesReplacedValue(t *testing.T) {
+	ir := compileSource(t, "s = \"a\"\ns = s .. \"b\"", compiler.Options{})
+	main := funcBody(t, ir, "main")
+	old := strings.Index(main, "load %aether.string, %aether.string* %s")
+	store := strings.LastIndex(main, "store %aether.string")
+	release := strings.LastIndex(main, "call void @aether.rc.release")
+	if old < 0 || store < old || release < store {
+		t.Errorf("expected the old value of s to be released after the store\n%s", main)
+	}
+	if strings.Contains(main, "@aether.rc.retain") {
+		t.Errorf("expected the new string to move into s\n%s", main)
+	}
+}
+
+func TestBorrowedBindingsAreNotCounted(t *testing.T) {
+	src := `func show(s) {
+t = s
+print(t)
+}
+func shout(s) {
+s = s .. "!"
+print(s)
+}
+show("a" .. "b")
+shout("c")`
+	ir := compileSource(t, src, compiler.Options{})
+	if show := funcBody(t, ir, "show"); strings.Contains(show, "@aether.rc.retain") {
+		t.Errorf("expected show to borrow its parameter\n%s", show)
+	}
+	if shout := funcBody(t, ir, "shout"); !strings.Contains(shout, "call void @aether.rc.retain") {
+		t.Errorf("expected shout to own the parameter it assigns\n%s", shout)
+	}
+}
+
+func TestDebugMainReportsLeaks(t *testing.T) {
+	src := "xs = [\"a\"]\nprint(xs)"
+	if ir := compileSource(t, src, compiler.Options{}); strings.Contains(ir, "@aether.rc.report") {
+		t.Errorf("expected no leak report outside debug builds\n%s", ir)
+	}
+	ir := compileSource(t, src, compiler.Options{Debug: true, InitOrder: []string{"util"}})
+	main := funcBody(t, ir, "main")
+	fini := strings.Index(main, "call void @__module_util.fini()")
+	report := strings.Index(main, "call void @aether.rc.report()")
+	if fini < 0 || report < fini {
+		t.Errorf("expected main to release the module globals and then report leaks\n%s", main)
+	}
+
+	ir = compileModule(t, "Names = [\"a\" .. \"b\"]\nCount = 1", "util")
+	if strings.Contains(ir, "fini") {
+		t.Errorf("expected no finalizer outside debug builds\n%s", ir)
+	}
+}
+
+// runIR runs ir with lli and returns what it wrote to stdout and stderr.
+func runIR(t *testing.T, ir string) string {
+	t.Helper()
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli not found")
+	}
+	path := filepath.Join(t.TempDir(), "main.ll")
+	if err := os.WriteFile(path, []byte(ir), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(lli, path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("lli: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+func TestProgramsFreeWhatTheyAllocate(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, want string
+	}{
+		{"strings", "s = \"a\"\ni = 0\nwhile i < 3 {\ns = s .. i\ni = i + 1\n}\nprint(s)", "a012\n"},
+		{"arrays", "func build(n) {\nxs = []\nfor i, x in [1, 2, 3] {\nxs = append(xs, \"x\" .. x)\n}\nreturn xs\n}\nys = build(3)\nys[0] = \"y\"\nprint(ys[1:], ys .. ys)", "[\"x2\", \"x3\"] [\"y\", \"x2\", \"x3\", \"y\", \"x2\", \"x3\"]\n"},
+		{"structs", "struct Box {\nlabel: string\n}\nb = Box { label: \"a\" .. \"b\" }\nb.label = \"c\" .. \"d\"\nprint(b)", "Box { label: \"cd\" }\n"},
+		{"closures", "func counter() {\nn = 0\nf = {\nn = n + 1\nreturn n\n}\nreturn f\n}\nc = counter()\nc()\nprint(c())", "2\n"},
+		{"swap", "a = \"x\" .. 1\nb = \"y\" .. 2\na, b = b, a\nprint(a, b)", "y2 x1\n"},
+		{"loops", "xs = [\"p\" .. \"q\"]\nfor x in xs {\nxs = [\"r\"]\nif x == \"pq\" {\nbreak\n}\n}\nprint(xs)", "[\"r\"]\n"},
+		{"match", "xs = [\"a\" .. \"b\", \"c\"]\nmatch xs {\ncase [first, ...rest] { print(first, rest) }\n}", "ab [\"c\"]\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := runIR(t, compileSource(t, tc.src, compiler.Options{Debug: true}))
+			if out != tc.want {
+				t.Errorf("got %q, want %q", out, tc.want)
+			}
+		})
+	}
+}
+
+func TestLeakReport(t *testing.T) {
+	ir := compileSource(t, "xs = [\"a\" .. \"b\"]\nprint(len(xs))", compiler.Options{Debug: true})
+	// Dropping the releases leaks the array and the buffer print writes to.
+	var kept []string
+	for _, line := range strings.Split(ir, "\n") {
+		if !strings.Contains(line, "call void @aether.rc.release(") {
+			kept = append(kept, line)
+		}
+	}
+	out := runIR(t, strings.Join(kept, "\n"))
+	if !strings.Contains(out, "leak check: 2 objects still allocated at exit") {
+		t.Errorf("expected a leak report, got %q", out)
+	}
+}
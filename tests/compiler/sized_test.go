@@ -0,0 +1,90 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestSizedParameterAnnotations(t *testing.T) {
+	src := "func f(a: i8, b: u16, c: i64, d: f32, e: f64, p: ptr) {\n    return c\n}\nprint(f(1, 2, 3, 1.5, 2.5, \"x\"))"
+	ir := compileSource(t, src, compiler.Options{})
+	want := "define i64 @f(i8 %a, i16 %b, i64 %c, float %d, double %e, i8* %p)"
+	if !strings.Contains(ir, want) {
+		t.Errorf("expected IR to contain %q\n%s", want, ir)
+	}
+}
+
+func TestUnsignedArithmetic(t *testing.T) {
+	src := "func f(x: u8, y: u8) {\n    return x / y\n}\nb = 200 as u8\nprint(f(b, 3 as u8), b > 100 as u8, b as i32)"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"udiv i8",
+		"icmp ugt i8",
+		"zext i8",
+		"@aether.fmt.uint",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "sdiv") {
+		t.Errorf("unsigned division must not use sdiv\n%s", ir)
+	}
+}
+
+func TestCastsBetweenNumericTypes(t *testing.T) {
+	src := "x = 3.9\nprint(x as i32, x as u32, x as f32, 300 as i8, 7 as i64, 0 as bool)"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"fptosi double",
+		"fptoui double",
+		"fptrunc double",
+		"trunc i32 300 to i8",
+		"sext i32 7 to i64",
+		"icmp ne i32 0, 0",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestLiteralTakesOperandType(t *testing.T) {
+	src := "b = 250 as u8\nc = b + 10\nprint(c)"
+	ir := compileSource(t, src, compiler.Options{})
+	if !strings.Contains(ir, "add i8") {
+		t.Errorf("expected the addition to stay an u8\n%s", ir)
+	}
+}
+
+func TestMixedOperandTypes(t *testing.T) {
+	src := "func f(a: i64, b: int) {\n    return a + b\n}\nfunc g(a, b) {\n    return a * b\n}\nprint(f(1, 2), g(2, 1.5))"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"sext i32",
+		"add i64",
+		"sitofp i32",
+		"fmul double",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestForeignSizedTypes(t *testing.T) {
+	src := "foreign func toupper(c: u8): u8\nforeign func labs(n: i64): i64\nforeign func puts(s: str): int\nprint(toupper(97 as u8), labs(-3 as i64))\nputs(\"hi\")"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"declare zeroext i8 @toupper(i8 zeroext %c)",
+		"declare i64 @labs(i64 %n)",
+		"declare i32 @puts(i8* %s)",
+		"@aether.fmt.uint",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestStringLiteralIsPointerAndLength(t *testing.T) {
+	ir := compileSource(t, `s = "pizza"`, compiler.Options{})
+	for _, want := range []string{
+		"%aether.string = type { i8*, i64 }",
+		"c\"pizza\\00\"",
+		"i64 5 }",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestConcatUsesRuntimeFormatter(t *testing.T) {
+	ir := compileSource(t, `s = "n = " .. 3`, compiler.Options{})
+	for _, want := range []string{"@aether.fmt.int(", "@aether.array.extend.i8("} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestStringComparison(t *testing.T) {
+	ir := compileSource(t, "a = \"x\"\na = \"z\"\nb = a < \"y\"\nc = a == \"x\"", compiler.Options{})
+	if strings.Count(ir, "call i32 @aether.string.compare(") != 2 {
+		t.Errorf("expected two string comparisons\n%s", ir)
+	}
+	if !strings.Contains(ir, "icmp slt i32") || !strings.Contains(ir, "icmp eq i32") {
+		t.Errorf("expected comparisons against the compare result\n%s", ir)
+	}
+}
+
+func TestPrintFormatsEveryKind(t *testing.T) {
+	src := "struct P {\nx: int\n}\nprint(1, 2.5, true, \"s\", [1], P { x: 1 })"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"@aether.fmt.int(",
+		"@aether.fmt.float(",
+		"c\"true\\00\"",
+		"@aether.fmt.aether.array.i32ptr(",
+		"@aether.fmt.struct.Pptr(",
+		"c\"%.*s\\00\"",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+)
+
+func TestMultipleReturnValuesUseStructReturn(t *testing.T) {
+	src := "func divmod(a, b) {\nreturn a / b, a % b\n}\nq, r = divmod(17, 5)"
+	ir := compileSource(t, src, compiler.Options{})
+	for _, want := range []string{
+		"define { i32, i32 } @divmod(i32 %a, i32 %b)",
+		"ret { i32, i32 }",
+		"extractvalue { i32, i32 }",
+		"%q = alloca i32",
+		"%r = alloca i32",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("expected IR to contain %q\n%s", want, ir)
+		}
+	}
+}
+
+func TestTupleSwapEvaluatesRightSideFirst(t *testing.T) {
+	ir := compileSource(t, "a = 1\nb = 2\na, b = b, a", compiler.Options{})
+	loadB := strings.Index(ir, "load i32, i32* %b")
+	loadA := strings.LastIndex(ir, "load i32, i32* %a")
+	storeA := strings.LastIndex(ir, "store i32 %")
+	if loadB < 0 || loadA < 0 || storeA < 0 || storeA < loadA || storeA < loadB {
+		t.Errorf("expected both loads before the stores\n%s", ir)
+	}
+}
+
+func TestArrayUnpackChecksLength(t *testing.T) {
+	ir := compileSource(t, "xs = [1, 2]\nx, y = xs", compiler.Options{})
+	if !strings.Contains(ir, "@aether.unpack_fail") {
+		t.Errorf("expected a length check when unpacking an array\n%s", ir)
+	}
+}
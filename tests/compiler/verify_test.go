@@ -0,0 +1,172 @@
+package compiler_test
+
+import (
+	"strings"
+	"testing"
+
+	"aether/src/compiler"
+	"aether/src/lexer"
+	"aether/src/parser"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+)
+
+func verifyMessages(m *ir.Module) []string {
+	var msgs []string
+	for _, err := range compiler.Verify(m) {
+		msgs = append(msgs, err.Message)
+	}
+	return msgs
+}
+
+func expectVerifyError(t *testing.T, m *ir.Module, want string) {
+	t.Helper()
+	msgs := verifyMessages(m)
+	for _, msg := range msgs {
+		if strings.Contains(msg, want) {
+			return
+		}
+	}
+	t.Errorf("expected a verifier error containing %q, got %q", want, msgs)
+}
+
+func TestVerifyAcceptsWellFormedIR(t *testing.T) {
+	m := ir.NewModule()
+	x := ir.NewParam("x", types.I32)
+	f := m.NewFunc("abs", types.I32, x)
+	entry, neg, done := f.NewBlock("entry"), f.NewBlock("neg"), f.NewBlock("done")
+	isNeg := entry.NewICmp(enum.IPredSLT, x, constant.NewInt(types.I32, 0))
+	entry.NewCondBr(isNeg, neg, done)
+	negated := neg.NewSub(constant.NewInt(types.I32, 0), x)
+	neg.NewBr(done)
+	phi := done.NewPhi(ir.NewIncoming(x, entry), ir.NewIncoming(negated, neg))
+	done.NewRet(phi)
+	if msgs := verifyMessages(m); len(msgs) > 0 {
+		t.Errorf("expected no errors, got %q", msgs)
+	}
+}
+
+func TestVerifyMissingTerminator(t *testing.T) {
+	m := ir.NewModule()
+	f := m.NewFunc("f", types.Void)
+	entry, then := f.NewBlock("entry"), f.NewBlock("then")
+	entry.NewBr(then)
+	expectVerifyError(t, m, "block %then does not end in a terminator")
+}
+
+func TestVerifyOperandTypes(t *testing.T) {
+	m := ir.NewModule()
+	callee := m.NewFunc("g", types.I32, ir.NewParam("a", types.I32))
+	f := m.NewFunc("f", types.I32)
+	entry := f.NewBlock("entry")
+	slot := entry.NewAlloca(types.I64)
+	// NewStore checks its operands, so the bad store is built by hand.
+	entry.Insts = append(entry.Insts, &ir.InstStore{Src: constant.NewInt(types.I32, 1), Dst: slot})
+	entry.Insts = append(entry.Insts, &ir.InstLoad{ElemType: types.I32, Src: slot})
+	entry.NewCall(callee, constant.NewInt(types.I64, 1))
+	entry.NewCall(callee)
+	entry.NewAdd(constant.NewInt(types.I32, 1), constant.NewInt(types.I64, 2))
+	entry.NewRet(constant.NewInt(types.I64, 0))
+
+	for _, want := range []string{
+		"stores i32 through a pointer to i64",
+		"loads i32 through a pointer to i64",
+		"passes i64 as argument 1 of @g, which takes i32",
+		"passes 0 arguments to @g, which takes 1",
+		"combines i32 with i64",
+		"returns i64 from a function returning i32",
+	} {
+		expectVerifyError(t, m, want)
+	}
+}
+
+func TestVerifyDominance(t *testing.T) {
+	m := ir.NewModule()
+	c := ir.NewParam("c", types.I1)
+	f := m.NewFunc("f", types.I32, c)
+	entry, then, merge := f.NewBlock("entry"), f.NewBlock("then"), f.NewBlock("merge")
+	entry.NewCondBr(c, then, merge)
+	sum := then.NewAdd(constant.NewInt(types.I32, 1), constant.NewInt(types.I32, 2))
+	sum.SetName("sum")
+	then.NewBr(merge)
+	merge.NewRet(sum)
+	expectVerifyError(t, m, "uses %sum, which is not defined on every path to it")
+}
+
+func TestVerifySignatures(t *testing.T) {
+	m := ir.NewModule()
+	f := m.NewFunc("f", types.I32, ir.NewParam("a", types.I32))
+	f.Sig.Params = append(f.Sig.Params, types.I32)
+	f.NewBlock("entry").NewRet(constant.NewInt(types.I32, 0))
+	other := m.NewFunc("f", types.Void)
+	other.NewBlock("entry").NewRet(nil)
+	expectVerifyError(t, m, "has 1 parameters but its signature lists 2")
+	expectVerifyError(t, m, "@f is defined more than once")
+}
+
+func TestCompileModuleVerifiesIR(t *testing.T) {
+	src := "func twice(x) {\nreturn x * 2\n}\nif twice(2) > 3 {\nprint(1)\n}"
+	p := parser.NewParser(lexer.NewLexer(src))
+	prog := p.Parse()
+	out, errs := compiler.CompileModule(prog, compiler.Options{ModuleName: "main"})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected internal compiler errors: %+v", errs)
+	}
+	if !strings.Contains(out, "define i32 @twice(i32 %x)") {
+		t.Errorf("expected the compiled IR\n%s", out)
+	}
+}
+
+func TestCompileModuleReportsUncheckedCode(t *testing.T) {
+	p := parser.NewParser(lexer.NewLexer("n = 1\nn.push(2)\nprint(n)"))
+	prog := p.Parse()
+	out, errs := compiler.CompileModule(prog, compiler.Options{ModuleName: "main"})
+	if len(errs) != 1 || errs[0].Line != 2 || errs[0].Message != "push on i32, which is not an array" {
+		t.Fatalf("expected an internal compiler error for the push, got %+v", errs)
+	}
+	if out != "" {
+		t.Errorf("expected no IR\n%s", out)
+	}
+}
+
+func TestCompileModuleReportsUncompiledOperands(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"x = 1\nprint(x, nothere)", "print of nothere, which is not defined"},
+		{"x = 1\nxs = [x, nothere]", "array literal with nothere, which is not defined"},
+	}
+	for _, tt := range tests {
+		p := parser.NewParser(lexer.NewLexer(tt.src))
+		prog := p.Parse()
+		_, errs := compiler.CompileModule(prog, compiler.Options{ModuleName: "main"})
+		if len(errs) != 1 || errs[0].Line != 2 || errs[0].Message != tt.want {
+			t.Errorf("%q: expected %q on line 2, got %+v", tt.src, tt.want, errs)
+		}
+	}
+}
+
+func TestCompileModuleReportsUncheckedArity(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"func f(a) {\nreturn a\n}\nprint(f(1, 2))", "too many arguments: expected 1, found 2"},
+		{"func f(a, b) {\nreturn a\n}\nprint(f(1))", "not enough arguments: expected 2, found 1"},
+		{"func f() {\nreturn 1, 2\n}\na, b, c = f()", "assignment of { i32, i32 } to 3 names"},
+		{"func f() {\nreturn 1\n}\na, b = f(), 2, 3", "assignment to 2 names: expected 2 values, found 3"},
+	}
+	for _, tt := range tests {
+		p := parser.NewParser(lexer.NewLexer(tt.src))
+		prog := p.Parse()
+		out, errs := compiler.CompileModule(prog, compiler.Options{ModuleName: "main"})
+		if len(errs) != 1 || errs[0].Line != 4 || errs[0].Message != tt.want {
+			t.Errorf("%q: expected %q on line 4, got %+v", tt.src, tt.want, errs)
+		}
+		if out != "" {
+			t.Errorf("%q: expected no IR\n%s", tt.src, out)
+		}
+	}
+}
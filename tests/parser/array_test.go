@@ -18,3 +18,65 @@ func TestParseArrayLiteral(t *testing.T) {
 		t.Fatalf("expected at least one statement")
 	}
 }
+
+func parseFuncBody(t *testing.T, body string) []parser.Statement {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer("func f() {\n" + body + "\n}"))
+	ast := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	if len(ast.Statements) == 0 {
+		t.Fatalf("expected a function")
+	}
+	fn, ok := ast.Statements[0].(*parser.Function)
+	if !ok {
+		t.Fatalf("expected *Function, got %T", ast.Statements[0])
+	}
+	return fn.Body.Statements
+}
+
+func TestParseArraySlice(t *testing.T) {
+	stmts := parseFuncBody(t, "ys = xs[1:3]\nzs = xs[:2]\nws = xs[1:]")
+	if len(stmts) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(stmts))
+	}
+	first := stmts[0].(*parser.Assignment).Value.(*parser.Slice)
+	if first.Low == nil || first.High == nil {
+		t.Errorf("expected both bounds, got %+v", first)
+	}
+	if first.Line != 2 {
+		t.Errorf("expected slice on line 2, got %d", first.Line)
+	}
+	if s := stmts[1].(*parser.Assignment).Value.(*parser.Slice); s.Low != nil || s.High == nil {
+		t.Errorf("expected only a high bound, got %+v", s)
+	}
+	if s := stmts[2].(*parser.Assignment).Value.(*parser.Slice); s.Low == nil || s.High != nil {
+		t.Errorf("expected only a low bound, got %+v", s)
+	}
+}
+
+func TestParseElementAssignment(t *testing.T) {
+	stmts := parseFuncBody(t, "xs[0] = 42")
+	if len(stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(stmts))
+	}
+	assign, ok := stmts[0].(*parser.ElementAssignment)
+	if !ok {
+		t.Fatalf("expected *ElementAssignment, got %T", stmts[0])
+	}
+	if _, ok := assign.Target.(*parser.ArrayIndex); !ok {
+		t.Errorf("expected array index target, got %T", assign.Target)
+	}
+}
+
+func TestParseForOverIdentifier(t *testing.T) {
+	stmts := parseFuncBody(t, "for x in xs {\nprint(x)\n}")
+	loop, ok := stmts[0].(*parser.For)
+	if !ok {
+		t.Fatalf("expected *For, got %T", stmts[0])
+	}
+	if ident, ok := loop.Iterable.(*parser.Identifier); !ok || ident.Value != "xs" {
+		t.Errorf("expected iterable xs, got %#v", loop.Iterable)
+	}
+}
@@ -0,0 +1,73 @@
+package parser_test
+
+import (
+	"aether/src/lexer"
+	"aether/src/parser"
+	"testing"
+)
+
+func TestParseForeignFunction(t *testing.T) {
+	input := "foreign func strlen(s: cstr): size_t"
+	p := parser.NewParser(lexer.NewLexer(input))
+	ast := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	f, ok := ast.Statements[0].(*parser.ForeignFunction)
+	if !ok {
+		t.Fatalf("expected *ForeignFunction node, got %T", ast.Statements[0])
+	}
+	if f.Name.Value != "strlen" || f.ReturnType != "size_t" || f.Variadic {
+		t.Errorf("unexpected declaration %+v", f)
+	}
+	if len(f.Params) != 1 || f.Params[0].Value != "s" || f.Params[0].Type != "cstr" {
+		t.Errorf("expected parameter s: cstr, got %+v", f.Params)
+	}
+}
+
+func TestParseVariadicForeignFunction(t *testing.T) {
+	input := "foreign func printf(format: cstr, ...): int"
+	p := parser.NewParser(lexer.NewLexer(input))
+	ast := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	f, ok := ast.Statements[0].(*parser.ForeignFunction)
+	if !ok {
+		t.Fatalf("expected *ForeignFunction node, got %T", ast.Statements[0])
+	}
+	if !f.Variadic || len(f.Params) != 1 {
+		t.Errorf("expected one fixed parameter and varargs, got %+v", f)
+	}
+}
+
+func TestParseForeignVarargsMustBeLast(t *testing.T) {
+	input := "foreign func bad(..., x: int)"
+	p := parser.NewParser(lexer.NewLexer(input))
+	p.Parse()
+	if p.Errors.Len() == 0 {
+		t.Error("expected an error for varargs before a parameter")
+	}
+}
+
+func TestParseIncludeCommentIsKept(t *testing.T) {
+	input := "// #include <math.h>\nforeign func sqrt(x: double): double"
+	p := parser.NewParser(lexer.NewLexer(input))
+	ast := p.Parse()
+	if len(ast.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(ast.Statements))
+	}
+	c, ok := ast.Statements[0].(*parser.CComment)
+	if !ok || c.Content != "#include <math.h>" {
+		t.Errorf("expected the include comment first, got %#v", ast.Statements[0])
+	}
+}
+
+func TestParseForeignUnknownCType(t *testing.T) {
+	input := "foreign func f(x: string): int"
+	p := parser.NewParser(lexer.NewLexer(input))
+	p.Parse()
+	if p.Errors.Len() == 0 {
+		t.Error("expected an error for a type that is not a C type")
+	}
+}
@@ -39,3 +39,23 @@ func TestParseIdentifierInExpression(t *testing.T) {
 		t.Errorf("expected identifier 'foo' as left arg, got %v", call.Args[0])
 	}
 }
+
+func TestIdentifierAndLiteralPositions(t *testing.T) {
+	input := "x = 1\ny = foo + 22"
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	ast := p.Parse()
+	assign, ok := ast.Statements[1].(*parser.Assignment)
+	if !ok {
+		t.Fatalf("expected *Assignment node, got %T", ast.Statements[1])
+	}
+	call := assign.Value.(*parser.Call)
+	id := call.Args[0].(*parser.Identifier)
+	if id.Line != 2 || id.Column != 5 {
+		t.Errorf("expected 'foo' at 2:5, got %d:%d", id.Line, id.Column)
+	}
+	lit := call.Args[1].(*parser.Literal)
+	if lit.Line != 2 || lit.Column != 11 {
+		t.Errorf("expected '22' at 2:11, got %d:%d", lit.Line, lit.Column)
+	}
+}
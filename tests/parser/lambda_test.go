@@ -19,3 +19,38 @@ func TestParseLambdaAssignment(t *testing.T) {
 		t.Errorf("expected *Block value, got %T", assign.Value)
 	}
 }
+
+func TestParseMultilineLambdaBody(t *testing.T) {
+	stmts := parseFuncBody(t, "f = {\nprint(x + 5)\n}\ns = { a: 1 }")
+	block, ok := stmts[0].(*parser.Assignment).Value.(*parser.Block)
+	if !ok {
+		t.Fatalf("expected *Block value, got %T", stmts[0].(*parser.Assignment).Value)
+	}
+	if len(block.Statements) != 1 {
+		t.Errorf("expected lambda body with 1 statement, got %d", len(block.Statements))
+	}
+	if _, ok := stmts[1].(*parser.Assignment).Value.(*parser.StructInstantiation); !ok {
+		t.Errorf("expected anonymous struct, got %T", stmts[1].(*parser.Assignment).Value)
+	}
+}
+
+func TestInspectVisitsLambdaIdentifiers(t *testing.T) {
+	stmts := parseFuncBody(t, "f = {\nprint(x + y)\n}")
+	var names []string
+	parser.Inspect(stmts[0].(*parser.Assignment).Value, func(n parser.Node) bool {
+		if ident, ok := n.(*parser.Identifier); ok {
+			names = append(names, ident.Value)
+		}
+		return true
+	})
+	want := []string{"print", "+", "x", "y"}
+	if len(names) != len(want) {
+		t.Fatalf("expected identifiers %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expected identifiers %v, got %v", want, names)
+			break
+		}
+	}
+}
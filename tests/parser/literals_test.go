@@ -35,3 +35,23 @@ func TestParseStringLiteral(t *testing.T) {
 		t.Errorf("expected string literal 'hello', got %v", assign.Value)
 	}
 }
+
+func TestParseFloatLiteral(t *testing.T) {
+	stmts := parseFuncBody(t, "x = 3.25\ny = 1..2")
+	lit, ok := stmts[0].(*parser.Assignment).Value.(*parser.Literal)
+	if !ok || lit.Value != "3.25" || lit.Kind != parser.NumberLiteral {
+		t.Errorf("expected number literal '3.25', got %#v", stmts[0].(*parser.Assignment).Value)
+	}
+	call, ok := stmts[1].(*parser.Assignment).Value.(*parser.Call)
+	if !ok || call.Function.(*parser.Identifier).Value != ".." {
+		t.Errorf("expected 1..2 to stay a concatenation, got %#v", stmts[1].(*parser.Assignment).Value)
+	}
+}
+
+func TestParseStringEscapes(t *testing.T) {
+	stmts := parseFuncBody(t, `s = "a\tb\n\"c\"\\"`)
+	lit, ok := stmts[0].(*parser.Assignment).Value.(*parser.Literal)
+	if !ok || lit.Value != "a\tb\n\"c\"\\" || lit.Kind != parser.StringLiteral {
+		t.Errorf("expected escapes to be resolved, got %#v", stmts[0].(*parser.Assignment).Value)
+	}
+}
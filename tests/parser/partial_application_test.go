@@ -118,4 +118,18 @@ func TestParsePartialApplicationChained(t *testing.T) {
 	if len(fn.Params) != 2 {
 		t.Errorf("expected 2 parameters, got %d", len(fn.Params))
 	}
-} 
\ No newline at end of file
+}
+
+func TestParsePlaceholderArgument(t *testing.T) {
+	stmts := parseFuncBody(t, "addFive = add(5, _)")
+	partial, ok := stmts[0].(*parser.Assignment).Value.(*parser.PartialApplication)
+	if !ok {
+		t.Fatalf("expected *PartialApplication, got %T", stmts[0].(*parser.Assignment).Value)
+	}
+	if len(partial.Args) != 2 {
+		t.Fatalf("expected 2 arguments, got %d", len(partial.Args))
+	}
+	if ident, ok := partial.Args[1].(*parser.Identifier); !ok || ident.Value != "_" {
+		t.Errorf("expected placeholder as second argument, got %#v", partial.Args[1])
+	}
+}
@@ -49,3 +49,31 @@ func TestParseMatchWithWildcardCase(t *testing.T) {
 		t.Errorf("expected second case pattern to be '_', got %v", matchNode.Cases[1].Pattern)
 	}
 }
+
+func TestParseMatchDestructuringPatterns(t *testing.T) {
+	stmts := parseFuncBody(t, "match xs {\ncase [0, y] { print(y) }\ncase [a, ...rest] { print(a) }\ncase { x: 0, y } { print(y) }\ncase -1 { print(1) }\n}")
+	m, ok := stmts[0].(*parser.Match)
+	if !ok {
+		t.Fatalf("expected *Match, got %T", stmts[0])
+	}
+	if len(m.Cases) != 4 {
+		t.Fatalf("expected 4 cases, got %d", len(m.Cases))
+	}
+	arr, ok := m.Cases[1].Pattern.(*parser.Array)
+	if !ok || len(arr.Elements) != 2 {
+		t.Fatalf("expected a 2-element array pattern, got %#v", m.Cases[1].Pattern)
+	}
+	if rest, ok := arr.Elements[1].(*parser.Spread); !ok || rest.Name != "rest" {
+		t.Errorf("expected ...rest, got %#v", arr.Elements[1])
+	}
+	st, ok := m.Cases[2].Pattern.(*parser.StructInstantiation)
+	if !ok {
+		t.Fatalf("expected a struct pattern, got %T", m.Cases[2].Pattern)
+	}
+	if id, ok := st.Fields["y"].(*parser.Identifier); !ok || id.Value != "y" {
+		t.Errorf("expected field y to bind y, got %#v", st.Fields["y"])
+	}
+	if lit, ok := m.Cases[3].Pattern.(*parser.Literal); !ok || lit.Value != "-1" {
+		t.Errorf("expected literal -1, got %#v", m.Cases[3].Pattern)
+	}
+}
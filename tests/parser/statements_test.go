@@ -26,3 +26,25 @@ print(2)
 		t.Errorf("expected third statement to be block, got %T", ast.Statements[2])
 	}
 }
+
+func TestParseModuleKeepsTopLevelInOrder(t *testing.T) {
+	input := `x = 1
+func f() {
+    return x
+}
+print(f())`
+	p := parser.NewParser(lexer.NewLexer(input))
+	ast := p.Parse()
+	if len(ast.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(ast.Statements))
+	}
+	if _, ok := ast.Statements[0].(*parser.Assignment); !ok {
+		t.Errorf("expected assignment first, got %T", ast.Statements[0])
+	}
+	if _, ok := ast.Statements[1].(*parser.Function); !ok {
+		t.Errorf("expected function second, got %T", ast.Statements[1])
+	}
+	if _, ok := ast.Statements[2].(*parser.Call); !ok {
+		t.Errorf("expected call third, got %T", ast.Statements[2])
+	}
+}
@@ -33,4 +33,30 @@ func TestParseTupleDestructuring(t *testing.T) {
   if len(assign.Names) != 2 {
     t.Errorf("expected 2 names in assignment, got %d", len(assign.Names))
   }
-} 
\ No newline at end of file
+} 
+
+func TestParseTupleAssignmentFromCall(t *testing.T) {
+	stmts := parseFuncBody(t, "q, r = divmod(17, 5)")
+	assign := stmts[0].(*parser.Assignment)
+	if len(assign.Names) != 2 {
+		t.Fatalf("expected 2 names, got %d", len(assign.Names))
+	}
+	if _, ok := assign.Value.(*parser.Call); !ok {
+		t.Errorf("expected the call as value, got %T", assign.Value)
+	}
+}
+
+func TestParseTupleSwapAndReturn(t *testing.T) {
+	stmts := parseFuncBody(t, "a, b = b, a\nreturn a, b")
+	tuple, ok := stmts[0].(*parser.Assignment).Value.(*parser.Tuple)
+	if !ok || len(tuple.Elements) != 2 {
+		t.Fatalf("expected a 2-element *Tuple value, got %#v", stmts[0].(*parser.Assignment).Value)
+	}
+	ret, ok := stmts[1].(*parser.Return)
+	if !ok {
+		t.Fatalf("expected *Return, got %T", stmts[1])
+	}
+	if tuple, ok := ret.Value.(*parser.Tuple); !ok || len(tuple.Elements) != 2 {
+		t.Errorf("expected return of a 2-element *Tuple, got %#v", ret.Value)
+	}
+}
@@ -118,4 +118,34 @@ func TestParseFunctionWithReturnType(t *testing.T) {
 	if len(fn.Params) != 2 {
 		t.Errorf("expected 2 parameters, got %d", len(fn.Params))
 	}
-} 
\ No newline at end of file
+}
+
+func TestParseCast(t *testing.T) {
+	input := "y = a + b as u8 * 2"
+	p := parser.NewParser(lexer.NewLexer(input))
+	ast := p.Parse()
+	if p.Errors.Len() > 0 {
+		t.Fatalf("parser errors: %+v", p.Errors.ToMessages())
+	}
+	var cast *parser.Cast
+	parser.Inspect(ast, func(n parser.Node) bool {
+		if c, ok := n.(*parser.Cast); ok {
+			cast = c
+		}
+		return true
+	})
+	if cast == nil {
+		t.Fatal("expected a cast")
+	}
+	if id, ok := cast.Expr.(*parser.Identifier); !ok || id.Value != "b" || cast.Type != "u8" {
+		t.Errorf("expected b as u8, got %#v", cast)
+	}
+}
+
+func TestParseCastNeedsType(t *testing.T) {
+	p := parser.NewParser(lexer.NewLexer("y = x as 3"))
+	p.Parse()
+	if p.Errors.Len() == 0 {
+		t.Error("expected an error for a cast without a type name")
+	}
+}